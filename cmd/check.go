@@ -118,6 +118,12 @@ func checkNetworkInterfaces(ctx context.Context, cfg *config.Config) error {
 		interfaces[rule.Via] = struct{}{}
 	}
 
+	for _, host := range cfg.Hosts {
+		if host.Via != "" {
+			interfaces[host.Via] = struct{}{}
+		}
+	}
+
 	// Check if interfaces exist using appropriate command for OS
 	var cmd *exec.Cmd
 	if _, err := exec.LookPath("ip"); err == nil {
@@ -0,0 +1,230 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/bavix/outway/internal/auth"
+	"github.com/bavix/outway/internal/config"
+)
+
+const (
+	// tokenEnv holds an API token for commands talking to a running proxy.
+	tokenEnv = "OUTWAY_TOKEN"
+
+	localTokenTTL = 5 * time.Minute
+	clientTimeout = 30 * time.Second
+)
+
+var (
+	errAPI           = errors.New("api request failed")
+	errNoCertificate = errors.New("no PEM certificate in file")
+)
+
+// clientFlags are the flags of commands talking to the admin API of a
+// running proxy.
+type clientFlags struct {
+	server    string
+	tokenFile string
+}
+
+func (f *clientFlags) register(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&f.server, "server", "", "Admin API address (default: http.listen of the config)")
+	cmd.Flags().StringVar(&f.tokenFile, "token-file", "", "File with an API token (default: $"+tokenEnv+" or a token signed with the config)")
+}
+
+// connect returns an API client for the proxy configured by the config file.
+func (f *clientFlags) connect() (*apiClient, error) {
+	cfg, err := config.Load(configPath())
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+
+	return f.client(cfg)
+}
+
+// client returns an API client for the proxy configured by cfg. Without a
+// token file or $OUTWAY_TOKEN the token is signed with the JWT secret of cfg.
+func (f *clientFlags) client(cfg *config.Config) (*apiClient, error) {
+	addr := f.server
+	if addr == "" {
+		addr = cfg.HTTP.Listen
+	}
+
+	c := &apiClient{base: apiBase(addr, cfg.HTTP.TLS()), http: &http.Client{Timeout: clientTimeout}}
+
+	if cfg.HTTP.TLS() {
+		tlsCfg, err := serverTLS(cfg.HTTP.TLSCertFile, c.base)
+		if err != nil {
+			return nil, err
+		}
+
+		c.http.Transport = &http.Transport{TLSClientConfig: tlsCfg}
+	}
+
+	switch {
+	case f.tokenFile != "":
+		b, err := os.ReadFile(f.tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read token: %w", err)
+		}
+
+		c.token = strings.TrimSpace(string(b))
+	case os.Getenv(tokenEnv) != "":
+		c.token = os.Getenv(tokenEnv)
+	default:
+		token, err := auth.IssueLocalToken(cfg, localTokenTTL)
+		if err != nil {
+			return nil, err
+		}
+
+		c.token = token
+	}
+
+	return c, nil
+}
+
+// apiBase returns the base URL of the admin API listening on addr, over
+// HTTPS when secure is set. Wildcard listen addresses are reached over
+// loopback.
+func apiBase(addr string, secure bool) string {
+	if strings.Contains(addr, "://") {
+		return strings.TrimSuffix(addr, "/")
+	}
+
+	scheme := "http://"
+	if secure {
+		scheme = "https://"
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return scheme + addr
+	}
+
+	switch host {
+	case "", "0.0.0.0":
+		host = "127.0.0.1"
+	case "::":
+		host = "::1"
+	}
+
+	return scheme + net.JoinHostPort(host, port)
+}
+
+// serverTLS returns a TLS config trusting the certificate the admin API is
+// served with besides the system roots, so self-signed certificates work.
+// When the certificate does not name the host of base, as with a loopback
+// address, the first name it holds is verified instead.
+func serverTLS(certFile, base string) (*tls.Config, error) {
+	b, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read TLS certificate: %w", err)
+	}
+
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("%w: %s", errNoCertificate, certFile)
+	}
+
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse TLS certificate: %w", err)
+	}
+
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		roots = x509.NewCertPool()
+	}
+
+	roots.AddCert(leaf)
+
+	tlsCfg := &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12}
+
+	if u, err := url.Parse(base); err == nil && leaf.VerifyHostname(u.Hostname()) != nil && len(leaf.DNSNames) > 0 {
+		tlsCfg.ServerName = leaf.DNSNames[0]
+	}
+
+	return tlsCfg, nil
+}
+
+// apiClient calls the admin API of a running proxy.
+type apiClient struct {
+	base  string
+	token string
+	http  *http.Client
+}
+
+func (c *apiClient) get(ctx context.Context, path string, out any) error {
+	return c.do(ctx, http.MethodGet, path, nil, out)
+}
+
+func (c *apiClient) post(ctx context.Context, path string, in, out any) error {
+	return c.do(ctx, http.MethodPost, path, in, out)
+}
+
+// do sends in as JSON to path under /api/v1 and decodes the reply into out.
+func (c *apiClient) do(ctx context.Context, method, path string, in, out any) error {
+	var body io.Reader
+
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+
+		body = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.base+"/api/v1"+path, body)
+	if err != nil {
+		return err
+	}
+
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		var reply struct {
+			Error string `json:"error"`
+		}
+
+		if json.NewDecoder(resp.Body).Decode(&reply) != nil || reply.Error == "" {
+			reply.Error = resp.Status
+		}
+
+		return fmt.Errorf("%w: %s %s: %s", errAPI, method, path, reply.Error)
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
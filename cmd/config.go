@@ -0,0 +1,299 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/bavix/outway/internal/config"
+)
+
+// configPath returns the config file of the --config flag or the default.
+func configPath() string {
+	if cfgFile != "" {
+		return cfgFile
+	}
+
+	return "/etc/outway/config.yaml"
+}
+
+func newConfigCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Inspect and manage the configuration file",
+	}
+
+	cmd.AddCommand(
+		newConfigValidateCmd(),
+		newConfigFmtCmd(),
+		newConfigSchemaCmd(),
+		newConfigDiffCmd(),
+		newRevisionsCmd(),
+	)
+
+	return cmd
+}
+
+// fileArg returns the file named by args or the config file.
+func fileArg(args []string) string {
+	if len(args) > 0 {
+		return args[0]
+	}
+
+	return configPath()
+}
+
+func newConfigValidateCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "validate [file]",
+		Short: "Check a config file without touching the system",
+		Long: "Load a config file with its includes and drop-ins and validate it. " +
+			"Errors name the file and line at fault. Unlike check, no firewall or interfaces are probed.",
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := fileArg(args)
+
+			cfg, err := config.Load(path)
+			if err != nil {
+				return err
+			}
+
+			_, err = fmt.Fprintf(cmd.OutOrStdout(), "%s: ok (upstreams: %d, rule groups: %d, hosts: %d, files: %d)\n",
+				path, len(cfg.Upstreams), len(cfg.RuleGroups), len(cfg.Hosts), len(cfg.Files()))
+
+			return err
+		},
+	}
+}
+
+func newConfigFmtCmd() *cobra.Command {
+	var write bool
+
+	cmd := &cobra.Command{
+		Use:   "fmt [file]",
+		Short: "Print a config file in canonical form",
+		Long: "Print a config file with keys in canonical order and the layout the proxy writes. " +
+			"Values, references and comments on keys are kept; includes are not expanded.",
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := fileArg(args)
+
+			b, err := os.ReadFile(path) //nolint:gosec // path is given by the user
+			if err != nil {
+				return err
+			}
+
+			out, err := config.Format(b)
+			if err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+
+			if !write {
+				_, err = cmd.OutOrStdout().Write(out)
+
+				return err
+			}
+
+			if bytes.Equal(b, out) {
+				return nil
+			}
+
+			info, err := os.Stat(path)
+			if err != nil {
+				return err
+			}
+
+			return os.WriteFile(path, out, info.Mode().Perm())
+		},
+	}
+	cmd.Flags().BoolVarP(&write, "write", "w", false, "Write the result back to the file")
+
+	return cmd
+}
+
+func newConfigSchemaCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "schema",
+		Short: "Print the JSON Schema of the config file",
+		Long: "Print a JSON Schema generated from the config structures. Point an editor at it, e.g. with " +
+			"\"# yaml-language-server: $schema=outway.schema.json\", for completion and checks.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			enc := json.NewEncoder(cmd.OutOrStdout())
+			enc.SetIndent("", "  ")
+
+			return enc.Encode(config.Schema())
+		},
+	}
+}
+
+func newConfigDiffCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "diff <a.yaml> <b.yaml>",
+		Short: "Show semantic differences between two config files",
+		Long: "Load both files with includes and defaults and list the values that differ. " +
+			"List entries are matched by name, so reordering or reformatting is no change.",
+		Args: cobra.ExactArgs(2), //nolint:mnd // two files
+		RunE: func(cmd *cobra.Command, args []string) error {
+			a, err := config.Load(args[0])
+			if err != nil {
+				return err
+			}
+
+			b, err := config.Load(args[1])
+			if err != nil {
+				return err
+			}
+
+			changes, err := config.Changes(a, b)
+			if err != nil {
+				return err
+			}
+
+			out := cmd.OutOrStdout()
+			if len(changes) == 0 {
+				_, err = fmt.Fprintln(out, "no differences")
+
+				return err
+			}
+
+			for _, line := range changes {
+				if _, err := fmt.Fprintln(out, line); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+	}
+}
+
+func newRevisionsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "revisions",
+		Short: "List, diff and roll back saved revisions of the config file",
+	}
+
+	cmd.AddCommand(newRevisionsListCmd(), newRevisionsDiffCmd(), newRevisionsRollbackCmd())
+
+	return cmd
+}
+
+// loadRevisionLog loads the config file and returns it with its revision log.
+func loadRevisionLog() (*config.Config, *config.RevisionLog, error) {
+	cfg, err := config.Load(configPath())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load config: %w", err)
+	}
+
+	log := cfg.RevisionLog()
+	if log == nil {
+		return nil, nil, config.ErrRevisionsDisabled
+	}
+
+	return cfg, log, nil
+}
+
+func newRevisionsListCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List revisions, newest first",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			_, log, err := loadRevisionLog()
+			if err != nil {
+				return err
+			}
+
+			revisions, err := log.List()
+			if err != nil {
+				return err
+			}
+
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0) //nolint:mnd // column padding
+			_, _ = fmt.Fprintln(w, "ID\tTIME\tAUTHOR\tSECTIONS\tNOTE")
+
+			for _, rev := range revisions {
+				_, _ = fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n",
+					rev.ID, rev.Time.Local().Format(time.DateTime), rev.Author, strings.Join(rev.Sections, ","), rev.Note)
+			}
+
+			return w.Flush()
+		},
+	}
+}
+
+func newRevisionsDiffCmd() *cobra.Command {
+	var from int
+
+	cmd := &cobra.Command{
+		Use:   "diff <id>",
+		Short: "Show what a revision changed",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := strconv.Atoi(args[0])
+			if err != nil {
+				return fmt.Errorf("invalid revision %q: %w", args[0], err)
+			}
+
+			_, log, err := loadRevisionLog()
+			if err != nil {
+				return err
+			}
+
+			diff, err := log.Diff(from, id)
+			if err != nil {
+				return err
+			}
+
+			_, err = fmt.Fprint(cmd.OutOrStdout(), diff)
+
+			return err
+		},
+	}
+	cmd.Flags().IntVar(&from, "from", 0, "Revision to compare with (default: the previous one)")
+
+	return cmd
+}
+
+func newRevisionsRollbackCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "rollback <id>",
+		Short: "Restore the config file from a revision",
+		Long: "Restore the config file from a revision. A running proxy applies it on SIGHUP " +
+			"or right away when started with --watch-config.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := strconv.Atoi(args[0])
+			if err != nil {
+				return fmt.Errorf("invalid revision %q: %w", args[0], err)
+			}
+
+			cfg, _, err := loadRevisionLog()
+			if err != nil {
+				return err
+			}
+
+			ctx := cmd.Context()
+			if u, err := user.Current(); err == nil {
+				ctx = config.WithAuthor(ctx, u.Username)
+			}
+
+			if err := cfg.Rollback(ctx, id, nil); err != nil {
+				return err
+			}
+
+			_, err = fmt.Fprintf(cmd.OutOrStdout(), "restored revision %d to %s\n", id, cfg.Path)
+
+			return err
+		},
+	}
+}
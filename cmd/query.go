@@ -0,0 +1,179 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/spf13/cobra"
+
+	"github.com/bavix/outway/internal/dnsproxy"
+)
+
+// resolveReply is the reply of the resolve endpoint with an explanation.
+type resolveReply struct {
+	Upstream       string                `json:"upstream"`
+	Rcode          int                   `json:"rcode"`
+	Records        []string              `json:"records"`
+	ResponseTimeMs int64                 `json:"response_time_ms"`
+	Explain        *dnsproxy.Explanation `json:"explain,omitempty"`
+}
+
+func newQueryCmd() *cobra.Command {
+	var (
+		flags   clientFlags
+		jsonOut bool
+		dryRun  bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "query <name> [type]",
+		Short: "Resolve a name through the running proxy and explain the answer",
+		Long: "Resolve a name through the pipeline of the running proxy, like a client query, and print the answer " +
+			"with the stage that produced it, the CNAME chain, the rule group routing it and the mark of each address. " +
+			"Like a client query it may reach an upstream, fill the cache and mark the addresses; " +
+			"with --dry-run the answer is neither marked nor cached.",
+		Example: "  outway query video.example.com\n  outway query example.com AAAA --json\n  outway query video.example.com --dry-run",
+		Args:    cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := flags.connect()
+			if err != nil {
+				return err
+			}
+
+			qtype := "A"
+			if len(args) > 1 {
+				qtype = strings.ToUpper(args[1])
+			}
+
+			params := url.Values{"name": {args[0]}, "type": {qtype}, "explain": {"1"}}
+			if dryRun {
+				params.Set("dry_run", "1")
+			}
+
+			var reply resolveReply
+			if err := client.get(cmd.Context(), "/resolve?"+params.Encode(), &reply); err != nil {
+				return err
+			}
+
+			if jsonOut {
+				return writeJSON(cmd.OutOrStdout(), reply)
+			}
+
+			return printQuery(cmd.OutOrStdout(), dns.Fqdn(args[0]), qtype, &reply)
+		},
+	}
+	flags.register(cmd)
+	cmd.Flags().BoolVar(&jsonOut, "json", false, "Print the reply as JSON")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Explain without marking the answered addresses")
+
+	return cmd
+}
+
+// printQuery prints reply in the layout of dig with the explanation as
+// comments.
+func printQuery(w io.Writer, name, qtype string, reply *resolveReply) error {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, ";; QUESTION SECTION:\n;%s\t\tIN\t%s\n\n", name, qtype)
+
+	if len(reply.Records) > 0 {
+		b.WriteString(";; ANSWER SECTION:\n")
+
+		for _, rr := range reply.Records {
+			b.WriteString(rr + "\n")
+		}
+
+		b.WriteString("\n")
+	}
+
+	e := reply.Explain
+	if e == nil {
+		e = &dnsproxy.Explanation{Source: reply.Upstream}
+	}
+
+	fmt.Fprintf(&b, ";; STAGE: %s", e.Stage)
+
+	if e.Source != e.Stage {
+		fmt.Fprintf(&b, " (%s)", e.Source)
+	}
+
+	fmt.Fprintf(&b, "\n;; STATUS: %s\n;; TIME: %d msec\n", dns.RcodeToString[reply.Rcode], reply.ResponseTimeMs)
+
+	if len(e.CNAMEChain) > 0 {
+		fmt.Fprintf(&b, ";; CNAME CHAIN: %s\n", strings.Join(e.CNAMEChain, " -> "))
+	}
+
+	if e.BlockGroup != "" || e.BlockList != "" {
+		fmt.Fprintf(&b, ";; BLOCKED: group %s, list %s\n", orDash(e.BlockGroup), orDash(e.BlockList))
+	}
+
+	if e.PolicyZone != "" {
+		fmt.Fprintf(&b, ";; POLICY: zone %s, action %s\n", e.PolicyZone, e.PolicyAction)
+	}
+
+	printRoute(&b, e)
+
+	_, err := io.WriteString(w, b.String())
+
+	return err
+}
+
+func printRoute(b *strings.Builder, e *dnsproxy.Explanation) {
+	if e.Route == nil {
+		if e.BlockGroup == "" && e.PolicyZone == "" {
+			b.WriteString(";; ROUTE: none, answers use the default route\n")
+		}
+
+		return
+	}
+
+	r := e.Route
+
+	fmt.Fprintf(b, ";; ROUTE: via %s, group %s, pattern %s", r.Via, orDash(r.Group), r.Pattern)
+
+	if r.AddressFamily != "" {
+		fmt.Fprintf(b, ", address family %s", r.AddressFamily)
+	}
+
+	if r.PinTTL {
+		b.WriteString(", pinned ttl")
+	}
+
+	b.WriteString("\n")
+
+	if len(e.Marks) == 0 {
+		return
+	}
+
+	b.WriteString("\n;; MARKS:\n")
+
+	for _, m := range e.Marks {
+		state := m.State
+		if m.State == dnsproxy.MarkActive {
+			state = fmt.Sprintf("%s, expires in %ds", state, m.ExpiresIn)
+		}
+
+		fmt.Fprintf(b, ";%s\tclient ttl %d, mark ttl %d, %s", m.IP, m.ClientTTL, m.MarkTTL, state)
+
+		if m.Installed != nil {
+			if *m.Installed {
+				b.WriteString(", route installed")
+			} else {
+				b.WriteString(", route missing")
+			}
+		}
+
+		b.WriteString("\n")
+	}
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
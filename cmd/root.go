@@ -41,6 +41,12 @@ func NewRootCmd() *cobra.Command {
 	rootCmd.AddCommand(newUpdateCmd())
 	rootCmd.AddCommand(newCheckCmd())
 	rootCmd.AddCommand(newTTLCmd())
+	rootCmd.AddCommand(newConfigCmd())
+	rootCmd.AddCommand(newQueryCmd())
+	rootCmd.AddCommand(newRulesCmd())
+	rootCmd.AddCommand(newStatusCmd())
+	rootCmd.AddCommand(newMarksCmd())
+	rootCmd.AddCommand(newCacheCmd())
 
 	// Add version command using built-in cobra version
 	rootCmd.Version = verpkg.GetVersion()
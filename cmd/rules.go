@@ -0,0 +1,234 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/bavix/outway/internal/config"
+	"github.com/bavix/outway/internal/dnsproxy"
+)
+
+// unmatched stands for domains no rule group routes, in output and
+// expectations.
+const unmatched = "unmatched"
+
+var (
+	errUnexpectedRoutes = errors.New("domains route differently than expected")
+	errInvalidExpect    = errors.New("invalid expectation")
+	errNoDomains        = errors.New("no domains given")
+	errExpectAndDomains = errors.New("--expect checks the domains of its file and takes no domain arguments")
+)
+
+// expectation is a domain with the interface it should route through.
+type expectation struct {
+	domain string
+	via    string
+	line   int
+}
+
+func newRulesCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "rules",
+		Short: "Inspect routing rules",
+	}
+
+	cmd.AddCommand(newRulesTestCmd())
+
+	return cmd
+}
+
+func newRulesTestCmd() *cobra.Command {
+	var (
+		expect string
+		at     string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "test [domain...]",
+		Short: "Match domains against the rule groups of a config file",
+		Long: "Print the rule group, pattern and interface that route each domain, or unmatched. " +
+			"Domains are read from the arguments or, without any, one per line from stdin. " +
+			"With --expect, the domains of the file are checked against the interfaces it lists " +
+			"instead, and the command fails if any routes differently. Matching is the one the proxy uses.",
+		Example: "  outway rules test www.youtube.com example.org\n" +
+			"  outway rules test < domains.txt\n" +
+			"  outway rules test --expect routes.txt",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if expect != "" && len(args) > 0 {
+				return errExpectAndDomains
+			}
+
+			cfg, err := config.Load(configPath())
+			if err != nil {
+				return fmt.Errorf("failed to load config: %w", err)
+			}
+
+			when, err := parseAt(at)
+			if err != nil {
+				return err
+			}
+
+			rules := dnsproxy.NewRuleStore(cfg.GetAllRules())
+
+			if expect != "" {
+				return checkExpectations(cmd.OutOrStdout(), rules, expect, when)
+			}
+
+			domains := args
+			if len(domains) == 0 {
+				if domains, err = readDomains(cmd.InOrStdin()); err != nil {
+					return err
+				}
+			}
+
+			if len(domains) == 0 {
+				return errNoDomains
+			}
+
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0) //nolint:mnd // column padding
+			_, _ = fmt.Fprintln(w, "DOMAIN\tGROUP\tPATTERN\tVIA")
+
+			for _, domain := range domains {
+				rule, ok := rules.FindAt(normalizeDomain(domain), when)
+				if !ok {
+					_, _ = fmt.Fprintf(w, "%s\t%s\t\t\n", domain, unmatched)
+
+					continue
+				}
+
+				_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", domain, rule.Group, rule.Pattern, rule.Via)
+			}
+
+			return w.Flush()
+		},
+	}
+	cmd.Flags().StringVar(&expect, "expect", "",
+		"File of \"domain interface\" lines (interface may be unmatched) to check instead of printing")
+	cmd.Flags().StringVar(&at, "at", "", "Evaluate schedules at this time (RFC 3339 or \"2006-01-02 15:04\" local; default: now)")
+
+	return cmd
+}
+
+// checkExpectations matches the domains of the expectation file at path and
+// reports those routed through another interface than listed.
+func checkExpectations(w io.Writer, rules *dnsproxy.RuleStore, path string, when time.Time) error {
+	f, err := os.Open(path) //nolint:gosec // path is given by the user
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	expectations, err := parseExpectations(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	failed := 0
+
+	for _, e := range expectations {
+		got := unmatched
+
+		rule, ok := rules.FindAt(normalizeDomain(e.domain), when)
+		if ok {
+			got = rule.Via
+		}
+
+		if got == e.via {
+			continue
+		}
+
+		failed++
+
+		if ok {
+			_, err = fmt.Fprintf(w, "%s:%d: %s routes via %s (group %s, pattern %s), expected %s\n",
+				path, e.line, e.domain, got, rule.Group, rule.Pattern, e.via)
+		} else {
+			_, err = fmt.Fprintf(w, "%s:%d: %s is unmatched, expected %s\n", path, e.line, e.domain, e.via)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%w: %d of %d", errUnexpectedRoutes, failed, len(expectations))
+	}
+
+	_, err = fmt.Fprintf(w, "%s: ok (domains: %d)\n", path, len(expectations))
+
+	return err
+}
+
+// parseExpectations reads "domain interface" lines; blank lines and lines
+// starting with # are skipped.
+func parseExpectations(r io.Reader) ([]expectation, error) {
+	var out []expectation
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) != 2 { //nolint:mnd // domain and interface
+			return nil, fmt.Errorf("line %d: %w: want \"domain interface\", got %q", line, errInvalidExpect, text)
+		}
+
+		out = append(out, expectation{domain: fields[0], via: fields[1], line: line})
+	}
+
+	return out, scanner.Err()
+}
+
+// readDomains reads one domain per line; blank lines and lines starting with
+// # are skipped.
+func readDomains(r io.Reader) ([]string, error) {
+	var out []string
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		out = append(out, strings.Fields(text)[0])
+	}
+
+	return out, scanner.Err()
+}
+
+// normalizeDomain returns domain the way the proxy matches query names.
+func normalizeDomain(domain string) string {
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSpace(domain), "."))
+}
+
+// parseAt parses the --at flag; empty means now.
+func parseAt(s string) (time.Time, error) {
+	if s == "" {
+		return time.Now(), nil
+	}
+
+	// Schedules are evaluated in local time, like the proxy does
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.Local(), nil
+	}
+
+	t, err := time.ParseInLocation("2006-01-02 15:04", s, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid --at time %q: %w", s, err)
+	}
+
+	return t, nil
+}
@@ -1,6 +1,13 @@
 package cmd
 
 import (
+	"context"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
 	"github.com/rs/zerolog"
 	"github.com/spf13/cobra"
 
@@ -8,11 +15,18 @@ import (
 	"github.com/bavix/outway/internal/dashboardhttp"
 	"github.com/bavix/outway/internal/dnsproxy"
 	"github.com/bavix/outway/internal/firewall"
+	"github.com/bavix/outway/internal/localzone"
 	"github.com/bavix/outway/internal/metrics"
 	"github.com/bavix/outway/internal/version"
 )
 
-var dryRun bool //nolint:gochecknoglobals // cobra command flag
+var (
+	dryRun      bool //nolint:gochecknoglobals // cobra command flag
+	watchConfig bool //nolint:gochecknoglobals // cobra command flag
+)
+
+// configWatchDebounce groups the writes of an editor saving the config file.
+const configWatchDebounce = 500 * time.Millisecond
 
 func newRunCmd() *cobra.Command { //nolint:cyclop,funlen
 	cmd := &cobra.Command{
@@ -54,6 +68,12 @@ func newRunCmd() *cobra.Command { //nolint:cyclop,funlen
 				tunnels[r.Via] = struct{}{}
 			}
 
+			for _, h := range cfg.Hosts {
+				if h.Via != "" {
+					tunnels[h.Via] = struct{}{}
+				}
+			}
+
 			tunnelList := make([]string, 0, len(tunnels))
 			for tunnel := range tunnels {
 				tunnelList = append(tunnelList, tunnel)
@@ -93,12 +113,73 @@ func newRunCmd() *cobra.Command { //nolint:cyclop,funlen
 				return err
 			}
 
+			watchReload(ctx, proxy, path)
+
 			<-ctx.Done()
 
+			// Persist the cache so the next start is warm
+			proxy.SaveCacheSnapshot(context.WithoutCancel(ctx))
+
 			return nil
 		},
 	}
 	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Validate config and backend, then exit")
+	cmd.Flags().BoolVar(&watchConfig, "watch-config", false, "Reload the config file when it changes")
 
 	return cmd
 }
+
+// watchReload reloads the config file on SIGHUP and, with --watch-config, when
+// the file changes. A file that fails to load or validate is logged and the
+// running configuration is kept.
+func watchReload(ctx context.Context, proxy *dnsproxy.Proxy, path string) {
+	log := zerolog.Ctx(ctx)
+
+	reload := func(reason string) {
+		if err := proxy.ReloadFile(ctx); err != nil {
+			log.Error().Err(err).Str("reason", reason).Msg("config reload rejected")
+		}
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(hup)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				reload("sighup")
+			}
+		}
+	}()
+
+	if !watchConfig {
+		return
+	}
+
+	watcher, err := localzone.NewWatcher(configWatchDebounce)
+	if err != nil {
+		log.Warn().Err(err).Msg("config file watcher unavailable")
+
+		return
+	}
+
+	if err := watcher.WatchFiles(proxy.GetConfig().Files()); err != nil {
+		log.Warn().Err(err).Str("config", path).Msg("failed to watch config file")
+
+		_ = watcher.Close()
+
+		return
+	}
+
+	// The drop-in directory is optional
+	_ = watcher.WatchFile(filepath.Join(filepath.Dir(path), config.DropInDir, "*.yaml"))
+
+	// Saves made by the dashboard reload as no changes
+	watcher.AddCallback(func() { reload("file change") })
+	watcher.Start(ctx)
+}
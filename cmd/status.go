@@ -0,0 +1,255 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/spf13/cobra"
+
+	"github.com/bavix/outway/internal/dnsproxy"
+)
+
+const (
+	bytesPerMiB    = 1 << 20
+	percentPerUnit = 100
+)
+
+var errUnknownType = errors.New("unknown query type")
+
+// statusReply is the reply of the status endpoint.
+type statusReply struct {
+	Version       string                    `json:"version"`
+	UptimeSeconds int64                     `json:"uptime_seconds"`
+	Queries       float64                   `json:"queries"`
+	Backend       string                    `json:"backend,omitempty"`
+	Cache         cacheReply                `json:"cache"`
+	Upstreams     []dnsproxy.UpstreamHealth `json:"upstreams"`
+	Marks         map[string]int            `json:"marks"`
+}
+
+type cacheReply struct {
+	dnsproxy.CacheStats
+
+	Enabled bool    `json:"enabled"`
+	HitRate float64 `json:"hit_rate"`
+}
+
+type marksReply struct {
+	Marks []dnsproxy.MarkedIP `json:"marks"`
+}
+
+func newStatusCmd() *cobra.Command {
+	var (
+		flags   clientFlags
+		jsonOut bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "status",
+		Short: "Show uptime, upstream health, cache and marks of the running proxy",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			client, err := flags.connect()
+			if err != nil {
+				return err
+			}
+
+			var reply statusReply
+			if err := client.get(cmd.Context(), "/status", &reply); err != nil {
+				return err
+			}
+
+			if jsonOut {
+				return writeJSON(cmd.OutOrStdout(), reply)
+			}
+
+			return printStatus(cmd.OutOrStdout(), &reply)
+		},
+	}
+	flags.register(cmd)
+	cmd.Flags().BoolVar(&jsonOut, "json", false, "Print the reply as JSON")
+
+	return cmd
+}
+
+func newMarksCmd() *cobra.Command {
+	var (
+		flags   clientFlags
+		jsonOut bool
+		via     string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "marks",
+		Short: "List the addresses marked by the running proxy per interface",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			client, err := flags.connect()
+			if err != nil {
+				return err
+			}
+
+			path := "/marks"
+			if via != "" {
+				path += "?" + url.Values{"via": {via}}.Encode()
+			}
+
+			var reply marksReply
+			if err := client.get(cmd.Context(), path, &reply); err != nil {
+				return err
+			}
+
+			if jsonOut {
+				return writeJSON(cmd.OutOrStdout(), reply)
+			}
+
+			if len(reply.Marks) == 0 {
+				_, err = fmt.Fprintln(cmd.OutOrStdout(), "no marked addresses")
+
+				return err
+			}
+
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0) //nolint:mnd // column padding
+			_, _ = fmt.Fprintln(w, "VIA\tIP\tEXPIRES IN\tGROUPS")
+
+			for _, m := range reply.Marks {
+				_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\n",
+					m.Via, m.IP, time.Duration(m.ExpiresIn)*time.Second, orDash(strings.Join(m.Groups, ",")))
+			}
+
+			return w.Flush()
+		},
+	}
+	flags.register(cmd)
+	cmd.Flags().BoolVar(&jsonOut, "json", false, "Print the reply as JSON")
+	cmd.Flags().StringVar(&via, "via", "", "Only list marks of this interface")
+
+	return cmd
+}
+
+func newCacheCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cache",
+		Short: "Manage the cache of the running proxy",
+	}
+
+	cmd.AddCommand(newCacheFlushCmd())
+
+	return cmd
+}
+
+func newCacheFlushCmd() *cobra.Command {
+	var flags clientFlags
+
+	cmd := &cobra.Command{
+		Use:   "flush [name [type]]",
+		Short: "Drop all cache entries, or those of one name",
+		Long: "Without arguments, drop the whole cache of the running proxy. " +
+			"With a name, drop only its entries, of all query types or of the given one.",
+		Example: "  outway cache flush\n  outway cache flush example.com\n  outway cache flush example.com AAAA",
+		Args:    cobra.MaximumNArgs(2), //nolint:mnd // name and type
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := flags.connect()
+			if err != nil {
+				return err
+			}
+
+			if len(args) == 0 {
+				if err := client.post(cmd.Context(), "/cache/flush", nil, nil); err != nil {
+					return err
+				}
+
+				_, err = fmt.Fprintln(cmd.OutOrStdout(), "cache flushed")
+
+				return err
+			}
+
+			var qtype uint16
+			if len(args) > 1 {
+				t, ok := dns.StringToType[strings.ToUpper(args[1])]
+				if !ok {
+					return fmt.Errorf("%w: %s", errUnknownType, args[1])
+				}
+
+				qtype = t
+			}
+
+			in := map[string]any{"name": args[0], "qtype": qtype}
+			if err := client.post(cmd.Context(), "/cache/delete", in, nil); err != nil {
+				return err
+			}
+
+			_, err = fmt.Fprintf(cmd.OutOrStdout(), "cache entries of %s dropped\n", args[0])
+
+			return err
+		},
+	}
+	flags.register(cmd)
+
+	return cmd
+}
+
+func printStatus(w io.Writer, st *statusReply) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0) //nolint:mnd // column padding
+
+	_, _ = fmt.Fprintf(tw, "version:\t%s\n", st.Version)
+	_, _ = fmt.Fprintf(tw, "uptime:\t%s\n", time.Duration(st.UptimeSeconds)*time.Second)
+	_, _ = fmt.Fprintf(tw, "backend:\t%s\n", orDash(st.Backend))
+	_, _ = fmt.Fprintf(tw, "queries:\t%.0f\n", st.Queries)
+	_, _ = fmt.Fprintf(tw, "cache:\t%s\n", cacheSummary(st.Cache))
+
+	_, _ = fmt.Fprintln(tw, "\nUPSTREAM\tADDRESS\tSTATE\tQUERIES\tFAILURES\tAVG RTT\tLAST ERROR")
+
+	for _, u := range st.Upstreams {
+		state := "healthy"
+		if !u.Healthy {
+			state = fmt.Sprintf("down (%d failures in a row)", u.ConsecutiveFailures)
+		}
+
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%.1fms\t%s\n",
+			u.Name, u.Address, state, u.Queries, u.Failures, u.AvgRTTMs, orDash(u.LastError))
+	}
+
+	if len(st.Marks) == 0 {
+		_, _ = fmt.Fprintln(tw, "\nno marked addresses")
+
+		return tw.Flush()
+	}
+
+	_, _ = fmt.Fprintln(tw, "\nVIA\tMARKED")
+
+	for _, via := range slices.Sorted(maps.Keys(st.Marks)) {
+		_, _ = fmt.Fprintf(tw, "%s\t%d\n", via, st.Marks[via])
+	}
+
+	return tw.Flush()
+}
+
+func cacheSummary(c cacheReply) string {
+	if !c.Enabled {
+		return "disabled"
+	}
+
+	out := fmt.Sprintf("%d of %d entries", c.Entries, c.MaxEntries)
+	if c.MaxSizeBytes > 0 {
+		out += fmt.Sprintf(", %.1f of %.0f MiB", float64(c.SizeBytes)/bytesPerMiB, float64(c.MaxSizeBytes)/bytesPerMiB)
+	}
+
+	return out + fmt.Sprintf(", hit rate %.1f%%", c.HitRate*percentPerUnit)
+}
+
+func writeJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(v)
+}
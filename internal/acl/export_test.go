@@ -0,0 +1,4 @@
+package acl
+
+// MaxClients exposes the client bucket limit to tests.
+const MaxClients = maxClients
@@ -0,0 +1,208 @@
+// Package acl decides which clients may query the DNS listener and how fast.
+package acl
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/bavix/outway/internal/config"
+)
+
+// Verdict is the outcome of checking a query against the guard.
+type Verdict int
+
+const (
+	// Allow lets the query through.
+	Allow Verdict = iota
+	// Denied means the client is not in the allow list.
+	Denied
+	// Limited means the client is over its rate limit.
+	Limited
+)
+
+const (
+	// ipv6ClientBits groups IPv6 clients by subnet: a single host can use a whole /64.
+	ipv6ClientBits = 64
+	// sweepInterval is how often buckets of idle clients are released.
+	sweepInterval = time.Minute
+	// maxClients bounds the tracked clients. Once reached, new clients share
+	// one bucket until idle ones are released, so a flood of spoofed sources
+	// cannot grow memory without bound.
+	maxClients = 1 << 16
+)
+
+type bucket struct {
+	limiter *rate.Limiter
+	seen    time.Time
+}
+
+// Guard enforces the listener ACL and per-client token buckets.
+type Guard struct {
+	mu        sync.Mutex
+	allow     []*net.IPNet
+	limit     rate.Limit
+	burst     int
+	action    string
+	clients   map[string]*bucket
+	overflow  *bucket
+	lastSweep time.Time
+}
+
+// NewGuard creates a guard that allows everyone; call Configure to load settings.
+func NewGuard() *Guard {
+	return &Guard{clients: make(map[string]*bucket), action: config.RateLimitActionDrop}
+}
+
+// Configure replaces the ACL and rate limit settings. Client buckets survive
+// unless the limits themselves change.
+func (g *Guard) Configure(acl config.ACLConfig, rl config.RateLimitConfig) {
+	allow := make([]*net.IPNet, 0, len(acl.Allow))
+
+	for _, entry := range acl.Allow {
+		if ipNet := parseEntry(entry); ipNet != nil {
+			allow = append(allow, ipNet)
+		}
+	}
+
+	limit := rate.Limit(rl.QPS)
+
+	burst := rl.Burst
+	if burst == 0 {
+		burst = max(1, int(2*rl.QPS))
+	}
+
+	action := strings.ToLower(rl.Action)
+	if action == "" {
+		action = config.RateLimitActionDrop
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if limit != g.limit || burst != g.burst {
+		g.clients = make(map[string]*bucket)
+		g.overflow = nil
+	}
+
+	g.allow = allow
+	g.limit = limit
+	g.burst = burst
+	g.action = action
+}
+
+// Action returns how limited clients are answered: drop, refuse or truncate.
+func (g *Guard) Action() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.action
+}
+
+// Check decides whether a query from ip may be answered.
+func (g *Guard) Check(ip net.IP) Verdict {
+	now := time.Now()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if len(g.allow) > 0 && !g.allowed(ip) {
+		return Denied
+	}
+
+	if g.limit <= 0 || ip == nil {
+		return Allow
+	}
+
+	g.sweep(now)
+
+	b := g.bucket(clientKey(ip))
+	b.seen = now
+
+	if !b.limiter.AllowN(now, 1) {
+		return Limited
+	}
+
+	return Allow
+}
+
+func (g *Guard) allowed(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+
+	for _, n := range g.allow {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// bucket returns the bucket of a client, or the shared one when too many
+// clients are tracked.
+func (g *Guard) bucket(key string) *bucket {
+	if b, ok := g.clients[key]; ok {
+		return b
+	}
+
+	if len(g.clients) >= maxClients {
+		if g.overflow == nil {
+			g.overflow = &bucket{limiter: rate.NewLimiter(g.limit, g.burst)}
+		}
+
+		return g.overflow
+	}
+
+	b := &bucket{limiter: rate.NewLimiter(g.limit, g.burst)}
+	g.clients[key] = b
+
+	return b
+}
+
+// sweep drops buckets of clients that have been idle long enough to refill.
+func (g *Guard) sweep(now time.Time) {
+	if now.Sub(g.lastSweep) < sweepInterval {
+		return
+	}
+
+	g.lastSweep = now
+
+	for key, b := range g.clients {
+		if now.Sub(b.seen) >= sweepInterval && b.limiter.TokensAt(now) >= float64(g.burst) {
+			delete(g.clients, key)
+		}
+	}
+}
+
+func clientKey(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		return v4.String()
+	}
+
+	return ip.Mask(net.CIDRMask(ipv6ClientBits, net.IPv6len*8)).String()
+}
+
+func parseEntry(entry string) *net.IPNet {
+	entry = strings.TrimSpace(entry)
+
+	if ip := net.ParseIP(entry); ip != nil {
+		bits := net.IPv6len * 8
+		if v4 := ip.To4(); v4 != nil {
+			ip, bits = v4, net.IPv4len*8
+		}
+
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+	}
+
+	_, ipNet, err := net.ParseCIDR(entry)
+	if err != nil {
+		return nil
+	}
+
+	return ipNet
+}
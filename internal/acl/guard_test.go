@@ -0,0 +1,77 @@
+package acl_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bavix/outway/internal/acl"
+	"github.com/bavix/outway/internal/config"
+)
+
+func TestGuard_AllowList(t *testing.T) {
+	t.Parallel()
+
+	g := acl.NewGuard()
+	assert.Equal(t, acl.Allow, g.Check(net.ParseIP("203.0.113.5")), "everyone is allowed without an acl")
+
+	g.Configure(config.ACLConfig{Allow: []string{"192.168.0.0/16", "fd00::/8", "10.1.2.3"}}, config.RateLimitConfig{})
+
+	assert.Equal(t, acl.Allow, g.Check(net.ParseIP("192.168.1.20")))
+	assert.Equal(t, acl.Allow, g.Check(net.ParseIP("fd12::1")))
+	assert.Equal(t, acl.Allow, g.Check(net.ParseIP("10.1.2.3")))
+	assert.Equal(t, acl.Denied, g.Check(net.ParseIP("10.1.2.4")))
+	assert.Equal(t, acl.Denied, g.Check(net.ParseIP("203.0.113.5")))
+	assert.Equal(t, acl.Denied, g.Check(nil))
+}
+
+func TestGuard_RateLimit(t *testing.T) {
+	t.Parallel()
+
+	g := acl.NewGuard()
+	g.Configure(config.ACLConfig{}, config.RateLimitConfig{QPS: 0.001, Burst: 2, Action: "Refuse"})
+
+	assert.Equal(t, config.RateLimitActionRefuse, g.Action())
+
+	client := net.ParseIP("198.51.100.7")
+	assert.Equal(t, acl.Allow, g.Check(client))
+	assert.Equal(t, acl.Allow, g.Check(client))
+	assert.Equal(t, acl.Limited, g.Check(client))
+
+	assert.Equal(t, acl.Allow, g.Check(net.ParseIP("198.51.100.8")), "buckets are per client")
+
+	// IPv6 clients share a bucket per /64
+	assert.Equal(t, acl.Allow, g.Check(net.ParseIP("2001:db8:0:1::1")))
+	assert.Equal(t, acl.Allow, g.Check(net.ParseIP("2001:db8:0:1::2")))
+	assert.Equal(t, acl.Limited, g.Check(net.ParseIP("2001:db8:0:1::3")))
+	assert.Equal(t, acl.Allow, g.Check(net.ParseIP("2001:db8:0:2::1")))
+
+	// Reloading the same limits keeps the buckets, new limits reset them
+	g.Configure(config.ACLConfig{}, config.RateLimitConfig{QPS: 0.001, Burst: 2})
+	assert.Equal(t, acl.Limited, g.Check(client))
+	assert.Equal(t, config.RateLimitActionDrop, g.Action())
+
+	g.Configure(config.ACLConfig{}, config.RateLimitConfig{QPS: 0.001, Burst: 3})
+	assert.Equal(t, acl.Allow, g.Check(client))
+}
+
+func TestGuard_ClientLimit(t *testing.T) {
+	t.Parallel()
+
+	g := acl.NewGuard()
+	g.Configure(config.ACLConfig{}, config.RateLimitConfig{QPS: 0.001, Burst: 2})
+
+	for i := range acl.MaxClients {
+		ip := net.IPv4(10, byte(i>>16), byte(i>>8), byte(i)) //nolint:gosec // test addresses
+		assert.Equal(t, acl.Allow, g.Check(ip))
+	}
+
+	// Clients beyond the limit share one bucket
+	assert.Equal(t, acl.Allow, g.Check(net.ParseIP("198.51.100.1")))
+	assert.Equal(t, acl.Allow, g.Check(net.ParseIP("198.51.100.2")))
+	assert.Equal(t, acl.Limited, g.Check(net.ParseIP("198.51.100.3")))
+
+	// Tracked clients keep their own
+	assert.Equal(t, acl.Allow, g.Check(net.IPv4(10, 0, 0, 1)))
+}
@@ -6,6 +6,8 @@ import (
 	"strings"
 
 	"github.com/go-chi/render"
+
+	"github.com/bavix/outway/internal/config"
 )
 
 type contextKey string
@@ -53,6 +55,7 @@ func AuthMiddleware(authService *Service) func(http.Handler) http.Handler {
 			ctx := context.WithValue(r.Context(), userEmailKey, claims.Email)
 			ctx = context.WithValue(ctx, userRoleKey, claims.Role)
 			ctx = context.WithValue(ctx, userClaimsKey, claims)
+			ctx = config.WithAuthor(ctx, claims.Email)
 
 			// Continue with the request
 			next.ServeHTTP(w, r.WithContext(ctx))
@@ -98,6 +101,7 @@ func OptionalAuthMiddleware(authService *Service) func(http.Handler) http.Handle
 			ctx := context.WithValue(r.Context(), userEmailKey, claims.Email)
 			ctx = context.WithValue(ctx, userRoleKey, claims.Role)
 			ctx = context.WithValue(ctx, userClaimsKey, claims)
+			ctx = config.WithAuthor(ctx, claims.Email)
 
 			// Continue with the request
 			next.ServeHTTP(w, r.WithContext(ctx))
@@ -28,6 +28,7 @@ var (
 	ErrEmailPasswordRequired   = errors.New("email and password are required")
 	ErrUnexpectedSigningMethod = errors.New("unexpected signing method")
 	ErrInvalidToken            = errors.New("invalid token")
+	ErrNoJWTSecret             = errors.New("config has no JWT secret")
 )
 
 // Service handles authentication operations.
@@ -354,13 +355,39 @@ func (s *Service) GetAuthStatus() (*AuthStatusResponse, error) {
 
 // generateAccessToken generates an access JWT token for the given user.
 func (s *Service) generateAccessToken(email, role string) (string, error) {
+	return signAccessToken(s.jwtSecret, email, role, s.accessTokenTTL)
+}
+
+// LocalSubject is the subject of tokens signed by IssueLocalToken. It is not
+// an email, so no user can hold it and config changes made with such a token
+// are recorded as made by it rather than by a user.
+const LocalSubject = "local"
+
+// IssueLocalToken signs a short-lived admin access token for LocalSubject with
+// the JWT secret of cfg. Commands running next to the service use it, so being
+// able to read the config file is enough to call the API.
+func IssueLocalToken(cfg *config.Config, ttl time.Duration) (string, error) {
+	if cfg.JWTSecret == "" {
+		return "", ErrNoJWTSecret
+	}
+
+	secret, err := base64.StdEncoding.DecodeString(cfg.JWTSecret)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode JWT secret from config: %w", err)
+	}
+
+	return signAccessToken(secret, LocalSubject, GetRoleAdmin().Name, ttl)
+}
+
+func signAccessToken(secret []byte, email, role string, ttl time.Duration) (string, error) {
+	now := time.Now()
 	claims := &Claims{
 		Email: email,
 		Role:  role,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(s.accessTokenTTL)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			NotBefore: jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
 			Issuer:    "outway",
 			Subject:   email,
 		},
@@ -368,7 +395,7 @@ func (s *Service) generateAccessToken(email, role string) (string, error) {
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
-	return token.SignedString(s.jwtSecret)
+	return token.SignedString(secret)
 }
 
 // generateRefreshToken generates a refresh token for the given user.
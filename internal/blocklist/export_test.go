@@ -0,0 +1,4 @@
+package blocklist
+
+// SetMaxListSize overrides the list size limit for tests.
+func (m *Manager) SetMaxListSize(n int64) { m.maxSize = n }
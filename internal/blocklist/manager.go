@@ -0,0 +1,394 @@
+package blocklist
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/bavix/outway/internal/config"
+)
+
+var (
+	errListStatus   = errors.New("unexpected list download status")
+	errListTooLarge = errors.New("list exceeds the size limit")
+)
+
+const (
+	// SourcePatterns identifies matches coming from the group's inline patterns.
+	SourcePatterns = "patterns"
+
+	defaultListsRefresh = 24 * time.Hour
+	defaultFetchTimeout = 30 * time.Second
+	maxListSize         = 64 << 20 // 64MB
+)
+
+// Match describes why a name is blocked and how to answer it.
+type Match struct {
+	Group    string
+	List     string
+	Mode     string
+	Sinkhole []net.IP
+}
+
+// ListStatus describes a loaded list subscription.
+type ListStatus struct {
+	Source    string    `json:"source"`
+	Entries   int       `json:"entries"`
+	Exception int       `json:"exceptions"`
+	UpdatedAt time.Time `json:"updated_at,omitzero"`
+	Error     string    `json:"error,omitempty"`
+}
+
+type list struct {
+	block     *domainSet
+	allow     *domainSet
+	updatedAt time.Time
+	err       error
+}
+
+type group struct {
+	name     string
+	mode     string
+	sinkhole []net.IP
+	patterns *domainSet
+	allow    *domainSet
+	sources  []string
+	refresh  time.Duration
+	disabled bool
+	schedule *config.Schedule // nil when the group always applies
+}
+
+// Manager keeps compiled block groups and their list subscriptions.
+type Manager struct {
+	mu     sync.RWMutex
+	groups []*group
+	lists  map[string]*list // source -> parsed list, shared between groups
+	client *http.Client
+	wake   chan struct{}
+
+	// maxSize bounds a list download or file in bytes
+	maxSize int64
+}
+
+// NewManager creates an empty manager; call SetGroups to load configuration.
+func NewManager() *Manager {
+	return &Manager{
+		lists:   make(map[string]*list),
+		client:  &http.Client{Timeout: defaultFetchTimeout},
+		wake:    make(chan struct{}, 1),
+		maxSize: maxListSize,
+	}
+}
+
+// SetGroups compiles block rule groups from config. Route groups are ignored.
+// Already downloaded lists are kept; new sources are fetched by the refresh loop.
+func (m *Manager) SetGroups(groups []config.RuleGroup) {
+	compiled := make([]*group, 0, len(groups))
+	wanted := make(map[string]struct{})
+
+	for _, g := range groups {
+		if !g.IsBlock() {
+			continue
+		}
+
+		cg := &group{
+			name:     g.Name,
+			mode:     strings.ToLower(g.BlockMode),
+			patterns: newDomainSet(),
+			allow:    newDomainSet(),
+			refresh:  g.ListsRefresh,
+			disabled: !g.IsEnabled(),
+		}
+		cg.schedule, _ = config.ParseSchedule(g.Schedule) // validated in config
+
+		if cg.mode == "" {
+			cg.mode = config.BlockModeNXDomain
+		}
+
+		for _, s := range g.Sinkhole {
+			if ip := net.ParseIP(strings.TrimSpace(s)); ip != nil {
+				cg.sinkhole = append(cg.sinkhole, ip)
+			}
+		}
+
+		for _, p := range g.Patterns {
+			cg.patterns.addPattern(p)
+		}
+
+		for _, p := range g.Allow {
+			cg.allow.addPattern(p)
+		}
+
+		for _, src := range g.Lists {
+			src = strings.TrimSpace(src)
+			if src == "" {
+				continue
+			}
+
+			cg.sources = append(cg.sources, src)
+			wanted[src] = struct{}{}
+		}
+
+		compiled = append(compiled, cg)
+	}
+
+	m.mu.Lock()
+	m.groups = compiled
+
+	missing := false
+
+	for src := range m.lists {
+		if _, ok := wanted[src]; !ok {
+			delete(m.lists, src)
+		}
+	}
+
+	for src := range wanted {
+		if _, ok := m.lists[src]; !ok {
+			missing = true
+		}
+	}
+	m.mu.Unlock()
+
+	if missing {
+		select {
+		case m.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Match reports whether name is blocked by any group. Groups are checked in
+// configuration order; a group's allow patterns and list exceptions skip that
+// group, as do disabled groups and groups outside their schedule.
+func (m *Manager) Match(name string) (Match, bool) {
+	if m == nil {
+		return Match{}, false
+	}
+
+	name = normalizeName(name)
+
+	now := time.Now()
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, g := range m.groups {
+		if g.disabled || !g.schedule.Active(now) || g.allow.match(name) {
+			continue
+		}
+
+		if g.patterns.match(name) {
+			return g.toMatch(SourcePatterns), true
+		}
+
+		for _, src := range g.sources {
+			l := m.lists[src]
+			if l == nil || l.allow.match(name) {
+				continue
+			}
+
+			if l.block.match(name) {
+				return g.toMatch(src), true
+			}
+		}
+	}
+
+	return Match{}, false
+}
+
+// HasGroups reports whether any block group is configured.
+func (m *Manager) HasGroups() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return len(m.groups) > 0
+}
+
+// Lists returns the status of all list subscriptions.
+func (m *Manager) Lists() []ListStatus {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	out := make([]ListStatus, 0, len(m.lists))
+	for src, l := range m.lists {
+		st := ListStatus{Source: src, UpdatedAt: l.updatedAt}
+		if l.block != nil {
+			st.Entries = l.block.len()
+		}
+
+		if l.allow != nil {
+			st.Exception = l.allow.len()
+		}
+
+		if l.err != nil {
+			st.Error = l.err.Error()
+		}
+
+		out = append(out, st)
+	}
+
+	return out
+}
+
+// Start loads all lists and keeps refreshing them until ctx is done.
+func (m *Manager) Start(ctx context.Context) {
+	go func() {
+		m.Refresh(ctx, false)
+
+		for {
+			timer := time.NewTimer(m.refreshInterval())
+
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+
+				return
+			case <-m.wake:
+				timer.Stop()
+				m.Refresh(ctx, true)
+			case <-timer.C:
+				m.Refresh(ctx, false)
+			}
+		}
+	}()
+}
+
+// Refresh downloads list sources. With onlyMissing set, sources that are
+// already loaded are left untouched. A failed download keeps the previous entries.
+func (m *Manager) Refresh(ctx context.Context, onlyMissing bool) {
+	m.mu.RLock()
+
+	sources := make([]string, 0)
+	seen := make(map[string]struct{})
+
+	for _, g := range m.groups {
+		for _, src := range g.sources {
+			if _, ok := seen[src]; ok {
+				continue
+			}
+
+			seen[src] = struct{}{}
+
+			if l, ok := m.lists[src]; onlyMissing && ok && l.err == nil {
+				continue
+			}
+
+			sources = append(sources, src)
+		}
+	}
+	m.mu.RUnlock()
+
+	for _, src := range sources {
+		block, allow, err := m.load(ctx, src)
+
+		m.mu.Lock()
+		prev := m.lists[src]
+
+		switch {
+		case err == nil:
+			m.lists[src] = &list{block: block, allow: allow, updatedAt: time.Now()}
+		case prev != nil:
+			prev.err = err
+		default:
+			m.lists[src] = &list{block: newDomainSet(), allow: newDomainSet(), err: err}
+		}
+		m.mu.Unlock()
+
+		if err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Str("list", src).Msg("failed to load block list")
+
+			continue
+		}
+
+		zerolog.Ctx(ctx).Info().
+			Str("list", src).
+			Int("entries", block.len()).
+			Int("exceptions", allow.len()).
+			Msg("block list loaded")
+	}
+}
+
+func (m *Manager) refreshInterval() time.Duration {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	interval := defaultListsRefresh
+
+	for _, g := range m.groups {
+		if g.refresh > 0 && g.refresh < interval {
+			interval = g.refresh
+		}
+	}
+
+	return interval
+}
+
+func (m *Manager) load(ctx context.Context, src string) (*domainSet, *domainSet, error) {
+	data, err := m.read(ctx, src)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return parseList(bytes.NewReader(data))
+}
+
+// read fetches a list. Lists over the size limit are rejected rather than
+// truncated, so the previous version stays in use.
+func (m *Manager) read(ctx context.Context, src string) ([]byte, error) {
+	if !strings.HasPrefix(src, "http://") && !strings.HasPrefix(src, "https://") {
+		f, err := os.Open(strings.TrimPrefix(src, "file://")) //nolint:gosec // list path comes from config
+		if err != nil {
+			return nil, err
+		}
+
+		defer func() { _ = f.Close() }()
+
+		return m.readLimited(f)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %d", errListStatus, resp.StatusCode)
+	}
+
+	return m.readLimited(resp.Body)
+}
+
+func (m *Manager) readLimited(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, m.maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(data)) > m.maxSize {
+		return nil, fmt.Errorf("%w of %d bytes", errListTooLarge, m.maxSize)
+	}
+
+	return data, nil
+}
+
+func (g *group) toMatch(src string) Match {
+	return Match{Group: g.name, List: src, Mode: g.mode, Sinkhole: g.sinkhole}
+}
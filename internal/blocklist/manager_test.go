@@ -0,0 +1,221 @@
+package blocklist_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bavix/outway/internal/blocklist"
+	"github.com/bavix/outway/internal/config"
+)
+
+const sampleList = `# hosts section
+0.0.0.0 localhost
+0.0.0.0 tracker.example.com ads.example.com # trailing comment
+127.0.0.1 metrics.example.org
+
+! adblock section
+[Adblock Plus 2.0]
+||doubleclick.net^
+||cdn.ads.net^$third-party
+||example.net/path^
+@@||good.doubleclick.net^
+
+plain.example.io
+*.wild.example.io
+*
+`
+
+func TestManager_MatchPatterns(t *testing.T) {
+	t.Parallel()
+
+	m := blocklist.NewManager()
+	m.SetGroups([]config.RuleGroup{
+		{Name: "vpn", Via: "utun4", Patterns: []string{"*.blocked.com"}},
+		{
+			Name:      "ads",
+			Action:    config.RuleActionBlock,
+			BlockMode: config.BlockModeSinkhole,
+			Sinkhole:  []string{"10.0.0.1"},
+			Patterns:  []string{"*.blocked.com", "exact.com"},
+			Allow:     []string{"ok.blocked.com"},
+		},
+	})
+
+	require.True(t, m.HasGroups())
+
+	match, ok := m.Match("Sub.Blocked.com.")
+	require.True(t, ok)
+	assert.Equal(t, "ads", match.Group)
+	assert.Equal(t, blocklist.SourcePatterns, match.List)
+	assert.Equal(t, config.BlockModeSinkhole, match.Mode)
+	require.Len(t, match.Sinkhole, 1)
+	assert.Equal(t, "10.0.0.1", match.Sinkhole[0].String())
+
+	_, ok = m.Match("blocked.com")
+	assert.True(t, ok)
+
+	_, ok = m.Match("exact.com")
+	assert.True(t, ok)
+
+	_, ok = m.Match("sub.exact.com")
+	assert.False(t, ok)
+
+	_, ok = m.Match("ok.blocked.com")
+	assert.False(t, ok)
+}
+
+func TestManager_RefreshFileList(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "list.txt")
+	require.NoError(t, os.WriteFile(path, []byte(sampleList), 0o600))
+
+	m := blocklist.NewManager()
+	m.SetGroups([]config.RuleGroup{{Name: "ads", Action: config.RuleActionBlock, Lists: []string{path}}})
+	m.Refresh(context.Background(), false)
+
+	blocked := []string{
+		"tracker.example.com", "ads.example.com", "metrics.example.org",
+		"doubleclick.net", "x.doubleclick.net", "cdn.ads.net",
+		"plain.example.io", "wild.example.io", "a.wild.example.io",
+	}
+	for _, name := range blocked {
+		match, ok := m.Match(name)
+		assert.True(t, ok, name)
+		assert.Equal(t, path, match.List, name)
+		assert.Equal(t, config.BlockModeNXDomain, match.Mode, name)
+	}
+
+	allowed := []string{"localhost", "good.doubleclick.net", "example.net", "sub.tracker.example.com", "example.com"}
+	for _, name := range allowed {
+		_, ok := m.Match(name)
+		assert.False(t, ok, name)
+	}
+
+	lists := m.Lists()
+	require.Len(t, lists, 1)
+	assert.Equal(t, path, lists[0].Source)
+	assert.Equal(t, 1, lists[0].Exception)
+	assert.Empty(t, lists[0].Error)
+}
+
+func TestManager_RefreshKeepsEntriesOnFailure(t *testing.T) {
+	t.Parallel()
+
+	var fail atomic.Bool
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if fail.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		_, _ = w.Write([]byte("||ads.example.com^\n"))
+	}))
+	t.Cleanup(srv.Close)
+
+	m := blocklist.NewManager()
+	m.SetGroups([]config.RuleGroup{{Name: "ads", Action: config.RuleActionBlock, Lists: []string{srv.URL}}})
+	m.Refresh(context.Background(), false)
+
+	_, ok := m.Match("ads.example.com")
+	require.True(t, ok)
+
+	fail.Store(true)
+
+	m.Refresh(context.Background(), false)
+
+	_, ok = m.Match("ads.example.com")
+	assert.True(t, ok)
+
+	lists := m.Lists()
+	require.Len(t, lists, 1)
+	assert.NotEmpty(t, lists[0].Error)
+}
+
+func TestManager_RefreshRejectsOversizedList(t *testing.T) {
+	t.Parallel()
+
+	body := "||ads.example.com^\n"
+
+	var large atomic.Bool
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if large.Load() {
+			_, _ = w.Write([]byte(body + "||tracker.example.com^\n"))
+
+			return
+		}
+
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+
+	m := blocklist.NewManager()
+	m.SetMaxListSize(int64(len(body)))
+	m.SetGroups([]config.RuleGroup{{Name: "ads", Action: config.RuleActionBlock, Lists: []string{srv.URL}}})
+	m.Refresh(context.Background(), false)
+
+	_, ok := m.Match("ads.example.com")
+	require.True(t, ok, "a list of exactly the limit is accepted")
+
+	// An oversized list is not truncated into a partial one
+	large.Store(true)
+	m.Refresh(context.Background(), false)
+
+	_, ok = m.Match("ads.example.com")
+	assert.True(t, ok)
+
+	_, ok = m.Match("tracker.example.com")
+	assert.False(t, ok)
+
+	lists := m.Lists()
+	require.Len(t, lists, 1)
+	assert.Contains(t, lists[0].Error, "size limit")
+}
+
+func TestManager_SetGroupsPrunesLists(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "list.txt")
+	require.NoError(t, os.WriteFile(path, []byte("ads.example.com\n"), 0o600))
+
+	m := blocklist.NewManager()
+	m.SetGroups([]config.RuleGroup{{Name: "ads", Action: config.RuleActionBlock, Lists: []string{path}}})
+	m.Refresh(context.Background(), false)
+	require.Len(t, m.Lists(), 1)
+
+	m.SetGroups(nil)
+	assert.False(t, m.HasGroups())
+	assert.Empty(t, m.Lists())
+
+	_, ok := m.Match("ads.example.com")
+	assert.False(t, ok)
+}
+
+func TestManager_SkipsInactiveGroups(t *testing.T) {
+	t.Parallel()
+
+	disabled := false
+
+	m := blocklist.NewManager()
+	m.SetGroups([]config.RuleGroup{
+		{Name: "off", Action: config.RuleActionBlock, Enabled: &disabled, Patterns: []string{"off.example"}},
+		{Name: "always", Action: config.RuleActionBlock, Schedule: []string{"00:00-24:00"}, Patterns: []string{"always.example"}},
+	})
+
+	_, ok := m.Match("off.example")
+	assert.False(t, ok)
+
+	_, ok = m.Match("always.example")
+	assert.True(t, ok)
+}
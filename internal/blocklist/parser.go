@@ -0,0 +1,180 @@
+// Package blocklist compiles block rule groups and domain list subscriptions
+// (hosts, adblock and plain domain formats) into fast name matchers.
+package blocklist
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+)
+
+// domainSet matches names exactly or together with all their subdomains.
+type domainSet struct {
+	exact  map[string]struct{}
+	suffix map[string]struct{}
+	all    bool
+}
+
+func newDomainSet() *domainSet {
+	return &domainSet{
+		exact:  make(map[string]struct{}),
+		suffix: make(map[string]struct{}),
+	}
+}
+
+// addPattern adds a rule pattern using the same semantics as routing rules:
+// "*.example.com" matches example.com and its subdomains, "*" matches everything,
+// anything else is an exact match.
+func (s *domainSet) addPattern(pattern string) {
+	p := normalizeName(pattern)
+
+	switch {
+	case p == "" || p == "*":
+		s.all = true
+	case strings.HasPrefix(p, "*."):
+		s.suffix[strings.TrimPrefix(p, "*.")] = struct{}{}
+	default:
+		s.exact[p] = struct{}{}
+	}
+}
+
+func (s *domainSet) len() int {
+	return len(s.exact) + len(s.suffix)
+}
+
+func (s *domainSet) match(name string) bool {
+	if s == nil {
+		return false
+	}
+
+	if s.all {
+		return true
+	}
+
+	if _, ok := s.exact[name]; ok {
+		return true
+	}
+
+	if len(s.suffix) == 0 {
+		return false
+	}
+
+	for n := name; n != ""; {
+		if _, ok := s.suffix[n]; ok {
+			return true
+		}
+
+		i := strings.IndexByte(n, '.')
+		if i < 0 {
+			break
+		}
+
+		n = n[i+1:]
+	}
+
+	return false
+}
+
+// parseList reads a list in hosts, adblock or plain domain format.
+// Adblock "||domain^" rules block the domain and its subdomains, "@@||domain^"
+// rules become exceptions; hosts and plain entries block exact names unless
+// written as "*.domain".
+func parseList(r io.Reader) (*domainSet, *domainSet, error) {
+	block := newDomainSet()
+	allow := newDomainSet()
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), 1<<20) //nolint:mnd // 1MB max line
+
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' || line[0] == '!' || line[0] == '[' {
+			continue
+		}
+
+		if i := strings.IndexByte(line, '#'); i > 0 {
+			line = strings.TrimSpace(line[:i])
+		}
+
+		switch {
+		case strings.HasPrefix(line, "@@||"):
+			if d, ok := parseAdblockDomain(strings.TrimPrefix(line, "@@||")); ok {
+				allow.suffix[d] = struct{}{}
+			}
+		case strings.HasPrefix(line, "||"):
+			if d, ok := parseAdblockDomain(strings.TrimPrefix(line, "||")); ok {
+				block.suffix[d] = struct{}{}
+			}
+		default:
+			parseHostsOrPlain(line, block)
+		}
+	}
+
+	if err := sc.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return block, allow, nil
+}
+
+// parseAdblockDomain extracts the domain from the body of a "||domain^$opts" rule.
+// Rules with paths or wildcards inside the domain cannot be enforced by DNS and are skipped.
+func parseAdblockDomain(rule string) (string, bool) {
+	end := strings.IndexAny(rule, "^$|/")
+	if end >= 0 {
+		if rule[end] == '/' {
+			return "", false
+		}
+
+		rule = rule[:end]
+	}
+
+	d := normalizeName(rule)
+	if d == "" || strings.ContainsAny(d, "*") {
+		return "", false
+	}
+
+	return d, true
+}
+
+// parseHostsOrPlain handles "0.0.0.0 a.com b.com" and "a.com" lines.
+func parseHostsOrPlain(line string, block *domainSet) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return
+	}
+
+	if net.ParseIP(fields[0]) != nil {
+		for _, f := range fields[1:] {
+			d := normalizeName(f)
+			if d == "" || isLocalHostsName(d) {
+				continue
+			}
+
+			block.exact[d] = struct{}{}
+		}
+
+		return
+	}
+
+	// A bare "*" in a downloaded list would block everything; require a dotted name.
+	if len(fields) == 1 && strings.Contains(fields[0], ".") {
+		block.addPattern(fields[0])
+	}
+}
+
+func isLocalHostsName(name string) bool {
+	switch name {
+	case "localhost", "localhost.localdomain", "local", "broadcasthost",
+		"ip6-localhost", "ip6-loopback", "ip6-localnet", "ip6-mcastprefix",
+		"ip6-allnodes", "ip6-allrouters", "ip6-allhosts", "0.0.0.0":
+		return true
+	default:
+		return false
+	}
+}
+
+func normalizeName(name string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+}
@@ -1,11 +1,14 @@
 package config
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"math"
 	"net"
 	"net/url"
 	"os"
+	"path/filepath"
 	"slices"
 	"strings"
 	"sync"
@@ -17,6 +20,10 @@ import (
 const (
 	// MaxDNSNameLength is the maximum length of a DNS name (RFC 1035).
 	MaxDNSNameLength = 253
+
+	// DefaultCacheSnapshotInterval is how often the cache snapshot is written
+	// when cache.snapshot_interval is not set.
+	DefaultCacheSnapshotInterval = 5 * time.Minute
 )
 
 var (
@@ -29,13 +36,17 @@ var (
 	errUpstreamInvalidWeight         = errors.New("upstream has invalid weight")
 	errRuleGroupNameCannotBeEmpty    = errors.New("rule group name cannot be empty")
 	errDuplicateRuleGroupName        = errors.New("duplicate rule group name")
-	errRuleGroupMustHavePattern      = errors.New("rule group must have at least one pattern")
 	errRuleGroupRequiresViaInterface = errors.New("rule group requires via interface")
 	errRuleGroupContainsEmptyPattern = errors.New("rule group contains empty pattern")
 	errDuplicateRulePattern          = errors.New("duplicate rule pattern")
 	errAddressMustBeHostPort         = errors.New("address must be host:port or :port")
 	errCacheTTLBoundsMustBeNonNeg    = errors.New("cache ttl bounds must be non-negative")
 	errCacheMinTTLGreaterThanMax     = errors.New("cache min_ttl_seconds cannot be greater than max_ttl_seconds")
+	errCacheSnapshotInterval         = errors.New("cache snapshot_interval must be non-negative")
+	errCachePrefetchAt               = errors.New("cache prefetch_at must be between 0 and 1")
+	errCacheNegativeTTLBounds        = errors.New("cache negative ttl bounds must be non-negative and min <= max")
+	errCacheServFailTTL              = errors.New("cache servfail_ttl_seconds must be between 0 and 300")
+	errCachePrefetchMinHits          = errors.New("cache prefetch_min_hits must be non-negative")
 
 	// HostOverride validation errors.
 	errHostPatternEmpty             = errors.New("host pattern cannot be empty")
@@ -43,7 +54,13 @@ var (
 	errDomainLabelTooLongOrEmpty    = errors.New("invalid domain pattern: label too long or empty")
 	errDomainLabelCannotStartHyphen = errors.New("invalid domain pattern: label cannot start with hyphen")
 	errDomainLabelCannotEndHyphen   = errors.New("invalid domain pattern: label cannot end with hyphen")
-	errHostOverrideNoRecords        = errors.New("host override must have at least one A or AAAA record")
+	errHostOverrideNoRecords        = errors.New("host override must have at least one record")
+	errHostCNAMEWithOtherRecords    = errors.New("cname cannot be combined with other records")
+	errHostCNAMELoop                = errors.New("cname cannot point to its own pattern")
+	errTXTRecordEmpty               = errors.New("txt record cannot be empty")
+	errMXRecordInvalid              = errors.New("invalid mx record")
+	errSRVRecordInvalid             = errors.New("invalid srv record")
+	errHostViaInvalid               = errors.New("invalid via interface")
 	errARecordEmpty                 = errors.New("a record cannot be empty")
 	errARecordInvalidIPv4           = errors.New("invalid IPv4 address in a record")
 	errARecordNotIPv4               = errors.New("a record must be IPv4 address")
@@ -52,6 +69,37 @@ var (
 	errAAAARecordNotIPv6            = errors.New("aaaa record must be IPv6 address")
 	errTTLTooLarge                  = errors.New("ttl too large")
 	errDomainInvalidCharacter       = errors.New("invalid domain pattern: invalid character in label")
+
+	// Block rule group validation errors.
+	errRuleGroupInvalidAction      = errors.New("rule group has invalid action")
+	errBlockGroupMustHaveSource    = errors.New("block rule group must have at least one pattern or list")
+	errBlockGroupInvalidMode       = errors.New("block rule group has invalid block_mode")
+	errBlockGroupInvalidSinkhole   = errors.New("block rule group has invalid sinkhole address")
+	errBlockGroupListsRefreshShort = errors.New("block rule group lists_refresh is too short")
+
+	// RPZ validation errors.
+	errRPZNameEmpty      = errors.New("rpz zone name cannot be empty")
+	errRPZDuplicateName  = errors.New("duplicate rpz zone name")
+	errRPZMustHaveSource = errors.New("rpz zone must have either file or axfr")
+	errRPZRefreshShort   = errors.New("rpz refresh is too short")
+
+	errECSInvalidMode   = errors.New("invalid ecs mode")
+	errECSInvalidPrefix = errors.New("invalid ecs prefix")
+	errECSInvalidBits   = errors.New("invalid ecs prefix length")
+
+	errRuleGroupInvalidFamily = errors.New("rule group has invalid address_family")
+	errRuleGroupClientTTL     = errors.New("rule group client ttl bounds must be non-negative and min <= max")
+
+	errACLInvalidEntry        = errors.New("acl allow entry must be an address or CIDR")
+	errRateLimitNegative      = errors.New("rate_limit qps and burst must be non-negative")
+	errRateLimitInvalidAction = errors.New("rate_limit has invalid action")
+
+	errHistoryNegative = errors.New("history settings must be non-negative")
+
+	errDnstapInvalidAddress = errors.New("dnstap address must be unix://, tcp:// or file://")
+	errDnstapBufferSize     = errors.New("dnstap buffer_size must be non-negative")
+
+	errHTTPTLSPair = errors.New("http tls_cert_file and tls_key_file must be set together")
 )
 
 const (
@@ -65,6 +113,72 @@ const (
 	// Protocol constants.
 	protocolDot = "dot"
 	protocolTLS = "tls"
+
+	minListsRefresh = time.Minute
+	minRPZRefresh   = time.Minute
+
+	defaultCachePrefetchAt       = 0.9
+	defaultCachePrefetchMinHits  = 3
+	defaultNegativeMaxTTLSeconds = 900
+	defaultServFailTTLSeconds    = 5
+	maxServFailTTLSeconds        = 300 // RFC 2308 section 7.1
+)
+
+// Rule group actions.
+const (
+	// RuleActionRoute marks resolved IPs and routes them via the group interface (default).
+	RuleActionRoute = "route"
+	// RuleActionBlock answers matching queries locally without contacting upstreams.
+	RuleActionBlock = "block"
+)
+
+// Block modes for rule groups with action "block".
+const (
+	// BlockModeNXDomain answers blocked names with NXDOMAIN (default).
+	BlockModeNXDomain = "nxdomain"
+	// BlockModeNoData answers blocked names with NOERROR and an empty answer section.
+	BlockModeNoData = "nodata"
+	// BlockModeSinkhole answers A/AAAA queries with the configured sinkhole addresses.
+	BlockModeSinkhole = "sinkhole"
+)
+
+// Address families answered for names of a rule group.
+const (
+	// AddressFamilyAny leaves A and AAAA answers untouched (default).
+	AddressFamilyAny = "any"
+	// AddressFamilyIPv4Only removes IPv6 addresses from answers.
+	AddressFamilyIPv4Only = "ipv4_only"
+	// AddressFamilyIPv6Only removes IPv4 addresses from answers.
+	AddressFamilyIPv6Only = "ipv6_only"
+	// AddressFamilyPreferIPv4 removes IPv6 addresses of names that also have IPv4 ones.
+	AddressFamilyPreferIPv4 = "prefer_ipv4"
+)
+
+// Responses to clients over their rate limit.
+const (
+	// RateLimitActionDrop sends no answer at all (default).
+	RateLimitActionDrop = "drop"
+	// RateLimitActionRefuse answers with REFUSED.
+	RateLimitActionRefuse = "refuse"
+	// RateLimitActionTruncate answers UDP queries with TC set so real clients retry over TCP.
+	RateLimitActionTruncate = "truncate"
+)
+
+// EDNS Client Subnet policies for queries sent upstream.
+const (
+	// ECSModePassthrough forwards the client's ECS option as received (default).
+	ECSModePassthrough = "passthrough"
+	// ECSModeStrip removes the ECS option before the query leaves the proxy.
+	ECSModeStrip = "strip"
+	// ECSModeFixed replaces the ECS option with the configured prefix.
+	ECSModeFixed = "fixed"
+	// ECSModeVia derives the ECS option from the address of the rule's via interface.
+	ECSModeVia = "via"
+
+	// DefaultECSIPv4Bits and DefaultECSIPv6Bits are the source prefix lengths
+	// used in via mode, the usual privacy-preserving sizes from RFC 7871.
+	DefaultECSIPv4Bits = 24
+	DefaultECSIPv6Bits = 56
 )
 
 func detectType(addr string) string {
@@ -100,21 +214,50 @@ type ListenConfig struct {
 	TCP string `yaml:"tcp"`
 }
 
+// ECSPolicy controls the EDNS Client Subnet option sent upstream.
+type ECSPolicy struct {
+	Mode     string `json:"mode"                yaml:"mode"`                // passthrough (default), strip, fixed or via
+	Prefix   string `json:"prefix,omitempty"    yaml:"prefix,omitempty"`    // CIDR sent in fixed mode
+	IPv4Bits int    `json:"ipv4_bits,omitempty" yaml:"ipv4_bits,omitempty"` // source prefix length in via mode (default 24)
+	IPv6Bits int    `json:"ipv6_bits,omitempty" yaml:"ipv6_bits,omitempty"` // source prefix length in via mode (default 56)
+}
+
+// Validate checks the policy mode and its parameters.
+func (p *ECSPolicy) Validate() error {
+	switch strings.ToLower(p.Mode) {
+	case "", ECSModePassthrough, ECSModeStrip, ECSModeVia:
+	case ECSModeFixed:
+		if _, _, err := net.ParseCIDR(strings.TrimSpace(p.Prefix)); err != nil {
+			return fmt.Errorf("%w: %s", errECSInvalidPrefix, p.Prefix)
+		}
+	default:
+		return fmt.Errorf("%w: %s", errECSInvalidMode, p.Mode)
+	}
+
+	if p.IPv4Bits < 0 || p.IPv4Bits > net.IPv4len*8 || p.IPv6Bits < 0 || p.IPv6Bits > net.IPv6len*8 {
+		return errECSInvalidBits
+	}
+
+	return nil
+}
+
 // UpstreamConfig defines a DNS upstream server.
 type UpstreamConfig struct {
-	Name    string `json:"name"             yaml:"name"`
-	Address string `json:"address"          yaml:"address"`
-	Type    string `json:"type,omitempty"   yaml:"type,omitempty"` // optional; autodetected when empty
-	Weight  int    `json:"weight,omitempty" yaml:"weight,omitempty"`
+	Name    string     `json:"name"             yaml:"name"`
+	Address string     `json:"address"          yaml:"address"`
+	Type    string     `json:"type,omitempty"   yaml:"type,omitempty"` // optional; autodetected when empty
+	Weight  int        `json:"weight,omitempty" yaml:"weight,omitempty"`
+	ECS     *ECSPolicy `json:"ecs,omitempty"    yaml:"ecs,omitempty"` // client subnet policy; a matching rule group's policy wins
 }
 
 // MarshalYAML implements custom YAML marshaling for UpstreamConfig,
 // omitting the derived Type field and normalizing weight.
 func (u UpstreamConfig) MarshalYAML() (any, error) {
 	type out struct {
-		Name    string `yaml:"name"`
-		Address string `yaml:"address"`
-		Weight  int    `yaml:"weight,omitempty"`
+		Name    string     `yaml:"name"`
+		Address string     `yaml:"address"`
+		Weight  int        `yaml:"weight,omitempty"`
+		ECS     *ECSPolicy `yaml:"ecs,omitempty"`
 	}
 
 	w := u.Weight
@@ -122,17 +265,18 @@ func (u UpstreamConfig) MarshalYAML() (any, error) {
 		w = 1
 	}
 
-	return out{Name: u.Name, Address: u.Address, Weight: w}, nil
+	return out{Name: u.Name, Address: u.Address, Weight: w, ECS: u.ECS}, nil
 }
 
 // UnmarshalYAML implements custom YAML unmarshaling for UpstreamConfig.
 // It derives Type from Address if omitted and normalizes weight.
 func (u *UpstreamConfig) UnmarshalYAML(unmarshal func(any) error) error {
 	type in struct {
-		Name    string `yaml:"name"`
-		Address string `yaml:"address"`
-		Type    string `yaml:"type,omitempty"`
-		Weight  int    `yaml:"weight,omitempty"`
+		Name    string     `yaml:"name"`
+		Address string     `yaml:"address"`
+		Type    string     `yaml:"type,omitempty"`
+		Weight  int        `yaml:"weight,omitempty"`
+		ECS     *ECSPolicy `yaml:"ecs,omitempty"`
 	}
 
 	var tmp in
@@ -141,6 +285,7 @@ func (u *UpstreamConfig) UnmarshalYAML(unmarshal func(any) error) error {
 	}
 
 	u.Name = strings.TrimSpace(tmp.Name)
+	u.ECS = tmp.ECS
 
 	u.Address = strings.TrimSpace(tmp.Address)
 	if tmp.Weight <= 0 {
@@ -160,28 +305,121 @@ func (u *UpstreamConfig) UnmarshalYAML(unmarshal func(any) error) error {
 
 // Rule defines a DNS routing rule for internal use.
 type Rule struct {
-	Pattern string
-	Via     string
-	PinTTL  bool
+	Group         string // name of the rule group the rule belongs to
+	Pattern       string
+	Via           string
+	PinTTL        bool
+	ECS           *ECSPolicy
+	AddressFamily string
+	ClientTTLMin  uint32
+	ClientTTLMax  uint32
+	Disabled      bool
+	Schedule      *Schedule // nil when the group always applies
+}
+
+// Active reports whether the rule applies at t.
+func (r *Rule) Active(t time.Time) bool {
+	return !r.Disabled && r.Schedule.Active(t)
 }
 
 // RuleGroup defines a group of related DNS rules.
 type RuleGroup struct {
 	Name        string   `yaml:"name"`
 	Description string   `yaml:"description,omitempty"`
-	Via         string   `yaml:"via"`
-	Patterns    []string `yaml:"patterns"`
+	Action      string   `yaml:"action,omitempty"` // route (default) or block
+	Via         string   `yaml:"via,omitempty"`
+	Patterns    []string `yaml:"patterns,omitempty"`
 	PinTTL      bool     `yaml:"pin_ttl,omitempty"`
+
+	// Enabled turns the group off without deleting it (default true).
+	Enabled *bool `yaml:"enabled,omitempty"`
+	// Schedule limits the group to weekly time windows in local time, e.g. "mon-fri 09:00-18:00".
+	Schedule []string `yaml:"schedule,omitempty"`
+
+	// ECS overrides the client subnet policy of upstreams for matching names.
+	ECS *ECSPolicy `yaml:"ecs,omitempty"`
+	// AddressFamily filters answers for interfaces that only carry one family:
+	// any (default), ipv4_only, ipv6_only or prefer_ipv4.
+	AddressFamily string `yaml:"address_family,omitempty"`
+	// ClientTTLMin and ClientTTLMax clamp answer TTLs handed to clients, in seconds (0 = unbounded).
+	ClientTTLMin int `yaml:"client_ttl_min,omitempty"`
+	ClientTTLMax int `yaml:"client_ttl_max,omitempty"`
+
+	// Block settings (used only when Action is "block").
+	BlockMode    string        `yaml:"block_mode,omitempty"`    // nxdomain (default), nodata or sinkhole
+	Sinkhole     []string      `yaml:"sinkhole,omitempty"`      // IPv4/IPv6 answers for sinkhole mode
+	Lists        []string      `yaml:"lists,omitempty"`         // hosts/adblock/domain list URLs or file paths
+	Allow        []string      `yaml:"allow,omitempty"`         // patterns that are never blocked by this group
+	ListsRefresh time.Duration `yaml:"lists_refresh,omitempty"` // list re-download interval (default 24h)
+}
+
+// Rules returns the routing rules of the group, one per pattern.
+func (g *RuleGroup) Rules() []Rule {
+	rules := make([]Rule, 0, len(g.Patterns))
+	schedule, _ := ParseSchedule(g.Schedule) // validated in config
+
+	for _, pattern := range g.Patterns {
+		rules = append(rules, Rule{
+			Group:         g.Name,
+			Pattern:       pattern,
+			Via:           g.Via,
+			PinTTL:        g.PinTTL,
+			ECS:           g.ECS,
+			AddressFamily: strings.ToLower(g.AddressFamily),
+			ClientTTLMin:  uint32(max(g.ClientTTLMin, 0)), //nolint:gosec // bounds validated in config
+			ClientTTLMax:  uint32(max(g.ClientTTLMax, 0)), //nolint:gosec // bounds validated in config
+			Disabled:      !g.IsEnabled(),
+			Schedule:      schedule,
+		})
+	}
+
+	return rules
+}
+
+// IsEnabled reports whether the group is switched on; its schedule may still
+// keep it inactive.
+func (g *RuleGroup) IsEnabled() bool {
+	return g.Enabled == nil || *g.Enabled
+}
+
+// Active reports whether the group is enabled and inside its schedule at t.
+func (g *RuleGroup) Active(t time.Time) bool {
+	if !g.IsEnabled() {
+		return false
+	}
+
+	schedule, err := ParseSchedule(g.Schedule)
+
+	return err != nil || schedule.Active(t)
+}
+
+// IsBlock reports whether the group blocks matching names instead of routing them.
+func (g *RuleGroup) IsBlock() bool {
+	return strings.EqualFold(g.Action, RuleActionBlock)
 }
 
 // HistoryConfig defines query history settings.
 type HistoryConfig struct {
-	Enabled       bool `yaml:"enabled,omitempty"`
-	MaxEntries    int  `yaml:"max_entries,omitempty"`
-	Retention     int  `yaml:"retention_hours,omitempty"`
-	BufferSize    int  `yaml:"buffer_size,omitempty"`
-	FlushInterval int  `yaml:"flush_interval_ms,omitempty"`
-	Compression   bool `yaml:"compression,omitempty"`
+	Enabled    bool `yaml:"enabled,omitempty"`
+	MaxEntries int  `yaml:"max_entries,omitempty"`
+	// Path is a directory keeping history across restarts; empty keeps it in memory only
+	Path string `yaml:"path,omitempty"`
+	// Retention removes rotated query log files and persisted history older than this many hours (0 = keep all)
+	Retention int `yaml:"retention_hours,omitempty"`
+	// BufferSize is the write buffer of the query log file in bytes (default 64 KiB)
+	BufferSize int `yaml:"buffer_size,omitempty"`
+	// FlushInterval bounds how long buffered entries wait before reaching the file (default 1000ms)
+	FlushInterval int `yaml:"flush_interval_ms,omitempty"`
+	// Compression gzips rotated query log files
+	Compression bool `yaml:"compression,omitempty"`
+	// File is the NDJSON query log; empty disables the file sink
+	File string `yaml:"file,omitempty"`
+	// MaxSizeMB rotates the query log once it grows past this size (default 100)
+	MaxSizeMB int `yaml:"max_size_mb,omitempty"`
+	// RotateHours rotates the query log once it is this old (0 = size only)
+	RotateHours int `yaml:"rotate_hours,omitempty"`
+	// Syslog also sends every query to the local syslog (journald on systemd hosts)
+	Syslog bool `yaml:"syslog,omitempty"`
 }
 
 // LogConfig defines logging configuration (simplified - only level used).
@@ -200,6 +438,42 @@ type CacheConfig struct {
 	MaxTTLSeconds int `yaml:"max_ttl_seconds,omitempty"`
 	// ServeStale enables serve-stale with background refresh (default true)
 	ServeStale bool `yaml:"serve_stale,omitempty"`
+	// NegativeMinTTLSeconds bounds the minimum TTL of NXDOMAIN/NODATA answers (default 0, SOA minimum is used)
+	NegativeMinTTLSeconds int `yaml:"negative_min_ttl_seconds,omitempty"`
+	// NegativeMaxTTLSeconds bounds the maximum TTL of NXDOMAIN/NODATA answers (default 900s, 0 = unbounded)
+	NegativeMaxTTLSeconds *int `yaml:"negative_max_ttl_seconds,omitempty"`
+	// ServFailTTLSeconds caches SERVFAIL answers for this long (default 5s, at most 300s, 0 = not cached)
+	ServFailTTLSeconds *int `yaml:"servfail_ttl_seconds,omitempty"`
+	// SnapshotPath persists the cache to this file across restarts (disabled when empty)
+	SnapshotPath string `yaml:"snapshot_path,omitempty"`
+	// SnapshotInterval is how often the snapshot is written (default 5m)
+	SnapshotInterval time.Duration `yaml:"snapshot_interval,omitempty"`
+	// Prefetch refreshes popular entries shortly before they expire
+	Prefetch bool `yaml:"prefetch,omitempty"`
+	// PrefetchAt is the fraction of the TTL after which hot entries are refreshed (default 0.9)
+	PrefetchAt float64 `yaml:"prefetch_at,omitempty"`
+	// PrefetchMinHits is the number of hits during the TTL that makes an entry hot (default 3)
+	PrefetchMinHits int `yaml:"prefetch_min_hits,omitempty"`
+}
+
+// NegativeMaxTTL returns the maximum TTL of negative answers, the default
+// raised to NegativeMinTTLSeconds when unset (0 = unbounded).
+func (c *CacheConfig) NegativeMaxTTL() int {
+	if c.NegativeMaxTTLSeconds == nil {
+		return max(defaultNegativeMaxTTLSeconds, c.NegativeMinTTLSeconds)
+	}
+
+	return *c.NegativeMaxTTLSeconds
+}
+
+// ServFailTTL returns how long SERVFAIL answers are cached, the default when
+// unset (0 = not cached).
+func (c *CacheConfig) ServFailTTL() int {
+	if c.ServFailTTLSeconds == nil {
+		return defaultServFailTTLSeconds
+	}
+
+	return *c.ServFailTTLSeconds
 }
 
 // HTTPConfig defines HTTP admin server settings.
@@ -211,6 +485,15 @@ type HTTPConfig struct {
 	IdleTimeout    time.Duration `yaml:"idle_timeout,omitempty"`
 	MaxHeaderBytes int           `yaml:"max_header_bytes,omitempty"`
 	MaxRequestSize int64         `yaml:"max_request_size,omitempty"` // Max request body size in bytes (default 1MB)
+
+	// TLSCertFile and TLSKeyFile serve the admin UI and API over HTTPS when both are set
+	TLSCertFile string `yaml:"tls_cert_file,omitempty"`
+	TLSKeyFile  string `yaml:"tls_key_file,omitempty"`
+}
+
+// TLS reports whether the admin UI and API are served over HTTPS.
+func (h HTTPConfig) TLS() bool {
+	return h.TLSCertFile != "" && h.TLSKeyFile != ""
 }
 
 // UpdateConfig defines automatic update settings.
@@ -219,10 +502,42 @@ type UpdateConfig struct {
 	IncludePrerelease bool `json:"include_prerelease" yaml:"include_prerelease,omitempty"`
 }
 
+// RPZConfig defines a Response Policy Zone loaded from a zone file or transferred via AXFR.
+type RPZConfig struct {
+	Name    string        `json:"name"              yaml:"name"`              // zone origin, e.g. rpz.example.org
+	File    string        `json:"file,omitempty"    yaml:"file,omitempty"`    // zone file path, reloaded on change
+	AXFR    string        `json:"axfr,omitempty"    yaml:"axfr,omitempty"`    // primary server host:port to transfer the zone from
+	Refresh time.Duration `json:"refresh,omitempty" yaml:"refresh,omitempty"` // AXFR re-transfer interval (default 1h)
+}
+
+// ACLConfig restricts which clients may query the DNS listener.
+type ACLConfig struct {
+	// Allow lists client addresses or CIDRs; other clients are REFUSED. Everyone may query when empty.
+	Allow []string `yaml:"allow,omitempty"`
+}
+
+// RateLimitConfig limits queries per client with a token bucket.
+// IPv6 clients are counted per /64.
+type RateLimitConfig struct {
+	QPS    float64 `yaml:"qps,omitempty"`    // sustained queries per second per client (disabled when 0)
+	Burst  int     `yaml:"burst,omitempty"`  // bucket size (default twice the qps)
+	Action string  `yaml:"action,omitempty"` // drop (default), refuse or truncate
+}
+
+// DnstapConfig streams client and forwarder messages to a dnstap collector.
+type DnstapConfig struct {
+	// Address is unix:///path, tcp://host:port or file:///path; dnstap is disabled when empty
+	Address    string `yaml:"address,omitempty"`
+	Identity   string `yaml:"identity,omitempty"`    // server identity (default hostname)
+	BufferSize int    `yaml:"buffer_size,omitempty"` // messages queued before dropping (default 4096)
+}
+
 // LocalZonesConfig is removed - Local DNS is now fully auto-detected
 
 // Config is the main application configuration.
 type Config struct {
+	// Include lists files or globs, relative to the main file, merged before it
+	Include       []string         `yaml:"include,omitempty"`
 	AppName       string           `yaml:"app_name,omitempty"`
 	Listen        ListenConfig     `yaml:"listen"`
 	Upstreams     []UpstreamConfig `yaml:"upstreams"`
@@ -232,23 +547,57 @@ type Config struct {
 	Cache         CacheConfig      `yaml:"cache,omitempty"`
 	HTTP          HTTPConfig       `yaml:"http,omitempty"`
 	Hosts         []HostOverride   `yaml:"hosts,omitempty"`
+	RPZ           []RPZConfig      `yaml:"rpz,omitempty"`
+	ACL           ACLConfig        `yaml:"acl,omitempty"`
+	RateLimit     RateLimitConfig  `yaml:"rate_limit,omitempty"`
+	Dnstap        DnstapConfig     `yaml:"dnstap,omitempty"`
+	Revisions     RevisionsConfig  `yaml:"revisions,omitempty"`
 	Update        UpdateConfig     `yaml:"update,omitempty"`
 	Users         []UserConfig     `yaml:"users,omitempty"`
 	JWTSecret     string           `yaml:"jwt_secret,omitempty"`     // Base64 encoded JWT secret
 	RefreshTokens []RefreshToken   `yaml:"refresh_tokens,omitempty"` // Persisted refresh tokens
 	// LocalZones removed - Local DNS is now fully auto-detected
 	Path string `yaml:"-"`
+
+	source *source // how the file was assembled (nil unless loaded)
 }
 
 // global mutex to serialize YAML writes.
 var saveMu sync.Mutex //nolint:gochecknoglobals // global mutex for config writes
 
 // HostOverride is a static host mapping (supports wildcard patterns like *.example.com).
+// PTR answers are generated automatically for A/AAAA addresses of non-wildcard patterns.
 type HostOverride struct {
-	Pattern string   `json:"pattern"        yaml:"pattern"`
-	A       []string `json:"a,omitempty"    yaml:"a,omitempty"`
-	AAAA    []string `json:"aaaa,omitempty" yaml:"aaaa,omitempty"`
-	TTL     uint32   `json:"ttl,omitempty"  yaml:"ttl,omitempty"`
+	Pattern string      `json:"pattern"         yaml:"pattern"`
+	A       []string    `json:"a,omitempty"     yaml:"a,omitempty"`
+	AAAA    []string    `json:"aaaa,omitempty"  yaml:"aaaa,omitempty"`
+	CNAME   string      `json:"cname,omitempty" yaml:"cname,omitempty"` // alias target, chased through the pipeline
+	TXT     []string    `json:"txt,omitempty"   yaml:"txt,omitempty"`
+	MX      []MXRecord  `json:"mx,omitempty"    yaml:"mx,omitempty"`
+	SRV     []SRVRecord `json:"srv,omitempty"   yaml:"srv,omitempty"`
+	TTL     uint32      `json:"ttl,omitempty"   yaml:"ttl,omitempty"`
+	Via     string      `json:"via,omitempty"   yaml:"via,omitempty"` // mark answered addresses via this interface
+}
+
+// MXRecord is a mail exchanger of a host override.
+type MXRecord struct {
+	Preference uint16 `json:"preference" yaml:"preference"`
+	Host       string `json:"host"       yaml:"host"`
+}
+
+// SRVRecord is a service location of a host override.
+type SRVRecord struct {
+	Priority uint16 `json:"priority"         yaml:"priority"`
+	Weight   uint16 `json:"weight,omitempty" yaml:"weight,omitempty"`
+	Port     uint16 `json:"port"             yaml:"port"`
+	Target   string `json:"target"           yaml:"target"`
+}
+
+// IsWildcard reports whether the override pattern matches subdomains.
+func (h *HostOverride) IsWildcard() bool {
+	p := strings.TrimSpace(h.Pattern)
+
+	return p == "*" || strings.HasPrefix(p, "*.")
 }
 
 // UserConfig represents a user configuration.
@@ -284,28 +633,9 @@ func (h *HostOverride) Validate() error {
 	// Allow wildcard patterns like *.example.com
 	pattern = strings.TrimPrefix(pattern, "*.")
 
-	// Basic domain validation - allow letters, numbers, dots, hyphens
-	// Must start and end with alphanumeric
 	if pattern != "" {
-		parts := strings.SplitSeq(pattern, ".")
-		for part := range parts {
-			if len(part) == 0 || len(part) > 63 {
-				return errDomainLabelTooLongOrEmpty
-			}
-			// Each label should be alphanumeric with optional hyphens
-			for i, r := range part {
-				if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') && r != '-' {
-					return fmt.Errorf("%w: '%c'", errDomainInvalidCharacter, r)
-				}
-
-				if i == 0 && r == '-' {
-					return errDomainLabelCannotStartHyphen
-				}
-
-				if i == len(part)-1 && r == '-' {
-					return errDomainLabelCannotEndHyphen
-				}
-			}
+		if err := validateDomainName(pattern); err != nil {
+			return err
 		}
 	}
 
@@ -348,14 +678,95 @@ func (h *HostOverride) Validate() error {
 		return fmt.Errorf("%w (max %d seconds): %d", errTTLTooLarge, maxTTL, h.TTL)
 	}
 
+	if err := h.validateExtraRecords(); err != nil {
+		return err
+	}
+
+	if h.Via != "" && strings.ContainsAny(h.Via, " \t/") {
+		return fmt.Errorf("%w: %q", errHostViaInvalid, h.Via)
+	}
+
 	// At least one record type should be present
-	if len(h.A) == 0 && len(h.AAAA) == 0 {
+	if len(h.A) == 0 && len(h.AAAA) == 0 && h.CNAME == "" && len(h.TXT) == 0 && len(h.MX) == 0 && len(h.SRV) == 0 {
 		return errHostOverrideNoRecords
 	}
 
 	return nil
 }
 
+// validateExtraRecords checks CNAME, TXT, MX and SRV data of an override.
+func (h *HostOverride) validateExtraRecords() error { //nolint:cyclop
+	if h.CNAME != "" {
+		if len(h.A) > 0 || len(h.AAAA) > 0 || len(h.TXT) > 0 || len(h.MX) > 0 || len(h.SRV) > 0 {
+			return errHostCNAMEWithOtherRecords
+		}
+
+		target := strings.TrimSuffix(strings.TrimSpace(h.CNAME), ".")
+		if err := validateDomainName(target); err != nil {
+			return fmt.Errorf("cname: %w", err)
+		}
+
+		if strings.EqualFold(target, strings.TrimSuffix(strings.TrimSpace(h.Pattern), ".")) {
+			return errHostCNAMELoop
+		}
+	}
+
+	for i, txt := range h.TXT {
+		if txt == "" {
+			return fmt.Errorf("%w (#%d)", errTXTRecordEmpty, i+1)
+		}
+	}
+
+	for i, mx := range h.MX {
+		if err := validateDomainName(strings.TrimSuffix(strings.TrimSpace(mx.Host), ".")); err != nil {
+			return fmt.Errorf("%w (#%d): %w", errMXRecordInvalid, i+1, err)
+		}
+	}
+
+	for i, srv := range h.SRV {
+		if srv.Port == 0 {
+			return fmt.Errorf("%w (#%d): port is required", errSRVRecordInvalid, i+1)
+		}
+
+		if err := validateDomainName(strings.TrimSuffix(strings.TrimSpace(srv.Target), ".")); err != nil {
+			return fmt.Errorf("%w (#%d): %w", errSRVRecordInvalid, i+1, err)
+		}
+	}
+
+	return nil
+}
+
+// validateDomainName performs basic domain validation: letters, numbers,
+// hyphens and (for service labels like _sip._tcp) a leading underscore.
+// Labels must not start or end with a hyphen.
+func validateDomainName(name string) error {
+	if name == "" || len(name) > MaxDNSNameLength {
+		return errDomainLabelTooLongOrEmpty
+	}
+
+	for part := range strings.SplitSeq(name, ".") {
+		if len(part) == 0 || len(part) > 63 {
+			return errDomainLabelTooLongOrEmpty
+		}
+		// Each label should be alphanumeric with optional hyphens
+		for i, r := range part {
+			if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') && r != '-' && (r != '_' || i != 0) {
+				return fmt.Errorf("%w: '%c'", errDomainInvalidCharacter, r)
+			}
+
+			if i == 0 && r == '-' {
+				return errDomainLabelCannotStartHyphen
+			}
+
+			if i == len(part)-1 && r == '-' {
+				return errDomainLabelCannotEndHyphen
+			}
+		}
+	}
+
+	return nil
+}
+
 // SafeConfig represents a configuration without sensitive data for API responses.
 type SafeConfig struct {
 	AppName    string           `json:"app_name,omitempty"`
@@ -367,6 +778,7 @@ type SafeConfig struct {
 	Cache      CacheConfig      `json:"cache,omitzero"`
 	HTTP       HTTPConfig       `json:"http,omitzero"`
 	Hosts      []HostOverride   `json:"hosts,omitempty"`
+	RPZ        []RPZConfig      `json:"rpz,omitempty"`
 	Update     UpdateConfig     `json:"update,omitzero"`
 	Users      []UserConfig     `json:"users,omitempty"`
 }
@@ -383,6 +795,7 @@ func (c *Config) ToSafeConfig() SafeConfig {
 		Cache:      c.Cache,
 		HTTP:       c.HTTP,
 		Hosts:      c.Hosts,
+		RPZ:        c.RPZ,
 		Update:     c.Update,
 		Users:      c.Users,
 	}
@@ -408,16 +821,13 @@ func (c *Config) GetMinMarkTTL(ttl uint32) time.Duration {
 func (c *Config) GetAllRules() []Rule {
 	var allRules []Rule
 
-	// Add rules from groups
+	// Add rules from groups; block groups are handled by the blocklist, not by routing
 	for _, group := range c.RuleGroups {
-		for _, pattern := range group.Patterns {
-			rule := Rule{
-				Pattern: pattern,
-				Via:     group.Via,
-				PinTTL:  group.PinTTL,
-			}
-			allRules = append(allRules, rule)
+		if group.IsBlock() {
+			continue
 		}
+
+		allRules = append(allRules, group.Rules()...)
 	}
 
 	return allRules
@@ -478,17 +888,23 @@ func (c *Config) GetRuleGroups() []RuleGroup {
 }
 
 func Load(path string) (*Config, error) { //nolint:cyclop,funlen
-	b, err := os.ReadFile(path) //nolint:gosec // config file path is validated
+	src, tree, err := loadSource(path)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := yaml.Marshal(tree)
 	if err != nil {
 		return nil, err
 	}
 
 	var cfg Config
 	if err := yaml.Unmarshal(b, &cfg); err != nil {
-		return nil, err
+		return nil, src.locate(path, err)
 	}
 
 	cfg.Path = path
+	cfg.source = src
 
 	// Set defaults
 	if cfg.AppName == "" {
@@ -531,6 +947,14 @@ func Load(path string) (*Config, error) { //nolint:cyclop,funlen
 		cfg.History.Enabled = true
 	}
 
+	if cfg.Revisions.Path == "" {
+		cfg.Revisions.Path = filepath.Join(filepath.Dir(path), revisionsDirName)
+	}
+
+	if cfg.Revisions.Keep <= 0 {
+		cfg.Revisions.Keep = defaultRevisionsKeep
+	}
+
 	// Set default log settings
 	if cfg.Log.Level == "" {
 		cfg.Log.Level = "info"
@@ -563,13 +987,21 @@ func Load(path string) (*Config, error) { //nolint:cyclop,funlen
 		cfg.Cache.MaxTTLSeconds = cfg.Cache.MinTTLSeconds
 	}
 
-	// Set default values for rule groups
-	for i := range cfg.RuleGroups {
-		// pin_ttl defaults to true (since it's omitempty, we need to check if it was explicitly set to false)
-		// For now, we'll always set it to true as default
-		cfg.RuleGroups[i].PinTTL = true
+	if cfg.Cache.SnapshotPath != "" && cfg.Cache.SnapshotInterval <= 0 {
+		cfg.Cache.SnapshotInterval = DefaultCacheSnapshotInterval
+	}
+
+	if cfg.Cache.Prefetch && cfg.Cache.PrefetchAt == 0 {
+		cfg.Cache.PrefetchAt = defaultCachePrefetchAt
 	}
 
+	if cfg.Cache.Prefetch && cfg.Cache.PrefetchMinHits == 0 {
+		cfg.Cache.PrefetchMinHits = defaultCachePrefetchMinHits
+	}
+
+	// Set default values for rule groups
+	setRuleGroupDefaults(cfg.RuleGroups)
+
 	// Set default HTTP settings
 	if cfg.HTTP.Listen == "" {
 		cfg.HTTP.Listen = "127.0.0.1:47823"
@@ -596,14 +1028,35 @@ func Load(path string) (*Config, error) { //nolint:cyclop,funlen
 	}
 
 	if err := cfg.Validate(); err != nil {
+		return nil, src.locate(path, err)
+	}
+
+	if src.loaded, err = toTree(&cfg); err != nil {
 		return nil, err
 	}
 
 	return &cfg, nil
 }
 
-// Save writes the configuration back to the original file path.
+// Files returns the config files the configuration was loaded from, the main
+// file first.
+func (c *Config) Files() []string {
+	if c.source == nil {
+		return []string{c.Path}
+	}
+
+	return append([]string{c.Path}, c.source.files...)
+}
+
+// Save writes the configuration back to the original file path. For a loaded
+// config only the changed sections are written to the main file; included
+// files and ${...} references of unchanged values are left as they are.
 func (c *Config) Save() error {
+	return c.SaveContext(context.Background())
+}
+
+// SaveContext is Save recording the revision as made by the author of ctx.
+func (c *Config) SaveContext(ctx context.Context) error {
 	saveMu.Lock()
 	defer saveMu.Unlock()
 
@@ -611,13 +1064,67 @@ func (c *Config) Save() error {
 		return fmt.Errorf("%w: config path is empty", errConfigPathEmpty)
 	}
 
-	out, err := yaml.Marshal(c)
+	if c.source == nil {
+		out, err := yaml.Marshal(c)
+		if err != nil {
+			return fmt.Errorf("failed to marshal config to YAML: %w", err)
+		}
+
+		return writeConfigFile(c.Path, out)
+	}
+
+	cur, err := toTree(c)
+	if err != nil {
+		return err
+	}
+
+	doc := c.source.patch(cur)
+
+	out, err := yaml.Marshal(doc)
 	if err != nil {
 		return fmt.Errorf("failed to marshal config to YAML: %w", err)
 	}
 
-	if err := os.WriteFile(c.Path, out, defaultFilePerm); err != nil {
-		return fmt.Errorf("failed to write config file %s: %w", c.Path, err)
+	previous, _ := os.ReadFile(c.Path) //nolint:gosec // config file path is validated
+
+	if err := writeConfigFile(c.Path, out); err != nil {
+		return err
+	}
+
+	sections := changedSections(c.source.raw, doc)
+	c.source.raw, c.source.loaded = doc, cur
+
+	if err := c.recordSave(ctx, previous, out, sections); err != nil {
+		return fmt.Errorf("failed to record config revision: %w", err)
+	}
+
+	return nil
+}
+
+// Rebase makes c save against the files next was loaded from, after a reload
+// copied the sections it applies from next. Values c keeps from before the
+// reload are not written back, so the files keep the pending changes.
+func (c *Config) Rebase(next *Config) error {
+	saveMu.Lock()
+	defer saveMu.Unlock()
+
+	if next.source == nil {
+		return nil
+	}
+
+	loaded, err := toTree(c)
+	if err != nil {
+		return err
+	}
+
+	c.source = &source{raw: next.source.raw, loaded: loaded, included: next.source.included, files: next.source.files}
+
+	return nil
+}
+
+func writeConfigFile(path string, out []byte) error {
+	if err := os.WriteFile(path, out, defaultFilePerm); err != nil {
+		return fmt.Errorf("failed to write config file %s: %w", path, err)
 	}
 
 	return nil
@@ -625,48 +1132,42 @@ func (c *Config) Save() error {
 
 func (c *Config) Validate() error { //nolint:gocognit,cyclop,funlen
 	if c.Listen.UDP == "" || c.Listen.TCP == "" {
-		return errListenUDPTCPMustBeSet
+		return at(errListenUDPTCPMustBeSet, "listen")
 	}
 
 	if err := validateAddr(c.Listen.UDP); err != nil {
-		return fmt.Errorf("invalid listen.udp: %w", err)
+		return at(fmt.Errorf("invalid listen.udp: %w", err), "listen", "udp")
 	}
 
 	if err := validateAddr(c.Listen.TCP); err != nil {
-		return fmt.Errorf("invalid listen.tcp: %w", err)
+		return at(fmt.Errorf("invalid listen.tcp: %w", err), "listen", "tcp")
 	}
 
 	if len(c.Upstreams) == 0 {
 		return errAtLeastOneUpstreamRequired
 	}
 
+	if err := c.validateCache(); err != nil {
+		return at(err, "cache")
+	}
+
 	for _, u := range c.Upstreams {
 		if u.Name == "" {
-			return errUpstreamNameCannotBeEmpty
-		}
-
-		// Cache limits sanity
-		if c.Cache.Enabled {
-			if c.Cache.MaxEntries < 0 || c.Cache.MaxSizeMB < 0 {
-				return errCacheLimitsMustBeNonNegative
-			}
-
-			// TTL bounds sanity
-			if c.Cache.MinTTLSeconds < 0 || c.Cache.MaxTTLSeconds < 0 {
-				return errCacheTTLBoundsMustBeNonNeg
-			}
-
-			if c.Cache.MaxTTLSeconds > 0 && c.Cache.MinTTLSeconds > c.Cache.MaxTTLSeconds {
-				return errCacheMinTTLGreaterThanMax
-			}
+			return at(errUpstreamNameCannotBeEmpty, "upstreams")
 		}
 
 		if u.Address == "" {
-			return fmt.Errorf("upstream '%s' %w", u.Name, errUpstreamAddressCannotBeEmpty)
+			return at(fmt.Errorf("upstream '%s' %w", u.Name, errUpstreamAddressCannotBeEmpty), "upstreams", u.Name, "address")
 		}
 		// Type is optional and derived from URL; do not enforce here
 		if u.Weight < 0 {
-			return fmt.Errorf("upstream '%s' %w %d", u.Name, errUpstreamInvalidWeight, u.Weight)
+			return at(fmt.Errorf("upstream '%s' %w %d", u.Name, errUpstreamInvalidWeight, u.Weight), "upstreams", u.Name, "weight")
+		}
+
+		if u.ECS != nil {
+			if err := u.ECS.Validate(); err != nil {
+				return at(fmt.Errorf("upstream '%s': %w", u.Name, err), "upstreams", u.Name, "ecs")
+			}
 		}
 	}
 
@@ -679,31 +1180,57 @@ func (c *Config) Validate() error { //nolint:gocognit,cyclop,funlen
 
 		for _, group := range c.RuleGroups {
 			if group.Name == "" {
-				return errRuleGroupNameCannotBeEmpty
+				return at(errRuleGroupNameCannotBeEmpty, "rule_groups")
 			}
 
 			if _, ok := groupNames[group.Name]; ok {
-				return fmt.Errorf("%w: %s", errDuplicateRuleGroupName, group.Name)
+				return at(fmt.Errorf("%w: %s", errDuplicateRuleGroupName, group.Name), "rule_groups", group.Name)
 			}
 
 			groupNames[group.Name] = struct{}{}
 
-			if len(group.Patterns) == 0 {
-				return fmt.Errorf("rule group '%s': %w", group.Name, errRuleGroupMustHavePattern)
+			switch strings.ToLower(group.Action) {
+			case "", RuleActionRoute:
+				// A group without patterns routes nothing but keeps its settings
+				if group.Via == "" {
+					return at(fmt.Errorf("rule group '%s': %w", group.Name, errRuleGroupRequiresViaInterface), "rule_groups", group.Name)
+				}
+			case RuleActionBlock:
+				if err := group.validateBlock(); err != nil {
+					return at(fmt.Errorf("rule group '%s': %w", group.Name, err), "rule_groups", group.Name)
+				}
+			default:
+				err := fmt.Errorf("rule group '%s': %w: %s", group.Name, errRuleGroupInvalidAction, group.Action)
+
+				return at(err, "rule_groups", group.Name, "action")
+			}
+
+			if err := group.ValidateAddressFamily(); err != nil {
+				return at(fmt.Errorf("rule group '%s': %w", group.Name, err), "rule_groups", group.Name, "address_family")
+			}
+
+			if err := group.ValidateClientTTL(); err != nil {
+				return at(fmt.Errorf("rule group '%s': %w", group.Name, err), "rule_groups", group.Name)
+			}
+
+			if _, err := ParseSchedule(group.Schedule); err != nil {
+				return at(fmt.Errorf("rule group '%s': %w", group.Name, err), "rule_groups", group.Name, "schedule")
 			}
 
-			if group.Via == "" {
-				return fmt.Errorf("rule group '%s': %w", group.Name, errRuleGroupRequiresViaInterface)
+			if group.ECS != nil {
+				if err := group.ECS.Validate(); err != nil {
+					return at(fmt.Errorf("rule group '%s': %w", group.Name, err), "rule_groups", group.Name, "ecs")
+				}
 			}
 
 			// Validate patterns within the group
 			for _, pattern := range group.Patterns {
 				if pattern == "" {
-					return fmt.Errorf("rule group '%s': %w", group.Name, errRuleGroupContainsEmptyPattern)
+					return at(fmt.Errorf("rule group '%s': %w", group.Name, errRuleGroupContainsEmptyPattern), "rule_groups", group.Name, "patterns")
 				}
 
 				if _, ok := seen[pattern]; ok {
-					return fmt.Errorf("%w: %s", errDuplicateRulePattern, pattern)
+					return at(fmt.Errorf("%w: %s", errDuplicateRulePattern, pattern), "rule_groups", group.Name, "patterns")
 				}
 
 				seen[pattern] = struct{}{}
@@ -711,6 +1238,224 @@ func (c *Config) Validate() error { //nolint:gocognit,cyclop,funlen
 		}
 	}
 
+	if err := c.validateRPZ(); err != nil {
+		return err
+	}
+
+	if err := c.validateAccess(); err != nil {
+		return err
+	}
+
+	if err := c.validateHistory(); err != nil {
+		return at(err, "history")
+	}
+
+	if err := c.validateDnstap(); err != nil {
+		return at(err, "dnstap")
+	}
+
+	if (c.HTTP.TLSCertFile == "") != (c.HTTP.TLSKeyFile == "") {
+		return at(errHTTPTLSPair, "http")
+	}
+
+	return nil
+}
+
+// setRuleGroupDefaults applies the defaults of Load to groups.
+func setRuleGroupDefaults(groups []RuleGroup) {
+	for i := range groups {
+		// pin_ttl defaults to true (since it's omitempty, we need to check if it was explicitly set to false)
+		// For now, we'll always set it to true as default
+		groups[i].PinTTL = true
+	}
+}
+
+// validateCache checks cache limits and TTL bounds.
+func (c *Config) validateCache() error {
+	if !c.Cache.Enabled {
+		return nil
+	}
+
+	if c.Cache.MaxEntries < 0 || c.Cache.MaxSizeMB < 0 {
+		return errCacheLimitsMustBeNonNegative
+	}
+
+	// TTL bounds sanity
+	if c.Cache.MinTTLSeconds < 0 || c.Cache.MaxTTLSeconds < 0 {
+		return errCacheTTLBoundsMustBeNonNeg
+	}
+
+	if c.Cache.MaxTTLSeconds > 0 && c.Cache.MinTTLSeconds > c.Cache.MaxTTLSeconds {
+		return errCacheMinTTLGreaterThanMax
+	}
+
+	if c.Cache.SnapshotInterval < 0 {
+		return errCacheSnapshotInterval
+	}
+
+	if c.Cache.PrefetchAt < 0 || c.Cache.PrefetchAt >= 1 {
+		return errCachePrefetchAt
+	}
+
+	if c.Cache.PrefetchMinHits < 0 {
+		return errCachePrefetchMinHits
+	}
+
+	if negMax := c.Cache.NegativeMaxTTL(); c.Cache.NegativeMinTTLSeconds < 0 || negMax < 0 ||
+		(negMax > 0 && c.Cache.NegativeMinTTLSeconds > negMax) {
+		return errCacheNegativeTTLBounds
+	}
+
+	if servFail := c.Cache.ServFailTTL(); servFail < 0 || servFail > maxServFailTTLSeconds {
+		return errCacheServFailTTL
+	}
+
+	return nil
+}
+
+// validateDnstap checks the dnstap output address.
+func (c *Config) validateDnstap() error {
+	if c.Dnstap.BufferSize < 0 {
+		return errDnstapBufferSize
+	}
+
+	if c.Dnstap.Address == "" {
+		return nil
+	}
+
+	u, err := url.Parse(c.Dnstap.Address)
+	if err != nil {
+		return fmt.Errorf("%w: %s", errDnstapInvalidAddress, c.Dnstap.Address)
+	}
+
+	switch u.Scheme {
+	case "unix", "file":
+		if u.Path == "" {
+			return fmt.Errorf("%w: %s", errDnstapInvalidAddress, c.Dnstap.Address)
+		}
+	case "tcp":
+		if _, _, err := net.SplitHostPort(u.Host); err != nil {
+			return fmt.Errorf("%w: %s", errDnstapInvalidAddress, c.Dnstap.Address)
+		}
+	default:
+		return fmt.Errorf("%w: %s", errDnstapInvalidAddress, c.Dnstap.Address)
+	}
+
+	return nil
+}
+
+// validateHistory checks the query log settings.
+func (c *Config) validateHistory() error {
+	h := c.History
+	if h.Retention < 0 || h.BufferSize < 0 || h.FlushInterval < 0 || h.MaxSizeMB < 0 || h.RotateHours < 0 {
+		return errHistoryNegative
+	}
+
+	return nil
+}
+
+// validateAccess checks the listener ACL and rate limit settings.
+func (c *Config) validateAccess() error {
+	for _, entry := range c.ACL.Allow {
+		entry = strings.TrimSpace(entry)
+		if net.ParseIP(entry) != nil {
+			continue
+		}
+
+		if _, _, err := net.ParseCIDR(entry); err != nil {
+			return at(fmt.Errorf("%w: %s", errACLInvalidEntry, entry), "acl", "allow")
+		}
+	}
+
+	if c.RateLimit.QPS < 0 || c.RateLimit.Burst < 0 {
+		return at(errRateLimitNegative, "rate_limit")
+	}
+
+	switch strings.ToLower(c.RateLimit.Action) {
+	case "", RateLimitActionDrop, RateLimitActionRefuse, RateLimitActionTruncate:
+		return nil
+	default:
+		return at(fmt.Errorf("%w: %s", errRateLimitInvalidAction, c.RateLimit.Action), "rate_limit", "action")
+	}
+}
+
+// validateRPZ checks response policy zone sources.
+func (c *Config) validateRPZ() error {
+	names := map[string]struct{}{}
+
+	for _, z := range c.RPZ {
+		name := strings.ToLower(strings.TrimSuffix(strings.TrimSpace(z.Name), "."))
+		if name == "" {
+			return at(errRPZNameEmpty, "rpz")
+		}
+
+		if _, ok := names[name]; ok {
+			return at(fmt.Errorf("%w: %s", errRPZDuplicateName, z.Name), "rpz", z.Name)
+		}
+
+		names[name] = struct{}{}
+
+		if (z.File == "") == (z.AXFR == "") {
+			return at(fmt.Errorf("rpz '%s': %w", z.Name, errRPZMustHaveSource), "rpz", z.Name)
+		}
+
+		if z.AXFR != "" {
+			if _, _, err := net.SplitHostPort(z.AXFR); err != nil {
+				return at(fmt.Errorf("rpz '%s': invalid axfr: %w", z.Name, err), "rpz", z.Name, "axfr")
+			}
+		}
+
+		if z.Refresh != 0 && z.Refresh < minRPZRefresh {
+			return at(fmt.Errorf("rpz '%s': %w (min %s)", z.Name, errRPZRefreshShort, minRPZRefresh), "rpz", z.Name, "refresh")
+		}
+	}
+
+	return nil
+}
+
+// ValidateAddressFamily checks the address_family setting of a rule group.
+func (g *RuleGroup) ValidateAddressFamily() error {
+	switch strings.ToLower(g.AddressFamily) {
+	case "", AddressFamilyAny, AddressFamilyIPv4Only, AddressFamilyIPv6Only, AddressFamilyPreferIPv4:
+		return nil
+	default:
+		return fmt.Errorf("%w: %s", errRuleGroupInvalidFamily, g.AddressFamily)
+	}
+}
+
+// ValidateClientTTL checks the client_ttl_min and client_ttl_max bounds of a rule group.
+func (g *RuleGroup) ValidateClientTTL() error {
+	// TTLs are limited to 2^31-1 seconds (RFC 2181 section 8)
+	if g.ClientTTLMin < 0 || g.ClientTTLMax < 0 || g.ClientTTLMin > math.MaxInt32 || g.ClientTTLMax > math.MaxInt32 ||
+		(g.ClientTTLMax > 0 && g.ClientTTLMin > g.ClientTTLMax) {
+		return errRuleGroupClientTTL
+	}
+
+	return nil
+}
+
+// validateBlock checks the block-specific settings of a rule group.
+func (g *RuleGroup) validateBlock() error {
+	if len(g.Patterns) == 0 && len(g.Lists) == 0 {
+		return errBlockGroupMustHaveSource
+	}
+
+	switch strings.ToLower(g.BlockMode) {
+	case "", BlockModeNXDomain, BlockModeNoData, BlockModeSinkhole:
+	default:
+		return fmt.Errorf("%w: %s", errBlockGroupInvalidMode, g.BlockMode)
+	}
+
+	for _, s := range g.Sinkhole {
+		if net.ParseIP(strings.TrimSpace(s)) == nil {
+			return fmt.Errorf("%w: %s", errBlockGroupInvalidSinkhole, s)
+		}
+	}
+
+	if g.ListsRefresh != 0 && g.ListsRefresh < minListsRefresh {
+		return fmt.Errorf("%w (min %s)", errBlockGroupListsRefreshShort, minListsRefresh)
+	}
+
 	return nil
 }
 
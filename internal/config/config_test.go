@@ -1,6 +1,12 @@
 package config_test
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -211,12 +217,18 @@ func TestConfigGetAllRules(t *testing.T) {
 		},
 	}
 
+	cfg.RuleGroups = append(cfg.RuleGroups, config.RuleGroup{
+		Name:     "ads",
+		Action:   config.RuleActionBlock,
+		Patterns: []string{"*.ads.com"},
+	})
+
 	rules := cfg.GetAllRules()
 
 	expectedRules := []config.Rule{
-		{Pattern: "*.example.com", Via: "eth0", PinTTL: true},
-		{Pattern: "test.com", Via: "eth0", PinTTL: true},
-		{Pattern: "*.test.com", Via: "wlan0", PinTTL: false},
+		{Group: "group1", Pattern: "*.example.com", Via: "eth0", PinTTL: true},
+		{Group: "group1", Pattern: "test.com", Via: "eth0", PinTTL: true},
+		{Group: "group2", Pattern: "*.test.com", Via: "wlan0", PinTTL: false},
 	}
 
 	assert.Equal(t, expectedRules, rules)
@@ -337,6 +349,36 @@ func TestCacheConfig(t *testing.T) {
 	assert.Equal(t, 3600, cfg.MaxTTLSeconds)
 }
 
+func TestLoadCacheNegativeTTLDefaults(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	load := func(cache string) config.CacheConfig {
+		path := filepath.Join(dir, "config.yaml")
+		writeFile(t, path, "upstreams:\n  - name: quad9\n    address: 9.9.9.9:53\ncache:\n  enabled: true\n"+cache)
+
+		cfg, err := config.Load(path)
+		require.NoError(t, err)
+
+		return cfg.Cache
+	}
+
+	unset := load("")
+	assert.Nil(t, unset.ServFailTTLSeconds)
+	assert.Equal(t, 5, unset.ServFailTTL())
+	assert.Equal(t, 900, unset.NegativeMaxTTL())
+
+	// An explicit 0 turns SERVFAIL caching off and lifts the negative bound
+	zero := load("  servfail_ttl_seconds: 0\n  negative_max_ttl_seconds: 0\n")
+	assert.Zero(t, zero.ServFailTTL())
+	assert.Zero(t, zero.NegativeMaxTTL())
+
+	// The default bound never undercuts the configured minimum
+	raised := load("  negative_min_ttl_seconds: 1200\n")
+	assert.Equal(t, 1200, raised.NegativeMaxTTL())
+}
+
 func TestHTTPConfig(t *testing.T) {
 	t.Parallel()
 
@@ -443,6 +485,284 @@ func TestConfigValidation(t *testing.T) {
 	}
 }
 
+func TestConfigValidationBlockGroups(t *testing.T) {
+	t.Parallel()
+
+	base := func(g config.RuleGroup) config.Config {
+		return config.Config{
+			Listen:     config.ListenConfig{UDP: ":53", TCP: ":53"},
+			Upstreams:  []config.UpstreamConfig{{Name: "test", Address: "udp://8.8.8.8:53"}},
+			RuleGroups: []config.RuleGroup{g},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		group   config.RuleGroup
+		wantErr bool
+	}{
+		{
+			name:  "patterns without via",
+			group: config.RuleGroup{Name: "ads", Action: "block", Patterns: []string{"*.ads.com"}},
+		},
+		{
+			name:  "lists only",
+			group: config.RuleGroup{Name: "ads", Action: "BLOCK", Lists: []string{"/etc/outway/ads.txt"}},
+		},
+		{
+			name: "sinkhole",
+			group: config.RuleGroup{
+				Name: "ads", Action: "block", Patterns: []string{"ads.com"},
+				BlockMode: config.BlockModeSinkhole, Sinkhole: []string{"0.0.0.0", "::"},
+			},
+		},
+		{
+			name:    "no sources",
+			group:   config.RuleGroup{Name: "ads", Action: "block"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown mode",
+			group:   config.RuleGroup{Name: "ads", Action: "block", Patterns: []string{"ads.com"}, BlockMode: "refuse"},
+			wantErr: true,
+		},
+		{
+			name: "bad sinkhole address",
+			group: config.RuleGroup{
+				Name: "ads", Action: "block", Patterns: []string{"ads.com"},
+				BlockMode: config.BlockModeSinkhole, Sinkhole: []string{"nope"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "refresh too short",
+			group: config.RuleGroup{
+				Name: "ads", Action: "block", Lists: []string{"/tmp/ads.txt"}, ListsRefresh: time.Second,
+			},
+			wantErr: true,
+		},
+		{
+			name:    "unknown action",
+			group:   config.RuleGroup{Name: "ads", Action: "drop", Patterns: []string{"ads.com"}, Via: "eth0"},
+			wantErr: true,
+		},
+		{
+			name:    "route group still needs via",
+			group:   config.RuleGroup{Name: "vpn", Patterns: []string{"*.example.com"}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg := base(tt.group)
+
+			err := cfg.Validate()
+			if tt.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestConfigValidationRPZ(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		zones   []config.RPZConfig
+		wantErr bool
+	}{
+		{name: "file", zones: []config.RPZConfig{{Name: "rpz.local", File: "/etc/outway/rpz.zone"}}},
+		{name: "axfr", zones: []config.RPZConfig{{Name: "rpz.local", AXFR: "127.0.0.1:53", Refresh: time.Hour}}},
+		{name: "no name", zones: []config.RPZConfig{{File: "/tmp/rpz.zone"}}, wantErr: true},
+		{name: "no source", zones: []config.RPZConfig{{Name: "rpz.local"}}, wantErr: true},
+		{name: "both sources", zones: []config.RPZConfig{{Name: "rpz.local", File: "/tmp/a", AXFR: "127.0.0.1:53"}}, wantErr: true},
+		{name: "bad axfr", zones: []config.RPZConfig{{Name: "rpz.local", AXFR: "127.0.0.1"}}, wantErr: true},
+		{name: "short refresh", zones: []config.RPZConfig{{Name: "rpz.local", AXFR: "127.0.0.1:53", Refresh: time.Second}}, wantErr: true},
+		{
+			name:    "duplicate",
+			zones:   []config.RPZConfig{{Name: "rpz.local", File: "/tmp/a"}, {Name: "RPZ.local.", File: "/tmp/b"}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg := config.Config{
+				Listen:    config.ListenConfig{UDP: ":53", TCP: ":53"},
+				Upstreams: []config.UpstreamConfig{{Name: "test", Address: "udp://8.8.8.8:53"}},
+				RPZ:       tt.zones,
+			}
+
+			err := cfg.Validate()
+			if tt.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestConfigValidationAccess(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		acl     config.ACLConfig
+		limit   config.RateLimitConfig
+		wantErr bool
+	}{
+		{name: "empty"},
+		{name: "cidrs and addresses", acl: config.ACLConfig{Allow: []string{"192.168.0.0/16", " ::1 ", "10.0.0.1"}}},
+		{name: "rate limit", limit: config.RateLimitConfig{QPS: 20, Burst: 40, Action: "truncate"}},
+		{name: "bad acl entry", acl: config.ACLConfig{Allow: []string{"lan"}}, wantErr: true},
+		{name: "negative qps", limit: config.RateLimitConfig{QPS: -1}, wantErr: true},
+		{name: "bad action", limit: config.RateLimitConfig{QPS: 5, Action: "tarpit"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg := config.Config{
+				Listen:    config.ListenConfig{UDP: ":53", TCP: ":53"},
+				Upstreams: []config.UpstreamConfig{{Name: "test", Address: "udp://8.8.8.8:53"}},
+				ACL:       tt.acl,
+				RateLimit: tt.limit,
+			}
+
+			err := cfg.Validate()
+			if tt.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestConfigValidationAddressFamily(t *testing.T) {
+	t.Parallel()
+
+	for family, wantErr := range map[string]bool{
+		"":                             false,
+		config.AddressFamilyAny:        false,
+		config.AddressFamilyIPv4Only:   false,
+		"IPv6_Only":                    false,
+		config.AddressFamilyPreferIPv4: false,
+		"ipv4":                         true,
+	} {
+		cfg := config.Config{
+			Listen:    config.ListenConfig{UDP: ":53", TCP: ":53"},
+			Upstreams: []config.UpstreamConfig{{Name: "test", Address: "udp://8.8.8.8:53"}},
+			RuleGroups: []config.RuleGroup{{
+				Name: "wg", Via: "wg0", Patterns: []string{"*.example.com"}, AddressFamily: family,
+			}},
+		}
+
+		err := cfg.Validate()
+		if wantErr {
+			require.Error(t, err, family)
+		} else {
+			require.NoError(t, err, family)
+		}
+	}
+
+	cfg := config.Config{RuleGroups: []config.RuleGroup{{
+		Name: "wg", Via: "wg0", Patterns: []string{"a.com"}, AddressFamily: "IPv4_Only",
+	}}}
+	assert.Equal(t, config.AddressFamilyIPv4Only, cfg.GetAllRules()[0].AddressFamily)
+}
+
+func TestConfigValidationClientTTL(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		min, max int
+		wantErr  bool
+	}{
+		{name: "unset", wantErr: false},
+		{name: "min only", min: 30, wantErr: false},
+		{name: "max only", max: 300, wantErr: false},
+		{name: "range", min: 30, max: 300, wantErr: false},
+		{name: "negative", min: -1, wantErr: true},
+		{name: "min above max", min: 600, max: 300, wantErr: true},
+		{name: "above rfc 2181", max: math.MaxInt32 + 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			g := config.RuleGroup{Name: "wg", Via: "wg0", Patterns: []string{"a.com"}, ClientTTLMin: tt.min, ClientTTLMax: tt.max}
+			if tt.wantErr {
+				require.Error(t, g.ValidateClientTTL())
+			} else {
+				require.NoError(t, g.ValidateClientTTL())
+			}
+		})
+	}
+
+	cfg := config.Config{RuleGroups: []config.RuleGroup{{
+		Name: "wg", Via: "wg0", Patterns: []string{"a.com"}, ClientTTLMin: 30, ClientTTLMax: 300,
+	}}}
+	rule := cfg.GetAllRules()[0]
+	assert.Equal(t, uint32(30), rule.ClientTTLMin)
+	assert.Equal(t, uint32(300), rule.ClientTTLMax)
+}
+
+func TestHostOverrideValidateRecords(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		host    config.HostOverride
+		wantErr bool
+	}{
+		{name: "cname", host: config.HostOverride{Pattern: "alias.lan", CNAME: "nas.lan"}},
+		{name: "txt", host: config.HostOverride{Pattern: "lan", TXT: []string{"v=spf1 -all"}}},
+		{name: "mx", host: config.HostOverride{Pattern: "lan", MX: []config.MXRecord{{Preference: 10, Host: "mail.lan"}}}},
+		{
+			name: "srv",
+			host: config.HostOverride{Pattern: "_sip._tcp.lan", SRV: []config.SRVRecord{{Priority: 10, Weight: 5, Port: 5060, Target: "pbx.lan"}}},
+		},
+		{name: "via", host: config.HostOverride{Pattern: "nas.lan", A: []string{"192.168.1.10"}, Via: "wg0"}},
+		{name: "no records", host: config.HostOverride{Pattern: "nas.lan"}, wantErr: true},
+		{name: "cname with a", host: config.HostOverride{Pattern: "alias.lan", CNAME: "nas.lan", A: []string{"192.168.1.10"}}, wantErr: true},
+		{name: "cname to self", host: config.HostOverride{Pattern: "alias.lan", CNAME: "alias.lan."}, wantErr: true},
+		{name: "empty txt", host: config.HostOverride{Pattern: "lan", TXT: []string{""}}, wantErr: true},
+		{name: "mx without host", host: config.HostOverride{Pattern: "lan", MX: []config.MXRecord{{Preference: 10}}}, wantErr: true},
+		{
+			name:    "srv without port",
+			host:    config.HostOverride{Pattern: "_sip._tcp.lan", SRV: []config.SRVRecord{{Target: "pbx.lan"}}},
+			wantErr: true,
+		},
+		{name: "bad via", host: config.HostOverride{Pattern: "nas.lan", A: []string{"192.168.1.10"}, Via: "wg 0"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := tt.host.Validate()
+			if tt.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
 func TestUpstreamConfigBasic(t *testing.T) {
 	t.Parallel()
 
@@ -504,3 +824,582 @@ func TestRuleGroupBasic(t *testing.T) {
 // 	assert.Equal(t, "cache ttl bounds must be non-negative", errCacheTTLBoundsMustBeNonNeg.Error())
 // 	assert.Equal(t, "cache min_ttl_seconds cannot be greater than max_ttl_seconds", errCacheMinTTLGreaterThanMax.Error())
 // }
+
+func TestECSPolicyValidate(t *testing.T) {
+	t.Parallel()
+
+	require.NoError(t, (&config.ECSPolicy{Mode: config.ECSModeVia, IPv4Bits: 20}).Validate())
+	require.NoError(t, (&config.ECSPolicy{Mode: config.ECSModeFixed, Prefix: "2001:db8::/48"}).Validate())
+	require.Error(t, (&config.ECSPolicy{Mode: config.ECSModeFixed}).Validate())
+	require.Error(t, (&config.ECSPolicy{Mode: "mirror"}).Validate())
+	require.Error(t, (&config.ECSPolicy{Mode: config.ECSModeVia, IPv6Bits: 129}).Validate())
+}
+
+func TestConfigDiff(t *testing.T) {
+	t.Parallel()
+
+	a := &config.Config{
+		Path:      "/etc/outway/config.yaml",
+		Listen:    config.ListenConfig{UDP: ":53", TCP: ":53"},
+		Upstreams: []config.UpstreamConfig{{Name: "cf", Address: "1.1.1.1:53"}},
+	}
+	b := &config.Config{
+		Path:      "/tmp/config.yaml",
+		Listen:    config.ListenConfig{UDP: ":53", TCP: ":53"},
+		Upstreams: []config.UpstreamConfig{{Name: "google", Address: "8.8.8.8:53"}},
+		Hosts:     []config.HostOverride{{Pattern: "nas.lan", A: []string{"192.168.1.10"}}},
+	}
+
+	assert.Equal(t, []string{"upstreams", "hosts"}, config.Diff(a, b))
+	assert.Empty(t, config.Diff(a, a))
+}
+
+func writeFile(t *testing.T, path, body string) {
+	t.Helper()
+
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o750))
+	require.NoError(t, os.WriteFile(path, []byte(body), 0o600))
+}
+
+//nolint:paralleltest // sets an environment variable
+func TestLoadIncludesAndSubstitution(t *testing.T) {
+	t.Setenv("OUTWAY_TEST_UPSTREAM", "9.9.9.9:53")
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+
+	writeFile(t, filepath.Join(dir, "secret"), "c2VjcmV0\n")
+	writeFile(t, filepath.Join(dir, "rules", "video.yaml"), `rule_groups:
+  - name: video
+    via: wg0
+    patterns: ["*.example.com"]
+  - name: work
+    via: wg1
+    patterns: ["*.corp"]
+`)
+	writeFile(t, filepath.Join(dir, "conf.d", "10-hosts.yaml"), `hosts:
+  - pattern: nas.lan
+    a: ["192.168.1.10"]
+`)
+	writeFile(t, path, `include:
+  - rules/*.yaml
+jwt_secret: ${file:secret}
+upstreams:
+  - name: quad9
+    address: ${OUTWAY_TEST_UPSTREAM}
+rule_groups:
+  - name: work
+    via: wg2
+    patterns: ["*.corp"]
+`)
+
+	cfg, err := config.Load(path)
+	require.NoError(t, err)
+
+	assert.Equal(t, "c2VjcmV0", cfg.JWTSecret)
+	assert.Equal(t, "9.9.9.9:53", cfg.Upstreams[0].Address)
+	require.Len(t, cfg.RuleGroups, 2)
+	assert.Equal(t, "wg0", cfg.RuleGroups[0].Via)
+	assert.Equal(t, "wg2", cfg.RuleGroups[1].Via, "the main file wins")
+	require.Len(t, cfg.Hosts, 1)
+	assert.Len(t, cfg.Files(), 3)
+
+	// Saving writes only the changed section and keeps references and fragments
+	cfg.Hosts = append(cfg.Hosts, config.HostOverride{Pattern: "tv.lan", A: []string{"192.168.1.20"}})
+	require.NoError(t, cfg.Save())
+
+	saved, err := os.ReadFile(path) //nolint:gosec // test file
+	require.NoError(t, err)
+	assert.Contains(t, string(saved), "${file:secret}")
+	assert.Contains(t, string(saved), "${OUTWAY_TEST_UPSTREAM}")
+	assert.Contains(t, string(saved), "tv.lan")
+	assert.NotContains(t, string(saved), "nas.lan")
+	assert.NotContains(t, string(saved), "video")
+
+	reloaded, err := config.Load(path)
+	require.NoError(t, err)
+	assert.Len(t, reloaded.Hosts, 2)
+	assert.Len(t, reloaded.RuleGroups, 2)
+}
+
+func TestLoadSubstitutionErrors(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	undefined := filepath.Join(dir, "undefined.yaml")
+	writeFile(t, undefined, "jwt_secret: ${OUTWAY_TEST_UNDEFINED}\n")
+
+	_, err := config.Load(undefined)
+	require.Error(t, err)
+
+	missing := filepath.Join(dir, "missing.yaml")
+	writeFile(t, missing, "include: [nope.yaml]\n")
+
+	_, err = config.Load(missing)
+	require.Error(t, err)
+
+	escaped := filepath.Join(dir, "escaped.yaml")
+	writeFile(t, escaped, "app_name: $${literal}\n")
+
+	cfg, err := config.Load(escaped)
+	require.NoError(t, err)
+	assert.Equal(t, "${literal}", cfg.AppName)
+}
+
+func TestConfigRevisionsAndRollback(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	writeFile(t, path, "upstreams:\n  - name: cf\n    address: 1.1.1.1:53\n")
+
+	cfg, err := config.Load(path)
+	require.NoError(t, err)
+
+	log := cfg.RevisionLog()
+	require.NotNil(t, log)
+
+	cfg.Hosts = []config.HostOverride{{Pattern: "nas.lan", A: []string{"192.168.1.10"}}}
+	require.NoError(t, cfg.SaveContext(config.WithAuthor(context.Background(), "admin@example.com")))
+
+	// Logins alone do not make revisions
+	cfg.RefreshTokens = []config.RefreshToken{{Token: "t", UserEmail: "admin@example.com"}}
+	require.NoError(t, cfg.Save())
+
+	revisions, err := log.List()
+	require.NoError(t, err)
+	require.Len(t, revisions, 2)
+	assert.Equal(t, 2, revisions[0].ID)
+	assert.Equal(t, "admin@example.com", revisions[0].Author)
+	assert.Equal(t, []string{"hosts"}, revisions[0].Sections)
+	assert.Equal(t, "initial", revisions[1].Note)
+
+	diff, err := log.Diff(0, 2)
+	require.NoError(t, err)
+	assert.Contains(t, diff, "+hosts:")
+
+	// A revision the running proxy rejects leaves the file as it is
+	errRejected := errors.New("rejected")
+	err = cfg.Rollback(context.Background(), 1, func(next *config.Config) error {
+		assert.Empty(t, next.Hosts)
+		assert.Equal(t, path, next.Path)
+
+		return errRejected
+	})
+	require.ErrorIs(t, err, errRejected)
+
+	current, err := config.Load(path)
+	require.NoError(t, err)
+	assert.Len(t, current.Hosts, 1)
+
+	require.NoError(t, cfg.Rollback(context.Background(), 1, nil))
+
+	restored, err := config.Load(path)
+	require.NoError(t, err)
+	assert.Empty(t, restored.Hosts)
+	assert.Len(t, restored.RefreshTokens, 1, "sessions survive a rollback")
+
+	revisions, err = log.List()
+	require.NoError(t, err)
+	require.Len(t, revisions, 3)
+	assert.Equal(t, "rollback to revision 1", revisions[0].Note)
+
+	require.ErrorIs(t, cfg.Rollback(context.Background(), 42, nil), config.ErrRevisionNotFound)
+}
+
+func TestRollbackKeepsComments(t *testing.T) {
+	t.Parallel()
+
+	const original = `# main outway config
+upstreams:
+  - name: cf # primary
+    address: 1.1.1.1:53
+refresh_tokens:
+  - token: t1
+    user_email: admin@example.com
+`
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	writeFile(t, path, original)
+
+	cfg, err := config.Load(path)
+	require.NoError(t, err)
+
+	cfg.Hosts = []config.HostOverride{{Pattern: "nas.lan", A: []string{"192.168.1.10"}}}
+	require.NoError(t, cfg.Save())
+
+	// Matching sessions restore the revision byte for byte
+	require.NoError(t, cfg.Rollback(context.Background(), 1, nil))
+
+	restored, err := os.ReadFile(path) //nolint:gosec // test file
+	require.NoError(t, err)
+	assert.Equal(t, original, string(restored))
+
+	// Other sessions replace only the session section
+	for _, tokens := range [][]config.RefreshToken{{{Token: "t2", UserEmail: "admin@example.com"}}, nil} {
+		cfg.RefreshTokens = tokens
+		require.NoError(t, cfg.Save())
+		require.NoError(t, cfg.Rollback(context.Background(), 1, nil))
+
+		restored, err = os.ReadFile(path) //nolint:gosec // test file
+		require.NoError(t, err)
+		assert.Contains(t, string(restored), "# main outway config")
+		assert.Contains(t, string(restored), "# primary")
+
+		loaded, err := config.Load(path)
+		require.NoError(t, err)
+		assert.Equal(t, tokens, loaded.RefreshTokens)
+	}
+}
+
+func TestRevisionSecretsRedacted(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	writeFile(t, path, `# outway
+upstreams:
+  - name: cf
+    address: 1.1.1.1:53
+jwt_secret: c2VjcmV0
+users:
+  - email: admin@example.com
+    password: $2a$10$hash
+    role: admin
+`)
+
+	cfg, err := config.Load(path)
+	require.NoError(t, err)
+
+	cfg.JWTSecret = "cm90YXRlZA=="
+	cfg.Users[0].Password = "$2a$10$rotated"
+	cfg.Hosts = []config.HostOverride{{Pattern: "nas.lan", A: []string{"192.168.1.10"}}}
+	require.NoError(t, cfg.Save())
+
+	_, content, err := cfg.RevisionLog().Get(2)
+	require.NoError(t, err)
+	require.Contains(t, string(content), "rotated", "revisions keep secrets for rollbacks")
+
+	redacted, err := config.RedactSecrets(content)
+	require.NoError(t, err)
+	assert.NotContains(t, string(redacted), "cm90YXRlZA")
+	assert.NotContains(t, string(redacted), "$2a$10$")
+	assert.Contains(t, string(redacted), "admin@example.com")
+	assert.Contains(t, string(redacted), "nas.lan")
+
+	diff, err := cfg.RevisionLog().Diff(0, 2)
+	require.NoError(t, err)
+	assert.Contains(t, diff, "+hosts:")
+	assert.NotContains(t, diff, "c2VjcmV0")
+	assert.NotContains(t, diff, "rotated")
+	assert.NotContains(t, diff, "$2a$10$")
+}
+
+func TestConfigImport(t *testing.T) {
+	t.Parallel()
+
+	cfg := &config.Config{
+		Listen:    config.ListenConfig{UDP: ":53", TCP: ":53"},
+		Upstreams: []config.UpstreamConfig{{Name: "cf", Address: "1.1.1.1:53", Type: "udp", Weight: 1}},
+		Hosts:     []config.HostOverride{{Pattern: "nas.lan", A: []string{"192.168.1.10"}}},
+		Users:     []config.UserConfig{{Email: "admin@example.com", Password: "hash", Role: "admin"}},
+	}
+
+	exported := cfg.Portable(true)
+	assert.Equal(t, []config.PortableUser{{Email: "admin@example.com", Role: "admin"}}, exported.Users)
+
+	in := config.Portable{
+		Upstreams: []config.UpstreamConfig{{Name: "google", Address: "8.8.8.8:53"}},
+		Hosts:     []config.HostOverride{{Pattern: "nas.lan", A: []string{"192.168.1.20"}}},
+	}
+
+	next, changes, err := cfg.Import(in, config.ImportMerge)
+	require.NoError(t, err)
+	assert.Len(t, next.Upstreams, 2)
+	assert.Equal(t, []config.SectionChange{
+		{Section: "upstreams", Added: []string{"google"}},
+		{Section: "hosts", Updated: []string{"nas.lan"}},
+	}, changes)
+	assert.Equal(t, "192.168.1.10", cfg.Hosts[0].A[0], "the running config is untouched")
+
+	next, changes, err = cfg.Import(in, config.ImportReplace)
+	require.NoError(t, err)
+	assert.Equal(t, "google", next.Upstreams[0].Name)
+	assert.Len(t, next.Upstreams, 1)
+	assert.Equal(t, []string{"cf"}, changes[0].Removed)
+
+	// Unchanged entries are not reported, even without derived fields
+	_, changes, err = cfg.Import(config.Portable{Upstreams: []config.UpstreamConfig{{Name: "cf", Address: "1.1.1.1:53"}}}, config.ImportMerge)
+	require.NoError(t, err)
+	assert.Equal(t, []config.SectionChange{{Section: "upstreams"}}, changes)
+
+	_, _, err = cfg.Import(config.Portable{Upstreams: []config.UpstreamConfig{}}, config.ImportReplace)
+	require.Error(t, err, "an import must leave a valid config")
+
+	_, err = config.ParseImportMode("overwrite")
+	require.Error(t, err)
+}
+
+func TestParseSchedule(t *testing.T) {
+	t.Parallel()
+
+	// 2026-10-19 is a Monday
+	at := func(day int, clock string) time.Time {
+		ts, err := time.ParseInLocation("2006-01-02 15:04", fmt.Sprintf("2026-10-%02d %s", day, clock), time.Local)
+		require.NoError(t, err)
+
+		return ts
+	}
+
+	s, err := config.ParseSchedule([]string{"mon-fri 09:00-18:00", "sat,sun 22:00-02:00"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"mon-fri 09:00-18:00", "sat,sun 22:00-02:00"}, s.Specs())
+
+	assert.True(t, s.Active(at(19, "09:00")))
+	assert.False(t, s.Active(at(19, "18:00")))
+	assert.False(t, s.Active(at(19, "08:59")))
+	assert.True(t, s.Active(at(23, "17:59")))
+	assert.False(t, s.Active(at(24, "12:00")))
+	assert.True(t, s.Active(at(24, "23:30")))
+	assert.True(t, s.Active(at(25, "01:59"))) // Saturday's window runs into Sunday
+	assert.True(t, s.Active(at(26, "01:00"))) // and Sunday's into Monday
+	assert.False(t, s.Active(at(26, "02:00")))
+	assert.False(t, s.Active(at(20, "01:00")))
+
+	everyDay, err := config.ParseSchedule([]string{"fri-mon 00:00-24:00"})
+	require.NoError(t, err)
+	assert.True(t, everyDay.Active(at(25, "23:59")))
+	assert.True(t, everyDay.Active(at(26, "00:00")))
+	assert.False(t, everyDay.Active(at(21, "12:00")))
+
+	none, err := config.ParseSchedule(nil)
+	require.NoError(t, err)
+	assert.Nil(t, none)
+	assert.True(t, none.Active(time.Now()))
+
+	invalid := []string{
+		"09:00", "mon 09:00-18:00 extra", "funday 09:00-10:00", "mon 9-18", "25:00-26:00", "24:00-01:00", "mon 09:60-10:00",
+	}
+
+	for _, spec := range invalid {
+		_, err := config.ParseSchedule([]string{spec})
+		require.Error(t, err, spec)
+	}
+}
+
+func TestRuleGroupEnabledAndSchedule(t *testing.T) {
+	t.Parallel()
+
+	disabled := false
+	cfg := &config.Config{RuleGroups: []config.RuleGroup{
+		{Name: "off", Via: "wg0", Enabled: &disabled, Patterns: []string{"off.example"}},
+		{Name: "night", Via: "wg0", Schedule: []string{"22:00-06:00"}, Patterns: []string{"night.example"}},
+	}}
+
+	rules := cfg.GetAllRules()
+	require.Len(t, rules, 2)
+
+	assert.False(t, cfg.RuleGroups[0].IsEnabled())
+	assert.True(t, rules[0].Disabled)
+	assert.False(t, rules[0].Active(time.Now()))
+
+	noon := time.Date(2026, 10, 19, 12, 0, 0, 0, time.Local)
+	assert.False(t, rules[1].Active(noon))
+	assert.True(t, rules[1].Active(noon.Add(11*time.Hour)))
+	assert.False(t, cfg.RuleGroups[1].Active(noon))
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeFile(t, path, `listen: {udp: ":5353", tcp: ":5353"}
+upstreams: [{name: cf, address: "1.1.1.1:53"}]
+rule_groups:
+  - name: night
+    via: wg0
+    schedule: ["noon"]
+    patterns: ["night.example"]
+`)
+
+	_, err := config.Load(path)
+	require.Error(t, err)
+}
+
+func TestLoadLocatesErrors(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+
+	writeFile(t, path, `listen: {udp: ":5353", tcp: ":5353"}
+upstreams:
+  - name: cf
+    address: 1.1.1.1:53
+rule_groups:
+  - name: video
+    via: wg0
+    patterns: ["*.example.com"]
+`)
+	writeFile(t, filepath.Join(dir, "conf.d", "10-work.yaml"), `rule_groups:
+  - name: work
+    via: wg1
+    patterns: ["*.corp"]
+    address_family: ipv5
+`)
+
+	_, err := config.Load(path)
+
+	var problem *config.Problem
+	require.ErrorAs(t, err, &problem)
+	assert.Equal(t, filepath.Join(dir, "conf.d", "10-work.yaml"), problem.File)
+	assert.Equal(t, 5, problem.Line)
+	assert.Contains(t, err.Error(), "10-work.yaml:5:5: rule group 'work'")
+
+	writeFile(t, filepath.Join(dir, "conf.d", "10-work.yaml"), "rule_groups: []\n")
+	writeFile(t, path, `listen: {udp: ":5353", tcp: ":5353"}
+upstreams:
+  - name: cf
+    address: 1.1.1.1:53
+    weight: heavy
+`)
+
+	_, err = config.Load(path)
+	require.ErrorAs(t, err, &problem)
+	assert.Equal(t, path, problem.File)
+	assert.Equal(t, 5, problem.Line)
+
+	writeFile(t, path, "listen:\n  udp: [\n")
+
+	_, err = config.Load(path)
+	require.ErrorAs(t, err, &problem)
+	assert.Equal(t, path, problem.File)
+	assert.Positive(t, problem.Line)
+}
+
+func TestValidateHTTPTLS(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeFile(t, path, `listen: {udp: ":5353", tcp: ":5353"}
+upstreams:
+  - name: cf
+    address: 1.1.1.1:53
+http:
+  tls_cert_file: /etc/outway/tls.crt
+`)
+
+	_, err := config.Load(path)
+	require.ErrorContains(t, err, "tls_cert_file and tls_key_file must be set together")
+
+	writeFile(t, path, `listen: {udp: ":5353", tcp: ":5353"}
+upstreams:
+  - name: cf
+    address: 1.1.1.1:53
+http:
+  tls_cert_file: /etc/outway/tls.crt
+  tls_key_file: /etc/outway/tls.key
+`)
+
+	cfg, err := config.Load(path)
+	require.NoError(t, err)
+	assert.True(t, cfg.HTTP.TLS())
+}
+
+func TestFormat(t *testing.T) {
+	t.Parallel()
+
+	out, err := config.Format([]byte(`# rules first
+rule_groups:
+  - patterns: ["*.example.com"]
+    via: ${VPN_IFACE}
+    name: video # streaming
+custom: kept
+listen:
+  tcp: ":53"
+  udp: ":53"
+`))
+	require.NoError(t, err)
+	assert.Equal(t, `listen:
+  udp: :53
+  tcp: :53
+# rules first
+rule_groups:
+- name: video # streaming
+  via: ${VPN_IFACE}
+  patterns:
+  - "*.example.com"
+custom: kept
+`, string(out))
+
+	again, err := config.Format(out)
+	require.NoError(t, err)
+	assert.Equal(t, string(out), string(again))
+
+	_, err = config.Format([]byte("listen: [\n"))
+	require.Error(t, err)
+}
+
+func TestSchema(t *testing.T) {
+	t.Parallel()
+
+	schema := config.Schema()
+	assert.Equal(t, config.SchemaID, schema["$schema"])
+
+	properties, ok := schema["properties"].(map[string]any)
+	require.True(t, ok)
+	assert.NotContains(t, properties, "Path")
+
+	groups, ok := properties["rule_groups"].(map[string]any)
+	require.True(t, ok)
+	assert.Equal(t, "array", groups["type"])
+
+	group, ok := groups["items"].(map[string]any)
+	require.True(t, ok)
+
+	fields, ok := group["properties"].(map[string]any)
+	require.True(t, ok)
+	assert.Equal(t, map[string]any{"type": "boolean"}, fields["enabled"])
+	assert.Equal(t, map[string]any{"type": "array", "items": map[string]any{"type": "string"}}, fields["schedule"])
+
+	family, ok := fields["address_family"].(map[string]any)
+	require.True(t, ok)
+	assert.Contains(t, family["enum"], config.AddressFamilyPreferIPv4)
+}
+
+func TestChanges(t *testing.T) {
+	t.Parallel()
+
+	a := &config.Config{
+		Upstreams: []config.UpstreamConfig{{Name: "cf", Address: "1.1.1.1:53"}, {Name: "g", Address: "8.8.8.8:53"}},
+		RuleGroups: []config.RuleGroup{
+			{Name: "video", Via: "wg0", Patterns: []string{"*.youtube.com"}},
+			{Name: "work", Via: "wg1", Patterns: []string{"*.corp"}},
+		},
+		Users:     []config.UserConfig{{Email: "a@example.com", Password: "hash", Role: "admin"}},
+		JWTSecret: "one",
+	}
+
+	b := &config.Config{
+		Upstreams: []config.UpstreamConfig{{Name: "g", Address: "8.8.8.8:53"}, {Name: "cf", Address: "1.1.1.1:53"}},
+		RuleGroups: []config.RuleGroup{
+			{Name: "video", Via: "wg2", Patterns: []string{"*.youtube.com"}},
+			{Name: "games", Via: "wg1", Patterns: []string{"*.steam.com"}},
+		},
+		Users:         []config.UserConfig{{Email: "a@example.com", Password: "other", Role: "admin"}},
+		JWTSecret:     "two",
+		RefreshTokens: []config.RefreshToken{{Token: "t", UserEmail: "a@example.com"}},
+	}
+
+	changes, err := config.Changes(a, b)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"~ rule_groups[name=video].via: wg0 -> wg2",
+		"- rule_groups[name=work]",
+		`+ rule_groups[name=games]: {name: games, via: wg1, patterns: ["*.steam.com"]}`,
+		"~ users[email=a@example.com].password: (secret) -> (secret)",
+		"~ jwt_secret: (secret) -> (secret)",
+	}, changes)
+
+	none, err := config.Changes(a, a)
+	require.NoError(t, err)
+	assert.Empty(t, none)
+}
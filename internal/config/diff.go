@@ -0,0 +1,155 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	yaml "github.com/goccy/go-yaml"
+)
+
+// secretKeys name values that are never printed by Changes.
+var secretKeys = []string{"password", "jwt_secret"} //nolint:gochecknoglobals // fixed list
+
+// Diff returns the top-level sections, named by their YAML keys, that differ
+// between a and b. Fields not stored in the file, such as Path, are ignored.
+func Diff(a, b *Config) []string {
+	va, vb := reflect.ValueOf(a).Elem(), reflect.ValueOf(b).Elem()
+	typ := va.Type()
+
+	var changed []string
+
+	for i := range typ.NumField() {
+		name, _, _ := strings.Cut(typ.Field(i).Tag.Get("yaml"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+
+		if !reflect.DeepEqual(va.Field(i).Interface(), vb.Field(i).Interface()) {
+			changed = append(changed, name)
+		}
+	}
+
+	return changed
+}
+
+// Changes describes how b differs from a, one line per value: "+ path: value"
+// when added, "- path: value" when removed and "~ path: old -> new" when
+// changed. List entries are matched by their key, so moving a rule group is
+// no change and rule_groups[name=video].via is the via of that group.
+// Refresh tokens are session state and left out; secrets are not printed.
+func Changes(a, b *Config) ([]string, error) {
+	ta, err := toTree(a)
+	if err != nil {
+		return nil, err
+	}
+
+	tb, err := toTree(b)
+	if err != nil {
+		return nil, err
+	}
+
+	ta, tb = remove(ta, "refresh_tokens"), remove(tb, "refresh_tokens")
+
+	var out []string
+
+	diffValue(&out, "", ta, tb)
+
+	return out, nil
+}
+
+func diffValue(out *[]string, path string, x, y any) {
+	switch xv := x.(type) {
+	case yaml.MapSlice:
+		if yv, ok := y.(yaml.MapSlice); ok {
+			diffMap(out, path, xv, yv)
+
+			return
+		}
+	case []any:
+		if yv, ok := y.([]any); ok && keyed(xv) && keyed(yv) {
+			diffList(out, path, xv, yv)
+
+			return
+		}
+	}
+
+	if !reflect.DeepEqual(x, y) {
+		*out = append(*out, fmt.Sprintf("~ %s: %s -> %s", path, showValue(path, x), showValue(path, y)))
+	}
+}
+
+func diffMap(out *[]string, path string, x, y yaml.MapSlice) {
+	for _, item := range x {
+		key := fmt.Sprint(item.Key)
+		if value, ok := lookup(y, key); ok {
+			diffValue(out, join(path, key), item.Value, value)
+		} else {
+			*out = append(*out, fmt.Sprintf("- %s: %s", join(path, key), showValue(join(path, key), item.Value)))
+		}
+	}
+
+	for _, item := range y {
+		key := fmt.Sprint(item.Key)
+		if _, ok := lookup(x, key); !ok {
+			*out = append(*out, fmt.Sprintf("+ %s: %s", join(path, key), showValue(join(path, key), item.Value)))
+		}
+	}
+}
+
+func diffList(out *[]string, path string, x, y []any) {
+	for _, item := range x {
+		key := entryKey(item)
+		if i := entryIndex(y, key); i >= 0 {
+			diffValue(out, path+"["+key+"]", item, y[i])
+		} else {
+			*out = append(*out, fmt.Sprintf("- %s[%s]", path, key))
+		}
+	}
+
+	for _, item := range y {
+		if key := entryKey(item); entryIndex(x, key) < 0 {
+			*out = append(*out, fmt.Sprintf("+ %s[%s]: %s", path, key, showValue(path, item)))
+		}
+	}
+}
+
+// keyed reports whether every entry of list has an entry key.
+func keyed(list []any) bool {
+	return len(list) > 0 && !slices.ContainsFunc(list, func(item any) bool { return entryKey(item) == "" })
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
+
+// showValue prints v on one line, hiding secrets.
+func showValue(path string, v any) string {
+	last := path[strings.LastIndex(path, ".")+1:]
+	if slices.Contains(secretKeys, last) {
+		return "(secret)"
+	}
+
+	if m, ok := v.(yaml.MapSlice); ok {
+		m = slices.Clone(m)
+		for i, item := range m {
+			if slices.Contains(secretKeys, fmt.Sprint(item.Key)) {
+				m[i].Value = "(secret)"
+			}
+		}
+
+		v = m
+	}
+
+	b, err := yaml.MarshalWithOptions(v, yaml.Flow(true))
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+
+	return strings.TrimSpace(string(b))
+}
@@ -0,0 +1,122 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	yaml "github.com/goccy/go-yaml"
+)
+
+// Format returns a config file in canonical form: keys in the order of the
+// config structs, unknown keys after them, and the layout Save writes.
+// Values are kept as written, including references, and so are comments on
+// keys and list entries.
+func Format(b []byte) ([]byte, error) {
+	comments := yaml.CommentMap{}
+
+	var tree yaml.MapSlice
+	if err := yaml.UnmarshalWithOptions(b, &tree, yaml.UseOrderedMap(), yaml.CommentToMap(comments)); err != nil {
+		return nil, err
+	}
+
+	out, err := yaml.MarshalWithOptions(sortKeys(tree, reflect.TypeFor[Config]()), yaml.WithComment(comments))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode config: %w", err)
+	}
+
+	return out, nil
+}
+
+// sortKeys orders the mappings of v by the fields of typ.
+func sortKeys(v any, typ reflect.Type) any {
+	for typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+
+	switch v := v.(type) {
+	case yaml.MapSlice:
+		if typ.Kind() != reflect.Struct {
+			return v
+		}
+
+		fields := yamlFields(typ)
+		out := slices.Clone(v)
+
+		for i, item := range out {
+			if f, ok := fields[fmt.Sprint(item.Key)]; ok {
+				out[i].Value = sortKeys(item.Value, f.typ)
+			}
+		}
+
+		// Unknown keys sort last and keep their order
+		rank := func(item yaml.MapItem) int {
+			if f, ok := fields[fmt.Sprint(item.Key)]; ok {
+				return f.index
+			}
+
+			return len(fields)
+		}
+
+		slices.SortStableFunc(out, func(a, b yaml.MapItem) int { return rank(a) - rank(b) })
+
+		return out
+	case []any:
+		if typ.Kind() != reflect.Slice {
+			return v
+		}
+
+		out := make([]any, len(v))
+		for i, item := range v {
+			out[i] = sortKeys(item, typ.Elem())
+		}
+
+		return out
+	default:
+		return v
+	}
+}
+
+type yamlField struct {
+	index int
+	typ   reflect.Type
+}
+
+// yamlFields maps the YAML keys of a struct to their position and type;
+// inlined structs contribute their fields in place.
+func yamlFields(typ reflect.Type) map[string]yamlField {
+	fields := map[string]yamlField{}
+
+	var walk func(t reflect.Type)
+
+	walk = func(t reflect.Type) {
+		for i := range t.NumField() {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+
+			name, opts, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+			if name == "-" {
+				continue
+			}
+
+			if strings.Contains(opts, "inline") && f.Type.Kind() == reflect.Struct {
+				walk(f.Type)
+
+				continue
+			}
+
+			if name == "" {
+				name = strings.ToLower(f.Name)
+			}
+
+			fields[name] = yamlField{index: len(fields), typ: f.Type}
+		}
+	}
+
+	walk(typ)
+
+	return fields
+}
@@ -0,0 +1,451 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+
+	yaml "github.com/goccy/go-yaml"
+)
+
+// DropInDir is the directory next to the main config file whose *.yaml
+// files are merged after the include list.
+const DropInDir = "conf.d"
+
+var (
+	errIncludeNotFound   = errors.New("included config file not found")
+	errIncludeInvalid    = errors.New("include must be a list of paths")
+	errNestedInclude     = errors.New("include is only allowed in the main config file")
+	errVariableUndefined = errors.New("undefined environment variable")
+)
+
+// refPattern matches ${NAME} and ${file:/path} references; $${ is a literal ${.
+var refPattern = regexp.MustCompile(`\$?\$\{([^}]*)\}`) //nolint:gochecknoglobals // compiled once
+
+// entryKeys name the fields identifying entries of top-level lists, e.g. rule
+// groups by name and hosts by pattern.
+var entryKeys = []string{"name", "pattern", "email", "token"} //nolint:gochecknoglobals // fixed key order
+
+// source records how a config was assembled, so Save can write changes back
+// to the main file without inlining fragments or substituted values.
+type source struct {
+	raw      yaml.MapSlice              // main file as written
+	loaded   yaml.MapSlice              // config as loaded, with defaults
+	included map[string]map[string]bool // section -> keys of list entries owned by fragments
+	files    []string                   // fragments in merge order
+}
+
+// loadSource reads the main file and its fragments and returns the merged
+// tree. Fragments are merged in include order, then conf.d/*.yaml sorted by
+// name, and the main file last, so its values win.
+func loadSource(path string) (*source, yaml.MapSlice, error) {
+	raw, err := readTree(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dir := filepath.Dir(path)
+
+	main, err := substitute(raw, dir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	files, err := fragmentFiles(dir, main.(yaml.MapSlice)) //nolint:forcetypeassert // substitute keeps the type
+	if err != nil {
+		return nil, nil, err
+	}
+
+	src := &source{raw: raw, included: map[string]map[string]bool{}, files: files}
+
+	var merged yaml.MapSlice
+
+	for _, file := range files {
+		tree, err := readTree(file)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if _, ok := lookup(tree, "include"); ok {
+			return nil, nil, fmt.Errorf("%s: %w", file, errNestedInclude)
+		}
+
+		fragment, err := substitute(tree, filepath.Dir(file))
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", file, err)
+		}
+
+		merged = mergeTree(merged, fragment.(yaml.MapSlice)) //nolint:forcetypeassert // substitute keeps the type
+	}
+
+	// Entries still defined only by fragments are left out when saving
+	for _, item := range merged {
+		if list, ok := item.Value.([]any); ok {
+			src.included[fmt.Sprint(item.Key)] = entrySet(list)
+		}
+	}
+
+	for _, item := range raw {
+		if list, ok := item.Value.([]any); ok {
+			for key := range entrySet(list) {
+				delete(src.included[fmt.Sprint(item.Key)], key)
+			}
+		}
+	}
+
+	return src, mergeTree(merged, main.(yaml.MapSlice)), nil //nolint:forcetypeassert // substitute keeps the type
+}
+
+// fragmentFiles lists the include entries of the main file, then the drop-in
+// directory. Include entries are paths or globs relative to dir.
+func fragmentFiles(dir string, main yaml.MapSlice) ([]string, error) {
+	var files []string
+
+	if v, ok := lookup(main, "include"); ok && v != nil {
+		list, ok := v.([]any)
+		if !ok {
+			return nil, errIncludeInvalid
+		}
+
+		for _, entry := range list {
+			pattern, ok := entry.(string)
+			if !ok {
+				return nil, errIncludeInvalid
+			}
+
+			if !filepath.IsAbs(pattern) {
+				pattern = filepath.Join(dir, pattern)
+			}
+
+			matches, err := filepath.Glob(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("include %q: %w", entry, err)
+			}
+
+			if len(matches) == 0 && !strings.ContainsAny(pattern, "*?[") {
+				return nil, fmt.Errorf("%w: %s", errIncludeNotFound, pattern)
+			}
+
+			files = append(files, matches...)
+		}
+	}
+
+	dropIns, err := filepath.Glob(filepath.Join(dir, DropInDir, "*.yaml"))
+	if err != nil {
+		return nil, fmt.Errorf("list %s: %w", DropInDir, err)
+	}
+
+	slices.Sort(dropIns)
+
+	return append(files, dropIns...), nil
+}
+
+func readTree(path string) (yaml.MapSlice, error) {
+	b, err := os.ReadFile(path) //nolint:gosec // config file path is validated
+	if err != nil {
+		return nil, err
+	}
+
+	var tree yaml.MapSlice
+	if err := yaml.UnmarshalWithOptions(b, &tree, yaml.UseOrderedMap()); err != nil {
+		return nil, yamlProblem(path, err)
+	}
+
+	return tree, nil
+}
+
+// toTree returns v as saved to YAML.
+func toTree(v any) (yaml.MapSlice, error) {
+	b, err := yaml.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal config to YAML: %w", err)
+	}
+
+	var tree yaml.MapSlice
+	if err := yaml.UnmarshalWithOptions(b, &tree, yaml.UseOrderedMap()); err != nil {
+		return nil, fmt.Errorf("failed to decode config tree: %w", err)
+	}
+
+	return tree, nil
+}
+
+// substitute expands references in string values of v. File references are
+// relative to dir and lose their trailing newline.
+func substitute(v any, dir string) (any, error) {
+	switch v := v.(type) {
+	case yaml.MapSlice:
+		out := make(yaml.MapSlice, 0, len(v))
+
+		for _, item := range v {
+			value, err := substitute(item.Value, dir)
+			if err != nil {
+				return nil, err
+			}
+
+			out = append(out, yaml.MapItem{Key: item.Key, Value: value})
+		}
+
+		return out, nil
+	case []any:
+		out := make([]any, 0, len(v))
+
+		for _, item := range v {
+			value, err := substitute(item, dir)
+			if err != nil {
+				return nil, err
+			}
+
+			out = append(out, value)
+		}
+
+		return out, nil
+	case string:
+		return expand(v, dir)
+	default:
+		return v, nil
+	}
+}
+
+func expand(s, dir string) (string, error) {
+	var err error
+
+	out := refPattern.ReplaceAllStringFunc(s, func(m string) string {
+		if strings.HasPrefix(m, "$$") {
+			return m[1:]
+		}
+
+		ref := m[2 : len(m)-1]
+
+		if path, ok := strings.CutPrefix(ref, "file:"); ok {
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(dir, path)
+			}
+
+			b, rerr := os.ReadFile(path) //nolint:gosec // path comes from the config file
+			if rerr != nil {
+				err = errors.Join(err, fmt.Errorf("substitute %s: %w", m, rerr))
+			}
+
+			return strings.TrimRight(string(b), "\r\n")
+		}
+
+		value, ok := os.LookupEnv(ref)
+		if !ok {
+			err = errors.Join(err, fmt.Errorf("%w: %s", errVariableUndefined, ref))
+		}
+
+		return value
+	})
+
+	return out, err
+}
+
+// mergeTree merges src into dst: mappings merge recursively, lists append
+// and entries with the same key replace earlier ones, other values override.
+func mergeTree(dst, src yaml.MapSlice) yaml.MapSlice {
+	out := slices.Clone(dst)
+
+	for _, item := range src {
+		key := fmt.Sprint(item.Key)
+		prev, _ := lookup(out, key)
+
+		switch value := item.Value.(type) {
+		case yaml.MapSlice:
+			if prevMap, ok := prev.(yaml.MapSlice); ok {
+				out = set(out, key, mergeTree(prevMap, value))
+
+				continue
+			}
+		case []any:
+			if prevList, ok := prev.([]any); ok {
+				out = set(out, key, mergeList(prevList, value))
+
+				continue
+			}
+		}
+
+		out = set(out, key, item.Value)
+	}
+
+	return out
+}
+
+func mergeList(dst, src []any) []any {
+	out := slices.Clone(dst)
+
+	for _, item := range src {
+		if i := entryIndex(out, entryKey(item)); i >= 0 {
+			out[i] = item
+
+			continue
+		}
+
+		out = append(out, item)
+	}
+
+	return out
+}
+
+// patch returns the main file with the sections that differ from the loaded
+// config replaced by cur. Unchanged values keep their references and list
+// entries owned by fragments stay in their files.
+func (s *source) patch(cur yaml.MapSlice) yaml.MapSlice {
+	doc := slices.Clone(s.raw)
+
+	for _, item := range cur {
+		key := fmt.Sprint(item.Key)
+
+		loaded, _ := lookup(s.loaded, key)
+		if reflect.DeepEqual(item.Value, loaded) {
+			continue
+		}
+
+		raw, _ := lookup(s.raw, key)
+
+		if list, ok := item.Value.([]any); ok {
+			own := s.ownEntries(key, list, raw, loaded)
+			if len(own) == 0 {
+				doc = remove(doc, key)
+			} else {
+				doc = set(doc, key, own)
+			}
+
+			continue
+		}
+
+		doc = set(doc, key, patchValue(raw, item.Value, loaded))
+	}
+
+	for _, item := range s.loaded {
+		if _, ok := lookup(cur, fmt.Sprint(item.Key)); !ok {
+			doc = remove(doc, fmt.Sprint(item.Key))
+		}
+	}
+
+	return doc
+}
+
+// ownEntries drops unchanged entries owned by fragments from list and keeps
+// the main file's spelling of unchanged entries.
+func (s *source) ownEntries(section string, list []any, raw, loaded any) []any {
+	rawList, _ := raw.([]any)
+	loadedList, _ := loaded.([]any)
+
+	out := make([]any, 0, len(list))
+
+	for _, item := range list {
+		key := entryKey(item)
+
+		if i := entryIndex(loadedList, key); i >= 0 && reflect.DeepEqual(item, loadedList[i]) {
+			if s.included[section][key] {
+				continue
+			}
+
+			if j := entryIndex(rawList, key); j >= 0 {
+				item = rawList[j]
+			}
+		}
+
+		out = append(out, item)
+	}
+
+	return out
+}
+
+func patchValue(raw, cur, loaded any) any {
+	curMap, ok := cur.(yaml.MapSlice)
+	if !ok {
+		return cur
+	}
+
+	rawMap, _ := raw.(yaml.MapSlice)
+	loadedMap, _ := loaded.(yaml.MapSlice)
+	out := slices.Clone(rawMap)
+
+	for _, item := range curMap {
+		key := fmt.Sprint(item.Key)
+
+		prev, _ := lookup(loadedMap, key)
+		if reflect.DeepEqual(item.Value, prev) {
+			continue
+		}
+
+		rawValue, _ := lookup(rawMap, key)
+		out = set(out, key, patchValue(rawValue, item.Value, prev))
+	}
+
+	for _, item := range loadedMap {
+		if _, ok := lookup(curMap, fmt.Sprint(item.Key)); !ok {
+			out = remove(out, fmt.Sprint(item.Key))
+		}
+	}
+
+	return out
+}
+
+func entrySet(list []any) map[string]bool {
+	keys := map[string]bool{}
+
+	for _, item := range list {
+		if key := entryKey(item); key != "" {
+			keys[key] = true
+		}
+	}
+
+	return keys
+}
+
+// entryKey returns the identifying field of a list entry, or "" if it has none.
+func entryKey(item any) string {
+	m, ok := item.(yaml.MapSlice)
+	if !ok {
+		return ""
+	}
+
+	for _, name := range entryKeys {
+		if v, ok := lookup(m, name); ok {
+			return name + "=" + fmt.Sprint(v)
+		}
+	}
+
+	return ""
+}
+
+func entryIndex(list []any, key string) int {
+	if key == "" {
+		return -1
+	}
+
+	return slices.IndexFunc(list, func(item any) bool { return entryKey(item) == key })
+}
+
+func lookup(m yaml.MapSlice, key string) (any, bool) {
+	for _, item := range m {
+		if fmt.Sprint(item.Key) == key {
+			return item.Value, true
+		}
+	}
+
+	return nil, false
+}
+
+func set(m yaml.MapSlice, key string, value any) yaml.MapSlice {
+	for i, item := range m {
+		if fmt.Sprint(item.Key) == key {
+			m[i].Value = value
+
+			return m
+		}
+	}
+
+	return append(m, yaml.MapItem{Key: key, Value: value})
+}
+
+func remove(m yaml.MapSlice, key string) yaml.MapSlice {
+	return slices.DeleteFunc(m, func(item yaml.MapItem) bool { return fmt.Sprint(item.Key) == key })
+}
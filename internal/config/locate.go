@@ -0,0 +1,182 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+
+	yaml "github.com/goccy/go-yaml"
+	"github.com/goccy/go-yaml/ast"
+	"github.com/goccy/go-yaml/parser"
+)
+
+// Problem is a config error located in one of the config files.
+type Problem struct {
+	File   string
+	Line   int // 1-based; 0 when only the file is known
+	Column int
+	Err    error
+}
+
+func (p *Problem) Error() string {
+	msg := p.Err.Error()
+
+	var yerr yaml.Error
+	if errors.As(p.Err, &yerr) {
+		msg = yerr.GetMessage() // the position is printed already
+	}
+
+	if p.Line == 0 {
+		return p.File + ": " + msg
+	}
+
+	return fmt.Sprintf("%s:%d:%d: %s", p.File, p.Line, p.Column, msg)
+}
+
+func (p *Problem) Unwrap() error { return p.Err }
+
+// fieldError is a validation error with the YAML keys leading to the value at
+// fault. List entries are named by their entry key, so rule_groups/video/via
+// is the via of the rule group named video.
+type fieldError struct {
+	path []string
+	err  error
+}
+
+func (e *fieldError) Error() string { return e.err.Error() }
+
+func (e *fieldError) Unwrap() error { return e.err }
+
+// at attaches the YAML path of the value at fault to err.
+func at(err error, path ...string) error {
+	return &fieldError{path: path, err: err}
+}
+
+// yamlProblem returns err as a Problem in file when it carries a position.
+func yamlProblem(file string, err error) error {
+	var yerr yaml.Error
+	if !errors.As(err, &yerr) || yerr.GetToken() == nil {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+
+	pos := yerr.GetToken().Position
+
+	return &Problem{File: file, Line: pos.Line, Column: pos.Column, Err: err}
+}
+
+// locate turns a decode or validation error of the config loaded from path
+// into a Problem pointing at the file and line at fault. Errors it cannot
+// place are returned as they are.
+func (s *source) locate(path string, err error) error {
+	// The main file wins merges, so it is searched first
+	files := append([]string{path}, s.files...)
+	slices.Reverse(files[1:])
+
+	var ferr *fieldError
+	if errors.As(err, &ferr) {
+		return locateField(files, ferr.path, err)
+	}
+
+	var yerr yaml.Error
+	if !errors.As(err, &yerr) {
+		return err
+	}
+
+	// Positions refer to the merged document; decode the files one by one
+	// to find the one at fault
+	for _, file := range files {
+		b, rerr := os.ReadFile(file) //nolint:gosec // config file path is validated
+		if rerr != nil {
+			continue
+		}
+
+		var probe Config
+		if derr := yaml.Unmarshal(b, &probe); errors.As(derr, &yerr) && yerr.GetToken() != nil {
+			return yamlProblem(file, derr)
+		}
+	}
+
+	return err
+}
+
+// locateField finds the file defining most of path and returns err at that spot.
+func locateField(files, path []string, err error) error {
+	var (
+		best  *Problem
+		depth int
+	)
+
+	for _, file := range files {
+		f, perr := parser.ParseFile(file, 0)
+		if perr != nil || len(f.Docs) == 0 {
+			continue
+		}
+
+		node, n := findPath(f.Docs[0].Body, path)
+		if node == nil || n <= depth {
+			continue
+		}
+
+		pos := node.GetToken().Position
+		best, depth = &Problem{File: file, Line: pos.Line, Column: pos.Column, Err: err}, n
+
+		if n == len(path) {
+			break
+		}
+	}
+
+	if best == nil {
+		return err
+	}
+
+	return best
+}
+
+// findPath walks path from node and returns the deepest node found with the
+// number of path elements it matched.
+func findPath(node ast.Node, path []string) (ast.Node, int) {
+	var found ast.Node
+
+	for i, key := range path {
+		next, report := child(node, key)
+		if next == nil {
+			return found, i
+		}
+
+		node, found = next, report
+	}
+
+	return found, len(path)
+}
+
+// child returns the value under key of a mapping, or the list entry named
+// key, along with the node to report.
+func child(node ast.Node, key string) (ast.Node, ast.Node) {
+	switch n := node.(type) {
+	case *ast.AnchorNode:
+		return child(n.Value, key)
+	case *ast.TagNode:
+		return child(n.Value, key)
+	case *ast.MappingValueNode:
+		if n.Key.GetToken().Value == key {
+			return n.Value, n.Key
+		}
+	case *ast.MappingNode:
+		for _, value := range n.Values {
+			if value.Key.GetToken().Value == key {
+				return value.Value, value.Key
+			}
+		}
+	case *ast.SequenceNode:
+		for _, entry := range n.Values {
+			for _, field := range entryKeys {
+				if value, _ := child(entry, field); value != nil && value.GetToken().Value == key {
+					return entry, entry
+				}
+			}
+		}
+	}
+
+	return nil, nil
+}
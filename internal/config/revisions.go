@@ -0,0 +1,535 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	yaml "github.com/goccy/go-yaml"
+	"github.com/goccy/go-yaml/ast"
+	"github.com/goccy/go-yaml/parser"
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+const (
+	defaultRevisionsKeep = 50
+	revisionsDirPerm     = 0o700
+	revisionIDWidth      = 6
+	// revisionsDirName is the default state directory next to the main file.
+	revisionsDirName = "revisions"
+)
+
+var (
+	// ErrRevisionNotFound is returned for an unknown or dropped revision.
+	ErrRevisionNotFound = errors.New("config revision not found")
+	// ErrRevisionsDisabled is returned when the config was not loaded from a file.
+	ErrRevisionsDisabled = errors.New("config revisions are not enabled")
+	// ErrRevisionInvalid is returned when a revision no longer loads, e.g. after an include was removed.
+	ErrRevisionInvalid = errors.New("revision is not a valid config")
+)
+
+// sessionSections change on every login and are neither recorded nor rolled back.
+var sessionSections = []string{"refresh_tokens"} //nolint:gochecknoglobals // fixed list
+
+// secretPaths are the values SafeConfig leaves out, redacted from revisions.
+//
+//nolint:gochecknoglobals // fixed list
+var secretPaths = []string{"$.jwt_secret", "$.users[*].password", "$.refresh_tokens"}
+
+const redactedValue = `"<redacted>"`
+
+// RevisionsConfig controls the revision history of the config file.
+type RevisionsConfig struct {
+	// Path is the state directory of revisions (default: revisions/ next to the config file)
+	Path string `yaml:"path,omitempty"`
+	// Keep is how many revisions are kept (default 50)
+	Keep int `yaml:"keep,omitempty"`
+}
+
+// Revision describes one saved state of the main config file.
+type Revision struct {
+	ID       int       `json:"id"`
+	Time     time.Time `json:"time"`
+	Author   string    `json:"author,omitempty"`
+	Sections []string  `json:"sections,omitempty"` // sections changed by this revision
+	Note     string    `json:"note,omitempty"`
+}
+
+type authorKey struct{}
+
+// WithAuthor returns a context whose config saves are recorded as made by author.
+func WithAuthor(ctx context.Context, author string) context.Context {
+	return context.WithValue(ctx, authorKey{}, author)
+}
+
+// AuthorFrom returns the author set by WithAuthor.
+func AuthorFrom(ctx context.Context) string {
+	author, _ := ctx.Value(authorKey{}).(string)
+
+	return author
+}
+
+// RevisionLog stores numbered copies of the main config file, one per save.
+type RevisionLog struct {
+	dir  string
+	keep int
+}
+
+// NewRevisionLog returns the revision log kept in dir.
+func NewRevisionLog(dir string, keep int) *RevisionLog {
+	if keep <= 0 {
+		keep = defaultRevisionsKeep
+	}
+
+	return &RevisionLog{dir: dir, keep: keep}
+}
+
+// RevisionLog returns the revision log of a loaded config, or nil.
+func (c *Config) RevisionLog() *RevisionLog {
+	if c.source == nil || c.Revisions.Path == "" {
+		return nil
+	}
+
+	return NewRevisionLog(c.Revisions.Path, c.Revisions.Keep)
+}
+
+// List returns revisions newest first.
+func (l *RevisionLog) List() ([]Revision, error) {
+	paths, err := filepath.Glob(filepath.Join(l.dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("list revisions: %w", err)
+	}
+
+	revisions := make([]Revision, 0, len(paths))
+
+	for _, path := range paths {
+		b, err := os.ReadFile(path) //nolint:gosec // revision of the state directory
+		if err != nil {
+			return nil, fmt.Errorf("read revision: %w", err)
+		}
+
+		var rev Revision
+		if err := json.Unmarshal(b, &rev); err != nil {
+			return nil, fmt.Errorf("decode revision %s: %w", path, err)
+		}
+
+		revisions = append(revisions, rev)
+	}
+
+	slices.SortFunc(revisions, func(a, b Revision) int { return b.ID - a.ID })
+
+	return revisions, nil
+}
+
+// Get returns a revision and the config file it saved.
+func (l *RevisionLog) Get(id int) (Revision, []byte, error) {
+	var rev Revision
+
+	b, err := os.ReadFile(l.path(id, ".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return rev, nil, fmt.Errorf("%w: %d", ErrRevisionNotFound, id)
+	}
+
+	if err != nil {
+		return rev, nil, fmt.Errorf("read revision: %w", err)
+	}
+
+	if err := json.Unmarshal(b, &rev); err != nil {
+		return rev, nil, fmt.Errorf("decode revision %d: %w", id, err)
+	}
+
+	content, err := os.ReadFile(l.path(id, ".yaml"))
+	if err != nil {
+		return rev, nil, fmt.Errorf("read revision %d: %w", id, err)
+	}
+
+	return rev, content, nil
+}
+
+// Diff returns a unified diff of the config file from revision from to revision
+// to, with secrets redacted. A from of zero means the revision before to.
+func (l *RevisionLog) Diff(from, to int) (string, error) {
+	_, after, err := l.Get(to)
+	if err != nil {
+		return "", err
+	}
+
+	var before []byte
+
+	if from == 0 {
+		from = to - 1
+		if _, content, err := l.Get(from); err == nil {
+			before = content
+		}
+	} else if _, before, err = l.Get(from); err != nil {
+		return "", err
+	}
+
+	if before, err = RedactSecrets(before); err != nil {
+		return "", err
+	}
+
+	if after, err = RedactSecrets(after); err != nil {
+		return "", err
+	}
+
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(before)),
+		B:        difflib.SplitLines(string(after)),
+		FromFile: "revision " + strconv.Itoa(from),
+		ToFile:   "revision " + strconv.Itoa(to),
+		Context:  3,
+	})
+}
+
+// RedactSecrets replaces the JWT secret, password hashes and refresh tokens in
+// a saved config file, keeping its layout and comments.
+func RedactSecrets(content []byte) ([]byte, error) {
+	file, err := parser.ParseBytes(content, parser.ParseComments)
+	if err != nil {
+		return nil, fmt.Errorf("parse revision: %w", err)
+	}
+
+	for _, s := range secretPaths {
+		path, err := yaml.PathString(s)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := path.ReplaceWithReader(file, strings.NewReader(redactedValue)); err != nil {
+			return nil, fmt.Errorf("redact %s: %w", s, err)
+		}
+	}
+
+	return []byte(file.String()), nil
+}
+
+// record stores content as the next revision and drops the oldest ones.
+func (l *RevisionLog) record(rev Revision, content []byte) (Revision, error) {
+	if err := os.MkdirAll(l.dir, revisionsDirPerm); err != nil {
+		return rev, fmt.Errorf("create revisions directory: %w", err)
+	}
+
+	revisions, err := l.List()
+	if err != nil {
+		return rev, err
+	}
+
+	rev.ID = 1
+	if len(revisions) > 0 {
+		rev.ID = revisions[0].ID + 1
+	}
+
+	meta, err := json.Marshal(rev)
+	if err != nil {
+		return rev, fmt.Errorf("encode revision: %w", err)
+	}
+
+	// The content goes first: a revision is listed once its metadata exists
+	if err := os.WriteFile(l.path(rev.ID, ".yaml"), content, defaultFilePerm); err != nil {
+		return rev, fmt.Errorf("write revision: %w", err)
+	}
+
+	if err := os.WriteFile(l.path(rev.ID, ".json"), meta, defaultFilePerm); err != nil {
+		return rev, fmt.Errorf("write revision: %w", err)
+	}
+
+	for _, old := range revisions[min(len(revisions), l.keep-1):] {
+		_ = os.Remove(l.path(old.ID, ".json"))
+		_ = os.Remove(l.path(old.ID, ".yaml"))
+	}
+
+	return rev, nil
+}
+
+func (l *RevisionLog) path(id int, ext string) string {
+	return filepath.Join(l.dir, fmt.Sprintf("%0*d%s", revisionIDWidth, id, ext))
+}
+
+// recordSave stores a save that changed sections from previous to content.
+// The first save also stores the file as it was, so it can be restored.
+func (c *Config) recordSave(ctx context.Context, previous, content []byte, sections []string) error {
+	log := c.RevisionLog()
+	if log == nil {
+		return nil
+	}
+
+	sections = slices.DeleteFunc(sections, func(s string) bool { return slices.Contains(sessionSections, s) })
+	if len(sections) == 0 {
+		return nil
+	}
+
+	revisions, err := log.List()
+	if err != nil {
+		return err
+	}
+
+	if len(revisions) == 0 && previous != nil {
+		if _, err := log.record(Revision{Time: time.Now(), Note: "initial"}, previous); err != nil {
+			return err
+		}
+	}
+
+	_, err = log.record(Revision{
+		Time:     time.Now(),
+		Author:   AuthorFrom(ctx),
+		Sections: sections,
+		Note:     noteFrom(ctx),
+	}, content)
+
+	return err
+}
+
+type noteKey struct{}
+
+func withNote(ctx context.Context, note string) context.Context {
+	return context.WithValue(ctx, noteKey{}, note)
+}
+
+func noteFrom(ctx context.Context) string {
+	note, _ := ctx.Value(noteKey{}).(string)
+
+	return note
+}
+
+// Rollback restores the main config file from revision id and records the
+// restore as a new revision. Sessions are kept. The revision is loaded and
+// validated first and handed to apply, which puts it into effect, e.g. by
+// reloading the running proxy; the file is only written once apply succeeds.
+// A nil apply leaves the running proxy to pick the file up on its next reload.
+func (c *Config) Rollback(ctx context.Context, id int, apply func(*Config) error) error {
+	log := c.RevisionLog()
+	if log == nil {
+		return ErrRevisionsDisabled
+	}
+
+	_, content, err := log.Get(id)
+	if err != nil {
+		return err
+	}
+
+	// Includes and drop-ins resolve relative to the directory of the file
+	tmp := c.Path + ".rollback"
+	defer func() { _ = os.Remove(tmp) }()
+
+	previous, content, next, err := c.loadRevision(id, content, tmp)
+	if err != nil {
+		return err
+	}
+
+	if apply != nil {
+		next.Path = c.Path
+
+		if err := apply(next); err != nil {
+			return fmt.Errorf("%w: revision %d: %w", ErrRevisionInvalid, id, err)
+		}
+	}
+
+	saveMu.Lock()
+	defer saveMu.Unlock()
+
+	// Logins while the revision was applied keep their sessions
+	if current, err := os.ReadFile(c.Path); err == nil && !bytes.Equal(current, previous) {
+		if content, err = keepSessions(content, current); err != nil {
+			return err
+		}
+
+		if err := writeConfigFile(tmp, content); err != nil {
+			return err
+		}
+
+		previous = current
+	}
+
+	if err := os.Rename(tmp, c.Path); err != nil {
+		return fmt.Errorf("restore revision %d: %w", id, err)
+	}
+
+	before, after, err := decodeSections(previous, content)
+	if err != nil {
+		return err
+	}
+
+	ctx = withNote(ctx, "rollback to revision "+strconv.Itoa(id))
+
+	return c.recordSave(ctx, previous, content, changedSections(before, after))
+}
+
+// loadRevision writes content of revision id with the current sessions to tmp
+// and loads it. It returns the current file, the written content and the
+// loaded config.
+func (c *Config) loadRevision(id int, content []byte, tmp string) ([]byte, []byte, *Config, error) {
+	saveMu.Lock()
+	defer saveMu.Unlock()
+
+	previous, err := os.ReadFile(c.Path)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("read config: %w", err)
+	}
+
+	if content, err = keepSessions(content, previous); err != nil {
+		return nil, nil, nil, err
+	}
+
+	if err := writeConfigFile(tmp, content); err != nil {
+		return nil, nil, nil, err
+	}
+
+	next, err := Load(tmp)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("%w: revision %d: %w", ErrRevisionInvalid, id, err)
+	}
+
+	return previous, content, next, nil
+}
+
+// keepSessions replaces session sections of content with those of current.
+// Only those sections are rewritten, so the comments and layout of the revision
+// are kept, and content is returned as is when its sessions already match.
+func keepSessions(content, current []byte) ([]byte, error) {
+	tree, cur, err := decodeSections(content, current)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := parser.ParseBytes(content, parser.ParseComments)
+	if err != nil {
+		return nil, fmt.Errorf("decode config: %w", err)
+	}
+
+	var root *ast.MappingNode
+	if len(file.Docs) > 0 {
+		root, _ = file.Docs[0].Body.(*ast.MappingNode)
+	}
+
+	if len(tree) > 0 && (root == nil || root.IsFlowStyle) {
+		return marshalSessions(tree, cur)
+	}
+
+	var (
+		changed bool
+		added   yaml.MapSlice
+	)
+
+	for _, section := range sessionSections {
+		want, inCurrent := lookup(cur, section)
+		have, inContent := lookup(tree, section)
+
+		switch {
+		case inCurrent == inContent && reflect.DeepEqual(want, have):
+			continue
+		case !inCurrent:
+			root.Values = slices.DeleteFunc(root.Values, func(v *ast.MappingValueNode) bool {
+				return v.Key.GetToken().Value == section
+			})
+		case !inContent:
+			added = append(added, yaml.MapItem{Key: section, Value: want})
+		default:
+			if err := replaceSection(file, section, want); err != nil {
+				return nil, err
+			}
+		}
+
+		changed = true
+	}
+
+	if !changed {
+		return content, nil
+	}
+
+	out := []byte(file.String())
+	if len(added) == 0 {
+		return out, nil
+	}
+
+	tail, err := yaml.Marshal(added)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal config to YAML: %w", err)
+	}
+
+	if len(out) > 0 && !bytes.HasSuffix(out, []byte("\n")) {
+		out = append(out, '\n')
+	}
+
+	return append(out, tail...), nil
+}
+
+func replaceSection(file *ast.File, section string, value any) error {
+	path, err := yaml.PathString("$." + section)
+	if err != nil {
+		return err
+	}
+
+	b, err := yaml.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to marshal config to YAML: %w", err)
+	}
+
+	if err := path.ReplaceWithReader(file, bytes.NewReader(b)); err != nil {
+		return fmt.Errorf("replace %s: %w", section, err)
+	}
+
+	return nil
+}
+
+// marshalSessions is keepSessions for documents that are not a block mapping.
+func marshalSessions(tree, cur yaml.MapSlice) ([]byte, error) {
+	for _, section := range sessionSections {
+		if value, ok := lookup(cur, section); ok {
+			tree = set(tree, section, value)
+		} else {
+			tree = remove(tree, section)
+		}
+	}
+
+	out, err := yaml.Marshal(tree)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal config to YAML: %w", err)
+	}
+
+	return out, nil
+}
+
+func decodeSections(a, b []byte) (yaml.MapSlice, yaml.MapSlice, error) {
+	var ta, tb yaml.MapSlice
+
+	if err := yaml.UnmarshalWithOptions(a, &ta, yaml.UseOrderedMap()); err != nil {
+		return nil, nil, fmt.Errorf("decode config: %w", err)
+	}
+
+	if err := yaml.UnmarshalWithOptions(b, &tb, yaml.UseOrderedMap()); err != nil {
+		return nil, nil, fmt.Errorf("decode config: %w", err)
+	}
+
+	return ta, tb, nil
+}
+
+// changedSections returns the top-level keys whose values differ.
+func changedSections(before, after yaml.MapSlice) []string {
+	var sections []string
+
+	for _, item := range after {
+		key := fmt.Sprint(item.Key)
+		if prev, _ := lookup(before, key); !reflect.DeepEqual(prev, item.Value) {
+			sections = append(sections, key)
+		}
+	}
+
+	for _, item := range before {
+		key := fmt.Sprint(item.Key)
+		if _, ok := lookup(after, key); !ok {
+			sections = append(sections, key)
+		}
+	}
+
+	return sections
+}
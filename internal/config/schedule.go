@@ -0,0 +1,190 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	minutesPerHour = 60
+	minutesPerDay  = 24 * minutesPerHour
+	daysPerWeek    = 7
+)
+
+var (
+	errScheduleWindow = errors.New(`schedule window must look like "mon-fri 09:00-18:00"`)
+	errScheduleDay    = errors.New("unknown schedule day")
+	errScheduleTime   = errors.New("invalid schedule time")
+)
+
+// dayNames are indexed by time.Weekday.
+//
+//nolint:gochecknoglobals // fixed list
+var dayNames = []string{"sunday", "monday", "tuesday", "wednesday", "thursday", "friday", "saturday"}
+
+// Schedule is a parsed list of weekly time windows in local time. A nil
+// schedule is always active.
+type Schedule struct {
+	specs   []string
+	windows []window
+}
+
+type window struct {
+	days       [daysPerWeek]bool // indexed by time.Weekday
+	start, end int               // minutes since midnight; an end not after start wraps to the next day
+}
+
+// ParseSchedule parses windows like "mon-fri 09:00-18:00", "sat,sun 10:00-14:00"
+// or "22:00-06:00" (every day). A window ending at or before its start ends on
+// the next day. An empty list gives a nil schedule.
+func ParseSchedule(specs []string) (*Schedule, error) {
+	if len(specs) == 0 {
+		return nil, nil //nolint:nilnil // no schedule means always active
+	}
+
+	s := &Schedule{specs: slices.Clone(specs)}
+
+	for _, spec := range specs {
+		w, err := parseWindow(spec)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", err, spec)
+		}
+
+		s.windows = append(s.windows, w)
+	}
+
+	return s, nil
+}
+
+// Specs returns the windows as written.
+func (s *Schedule) Specs() []string {
+	if s == nil {
+		return nil
+	}
+
+	return slices.Clone(s.specs)
+}
+
+// Active reports whether t falls into one of the windows.
+func (s *Schedule) Active(t time.Time) bool {
+	if s == nil {
+		return true
+	}
+
+	return slices.ContainsFunc(s.windows, func(w window) bool { return w.contains(t) })
+}
+
+func (w window) contains(t time.Time) bool {
+	minute := t.Hour()*minutesPerHour + t.Minute()
+	day := t.Weekday()
+
+	if w.start < w.end {
+		return w.days[day] && minute >= w.start && minute < w.end
+	}
+
+	yesterday := (day + daysPerWeek - 1) % daysPerWeek
+
+	return (w.days[day] && minute >= w.start) || (w.days[yesterday] && minute < w.end)
+}
+
+func parseWindow(spec string) (window, error) {
+	var w window
+
+	fields := strings.Fields(strings.ToLower(spec))
+
+	switch len(fields) {
+	case 1:
+		for i := range w.days {
+			w.days[i] = true
+		}
+	case 2: //nolint:mnd // days and times
+		if err := parseDays(fields[0], &w.days); err != nil {
+			return w, err
+		}
+
+		fields = fields[1:]
+	default:
+		return w, errScheduleWindow
+	}
+
+	from, to, ok := strings.Cut(fields[0], "-")
+	if !ok {
+		return w, errScheduleWindow
+	}
+
+	var err error
+
+	if w.start, err = parseClock(from); err != nil {
+		return w, err
+	}
+
+	if w.end, err = parseClock(to); err != nil {
+		return w, err
+	}
+
+	if w.start == minutesPerDay {
+		return w, errScheduleTime
+	}
+
+	return w, nil
+}
+
+// parseDays sets the days of a list like "mon-fri,sun"; ranges may wrap.
+func parseDays(spec string, days *[daysPerWeek]bool) error {
+	for item := range strings.SplitSeq(spec, ",") {
+		from, to, isRange := strings.Cut(item, "-")
+
+		first, err := parseDay(from)
+		if err != nil {
+			return err
+		}
+
+		last := first
+		if isRange {
+			if last, err = parseDay(to); err != nil {
+				return err
+			}
+		}
+
+		for d := first; ; d = (d + 1) % daysPerWeek {
+			days[d] = true
+
+			if d == last {
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+func parseDay(s string) (int, error) {
+	for i, name := range dayNames {
+		if len(s) >= 3 && strings.HasPrefix(name, s) {
+			return i, nil
+		}
+	}
+
+	return 0, fmt.Errorf("%w: %s", errScheduleDay, s)
+}
+
+// parseClock returns the minutes since midnight of "HH:MM"; "24:00" is the end of the day.
+func parseClock(s string) (int, error) {
+	hh, mm, ok := strings.Cut(s, ":")
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", errScheduleTime, s)
+	}
+
+	h, errH := strconv.Atoi(hh)
+	m, errM := strconv.Atoi(mm)
+
+	if errH != nil || errM != nil || h < 0 || m < 0 || m >= minutesPerHour || h*minutesPerHour+m > minutesPerDay {
+		return 0, fmt.Errorf("%w: %s", errScheduleTime, s)
+	}
+
+	return h*minutesPerHour + m, nil
+}
@@ -0,0 +1,86 @@
+package config
+
+import (
+	"reflect"
+	"time"
+)
+
+// SchemaID is the $schema of documents produced by Schema.
+const SchemaID = "https://json-schema.org/draft/2020-12/schema"
+
+// schemaEnums lists the accepted values of string fields, keyed by struct
+// and YAML key.
+//
+//nolint:gochecknoglobals // fixed lookup table
+var schemaEnums = map[string][]string{
+	"RuleGroup.action":         {RuleActionRoute, RuleActionBlock},
+	"RuleGroup.block_mode":     {BlockModeNXDomain, BlockModeNoData, BlockModeSinkhole},
+	"RuleGroup.address_family": {AddressFamilyAny, AddressFamilyIPv4Only, AddressFamilyIPv6Only, AddressFamilyPreferIPv4},
+	"RateLimitConfig.action":   {RateLimitActionDrop, RateLimitActionRefuse, RateLimitActionTruncate},
+	"ECSPolicy.mode":           {ECSModePassthrough, ECSModeStrip, ECSModeFixed, ECSModeVia},
+	"LogConfig.level":          {"debug", "info", "warn", "error"},
+}
+
+// Schema returns a JSON Schema of the config file generated from the config
+// structs, for editor completion and checks.
+func Schema() map[string]any {
+	schema := typeSchema(reflect.TypeFor[Config]())
+	schema["$schema"] = SchemaID
+	schema["title"] = "outway configuration"
+
+	return schema
+}
+
+//nolint:exhaustive // remaining kinds are not used by the config structs
+func typeSchema(typ reflect.Type) map[string]any {
+	for typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+
+	if typ == reflect.TypeFor[time.Duration]() {
+		return map[string]any{
+			"type":        []string{"string", "integer"},
+			"description": "duration such as 90s, 5m or 24h",
+		}
+	}
+
+	switch typ.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": typeSchema(typ.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": typeSchema(typ.Elem())}
+	case reflect.Struct:
+		return structSchema(typ)
+	default:
+		return map[string]any{}
+	}
+}
+
+func structSchema(typ reflect.Type) map[string]any {
+	properties := map[string]any{}
+
+	for name, f := range yamlFields(typ) {
+		prop := typeSchema(f.typ)
+		if enum, ok := schemaEnums[typ.Name()+"."+name]; ok {
+			prop["enum"] = enum
+		}
+
+		properties[name] = prop
+	}
+
+	return map[string]any{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
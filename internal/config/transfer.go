@@ -0,0 +1,176 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	yaml "github.com/goccy/go-yaml"
+)
+
+// ImportMode selects how imported sections combine with the running config.
+type ImportMode string
+
+const (
+	// ImportMerge adds imported entries and replaces those with the same key.
+	ImportMerge ImportMode = "merge"
+	// ImportReplace replaces each imported section as a whole.
+	ImportReplace ImportMode = "replace"
+)
+
+var errImportMode = errors.New("import mode must be merge or replace")
+
+// Portable holds the config sections that move between installations.
+// A nil section is left alone by Import. Users are exported without password
+// hashes for reference and are never imported.
+type Portable struct {
+	Upstreams  []UpstreamConfig `json:"upstreams"       yaml:"upstreams"`
+	RuleGroups []RuleGroup      `json:"rule_groups"     yaml:"rule_groups"`
+	Hosts      []HostOverride   `json:"hosts"           yaml:"hosts"`
+	Users      []PortableUser   `json:"users,omitempty" yaml:"users,omitempty"`
+}
+
+// PortableUser is an exported user without its password hash.
+type PortableUser struct {
+	Email string `json:"email" yaml:"email"`
+	Role  string `json:"role"  yaml:"role"`
+}
+
+// SectionChange names the entries an import adds, updates and removes.
+type SectionChange struct {
+	Section string   `json:"section"`
+	Added   []string `json:"added,omitempty"`
+	Updated []string `json:"updated,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+}
+
+// ParseImportMode returns the mode named by s; empty means merge.
+func ParseImportMode(s string) (ImportMode, error) {
+	switch mode := ImportMode(strings.ToLower(strings.TrimSpace(s))); mode {
+	case "", ImportMerge:
+		return ImportMerge, nil
+	case ImportReplace:
+		return ImportReplace, nil
+	default:
+		return "", fmt.Errorf("%w: %q", errImportMode, s)
+	}
+}
+
+// Portable returns the portable sections of c, with users when withUsers is set.
+func (c *Config) Portable(withUsers bool) Portable {
+	out := Portable{
+		Upstreams:  slices.Clone(c.Upstreams),
+		RuleGroups: slices.Clone(c.RuleGroups),
+		Hosts:      slices.Clone(c.Hosts),
+	}
+
+	if out.Upstreams == nil {
+		out.Upstreams = []UpstreamConfig{}
+	}
+
+	if out.RuleGroups == nil {
+		out.RuleGroups = []RuleGroup{}
+	}
+
+	if out.Hosts == nil {
+		out.Hosts = []HostOverride{}
+	}
+
+	if withUsers {
+		for _, user := range c.Users {
+			out.Users = append(out.Users, PortableUser{Email: user.Email, Role: user.Role})
+		}
+	}
+
+	return out
+}
+
+// Import returns a copy of c with the sections of in applied and validated,
+// and the changes per section. The copy is not tied to the config files: apply
+// it to the running proxy, then save c.
+func (c *Config) Import(in Portable, mode ImportMode) (*Config, []SectionChange, error) {
+	next := *c
+	next.source = nil
+
+	var changes []SectionChange
+
+	if in.Upstreams != nil {
+		var change SectionChange
+
+		next.Upstreams, change = importSection("upstreams", c.Upstreams, in.Upstreams, mode,
+			func(u UpstreamConfig) string { return u.Name })
+		changes = append(changes, change)
+	}
+
+	if in.RuleGroups != nil {
+		var change SectionChange
+
+		// Imported groups get the defaults loaded ones have
+		groups := slices.Clone(in.RuleGroups)
+		setRuleGroupDefaults(groups)
+
+		next.RuleGroups, change = importSection("rule_groups", c.RuleGroups, groups, mode,
+			func(g RuleGroup) string { return g.Name })
+		changes = append(changes, change)
+	}
+
+	if in.Hosts != nil {
+		var change SectionChange
+
+		next.Hosts, change = importSection("hosts", c.Hosts, in.Hosts, mode,
+			func(h HostOverride) string { return strings.ToLower(h.Pattern) })
+		changes = append(changes, change)
+	}
+
+	if err := next.Validate(); err != nil {
+		return nil, nil, fmt.Errorf("invalid import: %w", err)
+	}
+
+	return &next, changes, nil
+}
+
+// importSection combines cur and in by entry key: merge keeps entries missing
+// from in, replace drops them.
+func importSection[T any](section string, cur, in []T, mode ImportMode, key func(T) string) ([]T, SectionChange) {
+	change := SectionChange{Section: section}
+	out := slices.Clone(cur)
+
+	if mode == ImportReplace {
+		out = out[:0:0]
+
+		for _, entry := range cur {
+			if !slices.ContainsFunc(in, func(e T) bool { return key(e) == key(entry) }) {
+				change.Removed = append(change.Removed, key(entry))
+			}
+		}
+	}
+
+	for _, entry := range in {
+		i := slices.IndexFunc(cur, func(e T) bool { return key(e) == key(entry) })
+
+		switch {
+		case i < 0:
+			change.Added = append(change.Added, key(entry))
+		case !sameEntry(cur[i], entry):
+			change.Updated = append(change.Updated, key(entry))
+		}
+
+		if j := slices.IndexFunc(out, func(e T) bool { return key(e) == key(entry) }); j >= 0 {
+			out[j] = entry
+		} else {
+			out = append(out, entry)
+		}
+	}
+
+	return out, change
+}
+
+// sameEntry compares entries as they are saved, ignoring derived fields.
+func sameEntry(a, b any) bool {
+	ya, errA := yaml.Marshal(a)
+	yb, errB := yaml.Marshal(b)
+
+	return errA == nil && errB == nil && bytes.Equal(ya, yb)
+}
@@ -0,0 +1,6 @@
+package dashboardhttp
+
+import "net/http"
+
+// Handler exposes the router to tests.
+func (s *Server) Handler() http.Handler { return s.mux }
@@ -0,0 +1,171 @@
+package dashboardhttp
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/miekg/dns"
+
+	"github.com/bavix/outway/internal/dnsproxy"
+)
+
+const (
+	defaultHistoryLimit = 100
+	maxHistoryLimit     = 1000
+)
+
+var (
+	errInvalidHistoryTime   = errors.New("from and to must be RFC 3339 timestamps")
+	errInvalidHistoryType   = errors.New("unknown qtype")
+	errInvalidExportFormat  = errors.New("format must be csv or ndjson")
+	errHistoryExportAborted = errors.New("history export aborted")
+)
+
+//nolint:gochecknoglobals // CSV header of the history export
+var historyCSVHeader = []string{
+	"time", "client_ip", "name", "qtype", "status", "upstream", "duration", "rule_group", "via", "marked_ips", "block_list", "policy",
+}
+
+// parseHistoryFilter reads the history filter from query parameters:
+// domain, client, qtype, status, upstream, rule_group, from and to.
+func parseHistoryFilter(q url.Values) (dnsproxy.HistoryFilter, error) {
+	f := dnsproxy.HistoryFilter{
+		Domain:    strings.TrimSpace(q.Get("domain")),
+		ClientIP:  strings.TrimSpace(q.Get("client")),
+		Status:    strings.TrimSpace(q.Get("status")),
+		Upstream:  strings.TrimSpace(q.Get("upstream")),
+		RuleGroup: strings.TrimSpace(q.Get("rule_group")),
+	}
+
+	if v := q.Get("qtype"); v != "" {
+		if f.QType = parseQueryType(v); f.QType == 0 {
+			return f, fmt.Errorf("%w: %s", errInvalidHistoryType, v)
+		}
+	}
+
+	for name, dst := range map[string]*time.Time{"from": &f.From, "to": &f.To} {
+		v := q.Get(name)
+		if v == "" {
+			continue
+		}
+
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return f, fmt.Errorf("%w: %s", errInvalidHistoryTime, v)
+		}
+
+		*dst = t
+	}
+
+	return f, nil
+}
+
+// handleHistory returns matching events newest first with offset/limit pagination.
+func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseHistoryFilter(r.URL.Query())
+	if err != nil {
+		render.Status(r, defaultBadRequestStatus)
+		render.JSON(w, r, map[string]string{"error": err.Error()})
+
+		return
+	}
+
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+
+	if limit <= 0 {
+		limit = defaultHistoryLimit
+	}
+
+	limit = min(limit, maxHistoryLimit)
+	offset = max(offset, 0)
+
+	events, total := s.proxy.QueryHistory(filter, offset, limit)
+
+	render.Status(r, http.StatusOK)
+	render.JSON(w, r, map[string]any{
+		"events": events,
+		"total":  total,
+		"offset": offset,
+		"limit":  limit,
+	})
+}
+
+// handleHistoryExport streams all matching events as CSV or NDJSON (?format=csv|ndjson).
+func (s *Server) handleHistoryExport(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseHistoryFilter(r.URL.Query())
+	if err != nil {
+		render.Status(r, defaultBadRequestStatus)
+		render.JSON(w, r, map[string]string{"error": err.Error()})
+
+		return
+	}
+
+	format := strings.ToLower(r.URL.Query().Get("format"))
+	if format == "" {
+		format = "ndjson"
+	}
+
+	var write func(dnsproxy.QueryEvent) error
+
+	switch format {
+	case "ndjson":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+
+		enc := json.NewEncoder(w)
+		write = func(e dnsproxy.QueryEvent) error { return enc.Encode(e) }
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+
+		cw := csv.NewWriter(w)
+		defer cw.Flush()
+
+		if err := cw.Write(historyCSVHeader); err != nil {
+			return
+		}
+
+		write = func(e dnsproxy.QueryEvent) error { return cw.Write(historyCSVRecord(e)) }
+	default:
+		render.Status(r, defaultBadRequestStatus)
+		render.JSON(w, r, map[string]string{"error": errInvalidExportFormat.Error()})
+
+		return
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="history.%s"`, format))
+
+	ctx := r.Context()
+
+	_ = s.proxy.EachHistory(filter, func(e dnsproxy.QueryEvent) error {
+		if ctx.Err() != nil {
+			return errHistoryExportAborted
+		}
+
+		return write(e)
+	})
+}
+
+func historyCSVRecord(e dnsproxy.QueryEvent) []string {
+	return []string{
+		e.Time.Format(time.RFC3339Nano),
+		e.ClientIP,
+		e.Name,
+		dns.Type(e.QType).String(),
+		e.Status,
+		e.Upstream,
+		e.Duration,
+		e.RuleGroup,
+		e.Via,
+		strings.Join(e.MarkedIPs, " "),
+		e.BlockList,
+		e.Policy,
+	}
+}
@@ -0,0 +1,171 @@
+package dashboardhttp
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/render"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog"
+
+	"github.com/bavix/outway/internal/config"
+)
+
+var errInvalidRevision = errors.New("revision must be a positive number")
+
+type revisionsResponse struct {
+	Revisions []config.Revision `json:"revisions"`
+}
+
+type revisionResponse struct {
+	config.Revision
+
+	Content string `json:"content"`
+}
+
+type revisionDiffResponse struct {
+	From int    `json:"from"`
+	To   int    `json:"to"`
+	Diff string `json:"diff"`
+}
+
+// revisionLog returns the revision log or answers that revisions are off.
+func (s *Server) revisionLog(w http.ResponseWriter, r *http.Request) *config.RevisionLog {
+	log := s.proxy.GetConfig().RevisionLog()
+	if log == nil {
+		renderRevisionError(w, r, config.ErrRevisionsDisabled)
+	}
+
+	return log
+}
+
+// revisionID reads a positive revision number from the route or query.
+func revisionID(w http.ResponseWriter, r *http.Request, value string) (int, bool) {
+	id, err := strconv.Atoi(value)
+	if err != nil || id <= 0 {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, map[string]string{"error": errInvalidRevision.Error()})
+
+		return 0, false
+	}
+
+	return id, true
+}
+
+func renderRevisionError(w http.ResponseWriter, r *http.Request, err error) {
+	status := http.StatusInternalServerError
+
+	switch {
+	case errors.Is(err, config.ErrRevisionNotFound), errors.Is(err, config.ErrRevisionsDisabled):
+		status = http.StatusNotFound
+	case errors.Is(err, config.ErrRevisionInvalid):
+		status = http.StatusUnprocessableEntity
+	}
+
+	render.Status(r, status)
+	render.JSON(w, r, map[string]string{"error": err.Error()})
+}
+
+// handleRevisions lists saved revisions of the config file, newest first.
+func (s *Server) handleRevisions(w http.ResponseWriter, r *http.Request) {
+	log := s.revisionLog(w, r)
+	if log == nil {
+		return
+	}
+
+	revisions, err := log.List()
+	if err != nil {
+		renderRevisionError(w, r, err)
+
+		return
+	}
+
+	render.JSON(w, r, revisionsResponse{Revisions: revisions})
+}
+
+// handleRevision returns a revision with the config file it saved, secrets
+// redacted.
+func (s *Server) handleRevision(w http.ResponseWriter, r *http.Request) {
+	log := s.revisionLog(w, r)
+	if log == nil {
+		return
+	}
+
+	id, ok := revisionID(w, r, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+
+	rev, content, err := log.Get(id)
+	if err == nil {
+		content, err = config.RedactSecrets(content)
+	}
+
+	if err != nil {
+		renderRevisionError(w, r, err)
+
+		return
+	}
+
+	render.JSON(w, r, revisionResponse{Revision: rev, Content: string(content)})
+}
+
+// handleRevisionDiff returns a unified diff of a revision against ?from=
+// (default: the revision before it).
+func (s *Server) handleRevisionDiff(w http.ResponseWriter, r *http.Request) {
+	log := s.revisionLog(w, r)
+	if log == nil {
+		return
+	}
+
+	id, ok := revisionID(w, r, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+
+	from := 0
+	if v := r.URL.Query().Get("from"); v != "" {
+		if from, ok = revisionID(w, r, v); !ok {
+			return
+		}
+	}
+
+	diff, err := log.Diff(from, id)
+	if err != nil {
+		renderRevisionError(w, r, err)
+
+		return
+	}
+
+	if from == 0 {
+		from = id - 1
+	}
+
+	render.JSON(w, r, revisionDiffResponse{From: from, To: id, Diff: diff})
+}
+
+// handleRevisionRollback applies a revision to the running proxy and restores
+// it to the config file.
+func (s *Server) handleRevisionRollback(w http.ResponseWriter, r *http.Request) {
+	id, ok := revisionID(w, r, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+
+	// The file is only restored once the running proxy accepted the revision
+	apply := func(next *config.Config) error { return s.proxy.Reload(ctx, next) }
+
+	if err := s.proxy.GetConfig().Rollback(ctx, id, apply); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Int("revision", id).Msg("failed to restore config revision")
+		renderRevisionError(w, r, err)
+
+		return
+	}
+
+	s.broadcast(map[string]any{"type": "rule_groups", "data": ruleGroupDTOs(s.proxy.GetRuleGroups())})
+
+	w.WriteHeader(http.StatusNoContent)
+}
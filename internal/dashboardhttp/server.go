@@ -42,6 +42,7 @@ import (
 
 var (
 	errNameViaPatternsRequired = errors.New("name, via and patterns are required")
+	errBlockGroupSource        = errors.New("name and patterns or lists are required for block groups")
 	errRuleGroupExists         = errors.New("rule group already exists")
 	errUpstreamsRequired       = errors.New("upstreams required")
 	errRuleGroupNotFound       = errors.New("rule group not found")
@@ -98,6 +99,10 @@ func NewServer(addr string, proxy *dnsproxy.Proxy) *Server {
 				Repo:           "outway",
 				CurrentVersion: version.GetVersion(),
 				BinaryName:     "outway",
+				BeforeRestart: func() {
+					// Keep the cache warm across the self-update restart
+					proxy.SaveCacheSnapshot(context.Background())
+				},
 			})
 			if err != nil {
 				panic(err) // This should not happen with valid config
@@ -121,6 +126,9 @@ func NewServer(addr string, proxy *dnsproxy.Proxy) *Server {
 		s.authService = authService
 	}
 
+	// Known devices answer PTR queries for their private addresses
+	proxy.AddAddressBook(s.deviceManager)
+
 	// Derive ports from inputs and loaded config
 	if _, port, err := net.SplitHostPort(addr); err == nil {
 		s.adminPort, _ = net.DefaultResolver.LookupPort(context.Background(), "tcp", port)
@@ -154,6 +162,10 @@ func NewServerWithConfig(httpConfig *config.HTTPConfig, proxy *dnsproxy.Proxy) *
 				Repo:           "outway",
 				CurrentVersion: version.GetVersion(),
 				BinaryName:     "outway",
+				BeforeRestart: func() {
+					// Keep the cache warm across the self-update restart
+					proxy.SaveCacheSnapshot(context.Background())
+				},
 			})
 			if err != nil {
 				panic(err)
@@ -177,6 +189,9 @@ func NewServerWithConfig(httpConfig *config.HTTPConfig, proxy *dnsproxy.Proxy) *
 		s.authService = authService
 	}
 
+	// Known devices answer PTR queries for their private addresses
+	proxy.AddAddressBook(s.deviceManager)
+
 	s.routes()
 	// Fill ports from provided config and proxy config
 	if _, port, err := net.SplitHostPort(httpConfig.Listen); err == nil {
@@ -264,7 +279,11 @@ func (s *Server) Start(ctx context.Context) error {
 		Str("build_time", s.buildTime).
 		Msg("http listen")
 
-	go func() { _ = srv.ListenAndServe() }()
+	if cfg := s.proxy.GetConfig(); cfg != nil && cfg.HTTP.TLS() {
+		go func() { _ = srv.ListenAndServeTLS(cfg.HTTP.TLSCertFile, cfg.HTTP.TLSKeyFile) }()
+	} else {
+		go func() { _ = srv.ListenAndServe() }()
+	}
 
 	// periodic WS broadcasts
 	go func() {
@@ -336,6 +355,20 @@ func (s *Server) routes() {
 	upstreamsAPI.Use(auth.RequirePermission(auth.PermissionManageSystem))
 	upstreamsAPI.HandleFunc("", s.handleUpstreams).Methods("GET", "POST", "PUT", "DELETE")
 
+	// Config revisions and rollback (admin only)
+	revisionsAPI := api.PathPrefix("/config/revisions").Subrouter()
+	revisionsAPI.Use(auth.RequirePermission(auth.PermissionManageSystem))
+	revisionsAPI.HandleFunc("", s.handleRevisions).Methods("GET")
+	revisionsAPI.HandleFunc("/{id}", s.handleRevision).Methods("GET")
+	revisionsAPI.HandleFunc("/{id}/diff", s.handleRevisionDiff).Methods("GET")
+	revisionsAPI.HandleFunc("/{id}/rollback", s.handleRevisionRollback).Methods("POST")
+
+	// Config export and import for migrating setups (admin only)
+	transferAPI := api.PathPrefix("/config").Subrouter()
+	transferAPI.Use(auth.RequirePermission(auth.PermissionManageSystem))
+	transferAPI.HandleFunc("/export", s.handleConfigExport).Methods("GET")
+	transferAPI.HandleFunc("/import", s.handleConfigImport).Methods("POST")
+
 	// Hosts management (admin only)
 	hostsAPI := api.PathPrefix("/hosts").Subrouter()
 	hostsAPI.Use(auth.RequirePermission(auth.PermissionManageSystem))
@@ -401,10 +434,19 @@ func (s *Server) routes() {
 	overviewAPI.Use(auth.RequirePermission(auth.PermissionViewOverview))
 	overviewAPI.HandleFunc("", s.handleOverview).Methods("GET")
 
+	statusAPI := api.PathPrefix("/status").Subrouter()
+	statusAPI.Use(auth.RequirePermission(auth.PermissionViewOverview))
+	statusAPI.HandleFunc("", s.handleStatus).Methods("GET")
+
+	marksAPI := api.PathPrefix("/marks").Subrouter()
+	marksAPI.Use(auth.RequirePermission(auth.PermissionViewStats))
+	marksAPI.HandleFunc("", s.handleMarks).Methods("GET")
+
 	// History (protected)
 	historyAPI := api.PathPrefix("/history").Subrouter()
 	historyAPI.Use(auth.RequirePermission(auth.PermissionViewHistory))
 	historyAPI.HandleFunc("", s.handleHistory).Methods("GET")
+	historyAPI.HandleFunc("/export", s.handleHistoryExport).Methods("GET")
 
 	// Configuration (safe version without secrets) - protected
 	configAPI := api.PathPrefix("/config").Subrouter()
@@ -448,11 +490,158 @@ func (s *Server) routes() {
 }
 
 type ruleGroupDTO struct {
-	Name        string   `json:"name"`
-	Description string   `json:"description,omitempty"`
-	Via         string   `json:"via"`
-	Patterns    []string `json:"patterns"`
-	PinTTL      bool     `json:"pin_ttl"`
+	Name         string   `json:"name"`
+	Description  string   `json:"description,omitempty"`
+	Action       string   `json:"action,omitempty"`
+	Via          string   `json:"via"`
+	Patterns     []string `json:"patterns"`
+	PinTTL       bool     `json:"pin_ttl"`
+	BlockMode    string   `json:"block_mode,omitempty"`
+	Sinkhole     []string `json:"sinkhole,omitempty"`
+	Lists        []string `json:"lists,omitempty"`
+	Allow        []string `json:"allow,omitempty"`
+	ListsRefresh string   `json:"lists_refresh,omitempty"`
+
+	// Enabled defaults to true when omitted; Active is whether the group applies right now.
+	Enabled  *bool    `json:"enabled,omitempty"`
+	Schedule []string `json:"schedule,omitempty"`
+	Active   bool     `json:"active"`
+
+	ECS           *config.ECSPolicy `json:"ecs,omitempty"`
+	AddressFamily string            `json:"address_family,omitempty"`
+	ClientTTLMin  int               `json:"client_ttl_min,omitempty"`
+	ClientTTLMax  int               `json:"client_ttl_max,omitempty"`
+}
+
+func newRuleGroupDTO(g config.RuleGroup) ruleGroupDTO {
+	enabled := g.IsEnabled()
+
+	dto := ruleGroupDTO{
+		Name:          g.Name,
+		Description:   g.Description,
+		Action:        g.Action,
+		Via:           g.Via,
+		Patterns:      g.Patterns,
+		PinTTL:        g.PinTTL,
+		BlockMode:     g.BlockMode,
+		Sinkhole:      g.Sinkhole,
+		Lists:         g.Lists,
+		Allow:         g.Allow,
+		ECS:           g.ECS,
+		AddressFamily: g.AddressFamily,
+		ClientTTLMin:  g.ClientTTLMin,
+		ClientTTLMax:  g.ClientTTLMax,
+		Enabled:       &enabled,
+		Schedule:      g.Schedule,
+		Active:        g.Active(time.Now()),
+	}
+	if g.ListsRefresh > 0 {
+		dto.ListsRefresh = g.ListsRefresh.String()
+	}
+
+	return dto
+}
+
+func ruleGroupDTOs(groups []config.RuleGroup) []ruleGroupDTO {
+	out := make([]ruleGroupDTO, 0, len(groups))
+	for _, g := range groups {
+		out = append(out, newRuleGroupDTO(g))
+	}
+
+	return out
+}
+
+// validate checks the fields required by the group's action.
+func (d ruleGroupDTO) validate() error {
+	if strings.EqualFold(d.Action, config.RuleActionBlock) {
+		if d.Name == "" || (len(d.Patterns) == 0 && len(d.Lists) == 0) {
+			return errBlockGroupSource
+		}
+
+		return nil
+	}
+
+	if d.Name == "" || d.Via == "" || len(d.Patterns) == 0 {
+		return errNameViaPatternsRequired
+	}
+
+	return nil
+}
+
+func (d ruleGroupDTO) toConfig(name string) (config.RuleGroup, error) {
+	g := config.RuleGroup{
+		Name:          name,
+		Description:   d.Description,
+		Action:        strings.ToLower(d.Action),
+		Via:           d.Via,
+		Patterns:      d.Patterns,
+		PinTTL:        d.PinTTL,
+		BlockMode:     d.BlockMode,
+		Sinkhole:      d.Sinkhole,
+		Lists:         d.Lists,
+		Allow:         d.Allow,
+		ECS:           d.ECS,
+		AddressFamily: strings.ToLower(d.AddressFamily),
+		ClientTTLMin:  d.ClientTTLMin,
+		ClientTTLMax:  d.ClientTTLMax,
+		Enabled:       d.Enabled,
+		Schedule:      d.Schedule,
+	}
+
+	if err := g.ValidateAddressFamily(); err != nil {
+		return config.RuleGroup{}, err
+	}
+
+	if err := g.ValidateClientTTL(); err != nil {
+		return config.RuleGroup{}, err
+	}
+
+	if _, err := config.ParseSchedule(g.Schedule); err != nil {
+		return config.RuleGroup{}, err
+	}
+
+	if d.ECS != nil {
+		if err := d.ECS.Validate(); err != nil {
+			return config.RuleGroup{}, fmt.Errorf("invalid ecs: %w", err)
+		}
+	}
+
+	if d.ListsRefresh != "" {
+		refresh, err := time.ParseDuration(d.ListsRefresh)
+		if err != nil {
+			return config.RuleGroup{}, fmt.Errorf("invalid lists_refresh: %w", err)
+		}
+
+		g.ListsRefresh = refresh
+	}
+
+	return g, nil
+}
+
+// applyRuleGroup updates runtime matching for a newly added or updated group.
+func (s *Server) applyRuleGroup(g config.RuleGroup) {
+	if g.IsBlock() {
+		s.proxy.SyncBlockGroups()
+
+		return
+	}
+
+	for _, r := range g.Rules() {
+		s.proxy.Rules().Upsert(r)
+	}
+}
+
+// removeRuleGroup drops runtime matching state of a group.
+func (s *Server) removeRuleGroup(g config.RuleGroup) {
+	if g.IsBlock() {
+		s.proxy.SyncBlockGroups()
+
+		return
+	}
+
+	for _, p := range g.Patterns {
+		s.proxy.Rules().Delete(p)
+	}
 }
 
 type rulesResponse struct {
@@ -490,19 +679,8 @@ func serveIndex(w http.ResponseWriter, r *http.Request) {
 func (s *Server) handleRuleGroups(w http.ResponseWriter, r *http.Request) { //nolint:cyclop,funlen
 	switch r.Method {
 	case http.MethodGet:
-		var ruleGroups []ruleGroupDTO
-		for _, group := range s.proxy.GetRuleGroups() {
-			ruleGroups = append(ruleGroups, ruleGroupDTO{
-				Name:        group.Name,
-				Description: group.Description,
-				Via:         group.Via,
-				Patterns:    group.Patterns,
-				PinTTL:      group.PinTTL,
-			})
-		}
-
 		render.Status(r, http.StatusOK)
-		render.JSON(w, r, rulesResponse{RuleGroups: ruleGroups})
+		render.JSON(w, r, rulesResponse{RuleGroups: ruleGroupDTOs(s.proxy.GetRuleGroups())})
 	case http.MethodPost:
 		// Create a new rule group
 		var in ruleGroupDTO
@@ -513,9 +691,17 @@ func (s *Server) handleRuleGroups(w http.ResponseWriter, r *http.Request) { //no
 			return
 		}
 
-		if in.Name == "" || in.Via == "" || len(in.Patterns) == 0 {
+		if err := in.validate(); err != nil {
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, map[string]string{"error": err.Error()})
+
+			return
+		}
+
+		group, err := in.toConfig(in.Name)
+		if err != nil {
 			render.Status(r, http.StatusBadRequest)
-			render.JSON(w, r, map[string]string{"error": errNameViaPatternsRequired.Error()})
+			render.JSON(w, r, map[string]string{"error": err.Error()})
 
 			return
 		}
@@ -530,19 +716,12 @@ func (s *Server) handleRuleGroups(w http.ResponseWriter, r *http.Request) { //no
 		}
 		// Append to config
 		cfg := s.proxy.GetConfig()
-		cfg.RuleGroups = append(cfg.RuleGroups, config.RuleGroup{
-			Name:        in.Name,
-			Description: in.Description,
-			Via:         in.Via,
-			Patterns:    in.Patterns,
-			PinTTL:      in.PinTTL,
-		})
-		// Update runtime rules store
-		for _, p := range in.Patterns {
-			s.proxy.Rules().Upsert(config.Rule{Pattern: p, Via: in.Via, PinTTL: in.PinTTL})
-		}
+		cfg.RuleGroups = append(cfg.RuleGroups, group)
+		// Update runtime rules store; cached names of the group are resolved and marked again
+		s.applyRuleGroup(group)
+		s.proxy.UnmarkInactiveGroups(r.Context())
 
-		if err := cfg.Save(); err != nil {
+		if err := s.proxy.PersistRules(r.Context()); err != nil {
 			render.Status(r, http.StatusInternalServerError)
 			render.JSON(w, r, map[string]string{"error": err.Error()})
 
@@ -552,18 +731,7 @@ func (s *Server) handleRuleGroups(w http.ResponseWriter, r *http.Request) { //no
 		render.Status(r, http.StatusCreated)
 		render.JSON(w, r, in)
 		// Broadcast updated groups
-		var outGroups []ruleGroupDTO
-		for _, group := range cfg.GetRuleGroups() {
-			outGroups = append(outGroups, ruleGroupDTO{
-				Name:        group.Name,
-				Description: group.Description,
-				Via:         group.Via,
-				Patterns:    group.Patterns,
-				PinTTL:      group.PinTTL,
-			})
-		}
-
-		s.broadcast(map[string]any{"type": "rule_groups", "data": outGroups})
+		s.broadcast(map[string]any{"type": "rule_groups", "data": ruleGroupDTOs(cfg.GetRuleGroups())})
 	case http.MethodDelete:
 		// Rule group deletion not implemented yet
 		w.WriteHeader(http.StatusNotImplemented)
@@ -585,29 +753,6 @@ func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
 	render.JSON(w, r, st)
 }
 
-func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
-	// Parse pagination parameters
-	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
-	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
-
-	// Default limit if not specified
-	if limit <= 0 {
-		limit = 100 // Default to 100 events per page
-	}
-
-	// Get paginated history
-	events := s.proxy.HistoryPaginated(offset, limit)
-	total := s.proxy.HistorySize()
-
-	render.Status(r, http.StatusOK)
-	render.JSON(w, r, map[string]any{
-		"events": events,
-		"total":  total,
-		"offset": offset,
-		"limit":  limit,
-	})
-}
-
 //nolint:cyclop // complex request handling with multiple methods
 func (s *Server) handleUpstreams(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
@@ -624,7 +769,7 @@ func (s *Server) handleUpstreams(w http.ResponseWriter, r *http.Request) {
 					a = u.Type + "://" + a
 				}
 
-				norm = append(norm, config.UpstreamConfig{Name: u.Name, Address: a, Weight: u.Weight, Type: u.Type})
+				norm = append(norm, config.UpstreamConfig{Name: u.Name, Address: a, Weight: u.Weight, Type: u.Type, ECS: u.ECS})
 			}
 
 			render.Status(r, http.StatusOK)
@@ -794,20 +939,7 @@ func (s *Server) handleWS(w http.ResponseWriter, r *http.Request) { //nolint:cyc
 	s.broadcastCacheSnapshot(r.Context())
 
 	// Convert rule groups to new format
-	groups := s.proxy.GetRuleGroups()
-
-	ruleGroups := make([]ruleGroupDTO, 0, len(groups))
-	for _, group := range groups {
-		ruleGroups = append(ruleGroups, ruleGroupDTO{
-			Name:        group.Name,
-			Description: group.Description,
-			Via:         group.Via,
-			Patterns:    group.Patterns,
-			PinTTL:      group.PinTTL,
-		})
-	}
-
-	s.sendJSON(conn, map[string]any{"type": "rule_groups", "data": ruleGroups})
+	s.sendJSON(conn, map[string]any{"type": "rule_groups", "data": ruleGroupDTOs(s.proxy.GetRuleGroups())})
 	// Ensure addresses in snapshot include scheme for UI consistency
 	{
 		ups := s.proxy.GetConfig().Upstreams
@@ -819,7 +951,7 @@ func (s *Server) handleWS(w http.ResponseWriter, r *http.Request) { //nolint:cyc
 				a = u.Type + "://" + a
 			}
 
-			norm = append(norm, config.UpstreamConfig{Name: u.Name, Address: a, Weight: u.Weight, Type: u.Type})
+			norm = append(norm, config.UpstreamConfig{Name: u.Name, Address: a, Weight: u.Weight, Type: u.Type, ECS: u.ECS})
 		}
 
 		s.sendJSON(conn, map[string]any{"type": "upstreams", "data": norm})
@@ -901,13 +1033,7 @@ func (s *Server) handleRuleGroup(w http.ResponseWriter, r *http.Request) { //nol
 		for _, group := range groups {
 			if group.Name == name {
 				render.Status(r, http.StatusOK)
-				render.JSON(w, r, ruleGroupDTO{
-					Name:        group.Name,
-					Description: group.Description,
-					Via:         group.Via,
-					Patterns:    group.Patterns,
-					PinTTL:      group.PinTTL,
-				})
+				render.JSON(w, r, newRuleGroupDTO(group))
 
 				return
 			}
@@ -926,27 +1052,31 @@ func (s *Server) handleRuleGroup(w http.ResponseWriter, r *http.Request) { //nol
 			return
 		}
 
+		in.Name = name
+		if err := in.validate(); err != nil {
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, map[string]string{"error": err.Error()})
+
+			return
+		}
+
+		group, err := in.toConfig(name)
+		if err != nil {
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, map[string]string{"error": err.Error()})
+
+			return
+		}
+
 		cfg := s.proxy.GetConfig()
 		updated := false
 
 		for i, g := range cfg.RuleGroups {
 			if g.Name == name {
-				// remove old patterns from runtime store
-				for _, p := range g.Patterns {
-					s.proxy.Rules().Delete(p)
-				}
-				// update config
-				cfg.RuleGroups[i] = config.RuleGroup{
-					Name:        name,
-					Description: in.Description,
-					Via:         in.Via,
-					Patterns:    in.Patterns,
-					PinTTL:      in.PinTTL,
-				}
-				// add new patterns to runtime store
-				for _, p := range in.Patterns {
-					s.proxy.Rules().Upsert(config.Rule{Pattern: p, Via: in.Via, PinTTL: in.PinTTL})
-				}
+				cfg.RuleGroups[i] = group
+				// swap old patterns for new ones in runtime store
+				s.removeRuleGroup(g)
+				s.applyRuleGroup(group)
 
 				updated = true
 
@@ -961,7 +1091,10 @@ func (s *Server) handleRuleGroup(w http.ResponseWriter, r *http.Request) { //nol
 			return
 		}
 
-		if err := cfg.Save(); err != nil {
+		// Addresses routed by a group that was just switched off leave its interface now
+		s.proxy.UnmarkInactiveGroups(r.Context())
+
+		if err := s.proxy.PersistRules(r.Context()); err != nil {
 			render.Status(r, defaultInternalServerErrorStatus)
 			render.JSON(w, r, map[string]string{"error": err.Error()})
 
@@ -970,18 +1103,7 @@ func (s *Server) handleRuleGroup(w http.ResponseWriter, r *http.Request) { //nol
 
 		w.WriteHeader(http.StatusNoContent)
 		// broadcast
-		var outGroups []ruleGroupDTO
-		for _, group := range cfg.GetRuleGroups() {
-			outGroups = append(outGroups, ruleGroupDTO{
-				Name:        group.Name,
-				Description: group.Description,
-				Via:         group.Via,
-				Patterns:    group.Patterns,
-				PinTTL:      group.PinTTL,
-			})
-		}
-
-		s.broadcast(map[string]any{"type": "rule_groups", "data": outGroups})
+		s.broadcast(map[string]any{"type": "rule_groups", "data": ruleGroupDTOs(cfg.GetRuleGroups())})
 
 	case http.MethodDelete:
 		// Delete rule group
@@ -1005,13 +1127,12 @@ func (s *Server) handleRuleGroup(w http.ResponseWriter, r *http.Request) { //nol
 
 			return
 		}
-		// remove patterns from runtime store
-		for _, p := range g.Patterns {
-			s.proxy.Rules().Delete(p)
-		}
-		// remove from config
+		// remove from config, then from runtime store
 		cfg.RuleGroups = append(cfg.RuleGroups[:idx], cfg.RuleGroups[idx+1:]...)
-		if err := cfg.Save(); err != nil {
+		s.removeRuleGroup(g)
+		s.proxy.UnmarkInactiveGroups(r.Context())
+
+		if err := s.proxy.PersistRules(r.Context()); err != nil {
 			render.Status(r, defaultInternalServerErrorStatus)
 			render.JSON(w, r, map[string]string{"error": err.Error()})
 
@@ -1020,18 +1141,7 @@ func (s *Server) handleRuleGroup(w http.ResponseWriter, r *http.Request) { //nol
 
 		w.WriteHeader(http.StatusNoContent)
 		// broadcast
-		var outGroups []ruleGroupDTO
-		for _, group := range cfg.GetRuleGroups() {
-			outGroups = append(outGroups, ruleGroupDTO{
-				Name:        group.Name,
-				Description: group.Description,
-				Via:         group.Via,
-				Patterns:    group.Patterns,
-				PinTTL:      group.PinTTL,
-			})
-		}
-
-		s.broadcast(map[string]any{"type": "rule_groups", "data": outGroups})
+		s.broadcast(map[string]any{"type": "rule_groups", "data": ruleGroupDTOs(cfg.GetRuleGroups())})
 
 	default:
 		w.WriteHeader(http.StatusMethodNotAllowed)
@@ -1119,28 +1229,8 @@ func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
 
 // handleCacheFlush clears all DNS cache entries.
 func (s *Server) handleCacheFlush(w http.ResponseWriter, r *http.Request) {
-	// Find cache in the active resolver chain and flush it
-	res := s.proxy.ResolverActive()
-	// Walk decorators to find cache
-	switch v := res.(type) {
-	case *dnsproxy.MetricsResolver:
-		core := v.Next
-		switch c := core.(type) {
-		case *dnsproxy.ServeStaleResolver:
-			if c.Cache != nil {
-				c.Cache.Flush()
-			}
-		case *dnsproxy.CachedResolver:
-			c.Flush()
-		}
-	case *dnsproxy.ServeStaleResolver:
-		if v.Cache != nil {
-			v.Cache.Flush()
-		}
-	case *dnsproxy.CachedResolver:
-		v.Flush()
-	case *dnsproxy.UpstreamResolver:
-		// UpstreamResolver doesn't have cache, nothing to flush
+	if cache := s.proxy.Cache(); cache != nil {
+		cache.Flush()
 	}
 
 	render.Status(r, http.StatusOK)
@@ -1148,8 +1238,6 @@ func (s *Server) handleCacheFlush(w http.ResponseWriter, r *http.Request) {
 }
 
 // handleCacheDelete removes cache entries for a specific domain and optional qtype.
-//
-//nolint:cyclop
 func (s *Server) handleCacheDelete(w http.ResponseWriter, r *http.Request) {
 	var in struct {
 		Name  string `json:"name"`
@@ -1170,30 +1258,9 @@ func (s *Server) handleCacheDelete(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Then delete relevant keys if cache is present
-	res := s.proxy.ResolverActive()
-
 	var deleteFn func(string, uint16)
-
-	switch v := res.(type) {
-	case *dnsproxy.MetricsResolver:
-		// Core is inside MetricsResolver.Next
-		core := v.Next
-		switch c := core.(type) {
-		case *dnsproxy.ServeStaleResolver:
-			if c.Cache != nil {
-				deleteFn = c.Cache.Delete
-			}
-		case *dnsproxy.CachedResolver:
-			deleteFn = c.Delete
-		}
-	case *dnsproxy.ServeStaleResolver:
-		if v.Cache != nil {
-			deleteFn = v.Cache.Delete
-		}
-	case *dnsproxy.CachedResolver:
-		deleteFn = v.Delete
-	case *dnsproxy.UpstreamResolver:
-		// UpstreamResolver doesn't have cache, nothing to delete
+	if cache := s.proxy.Cache(); cache != nil {
+		deleteFn = cache.Delete
 	}
 
 	if deleteFn == nil {
@@ -1221,25 +1288,8 @@ func (s *Server) handleCacheDeleteKey(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	res := s.proxy.ResolverActive()
-	switch v := res.(type) {
-	case *dnsproxy.MetricsResolver:
-		switch core := v.Next.(type) {
-		case *dnsproxy.ServeStaleResolver:
-			if core.Cache != nil {
-				core.Cache.DeleteKey(key)
-			}
-		case *dnsproxy.CachedResolver:
-			core.DeleteKey(key)
-		}
-	case *dnsproxy.ServeStaleResolver:
-		if v.Cache != nil {
-			v.Cache.DeleteKey(key)
-		}
-	case *dnsproxy.CachedResolver:
-		v.DeleteKey(key)
-	case *dnsproxy.UpstreamResolver:
-		// UpstreamResolver doesn't have cache, nothing to delete
+	if cache := s.proxy.Cache(); cache != nil {
+		cache.DeleteKey(key)
 	}
 
 	s.broadcastCacheSnapshot(r.Context())
@@ -1248,8 +1298,6 @@ func (s *Server) handleCacheDeleteKey(w http.ResponseWriter, r *http.Request) {
 }
 
 // handleCacheGetKey returns raw DNS message for a key.
-//
-//nolint:cyclop
 func (s *Server) handleCacheGetKey(w http.ResponseWriter, r *http.Request) {
 	key := r.URL.Query().Get("key")
 	if key == "" {
@@ -1259,31 +1307,13 @@ func (s *Server) handleCacheGetKey(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	res := s.proxy.ResolverActive()
-
 	var (
 		msg *dns.Msg
 		ok  bool
 	)
 
-	switch v := res.(type) {
-	case *dnsproxy.MetricsResolver:
-		switch core := v.Next.(type) {
-		case *dnsproxy.ServeStaleResolver:
-			if core.Cache != nil {
-				msg, ok = core.Cache.Get(key)
-			}
-		case *dnsproxy.CachedResolver:
-			msg, ok = core.Get(key)
-		}
-	case *dnsproxy.ServeStaleResolver:
-		if v.Cache != nil {
-			msg, ok = v.Cache.Get(key)
-		}
-	case *dnsproxy.CachedResolver:
-		msg, ok = v.Get(key)
-	case *dnsproxy.UpstreamResolver:
-		// UpstreamResolver doesn't have cache, nothing to get
+	if cache := s.proxy.Cache(); cache != nil {
+		msg, ok = cache.Get(key)
 	}
 
 	if !ok || msg == nil {
@@ -1305,37 +1335,13 @@ func (s *Server) handleCacheList(w http.ResponseWriter, r *http.Request) {
 	sortBy := r.URL.Query().Get("sort")
 	order := r.URL.Query().Get("order")
 
-	// Find cache
-	res := s.proxy.ResolverActive()
-
 	var (
 		items any
 		total int
 	)
 
-	switch v := res.(type) {
-	case *dnsproxy.MetricsResolver:
-		switch core := v.Next.(type) {
-		case *dnsproxy.ServeStaleResolver:
-			if core.Cache != nil {
-				it, t := core.Cache.List(offset, limit, q, sortBy, order)
-				items, total = it, t
-			}
-		case *dnsproxy.CachedResolver:
-			it, t := core.List(offset, limit, q, sortBy, order)
-			items, total = it, t
-		}
-	case *dnsproxy.ServeStaleResolver:
-		if v.Cache != nil {
-			it, t := v.Cache.List(offset, limit, q, sortBy, order)
-			items, total = it, t
-		}
-	case *dnsproxy.CachedResolver:
-		it, t := v.List(offset, limit, q, sortBy, order)
-		items, total = it, t
-	case *dnsproxy.UpstreamResolver:
-		// UpstreamResolver doesn't have cache, return empty list
-		items, total = []any{}, 0
+	if cache := s.proxy.Cache(); cache != nil {
+		items, total = cache.List(offset, limit, q, sortBy, order)
 	}
 
 	if items == nil {
@@ -1353,39 +1359,13 @@ func (s *Server) broadcastCacheSnapshot(_ context.Context) {
 		q      = ""
 	)
 
-	// Similar lookup as list
-	res := s.proxy.ResolverActive()
-
-	var (
-		items any
-		total int
-	)
-
-	switch v := res.(type) {
-	case *dnsproxy.MetricsResolver:
-		switch core := v.Next.(type) {
-		case *dnsproxy.ServeStaleResolver:
-			if core.Cache != nil {
-				items, total = core.Cache.List(offset, limit, q, "expires", "desc")
-			}
-		case *dnsproxy.CachedResolver:
-			items, total = core.List(offset, limit, q, "expires", "desc")
-		}
-	case *dnsproxy.ServeStaleResolver:
-		if v.Cache != nil {
-			items, total = v.Cache.List(offset, limit, q, "expires", "desc")
-		}
-	case *dnsproxy.CachedResolver:
-		items, total = v.List(offset, limit, q, "expires", "desc")
-	case *dnsproxy.UpstreamResolver:
-		// UpstreamResolver doesn't have cache, return empty list
-		items, total = []any{}, 0
-	}
-
-	if items == nil {
+	cache := s.proxy.Cache()
+	if cache == nil {
 		return
 	}
 
+	items, total := cache.List(offset, limit, q, "expires", "desc")
+
 	s.broadcast(map[string]any{"type": "cache", "data": map[string]any{"items": items, "total": total, "offset": offset, "limit": limit}})
 }
 
@@ -1415,6 +1395,13 @@ func (s *Server) handleResolveTest(w http.ResponseWriter, r *http.Request) {
 
 	m := new(dns.Msg)
 	m.SetQuestion(dns.Fqdn(name), qtype)
+
+	if r.URL.Query().Get("explain") == "1" {
+		s.handleResolveExplain(w, r, m)
+
+		return
+	}
+
 	// go through proxy pipeline synchronously
 	resolver := s.proxy.ResolverActive()
 	if resolver == nil {
@@ -1454,6 +1441,37 @@ func (s *Server) handleResolveTest(w http.ResponseWriter, r *http.Request) {
 	render.JSON(w, r, resp)
 }
 
+// handleResolveExplain answers a resolve test with an explanation of the
+// stage that answered and the routing of the answers. With dry_run=1 the
+// answers are not marked.
+func (s *Server) handleResolveExplain(w http.ResponseWriter, r *http.Request, m *dns.Msg) {
+	e, err := s.proxy.Explain(r.Context(), m, r.URL.Query().Get("dry_run") == "1")
+	if err != nil {
+		render.Status(r, defaultBadGatewayStatus)
+		render.JSON(w, r, map[string]string{"error": err.Error()})
+
+		return
+	}
+
+	out := e.Msg
+
+	resp := map[string]any{
+		"upstream":         e.Source,
+		"rcode":            out.Rcode,
+		"answers":          len(out.Answer),
+		"records":          rrToStrings(out.Answer),
+		"response_time_ms": e.Duration.Milliseconds(),
+		"explain":          e,
+	}
+
+	if ttl := extractTTL(out.Answer); ttl != nil {
+		resp["ttl"] = *ttl
+	}
+
+	render.Status(r, http.StatusOK)
+	render.JSON(w, r, resp)
+}
+
 func extractTTL(answers []dns.RR) *uint32 {
 	if len(answers) == 0 {
 		return nil
@@ -1504,12 +1522,7 @@ func (s *Server) buildMiddlewareChain(ctx context.Context) http.Handler {
 	var h http.Handler = s.mux
 
 	// Request size limiting middleware
-	maxRequestSize := int64(defaultMaxRequestSize)
-	if cfg := s.proxy.GetConfig(); cfg != nil && cfg.HTTP.MaxRequestSize > 0 {
-		maxRequestSize = cfg.HTTP.MaxRequestSize
-	}
-
-	h = limitRequestSize(h, maxRequestSize)
+	h = limitRequestSize(h, s.maxRequestSize())
 
 	// Gzip compression middleware (for large responses)
 	h = gzipCompressionMiddleware(h)
@@ -1554,6 +1567,15 @@ func (s *Server) buildMiddlewareChain(ctx context.Context) http.Handler {
 	return otelhttp.NewHandler(h, "dashboardhttp")
 }
 
+// maxRequestSize returns the largest request body accepted, in bytes.
+func (s *Server) maxRequestSize() int64 {
+	if cfg := s.proxy.GetConfig(); cfg != nil && cfg.HTTP.MaxRequestSize > 0 {
+		return cfg.HTTP.MaxRequestSize
+	}
+
+	return defaultMaxRequestSize
+}
+
 // limitRequestSize limits the size of request body.
 func limitRequestSize(next http.Handler, maxSize int64) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,214 @@
+package dashboardhttp_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bavix/outway/internal/auth"
+	"github.com/bavix/outway/internal/config"
+	"github.com/bavix/outway/internal/dashboardhttp"
+	"github.com/bavix/outway/internal/dnsproxy"
+)
+
+const serverTestConfig = `listen:
+  udp: ":5353"
+  tcp: ":5353"
+jwt_secret: c2VjcmV0LXNlY3JldC1zZWNyZXQtc2VjcmV0LXNlY3JldA==
+users:
+  - email: admin@example.com
+    password: hash
+    role: admin
+upstreams:
+  - name: cloudflare
+    address: 1.1.1.1:53
+rule_groups:
+  - name: video
+    description: Streaming
+    via: wg0
+    pin_ttl: true
+    patterns: ["*.youtube.com", "*.netflix.com"]
+  - name: ads
+    action: block
+    patterns: ["ads.example.com"]
+  - name: work
+    via: wg1
+    address_family: ipv4_only
+    client_ttl_max: 300
+    patterns: ["*.corp.example"]
+`
+
+// apiClient calls the dashboard API of a server built from a config file.
+type apiClient struct {
+	t       *testing.T
+	path    string
+	handler http.Handler
+	token   string
+}
+
+func newAPIClient(t *testing.T, body string) *apiClient {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(body), 0o600))
+
+	cfg, err := config.Load(path)
+	require.NoError(t, err)
+
+	token, err := auth.IssueLocalToken(cfg, time.Minute)
+	require.NoError(t, err)
+
+	server := dashboardhttp.NewServer("127.0.0.1:0", dnsproxy.New(cfg, nil))
+
+	return &apiClient{t: t, path: path, handler: server.Handler(), token: token}
+}
+
+func (c *apiClient) do(method, target string, body any) *httptest.ResponseRecorder {
+	c.t.Helper()
+
+	var payload bytes.Buffer
+	if body != nil {
+		require.NoError(c.t, json.NewEncoder(&payload).Encode(body))
+	}
+
+	return c.send(method, target, payload.Bytes())
+}
+
+// send calls the API with a raw request body.
+func (c *apiClient) send(method, target string, body []byte) *httptest.ResponseRecorder {
+	c.t.Helper()
+
+	req := httptest.NewRequestWithContext(c.t.Context(), method, target, bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	rec := httptest.NewRecorder()
+	c.handler.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func (c *apiClient) load() *config.Config {
+	c.t.Helper()
+
+	cfg, err := config.Load(c.path)
+	require.NoError(c.t, err)
+
+	return cfg
+}
+
+func TestRuleGroupsAPIPersistsGroups(t *testing.T) {
+	t.Parallel()
+
+	client := newAPIClient(t, serverTestConfig)
+
+	rec := client.do(http.MethodPut, "/api/v1/rule-groups/video", map[string]any{
+		"description": "Streaming",
+		"via":         "wg0",
+		"pin_ttl":     true,
+		"patterns":    []string{"*.youtube.com", "*.twitch.tv"},
+	})
+	require.Equal(t, http.StatusNoContent, rec.Code, rec.Body.String())
+
+	rec = client.do(http.MethodPost, "/api/v1/rule-groups", map[string]any{
+		"name":     "games",
+		"via":      "wg2",
+		"patterns": []string{"*.steam.com"},
+	})
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	rec = client.do(http.MethodDelete, "/api/v1/rule-groups/ads", nil)
+	require.Equal(t, http.StatusNoContent, rec.Code, rec.Body.String())
+
+	rec = client.do(http.MethodGet, "/api/v1/rule-groups", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var listed struct {
+		RuleGroups []struct {
+			Name     string   `json:"name"`
+			Patterns []string `json:"patterns"`
+		} `json:"rule_groups"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &listed))
+	require.Len(t, listed.RuleGroups, 3)
+	assert.Equal(t, []string{"*.youtube.com", "*.twitch.tv"}, listed.RuleGroups[0].Patterns)
+	assert.Equal(t, "games", listed.RuleGroups[2].Name)
+
+	// Route groups pin TTLs unless told otherwise
+	assert.Equal(t, []config.RuleGroup{
+		{Name: "video", Description: "Streaming", Via: "wg0", PinTTL: true, Patterns: []string{"*.youtube.com", "*.twitch.tv"}},
+		{Name: "work", Via: "wg1", PinTTL: true, AddressFamily: "ipv4_only", ClientTTLMax: 300, Patterns: []string{"*.corp.example"}},
+		{Name: "games", Via: "wg2", PinTTL: true, Patterns: []string{"*.steam.com"}},
+	}, client.load().RuleGroups)
+}
+
+func TestUpstreamsAPIKeepsECS(t *testing.T) {
+	t.Parallel()
+
+	client := newAPIClient(t, `listen:
+  udp: ":5353"
+  tcp: ":5353"
+jwt_secret: c2VjcmV0LXNlY3JldC1zZWNyZXQtc2VjcmV0LXNlY3JldA==
+users:
+  - email: admin@example.com
+    password: hash
+    role: admin
+upstreams:
+  - name: cloudflare
+    address: 1.1.1.1:53
+    ecs:
+      mode: fixed
+      prefix: 198.51.100.0/24
+  - name: quad9
+    address: 9.9.9.9:53
+`)
+
+	rec := client.do(http.MethodGet, "/api/v1/upstreams", nil)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	// The UI posts back the upstreams it fetched
+	var fetched struct {
+		Upstreams []map[string]any `json:"upstreams"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &fetched))
+	require.Len(t, fetched.Upstreams, 2)
+
+	rec = client.do(http.MethodPost, "/api/v1/upstreams", fetched)
+	require.Equal(t, http.StatusNoContent, rec.Code, rec.Body.String())
+
+	want := &config.ECSPolicy{Mode: config.ECSModeFixed, Prefix: "198.51.100.0/24"}
+
+	rec = client.do(http.MethodGet, "/api/v1/upstreams", nil)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var listed struct {
+		Upstreams []config.UpstreamConfig `json:"upstreams"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &listed))
+	require.Len(t, listed.Upstreams, 2)
+	assert.Equal(t, want, listed.Upstreams[0].ECS)
+	assert.Nil(t, listed.Upstreams[1].ECS)
+
+	// Saved in the background
+	require.Eventually(t, func() bool {
+		saved, err := config.Load(client.path)
+
+		return err == nil && len(saved.Upstreams) == 2 && saved.Upstreams[0].ECS != nil
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, want, client.load().Upstreams[0].ECS)
+
+	// Policies are validated like the config file
+	fetched.Upstreams[0]["ecs"] = map[string]any{"mode": "fixed"}
+
+	rec = client.do(http.MethodPost, "/api/v1/upstreams", fetched)
+	assert.NotEqual(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, want, client.load().Upstreams[0].ECS)
+}
@@ -0,0 +1,82 @@
+package dashboardhttp
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-chi/render"
+
+	"github.com/bavix/outway/internal/dnsproxy"
+)
+
+type statusResponse struct {
+	Version       string                    `json:"version"`
+	UptimeSeconds int64                     `json:"uptime_seconds"`
+	Queries       float64                   `json:"queries"`
+	Backend       string                    `json:"backend,omitempty"`
+	Cache         cacheStatus               `json:"cache"`
+	Upstreams     []dnsproxy.UpstreamHealth `json:"upstreams"`
+	Marks         map[string]int            `json:"marks"` // marked addresses per interface
+}
+
+type cacheStatus struct {
+	dnsproxy.CacheStats
+
+	Enabled bool    `json:"enabled"`
+	HitRate float64 `json:"hit_rate"`
+}
+
+type marksResponse struct {
+	Marks []dnsproxy.MarkedIP `json:"marks"`
+}
+
+// handleStatus summarizes the runtime state for status checks from the CLI.
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	st := s.collectStats()
+	cfg := s.proxy.GetConfig()
+
+	resp := statusResponse{
+		Version:       s.version,
+		UptimeSeconds: int64(time.Since(s.startTime) / time.Second),
+		Queries:       st.DNSQueriesTotal,
+		Backend:       s.proxy.BackendName(),
+		Cache: cacheStatus{
+			CacheStats: s.proxy.Cache().Stats(),
+			Enabled:    cfg.Cache.Enabled,
+			HitRate:    st.CacheHitRate,
+		},
+		Upstreams: s.proxy.UpstreamHealth(),
+		Marks:     map[string]int{},
+	}
+
+	for _, m := range s.proxy.Marks() {
+		resp.Marks[m.Via]++
+	}
+
+	render.Status(r, http.StatusOK)
+	render.JSON(w, r, resp)
+}
+
+// handleMarks lists the marked addresses, optionally of one interface (?via=).
+func (s *Server) handleMarks(w http.ResponseWriter, r *http.Request) {
+	marks := s.proxy.Marks()
+
+	if via := r.URL.Query().Get("via"); via != "" {
+		filtered := marks[:0]
+
+		for _, m := range marks {
+			if m.Via == via {
+				filtered = append(filtered, m)
+			}
+		}
+
+		marks = filtered
+	}
+
+	if marks == nil {
+		marks = []dnsproxy.MarkedIP{}
+	}
+
+	render.Status(r, http.StatusOK)
+	render.JSON(w, r, marksResponse{Marks: marks})
+}
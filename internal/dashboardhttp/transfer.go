@@ -0,0 +1,305 @@
+package dashboardhttp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-chi/render"
+	yaml "github.com/goccy/go-yaml"
+	"github.com/rs/zerolog"
+
+	"github.com/bavix/outway/internal/config"
+	"github.com/bavix/outway/internal/devices"
+	"github.com/bavix/outway/internal/wol"
+)
+
+// transferVersion is the format version of exported documents.
+const transferVersion = 1
+
+var (
+	errTransferVersion = errors.New("unsupported export version")
+	errDeviceMAC       = errors.New("device has an invalid MAC address")
+)
+
+// transferDocument is a portable copy of a setup: the config sections of
+// config.Portable plus manually added devices and Wake-on-LAN settings, which
+// are not kept in the config file.
+type transferDocument struct {
+	Version    int       `yaml:"version"`
+	ExportedAt time.Time `yaml:"exported_at,omitempty"`
+
+	config.Portable `yaml:",inline"`
+
+	Devices []transferDevice `yaml:"devices"`
+	WoL     *wol.Config      `yaml:"wol,omitempty"`
+}
+
+type transferDevice struct {
+	Name     string `yaml:"name"`
+	MAC      string `yaml:"mac"`
+	IP       string `yaml:"ip,omitempty"`
+	Hostname string `yaml:"hostname,omitempty"`
+	Vendor   string `yaml:"vendor,omitempty"`
+}
+
+type importResponse struct {
+	Mode    config.ImportMode      `json:"mode"`
+	DryRun  bool                   `json:"dry_run"`
+	Changes []config.SectionChange `json:"changes"`
+}
+
+// handleConfigExport downloads the portable sections as YAML; ?users=1 adds
+// users without password hashes.
+func (s *Server) handleConfigExport(w http.ResponseWriter, r *http.Request) {
+	doc := transferDocument{
+		Version:    transferVersion,
+		ExportedAt: time.Now().UTC(),
+		Portable:   s.proxy.GetConfig().Portable(r.URL.Query().Get("users") == "1"),
+		Devices:    []transferDevice{},
+		WoL:        s.deviceManager.WoLConfig(),
+	}
+
+	for _, device := range s.manualDevices(r) {
+		doc.Devices = append(doc.Devices, transferDevice{
+			Name:     device.Name,
+			MAC:      device.MAC,
+			IP:       device.IP,
+			Hostname: device.Hostname,
+			Vendor:   device.Vendor,
+		})
+	}
+
+	out, err := yaml.Marshal(doc)
+	if err != nil {
+		render.Status(r, http.StatusInternalServerError)
+		render.JSON(w, r, map[string]string{"error": err.Error()})
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Header().Set("Content-Disposition", `attachment; filename="outway-export.yaml"`)
+	_, _ = w.Write(out)
+}
+
+// handleConfigImport applies an exported document (YAML or JSON). ?mode=merge
+// (default) adds and updates entries, ?mode=replace also drops entries missing
+// from the document. ?dry_run=1 only reports the changes. Users are not imported.
+//
+//nolint:cyclop,funlen // validate every part before applying any
+func (s *Server) handleConfigImport(w http.ResponseWriter, r *http.Request) {
+	mode, err := config.ParseImportMode(r.URL.Query().Get("mode"))
+	if err != nil {
+		render.Status(r, defaultBadRequestStatus)
+		render.JSON(w, r, map[string]string{"error": err.Error()})
+
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s.maxRequestSize()))
+	if err != nil {
+		status := defaultBadRequestStatus
+		if tooLarge := (*http.MaxBytesError)(nil); errors.As(err, &tooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+
+		render.Status(r, status)
+		render.JSON(w, r, map[string]string{"error": err.Error()})
+
+		return
+	}
+
+	var doc transferDocument
+	if err := yaml.Unmarshal(body, &doc); err != nil {
+		render.Status(r, defaultBadRequestStatus)
+		render.JSON(w, r, map[string]string{"error": "invalid document: " + err.Error()})
+
+		return
+	}
+
+	if doc.Version != transferVersion {
+		render.Status(r, http.StatusUnprocessableEntity)
+		render.JSON(w, r, map[string]string{"error": fmt.Sprintf("%s: %d", errTransferVersion, doc.Version)})
+
+		return
+	}
+
+	cfg := s.proxy.GetConfig()
+
+	next, changes, err := cfg.Import(doc.Portable, mode)
+	if err != nil {
+		render.Status(r, http.StatusUnprocessableEntity)
+		render.JSON(w, r, map[string]string{"error": err.Error()})
+
+		return
+	}
+
+	current := s.manualDevices(r)
+
+	if doc.Devices != nil {
+		change, err := planDevices(current, doc.Devices, mode)
+		if err != nil {
+			render.Status(r, http.StatusUnprocessableEntity)
+			render.JSON(w, r, map[string]string{"error": err.Error()})
+
+			return
+		}
+
+		changes = append(changes, change)
+	}
+
+	if doc.WoL != nil {
+		// A scratch manager runs the same checks as the live one
+		settings := *doc.WoL
+		if err := wol.NewConfigManager().SetConfig(&settings); err != nil {
+			render.Status(r, http.StatusUnprocessableEntity)
+			render.JSON(w, r, map[string]string{"error": err.Error()})
+
+			return
+		}
+
+		change := config.SectionChange{Section: "wol"}
+		if *doc.WoL != *s.deviceManager.WoLConfig() {
+			change.Updated = []string{"wol"}
+		}
+
+		changes = append(changes, change)
+	}
+
+	dryRun := r.URL.Query().Get("dry_run") == "1"
+	if !dryRun {
+		ctx := r.Context()
+
+		if err := s.proxy.Reload(ctx, next); err != nil {
+			render.Status(r, http.StatusUnprocessableEntity)
+			render.JSON(w, r, map[string]string{"error": err.Error()})
+
+			return
+		}
+
+		if err := cfg.SaveContext(ctx); err != nil {
+			zerolog.Ctx(ctx).Error().Err(err).Msg("failed to save imported config")
+			render.Status(r, http.StatusInternalServerError)
+			render.JSON(w, r, map[string]string{"error": err.Error()})
+
+			return
+		}
+
+		if doc.Devices != nil {
+			s.applyDevices(r, current, doc.Devices, mode)
+		}
+
+		if doc.WoL != nil {
+			_ = s.deviceManager.SetWoLConfig(doc.WoL) // validated above
+		}
+
+		s.broadcast(map[string]any{"type": "rule_groups", "data": ruleGroupDTOs(s.proxy.GetRuleGroups())})
+	}
+
+	render.JSON(w, r, importResponse{Mode: mode, DryRun: dryRun, Changes: changes})
+}
+
+// manualDevices returns devices added by hand; discovered ones are found
+// again on the target network.
+func (s *Server) manualDevices(r *http.Request) []*devices.Device {
+	var out []*devices.Device
+
+	for _, device := range s.deviceManager.GetAllDevices(r.Context()) {
+		if device.Source == "manual" {
+			out = append(out, device)
+		}
+	}
+
+	slices.SortFunc(out, func(a, b *devices.Device) int { return strings.Compare(a.MAC, b.MAC) })
+
+	return out
+}
+
+// planDevices reports how in changes the manual devices, keyed by MAC.
+func planDevices(current []*devices.Device, in []transferDevice, mode config.ImportMode) (config.SectionChange, error) {
+	change := config.SectionChange{Section: "devices"}
+
+	for _, entry := range in {
+		mac, err := net.ParseMAC(entry.MAC)
+		if err != nil {
+			return change, fmt.Errorf("%w: %q", errDeviceMAC, entry.MAC)
+		}
+
+		i := deviceIndex(current, mac.String())
+
+		switch {
+		case i < 0:
+			change.Added = append(change.Added, mac.String())
+		case current[i].Name != entry.Name || current[i].IP != entry.IP ||
+			current[i].Hostname != entry.Hostname || current[i].Vendor != entry.Vendor:
+			change.Updated = append(change.Updated, mac.String())
+		}
+	}
+
+	if mode == config.ImportReplace {
+		for _, device := range current {
+			if !slices.ContainsFunc(in, func(e transferDevice) bool { return sameMAC(e.MAC, device.MAC) }) {
+				change.Removed = append(change.Removed, device.MAC)
+			}
+		}
+	}
+
+	return change, nil
+}
+
+// applyDevices adds and updates the devices of in; replace also removes
+// manual devices missing from it. MACs were checked by planDevices.
+func (s *Server) applyDevices(r *http.Request, current []*devices.Device, in []transferDevice, mode config.ImportMode) {
+	logger := zerolog.Ctx(r.Context())
+
+	for _, entry := range in {
+		mac, _ := net.ParseMAC(entry.MAC)
+
+		var err error
+		if i := deviceIndex(current, mac.String()); i >= 0 {
+			err = s.deviceManager.UpdateDevice(current[i].ID, entry.Name, current[i].MAC, entry.IP, entry.Hostname, entry.Vendor)
+		} else if existing, ok := s.deviceManager.GetDeviceByMAC(mac.String()); ok {
+			// A discovered device becomes known by the imported name
+			err = s.deviceManager.UpdateDevice(existing.ID, entry.Name, existing.MAC, entry.IP, entry.Hostname, entry.Vendor)
+		} else {
+			_, err = s.deviceManager.AddDevice(entry.Name, mac.String(), entry.IP, entry.Hostname, entry.Vendor)
+		}
+
+		if err != nil {
+			logger.Warn().Err(err).Str("mac", entry.MAC).Msg("failed to import device")
+		}
+	}
+
+	if mode != config.ImportReplace {
+		return
+	}
+
+	for _, device := range current {
+		if !slices.ContainsFunc(in, func(e transferDevice) bool { return sameMAC(e.MAC, device.MAC) }) {
+			if err := s.deviceManager.DeleteDevice(device.ID); err != nil {
+				logger.Warn().Err(err).Str("mac", device.MAC).Msg("failed to remove device")
+			}
+		}
+	}
+}
+
+func deviceIndex(list []*devices.Device, mac string) int {
+	return slices.IndexFunc(list, func(d *devices.Device) bool { return sameMAC(d.MAC, mac) })
+}
+
+func sameMAC(a, b string) bool {
+	ma, errA := net.ParseMAC(a)
+	mb, errB := net.ParseMAC(b)
+
+	if errA != nil || errB != nil {
+		return strings.EqualFold(a, b)
+	}
+
+	return ma.String() == mb.String()
+}
@@ -0,0 +1,128 @@
+package dashboardhttp_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bavix/outway/internal/config"
+)
+
+const transferTargetConfig = `listen:
+  udp: ":5353"
+  tcp: ":5353"
+jwt_secret: c2VjcmV0LXNlY3JldC1zZWNyZXQtc2VjcmV0LXNlY3JldA==
+users:
+  - email: admin@example.com
+    password: hash
+    role: admin
+upstreams:
+  - name: quad9
+    address: 9.9.9.9:53
+rule_groups:
+  - name: old
+    via: wg9
+    patterns: ["*.old.example"]
+`
+
+type importReply struct {
+	DryRun  bool                   `json:"dry_run"`
+	Changes []config.SectionChange `json:"changes"`
+}
+
+func TestConfigExportImport(t *testing.T) {
+	t.Parallel()
+
+	source := newAPIClient(t, serverTestConfig)
+
+	rec := source.do(http.MethodGet, "/api/v1/config/export", nil)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	doc := rec.Body.Bytes()
+
+	target := newAPIClient(t, transferTargetConfig)
+
+	// A dry run reports the changes and keeps the config
+	rec = target.send(http.MethodPost, "/api/v1/config/import?mode=replace&dry_run=1", doc)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var reply importReply
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &reply))
+	assert.True(t, reply.DryRun)
+	assert.NotEmpty(t, reply.Changes)
+	assert.Equal(t, "old", target.load().RuleGroups[0].Name)
+
+	rec = target.send(http.MethodPost, "/api/v1/config/import?mode=replace", doc)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	saved := target.load()
+	assert.Equal(t, source.load().Upstreams, saved.Upstreams)
+	assert.Equal(t, source.load().RuleGroups, saved.RuleGroups)
+
+	// Importing the same document again changes nothing, pinned TTLs included
+	rec = target.send(http.MethodPost, "/api/v1/config/import?mode=merge&dry_run=1", doc)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	reply = importReply{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &reply))
+
+	for _, change := range reply.Changes {
+		assert.Empty(t, change.Added, change.Section)
+		assert.Empty(t, change.Updated, change.Section)
+		assert.Empty(t, change.Removed, change.Section)
+	}
+}
+
+func TestConfigImportDefaultsPinTTL(t *testing.T) {
+	t.Parallel()
+
+	client := newAPIClient(t, transferTargetConfig)
+
+	doc := []byte(`version: 1
+rule_groups:
+  - name: video
+    via: wg0
+    patterns: ["*.youtube.com"]
+`)
+
+	rec := client.send(http.MethodPost, "/api/v1/config/import", doc)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	groups := client.load().RuleGroups
+	require.Len(t, groups, 2)
+	assert.Equal(t, "video", groups[1].Name)
+	assert.True(t, groups[1].PinTTL)
+
+	// The running proxy pins TTLs of the group without a restart
+	rec = client.do(http.MethodGet, "/api/v1/rule-groups", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var listed struct {
+		RuleGroups []struct {
+			Name   string `json:"name"`
+			PinTTL bool   `json:"pin_ttl"`
+		} `json:"rule_groups"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &listed))
+	require.Len(t, listed.RuleGroups, 2)
+	assert.Equal(t, "video", listed.RuleGroups[1].Name)
+	assert.True(t, listed.RuleGroups[1].PinTTL)
+}
+
+func TestConfigImportRejectsLargeBody(t *testing.T) {
+	t.Parallel()
+
+	client := newAPIClient(t, transferTargetConfig+`http:
+  max_request_size: 64
+`)
+
+	doc := append([]byte("version: 1\nhosts:\n"), bytes.Repeat([]byte("# padding\n"), 16)...)
+
+	rec := client.send(http.MethodPost, "/api/v1/config/import", doc)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code, rec.Body.String())
+	assert.Equal(t, "old", client.load().RuleGroups[0].Name)
+}
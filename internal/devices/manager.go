@@ -3,6 +3,7 @@ package devices
 import (
 	"context"
 	"fmt"
+	"net"
 	"os"
 	"strings"
 	"sync"
@@ -404,6 +405,22 @@ func (dm *DeviceManager) GetDeviceByIP(ip string) (*Device, bool) {
 	return nil, false
 }
 
+// LookupAddr returns host names of resolvable devices with the given address.
+func (dm *DeviceManager) LookupAddr(ip net.IP) []string {
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	var names []string
+
+	for _, device := range dm.devices {
+		if device.CanBeResolved() && ip.Equal(net.ParseIP(device.IP)) {
+			names = append(names, device.Hostname)
+		}
+	}
+
+	return names
+}
+
 // GetDevicesByType returns devices filtered by type.
 func (dm *DeviceManager) GetDevicesByType(deviceType DeviceType) []*Device {
 	dm.mu.RLock()
@@ -653,6 +670,16 @@ func (dm *DeviceManager) DeleteDevice(id string) error {
 	return nil
 }
 
+// WoLConfig returns a copy of the Wake-on-LAN settings.
+func (dm *DeviceManager) WoLConfig() *wol.Config {
+	return dm.wolService.GetConfig()
+}
+
+// SetWoLConfig validates and replaces the Wake-on-LAN settings.
+func (dm *DeviceManager) SetWoLConfig(cfg *wol.Config) error {
+	return dm.wolService.SetConfig(cfg)
+}
+
 // GetStats returns device statistics.
 func (dm *DeviceManager) GetStats() map[string]any {
 	dm.mu.RLock()
@@ -0,0 +1,43 @@
+package dnsproxy
+
+import (
+	"net"
+	"sync"
+
+	"github.com/bavix/outway/internal/lanresolver"
+)
+
+// addressBooks feeds PTR answers of the LAN resolver from host overrides and
+// sources registered at runtime (for example, the device manager).
+type addressBooks struct {
+	hosts HostsManager
+
+	mu    sync.RWMutex
+	extra []lanresolver.AddressBook
+}
+
+func (b *addressBooks) LookupAddr(ip net.IP) []string {
+	var names []string
+
+	if b.hosts != nil {
+		for _, name := range hostNamesForIP(b.hosts.GetHosts(), ip) {
+			names = append(names, name.target)
+		}
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, book := range b.extra {
+		names = append(names, book.LookupAddr(ip)...)
+	}
+
+	return names
+}
+
+func (b *addressBooks) add(book lanresolver.AddressBook) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.extra = append(b.extra, book)
+}
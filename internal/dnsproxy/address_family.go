@@ -0,0 +1,168 @@
+package dnsproxy
+
+import (
+	"context"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/bavix/outway/internal/config"
+)
+
+// AddressFamilyResolver filters A/AAAA answers and HTTPS/SVCB address hints
+// for names of rule groups whose interface carries only one address family.
+// It sits below the mark decorator, so dropped addresses are never routed.
+type AddressFamilyResolver struct {
+	Next  Resolver
+	Rules *RuleStore
+}
+
+func (a *AddressFamilyResolver) Resolve(ctx context.Context, q *dns.Msg) (*dns.Msg, string, error) {
+	out, src, err := a.Next.Resolve(ctx, q)
+	if err != nil || out == nil || a.Rules == nil || q == nil || len(q.Question) == 0 {
+		return out, src, err
+	}
+
+	name := strings.ToLower(strings.TrimSuffix(q.Question[0].Name, "."))
+
+	rule, ok := a.Rules.Find(name)
+	if !ok {
+		return out, src, err
+	}
+
+	var dropV4, dropV6 bool
+
+	switch rule.AddressFamily {
+	case config.AddressFamilyIPv4Only:
+		dropV6 = true
+	case config.AddressFamilyIPv6Only:
+		dropV4 = true
+	case config.AddressFamilyPreferIPv4:
+		dropV6 = a.hasIPv4(ctx, q, out)
+	default:
+		return out, src, err
+	}
+
+	return filterFamilies(out, dropV4, dropV6), src, nil
+}
+
+// hasIPv4 reports whether the queried name resolves to IPv4 addresses: from
+// the hints of an HTTPS answer or, for AAAA queries, by asking for A records.
+func (a *AddressFamilyResolver) hasIPv4(ctx context.Context, q, out *dns.Msg) bool {
+	for _, rr := range out.Answer {
+		if rr.Header().Rrtype == dns.TypeA {
+			return true
+		}
+
+		if svcb := svcbOf(rr); svcb != nil {
+			for _, kv := range svcb.Value {
+				if kv.Key() == dns.SVCB_IPV4HINT {
+					return true
+				}
+			}
+		}
+	}
+
+	if q.Question[0].Qtype != dns.TypeAAAA {
+		return false
+	}
+
+	probe := q.Copy()
+	probe.Question[0].Qtype = dns.TypeA
+
+	res, _, err := a.Next.Resolve(ctx, probe)
+	if err != nil || res == nil {
+		return false
+	}
+
+	for _, rr := range res.Answer {
+		if rr.Header().Rrtype == dns.TypeA {
+			return true
+		}
+	}
+
+	return false
+}
+
+// filterFamilies returns a copy of msg without the dropped address families.
+// A reply left without answers becomes NODATA with an SOA holding the TTL of
+// the dropped records, so caches keep it as long as they would the records.
+func filterFamilies(msg *dns.Msg, dropV4, dropV6 bool) *dns.Msg {
+	if !dropV4 && !dropV6 {
+		return msg
+	}
+
+	out := msg.Copy()
+	out.Answer = filterFamilyRRs(out.Answer, dropV4, dropV6)
+	out.Extra = filterFamilyRRs(out.Extra, dropV4, dropV6)
+
+	if len(out.Answer) == 0 && len(msg.Answer) > 0 && len(msg.Question) > 0 {
+		if _, ok := negativeTTL(out); !ok {
+			out.Ns = append(out.Ns, nodataSOA(msg.Question[0].Name, ttlFromMsg(msg)))
+		}
+	}
+
+	return out
+}
+
+// nodataSOA returns the SOA of a synthesized NODATA reply for name; its TTL
+// and MINIMUM bound the negative caching time (RFC 2308).
+func nodataSOA(name string, ttl uint32) *dns.SOA {
+	return &dns.SOA{
+		Hdr:    dns.RR_Header{Name: name, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: ttl},
+		Ns:     name,
+		Mbox:   "hostmaster." + name,
+		Serial: 1,
+		Minttl: ttl,
+	}
+}
+
+func filterFamilyRRs(rrs []dns.RR, dropV4, dropV6 bool) []dns.RR {
+	kept := rrs[:0]
+
+	for _, rr := range rrs {
+		switch rr.Header().Rrtype {
+		case dns.TypeA:
+			if dropV4 {
+				continue
+			}
+		case dns.TypeAAAA:
+			if dropV6 {
+				continue
+			}
+		}
+
+		if svcb := svcbOf(rr); svcb != nil {
+			svcb.Value = filterHints(svcb.Value, dropV4, dropV6)
+		}
+
+		kept = append(kept, rr)
+	}
+
+	return kept
+}
+
+func filterHints(values []dns.SVCBKeyValue, dropV4, dropV6 bool) []dns.SVCBKeyValue {
+	kept := values[:0]
+
+	for _, kv := range values {
+		if (dropV4 && kv.Key() == dns.SVCB_IPV4HINT) || (dropV6 && kv.Key() == dns.SVCB_IPV6HINT) {
+			continue
+		}
+
+		kept = append(kept, kv)
+	}
+
+	return kept
+}
+
+func svcbOf(rr dns.RR) *dns.SVCB {
+	switch v := rr.(type) {
+	case *dns.HTTPS:
+		return &v.SVCB
+	case *dns.SVCB:
+		return v
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,136 @@
+package dnsproxy_test
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bavix/outway/internal/config"
+	"github.com/bavix/outway/internal/dnsproxy"
+)
+
+// dualStack answers every name with both families; v6only.example has no A records.
+func dualStack() *MockResolver {
+	return &MockResolver{resolveFunc: func(_ context.Context, q *dns.Msg) (*dns.Msg, string, error) {
+		m := new(dns.Msg)
+		m.SetReply(q)
+
+		name := q.Question[0].Name
+		hdr := func(t uint16) dns.RR_Header {
+			return dns.RR_Header{Name: name, Rrtype: t, Class: dns.ClassINET, Ttl: 60}
+		}
+
+		switch q.Question[0].Qtype {
+		case dns.TypeA:
+			if name != "v6only.example." {
+				m.Answer = append(m.Answer, &dns.A{Hdr: hdr(dns.TypeA), A: net.ParseIP("192.0.2.1")})
+			}
+		case dns.TypeAAAA:
+			m.Answer = append(m.Answer, &dns.AAAA{Hdr: hdr(dns.TypeAAAA), AAAA: net.ParseIP("2001:db8::1")})
+		case dns.TypeHTTPS:
+			m.Answer = append(m.Answer, &dns.HTTPS{SVCB: dns.SVCB{
+				Hdr:      hdr(dns.TypeHTTPS),
+				Priority: 1,
+				Target:   ".",
+				Value: []dns.SVCBKeyValue{
+					&dns.SVCBAlpn{Alpn: []string{"h2"}},
+					&dns.SVCBIPv4Hint{Hint: []net.IP{net.ParseIP("192.0.2.1")}},
+					&dns.SVCBIPv6Hint{Hint: []net.IP{net.ParseIP("2001:db8::1")}},
+				},
+			}})
+		}
+
+		return m, "mock", nil
+	}}
+}
+
+func TestAddressFamilyResolver(t *testing.T) {
+	t.Parallel()
+
+	rules := dnsproxy.NewRuleStore([]config.Rule{
+		{Pattern: "*.v4.example", Via: "wg0", AddressFamily: config.AddressFamilyIPv4Only},
+		{Pattern: "*.v6.example", Via: "wg1", AddressFamily: config.AddressFamilyIPv6Only},
+		{Pattern: "*.prefer.example", Via: "wg2", AddressFamily: config.AddressFamilyPreferIPv4},
+		{Pattern: "v6only.example", Via: "wg2", AddressFamily: config.AddressFamilyPreferIPv4},
+		{Pattern: "*.any.example", Via: "wg3"},
+	})
+
+	r := &dnsproxy.AddressFamilyResolver{Next: dualStack(), Rules: rules}
+
+	answers := func(name string, qtype uint16) []dns.RR {
+		q := new(dns.Msg)
+		q.SetQuestion(name, qtype)
+
+		out, _, err := r.Resolve(context.Background(), q)
+		require.NoError(t, err)
+
+		return out.Answer
+	}
+
+	hints := func(rrs []dns.RR) []uint16 {
+		require.Len(t, rrs, 1)
+
+		https, ok := rrs[0].(*dns.HTTPS)
+		require.True(t, ok)
+
+		keys := make([]uint16, 0, len(https.Value))
+		for _, kv := range https.Value {
+			keys = append(keys, uint16(kv.Key()))
+		}
+
+		return keys
+	}
+
+	assert.Empty(t, answers("www.v4.example.", dns.TypeAAAA))
+	assert.Len(t, answers("www.v4.example.", dns.TypeA), 1)
+	assert.Equal(t, []uint16{uint16(dns.SVCB_ALPN), uint16(dns.SVCB_IPV4HINT)}, hints(answers("www.v4.example.", dns.TypeHTTPS)))
+
+	assert.Empty(t, answers("www.v6.example.", dns.TypeA))
+	assert.Len(t, answers("www.v6.example.", dns.TypeAAAA), 1)
+	assert.Equal(t, []uint16{uint16(dns.SVCB_ALPN), uint16(dns.SVCB_IPV6HINT)}, hints(answers("www.v6.example.", dns.TypeHTTPS)))
+
+	assert.Empty(t, answers("www.prefer.example.", dns.TypeAAAA), "names with IPv4 lose their IPv6 addresses")
+	assert.Len(t, answers("v6only.example.", dns.TypeAAAA), 1, "IPv6-only names keep them")
+	assert.Equal(t, []uint16{uint16(dns.SVCB_ALPN), uint16(dns.SVCB_IPV4HINT)}, hints(answers("www.prefer.example.", dns.TypeHTTPS)))
+
+	assert.Len(t, answers("www.any.example.", dns.TypeAAAA), 1)
+	assert.Len(t, answers("unrouted.example.", dns.TypeAAAA), 1)
+}
+
+func TestAddressFamilyResolverFilteredRepliesAreCached(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int32
+
+	upstream := dualStack()
+	counting := &MockResolver{resolveFunc: func(ctx context.Context, q *dns.Msg) (*dns.Msg, string, error) {
+		calls.Add(1)
+
+		return upstream.Resolve(ctx, q)
+	}}
+
+	rules := dnsproxy.NewRuleStore([]config.Rule{
+		{Pattern: "*.v4.example", Via: "wg0", AddressFamily: config.AddressFamilyIPv4Only},
+	})
+	cache := dnsproxy.NewCachedResolverWithSize(
+		&dnsproxy.AddressFamilyResolver{Next: counting, Rules: rules}, 100, 0, 1, 3600)
+
+	for range 3 {
+		q := new(dns.Msg)
+		q.SetQuestion("www.v4.example.", dns.TypeAAAA)
+
+		out, _, err := cache.Resolve(context.Background(), q)
+		require.NoError(t, err)
+		assert.Equal(t, dns.RcodeSuccess, out.Rcode)
+		assert.Empty(t, out.Answer)
+		require.Len(t, out.Ns, 1)
+		assert.Equal(t, uint16(dns.TypeSOA), out.Ns[0].Header().Rrtype)
+	}
+
+	assert.Equal(t, int32(1), calls.Load(), "the NODATA reply is answered from the cache")
+}
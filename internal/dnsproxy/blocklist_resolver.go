@@ -0,0 +1,103 @@
+package dnsproxy
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/rs/zerolog"
+
+	"github.com/bavix/outway/internal/blocklist"
+	"github.com/bavix/outway/internal/config"
+	"github.com/bavix/outway/internal/metrics"
+)
+
+const (
+	sourceBlocklist = "blocklist"
+	defaultBlockTTL = 60
+)
+
+// BlocklistResolver answers names matched by block rule groups locally
+// and passes everything else to Next. It sits in front of the cache so that
+// list and allowlist changes take effect immediately.
+type BlocklistResolver struct {
+	Next  Resolver
+	Lists *blocklist.Manager
+}
+
+func (b *BlocklistResolver) Resolve(ctx context.Context, q *dns.Msg) (*dns.Msg, string, error) {
+	if q == nil || len(q.Question) == 0 || b.Lists == nil {
+		return b.Next.Resolve(ctx, q)
+	}
+
+	question := q.Question[0]
+	name := strings.ToLower(strings.TrimSuffix(question.Name, "."))
+
+	match, ok := b.Lists.Match(name)
+	if !ok {
+		return b.Next.Resolve(ctx, q)
+	}
+
+	metrics.IncBlocked(match.Group, match.List)
+	QueryTraceFromContext(ctx).SetBlocked(match.Group, match.List)
+
+	zerolog.Ctx(ctx).Debug().
+		Str("query", name).
+		Uint16("qtype", question.Qtype).
+		Str("group", match.Group).
+		Str("list", match.List).
+		Str("mode", match.Mode).
+		Msg("query blocked")
+
+	return blockedReply(q, match), sourceBlocklist, nil
+}
+
+// blockedReply builds the local answer for a blocked query.
+func blockedReply(q *dns.Msg, match blocklist.Match) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetReply(q)
+	m.RecursionAvailable = true
+
+	question := q.Question[0]
+
+	switch match.Mode {
+	case config.BlockModeNoData:
+		return m
+	case config.BlockModeSinkhole:
+		hdr := dns.RR_Header{Name: question.Name, Rrtype: question.Qtype, Class: dns.ClassINET, Ttl: defaultBlockTTL}
+
+		switch question.Qtype {
+		case dns.TypeA:
+			m.Answer = append(m.Answer, &dns.A{Hdr: hdr, A: sinkholeAddr(match.Sinkhole, true)})
+		case dns.TypeAAAA:
+			m.Answer = append(m.Answer, &dns.AAAA{Hdr: hdr, AAAA: sinkholeAddr(match.Sinkhole, false)})
+		}
+
+		return m
+	default:
+		m.Rcode = dns.RcodeNameError
+
+		return m
+	}
+}
+
+// sinkholeAddr picks the first configured address of the requested family,
+// falling back to the unspecified address.
+func sinkholeAddr(addrs []net.IP, v4 bool) net.IP {
+	for _, ip := range addrs {
+		if (ip.To4() != nil) == v4 {
+			if v4 {
+				return ip.To4()
+			}
+
+			return ip
+		}
+	}
+
+	if v4 {
+		return net.IPv4zero.To4()
+	}
+
+	return net.IPv6unspecified
+}
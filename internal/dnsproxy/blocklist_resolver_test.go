@@ -0,0 +1,109 @@
+package dnsproxy_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bavix/outway/internal/blocklist"
+	"github.com/bavix/outway/internal/config"
+	"github.com/bavix/outway/internal/dnsproxy"
+)
+
+func newBlocklistResolver(t *testing.T, group config.RuleGroup) *dnsproxy.BlocklistResolver {
+	t.Helper()
+
+	lists := blocklist.NewManager()
+	lists.SetGroups([]config.RuleGroup{group})
+
+	return &dnsproxy.BlocklistResolver{Next: &MockResolver{}, Lists: lists}
+}
+
+func TestBlocklistResolver_Modes(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		mode     string
+		sinkhole []string
+		qtype    uint16
+		rcode    int
+		answer   string
+	}{
+		{name: "nxdomain", mode: config.BlockModeNXDomain, qtype: dns.TypeA, rcode: dns.RcodeNameError},
+		{name: "nodata", mode: config.BlockModeNoData, qtype: dns.TypeA, rcode: dns.RcodeSuccess},
+		{name: "sinkhole default v4", mode: config.BlockModeSinkhole, qtype: dns.TypeA, answer: "0.0.0.0"},
+		{name: "sinkhole default v6", mode: config.BlockModeSinkhole, qtype: dns.TypeAAAA, answer: "::"},
+		{
+			name: "sinkhole custom", mode: config.BlockModeSinkhole, sinkhole: []string{"10.0.0.1", "fd00::1"},
+			qtype: dns.TypeAAAA, answer: "fd00::1",
+		},
+		{name: "sinkhole other type", mode: config.BlockModeSinkhole, qtype: dns.TypeTXT, rcode: dns.RcodeSuccess},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := newBlocklistResolver(t, config.RuleGroup{
+				Name:      "ads",
+				Action:    config.RuleActionBlock,
+				BlockMode: tt.mode,
+				Sinkhole:  tt.sinkhole,
+				Patterns:  []string{"*.ads.com"},
+			})
+
+			q := new(dns.Msg)
+			q.SetQuestion("track.ads.com.", tt.qtype)
+
+			ctx, trace := dnsproxy.WithQueryTrace(context.Background())
+
+			msg, src, err := r.Resolve(ctx, q)
+			require.NoError(t, err)
+			assert.Equal(t, "blocklist", src)
+			assert.Equal(t, tt.rcode, msg.Rcode)
+			assert.Equal(t, "ads", trace.RuleGroup())
+			assert.Equal(t, blocklist.SourcePatterns, trace.BlockList())
+
+			if tt.answer == "" {
+				assert.Empty(t, msg.Answer)
+
+				return
+			}
+
+			require.Len(t, msg.Answer, 1)
+
+			switch rr := msg.Answer[0].(type) {
+			case *dns.A:
+				assert.Equal(t, tt.answer, rr.A.String())
+			case *dns.AAAA:
+				assert.Equal(t, tt.answer, rr.AAAA.String())
+			default:
+				t.Fatalf("unexpected answer %T", rr)
+			}
+		})
+	}
+}
+
+func TestBlocklistResolver_PassThrough(t *testing.T) {
+	t.Parallel()
+
+	r := newBlocklistResolver(t, config.RuleGroup{
+		Name:     "ads",
+		Action:   config.RuleActionBlock,
+		Patterns: []string{"*.ads.com"},
+		Allow:    []string{"ok.ads.com"},
+	})
+
+	for _, name := range []string{"example.com.", "ok.ads.com."} {
+		q := new(dns.Msg)
+		q.SetQuestion(name, dns.TypeA)
+
+		_, src, err := r.Resolve(context.Background(), q)
+		require.NoError(t, err)
+		assert.Equal(t, "mock", src, name)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	lru "github.com/hashicorp/golang-lru/v2/expirable"
@@ -25,8 +26,16 @@ const (
 
 type cacheItem struct {
 	msg    *dns.Msg
+	stored time.Time
 	expire time.Time
-	size   int64 // Approximate size in bytes
+	size   int64       // Approximate size in bytes
+	stats  *cacheStats // Shared by copies of the item held by the LRU
+}
+
+// cacheStats tracks popularity of an entry during its lifetime.
+type cacheStats struct {
+	hits       atomic.Uint32
+	prefetched atomic.Bool
 }
 
 // cacheChangeNotify is an optional hook set by dashboardhttp to broadcast cache changes.
@@ -43,11 +52,19 @@ type CachedResolver struct {
 	MaxSizeBytes  int64 // Maximum cache size in bytes (0 = disabled)
 	MinTTLSeconds int
 	MaxTTLSeconds int
+	// Negative answers (NXDOMAIN/NODATA) use the SOA minimum clamped to these bounds (0 = unbounded)
+	NegativeMinTTLSeconds int
+	NegativeMaxTTLSeconds int
+	// ServFailTTLSeconds caches SERVFAIL answers briefly (0 = not cached)
+	ServFailTTLSeconds int
 
 	lru         *lru.LRU[string, cacheItem]
 	sf          singleflight.Group
 	currentSize int64 // Current cache size in bytes
 	sizeMu      sync.RWMutex
+
+	// ecs decides whether the client subnet is part of the key (nil = always)
+	ecs atomic.Pointer[ecsKeyPolicy]
 }
 
 func NewCachedResolver(next Resolver, maxEntries int, minTTLSeconds int, maxTTLSeconds int) *CachedResolver {
@@ -81,9 +98,19 @@ func (c *CachedResolver) Resolve(ctx context.Context, q *dns.Msg) (*dns.Msg, str
 		return c.Next.Resolve(ctx, q)
 	}
 
-	key := strings.ToLower(strings.TrimSuffix(q.Question[0].Name, ".")) + ":" + strconv.FormatUint(uint64(q.Question[0].Qtype), 10)
+	key := c.key(q)
 
-	it, ok := c.lru.Get(key)
+	// Dry runs leave their answers unmarked, so they neither store them for
+	// client queries nor share a lookup with one
+	if QueryTraceFromContext(ctx).DryRun() {
+		if it, ok := c.lookup(q, key); ok && !time.Now().After(it.expire) {
+			return hitReply(q, it), sourceCache, nil
+		}
+
+		return c.Next.Resolve(ctx, q)
+	}
+
+	it, ok := c.lookup(q, key)
 	if !ok {
 		// Coalesce concurrent cache misses for the same key
 		zerolog.Ctx(ctx).Debug().
@@ -131,43 +158,149 @@ func (c *CachedResolver) Resolve(ctx context.Context, q *dns.Msg) (*dns.Msg, str
 		return c.resolveAndCache(ctx, q, key)
 	}
 
-	reply := new(dns.Msg)
-	reply.SetReply(q)
-	reply.RecursionAvailable = it.msg.RecursionAvailable
-	reply.Authoritative = it.msg.Authoritative
-	reply.Rcode = it.msg.Rcode
-	reply.Answer = it.msg.Answer
-	reply.Ns = it.msg.Ns
-	reply.Extra = it.msg.Extra
+	if it.stats != nil {
+		it.stats.hits.Add(1)
+	}
+
+	return hitReply(q, it), sourceCache, nil
+}
+
+// hitReply answers q from a fresh entry. Clients must not keep records longer
+// than the entry lives: addresses are re-marked only when it is refreshed.
+func hitReply(q *dns.Msg, it cacheItem) *dns.Msg {
+	reply := cachedReply(q, it.msg)
+	remaining := uint32(time.Until(it.expire) / time.Second)
+	reply.Answer = capTTLs(reply.Answer, remaining)
+	reply.Ns = capTTLs(reply.Ns, remaining)
+	reply.Extra = capTTLs(reply.Extra, remaining)
+
+	return reply
+}
+
+// lookup returns the entry for key. A client without the DO bit may also be
+// answered from a fresh DNSSEC-enabled entry; its DNSSEC records are stripped
+// when the reply is built.
+//
+//nolint:funcorder // keep helper close to Resolve for readability
+func (c *CachedResolver) lookup(q *dns.Msg, key string) (cacheItem, bool) {
+	if it, ok := c.lru.Get(key); ok {
+		return it, true
+	}
+
+	parts := c.keyParts(q)
+	if parts.do {
+		return cacheItem{}, false
+	}
+
+	parts.do = true
+
+	if it, ok := c.lru.Get(parts.String()); ok && !time.Now().After(it.expire) {
+		return it, true
+	}
+
+	return cacheItem{}, false
+}
+
+// SetECSPolicy keys entries by the client subnet only for queries that reach
+// an upstream with it: those of rule groups with the passthrough policy and,
+// when upstreamPassthrough is set, those without a rule group policy.
+func (c *CachedResolver) SetECSPolicy(rules *RuleStore, upstreamPassthrough bool) {
+	c.ecs.Store(&ecsKeyPolicy{rules: rules, upstream: upstreamPassthrough})
+}
 
-	return reply, sourceCache, nil
+// key returns the cache key of a query.
+//
+//nolint:funcorder // keep helper close to Resolve for readability
+func (c *CachedResolver) key(q *dns.Msg) string {
+	return c.keyParts(q).String()
+}
+
+//nolint:funcorder // keep helper close to Resolve for readability
+func (c *CachedResolver) keyParts(q *dns.Msg) cacheKeyParts {
+	parts := keyPartsFromQuery(q)
+
+	if policy := c.ecs.Load(); parts.ecs != nil && policy != nil && !policy.passthrough(parts.name) {
+		parts.ecs = nil
+	}
+
+	return parts
 }
 
 //nolint:funcorder // keep helper close to Resolve for readability
 func (c *CachedResolver) resolveAndCache(ctx context.Context, q *dns.Msg, key string) (*dns.Msg, string, error) {
 	out, src, err := c.Next.Resolve(ctx, q)
-	// Skip caching when there are no answers (empty result pool)
-	if err == nil && out != nil && len(out.Answer) > 0 {
+	if err == nil && out != nil {
 		c.put(ctx, key, out)
 	}
 
 	return out, src, err
 }
 
-//nolint:funcorder,cyclop,nestif,funlen // helper grouped with resolveAndCache, complex eviction logic
+//nolint:funcorder // helper grouped with resolveAndCache
 func (c *CachedResolver) put(ctx context.Context, key string, msg *dns.Msg) {
-	if msg == nil {
+	if msg == nil || msg.Truncated {
 		return
 	}
 
-	ttl := ttlFromMsg(msg)
-	if ttl <= 0 {
-		ttl = uint32(c.MinTTLSeconds) //nolint:gosec // TTL bounds validated in config
+	ttl, ok := c.cacheTTL(msg)
+	if !ok {
+		return
 	}
 
-	t := max(c.MinTTLSeconds, min(int(ttl), c.MaxTTLSeconds))
-	ttl = uint32(t) //nolint:gosec // TTL bounds validated in config
+	c.store(ctx, key, msg, time.Now().Add(time.Duration(ttl)*time.Second))
+}
+
+// cacheTTL returns how long msg may be cached: positive answers use the
+// smallest record TTL, negative answers the SOA minimum (RFC 2308) and
+// SERVFAIL a short fixed TTL. Other responses are not cached.
+//
+//nolint:funcorder // helper grouped with put
+func (c *CachedResolver) cacheTTL(msg *dns.Msg) (uint32, bool) {
+	switch {
+	case msg.Rcode == dns.RcodeServerFailure:
+		return uint32(c.ServFailTTLSeconds), c.ServFailTTLSeconds > 0 //nolint:gosec // bounds validated in config
+	case msg.Rcode == dns.RcodeSuccess && len(msg.Answer) > 0:
+		ttl := ttlFromMsg(msg)
+		if ttl <= 0 {
+			ttl = uint32(c.MinTTLSeconds) //nolint:gosec // TTL bounds validated in config
+		}
+
+		t := max(c.MinTTLSeconds, min(int(ttl), c.MaxTTLSeconds))
+
+		return uint32(t), true //nolint:gosec // TTL bounds validated in config
+	case msg.Rcode == dns.RcodeNameError || msg.Rcode == dns.RcodeSuccess:
+		ttl, ok := negativeTTL(msg)
+		if !ok {
+			// Without an SOA there is no way to tell how long the answer holds
+			return 0, false
+		}
+
+		t := max(c.NegativeMinTTLSeconds, int(ttl))
+		if c.NegativeMaxTTLSeconds > 0 {
+			t = min(t, c.NegativeMaxTTLSeconds)
+		}
+
+		return uint32(t), t > 0 //nolint:gosec // TTL bounds validated in config
+	default:
+		return 0, false
+	}
+}
 
+// negativeTTL returns min(SOA TTL, SOA MINIMUM) from the authority section.
+func negativeTTL(msg *dns.Msg) (uint32, bool) {
+	for _, rr := range msg.Ns {
+		if soa, ok := rr.(*dns.SOA); ok {
+			return min(soa.Hdr.Ttl, soa.Minttl), true
+		}
+	}
+
+	return 0, false
+}
+
+// store adds an entry with an explicit expiry, evicting to honor the size limit.
+//
+//nolint:funcorder,cyclop,nestif,funlen // complex eviction logic
+func (c *CachedResolver) store(ctx context.Context, key string, msg *dns.Msg, expire time.Time) {
 	// Calculate approximate size of the message
 	itemSize := estimateMsgSize(msg)
 
@@ -227,8 +360,10 @@ func (c *CachedResolver) put(ctx context.Context, key string, msg *dns.Msg) {
 
 	it := cacheItem{
 		msg:    msg.Copy(),
-		expire: time.Now().Add(time.Duration(ttl) * time.Second),
+		stored: time.Now(),
+		expire: expire,
 		size:   itemSize,
+		stats:  &cacheStats{},
 	}
 	c.lru.Add(key, it)
 
@@ -317,10 +452,29 @@ func (c *CachedResolver) Flush() {
 	}
 }
 
-// Delete removes cache entries for a specific name and qtype.
-// If qtype is 0, deletes common types for the name.
-//
-//nolint:cyclop // complex branching for different qtypes
+// CacheStats describes the fill of the cache.
+type CacheStats struct {
+	Entries      int   `json:"entries"`
+	MaxEntries   int   `json:"max_entries"`
+	SizeBytes    int64 `json:"size_bytes,omitempty"` // tracked only with a size limit
+	MaxSizeBytes int64 `json:"max_size_bytes,omitempty"`
+}
+
+// Stats returns the number of entries and bytes held by the cache.
+func (c *CachedResolver) Stats() CacheStats {
+	if c == nil || c.lru == nil {
+		return CacheStats{}
+	}
+
+	c.sizeMu.RLock()
+	defer c.sizeMu.RUnlock()
+
+	return CacheStats{Entries: c.lru.Len(), MaxEntries: c.MaxEntries, SizeBytes: c.currentSize, MaxSizeBytes: c.MaxSizeBytes}
+}
+
+// Delete removes cache entries for a specific name and qtype, including
+// their DNSSEC, class and client subnet variants.
+// If qtype is 0, deletes all types for the name.
 func (c *CachedResolver) Delete(name string, qtype uint16) {
 	if c == nil || c.lru == nil {
 		return
@@ -331,31 +485,30 @@ func (c *CachedResolver) Delete(name string, qtype uint16) {
 		return
 	}
 
-	if qtype != 0 {
-		key := name + ":" + strconv.FormatUint(uint64(qtype), 10)
-		if c.MaxSizeBytes > 0 {
-			if item, exists := c.lru.Peek(key); exists {
-				c.sizeMu.Lock()
-				c.currentSize -= item.size
-				c.sizeMu.Unlock()
-			}
-		}
+	c.deleteWhere(func(parts cacheKeyParts) bool {
+		return parts.name == name && (qtype == 0 || parts.qtype == qtype)
+	})
+}
 
-		c.lru.Remove(key)
+// DeleteMatching removes the cache entries of every name match reports true
+// for. Names are lower case without the trailing dot.
+func (c *CachedResolver) DeleteMatching(match func(name string) bool) {
+	if c == nil || c.lru == nil {
+		return
+	}
+
+	c.deleteWhere(func(parts cacheKeyParts) bool { return match(parts.name) })
+}
+
+func (c *CachedResolver) deleteWhere(match func(parts cacheKeyParts) bool) {
+	removed := false
 
-		if cacheChangeNotify != nil {
-			cacheChangeNotify()
+	for _, key := range c.lru.Keys() {
+		parts, ok := parseCacheKey(key)
+		if !ok || !match(parts) {
+			continue
 		}
 
-		return
-	}
-	// Remove a set of common qtypes for this name
-	common := []uint16{
-		dns.TypeA, dns.TypeAAAA, dns.TypeCNAME, dns.TypeMX, dns.TypeNS,
-		dns.TypeTXT, dns.TypeSRV, dns.TypePTR,
-	}
-	for _, t := range common {
-		key := name + ":" + strconv.FormatUint(uint64(t), 10)
 		if c.MaxSizeBytes > 0 {
 			if item, exists := c.lru.Peek(key); exists {
 				c.sizeMu.Lock()
@@ -366,9 +519,11 @@ func (c *CachedResolver) Delete(name string, qtype uint16) {
 
 		c.lru.Remove(key)
 
-		if cacheChangeNotify != nil {
-			cacheChangeNotify()
-		}
+		removed = true
+	}
+
+	if removed && cacheChangeNotify != nil {
+		cacheChangeNotify()
 	}
 }
 
@@ -380,10 +535,24 @@ type cacheEntry struct {
 	Answers   int       `json:"answers"`
 	ExpiresAt time.Time `json:"expires_at"`
 	RCode     int       `json:"rcode"`
+	Status    string    `json:"status"` // NOERROR, NODATA, NXDOMAIN or SERVFAIL
+}
+
+// responseStatus names the rcode of a cached answer, telling NODATA apart.
+func responseStatus(msg *dns.Msg) string {
+	if msg.Rcode == dns.RcodeSuccess && len(msg.Answer) == 0 {
+		return "NODATA"
+	}
+
+	if s, ok := dns.RcodeToString[msg.Rcode]; ok {
+		return s
+	}
+
+	return strconv.Itoa(msg.Rcode)
 }
 
 // List returns a paginated list of non-expired cache entries filtered by substring q.
-// sortBy: name|expires|qtype|answers, order: asc|desc.
+// sortBy: name|expires|qtype|answers|status, order: asc|desc.
 //
 //nolint:gocognit,cyclop,funlen,nonamedreturns // sorting and pagination branching kept explicit for clarity
 func (c *CachedResolver) List(offset, limit int, q string, sortBy, order string) (items []cacheEntry, total int) {
@@ -403,26 +572,24 @@ func (c *CachedResolver) List(offset, limit int, q string, sortBy, order string)
 			continue
 		}
 
-		const keyParts = 2
-
-		parts := strings.SplitN(k, ":", keyParts)
-		if len(parts) != keyParts {
+		parts, ok := parseCacheKey(k)
+		if !ok {
 			continue
 		}
 
-		name := parts[0]
+		name := parts.name
 		if ql != "" && !strings.Contains(name, ql) {
 			continue
 		}
 
-		qtype64, _ := strconv.ParseUint(parts[1], 10, 16)
 		tmp = append(tmp, cacheEntry{
 			Key:       k,
 			Name:      name,
-			QType:     uint16(qtype64),
+			QType:     parts.qtype,
 			Answers:   len(it.msg.Answer),
 			ExpiresAt: it.expire,
 			RCode:     it.msg.Rcode,
+			Status:    responseStatus(it.msg),
 		})
 	}
 
@@ -459,6 +626,12 @@ func (c *CachedResolver) List(offset, limit int, q string, sortBy, order string)
 			}
 
 			return tmp[i].Answers < tmp[j].Answers
+		case "status":
+			if desc {
+				return tmp[i].Status > tmp[j].Status
+			}
+
+			return tmp[i].Status < tmp[j].Status
 		default: // expires
 			if desc {
 				return tmp[i].ExpiresAt.After(tmp[j].ExpiresAt)
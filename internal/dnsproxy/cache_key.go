@@ -0,0 +1,278 @@
+package dnsproxy
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/bavix/outway/internal/config"
+)
+
+// Cache keys are "name:qtype" for plain IN queries. Queries that may get a
+// different answer append "|"-separated flags: the class ("c=3"), the DO and
+// CD bits ("do", "cd") and the ECS source prefix ("ecs=192.0.2.0/24").
+const (
+	keyFlagSep   = "|"
+	keyFlagDO    = "do"
+	keyFlagCD    = "cd"
+	keyFlagClass = "c="
+	keyFlagECS   = "ecs="
+
+	ecsFamilyIPv4 = 1
+	ecsFamilyIPv6 = 2
+	ednsUDPSize   = 1232
+)
+
+// cacheKeyParts is the decoded form of a cache key.
+type cacheKeyParts struct {
+	name  string
+	qtype uint16
+	class uint16
+	do    bool
+	cd    bool
+	ecs   *net.IPNet
+}
+
+func keyPartsFromQuery(q *dns.Msg) cacheKeyParts {
+	question := q.Question[0]
+
+	parts := cacheKeyParts{
+		name:  strings.ToLower(strings.TrimSuffix(question.Name, ".")),
+		qtype: question.Qtype,
+		class: question.Qclass,
+		cd:    q.CheckingDisabled,
+	}
+
+	if opt := q.IsEdns0(); opt != nil {
+		parts.do = opt.Do()
+		parts.ecs = ecsPrefix(opt)
+	}
+
+	return parts
+}
+
+// ecsKeyPolicy tells which queries reach an upstream with the client's subnet.
+// Only their answers may depend on it: under the strip, fixed and via
+// policies every client is sent the same subnet, so the prefix is left out of
+// the key and clients share one entry.
+type ecsKeyPolicy struct {
+	rules    *RuleStore
+	upstream bool // some upstream forwards the client's subnet
+}
+
+// passthrough reports whether the client subnet of a query for name is
+// forwarded: the policy of the matching rule group wins over the upstreams'.
+func (p *ecsKeyPolicy) passthrough(name string) bool {
+	if p.rules != nil {
+		if rule, ok := p.rules.Find(name); ok && rule.ECS != nil {
+			return isECSPassthrough(rule.ECS)
+		}
+	}
+
+	return p.upstream
+}
+
+func isECSPassthrough(policy *config.ECSPolicy) bool {
+	return policy == nil || policy.Mode == "" || policy.Mode == config.ECSModePassthrough
+}
+
+// ecsPrefix returns the client subnet source prefix of an OPT record.
+func ecsPrefix(opt *dns.OPT) *net.IPNet {
+	for _, o := range opt.Option {
+		subnet, ok := o.(*dns.EDNS0_SUBNET)
+		if !ok {
+			continue
+		}
+
+		bits := net.IPv6len * 8
+		if subnet.Family == ecsFamilyIPv4 {
+			bits = net.IPv4len * 8
+		}
+
+		mask := net.CIDRMask(int(subnet.SourceNetmask), bits)
+		if mask == nil {
+			return nil
+		}
+
+		return &net.IPNet{IP: subnet.Address.Mask(mask), Mask: mask}
+	}
+
+	return nil
+}
+
+func (k cacheKeyParts) String() string {
+	var b strings.Builder
+
+	b.WriteString(k.name)
+	b.WriteByte(':')
+	b.WriteString(strconv.FormatUint(uint64(k.qtype), 10))
+
+	if k.class != dns.ClassINET && k.class != 0 {
+		b.WriteString(keyFlagSep + keyFlagClass + strconv.FormatUint(uint64(k.class), 10))
+	}
+
+	if k.do {
+		b.WriteString(keyFlagSep + keyFlagDO)
+	}
+
+	if k.cd {
+		b.WriteString(keyFlagSep + keyFlagCD)
+	}
+
+	if k.ecs != nil && k.ecs.IP != nil {
+		b.WriteString(keyFlagSep + keyFlagECS + k.ecs.String())
+	}
+
+	return b.String()
+}
+
+// parseCacheKey decodes a key built by CachedResolver.key.
+func parseCacheKey(key string) (cacheKeyParts, bool) {
+	name, rest, ok := strings.Cut(key, ":")
+	if !ok || name == "" {
+		return cacheKeyParts{}, false
+	}
+
+	flags := strings.Split(rest, keyFlagSep)
+
+	qtype, err := strconv.ParseUint(flags[0], 10, 16)
+	if err != nil {
+		return cacheKeyParts{}, false
+	}
+
+	parts := cacheKeyParts{name: name, qtype: uint16(qtype), class: dns.ClassINET}
+
+	for _, flag := range flags[1:] {
+		switch {
+		case flag == keyFlagDO:
+			parts.do = true
+		case flag == keyFlagCD:
+			parts.cd = true
+		case strings.HasPrefix(flag, keyFlagClass):
+			class, err := strconv.ParseUint(strings.TrimPrefix(flag, keyFlagClass), 10, 16)
+			if err != nil {
+				return cacheKeyParts{}, false
+			}
+
+			parts.class = uint16(class)
+		case strings.HasPrefix(flag, keyFlagECS):
+			_, ecs, err := net.ParseCIDR(strings.TrimPrefix(flag, keyFlagECS))
+			if err != nil {
+				return cacheKeyParts{}, false
+			}
+
+			parts.ecs = ecs
+		default:
+			return cacheKeyParts{}, false
+		}
+	}
+
+	return parts, true
+}
+
+// query rebuilds a query equivalent to the one the key was made from.
+func (k cacheKeyParts) query() *dns.Msg {
+	q := new(dns.Msg)
+	q.SetQuestion(dns.Fqdn(k.name), k.qtype)
+	q.Question[0].Qclass = k.class
+	q.RecursionDesired = true
+	q.CheckingDisabled = k.cd
+
+	if k.do || k.ecs != nil {
+		q.SetEdns0(ednsUDPSize, k.do)
+	}
+
+	if k.ecs != nil {
+		opt := q.IsEdns0()
+		opt.Option = append(opt.Option, ecsOption(k.ecs))
+	}
+
+	return q
+}
+
+func sameSubnet(a, b *net.IPNet) bool {
+	return a != nil && b != nil && a.String() == b.String()
+}
+
+// isDNSSECType reports whether t only carries DNSSEC data.
+func isDNSSECType(t uint16) bool {
+	switch t {
+	case dns.TypeRRSIG, dns.TypeNSEC, dns.TypeNSEC3, dns.TypeNSEC3PARAM:
+		return true
+	default:
+		return false
+	}
+}
+
+// stripDNSSEC drops DNSSEC records the client did not ask for, keeping
+// records of the queried type itself.
+func stripDNSSEC(rrs []dns.RR, qtype uint16) []dns.RR {
+	out := make([]dns.RR, 0, len(rrs))
+
+	for _, rr := range rrs {
+		if t := rr.Header().Rrtype; t != qtype && isDNSSECType(t) {
+			continue
+		}
+
+		out = append(out, rr)
+	}
+
+	return out
+}
+
+// cachedReply builds the answer to q from a cached message. DNSSEC records
+// are removed for clients without the DO bit and the OPT record mirrors the
+// client's EDNS instead of the upstream's.
+func cachedReply(q, cached *dns.Msg) *dns.Msg {
+	reply := new(dns.Msg)
+	reply.SetReply(q)
+	reply.RecursionAvailable = cached.RecursionAvailable
+	reply.Authoritative = cached.Authoritative
+	reply.Rcode = cached.Rcode
+
+	clientOpt := q.IsEdns0()
+	do := clientOpt != nil && clientOpt.Do()
+	qtype := q.Question[0].Qtype
+
+	reply.Answer = cached.Answer
+	reply.Ns = cached.Ns
+	reply.AuthenticatedData = cached.AuthenticatedData && (do || q.AuthenticatedData)
+
+	if !do {
+		reply.Answer = stripDNSSEC(cached.Answer, qtype)
+		reply.Ns = stripDNSSEC(cached.Ns, qtype)
+	}
+
+	reply.Extra = make([]dns.RR, 0, len(cached.Extra)+1)
+
+	for _, rr := range cached.Extra {
+		if rr.Header().Rrtype == dns.TypeOPT || (!do && isDNSSECType(rr.Header().Rrtype)) {
+			continue
+		}
+
+		reply.Extra = append(reply.Extra, rr)
+	}
+
+	if clientOpt != nil {
+		reply.SetEdns0(clientOpt.UDPSize(), do)
+
+		// Echo the client subnet of the answer (RFC 7871) to clients that sent
+		// one. An answer shared by every subnet is reported with scope 0.
+		if client := ecsPrefix(clientOpt); client != nil {
+			upstream := cached.IsEdns0()
+			if upstream != nil && sameSubnet(ecsPrefix(upstream), client) {
+				for _, o := range upstream.Option {
+					if subnet, ok := o.(*dns.EDNS0_SUBNET); ok {
+						reply.IsEdns0().Option = append(reply.IsEdns0().Option, subnet)
+					}
+				}
+			} else {
+				restoreECS(q, reply)
+			}
+		}
+	}
+
+	return reply
+}
@@ -0,0 +1,202 @@
+package dnsproxy_test
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bavix/outway/internal/config"
+	"github.com/bavix/outway/internal/dnsproxy"
+)
+
+func signedResolver(upstream *atomic.Int32) *MockResolver {
+	return &MockResolver{resolveFunc: func(_ context.Context, q *dns.Msg) (*dns.Msg, string, error) {
+		upstream.Add(1)
+
+		m := new(dns.Msg)
+		m.SetReply(q)
+		m.AuthenticatedData = true
+		m.Answer = append(m.Answer,
+			&dns.A{
+				Hdr: dns.RR_Header{Name: q.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+				A:   net.ParseIP("192.0.2.1"),
+			},
+			&dns.RRSIG{
+				Hdr:         dns.RR_Header{Name: q.Question[0].Name, Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: 300},
+				TypeCovered: dns.TypeA,
+				SignerName:  "example.",
+			},
+		)
+
+		if opt := q.IsEdns0(); opt != nil {
+			m.SetEdns0(opt.UDPSize(), opt.Do())
+
+			for _, o := range opt.Option {
+				if subnet, ok := o.(*dns.EDNS0_SUBNET); ok {
+					m.IsEdns0().Option = append(m.IsEdns0().Option, subnet)
+				}
+			}
+		}
+
+		return m, "mock", nil
+	}}
+}
+
+func TestCachedResolver_DNSSECVariants(t *testing.T) {
+	t.Parallel()
+
+	var upstream atomic.Int32
+
+	cache := dnsproxy.NewCachedResolver(signedResolver(&upstream), 100, 60, 3600)
+
+	query := func(do bool) *dns.Msg {
+		q := new(dns.Msg)
+		q.SetQuestion("signed.example.", dns.TypeA)
+
+		if do {
+			q.SetEdns0(1232, true)
+		}
+
+		out, _, err := cache.Resolve(context.Background(), q)
+		require.NoError(t, err)
+
+		return out
+	}
+
+	withDO := query(true)
+	require.Len(t, withDO.Answer, 2)
+	assert.True(t, withDO.AuthenticatedData)
+
+	// Plain clients are answered from the DO entry without signatures
+	plain := query(false)
+	require.Len(t, plain.Answer, 1)
+	assert.Equal(t, dns.TypeA, plain.Answer[0].Header().Rrtype)
+	assert.False(t, plain.AuthenticatedData)
+	assert.Nil(t, plain.IsEdns0())
+
+	assert.Len(t, query(true).Answer, 2)
+	assert.Equal(t, int32(1), upstream.Load())
+}
+
+func TestCachedResolver_KeyVariants(t *testing.T) {
+	t.Parallel()
+
+	var upstream atomic.Int32
+
+	cache := dnsproxy.NewCachedResolver(signedResolver(&upstream), 100, 60, 3600)
+
+	resolve := func(mutate func(q *dns.Msg)) *dns.Msg {
+		q := new(dns.Msg)
+		q.SetQuestion("variant.example.", dns.TypeA)
+		mutate(q)
+
+		out, _, err := cache.Resolve(context.Background(), q)
+		require.NoError(t, err)
+
+		return out
+	}
+
+	ecs := func(prefix string) func(q *dns.Msg) {
+		return func(q *dns.Msg) {
+			_, subnet, _ := net.ParseCIDR(prefix)
+			ones, _ := subnet.Mask.Size()
+
+			q.SetEdns0(1232, false)
+			q.IsEdns0().Option = append(q.IsEdns0().Option, &dns.EDNS0_SUBNET{
+				Code:          dns.EDNS0SUBNET,
+				Family:        1,
+				SourceNetmask: uint8(ones), //nolint:gosec // test prefix
+				Address:       subnet.IP.To4(),
+			})
+		}
+	}
+
+	variants := []func(q *dns.Msg){
+		func(*dns.Msg) {},
+		func(q *dns.Msg) { q.CheckingDisabled = true },
+		func(q *dns.Msg) { q.Question[0].Qclass = dns.ClassCHAOS },
+		ecs("198.51.100.0/24"),
+		ecs("203.0.113.0/24"),
+	}
+
+	for _, v := range variants {
+		resolve(v)
+		resolve(v)
+	}
+
+	assert.Equal(t, int32(len(variants)), upstream.Load(), "each variant is cached separately")
+
+	// The ECS option of the answer is echoed back to the client
+	out := resolve(ecs("198.51.100.7/24"))
+	require.NotNil(t, out.IsEdns0())
+	require.Len(t, out.IsEdns0().Option, 1)
+	assert.Equal(t, int32(len(variants)), upstream.Load(), "address bits beyond the prefix share an entry")
+
+	_, total := cache.List(0, 100, "variant.example", "", "")
+	assert.Equal(t, len(variants), total)
+
+	cache.Delete("variant.example.", dns.TypeA)
+
+	_, total = cache.List(0, 100, "variant.example", "", "")
+	assert.Zero(t, total, "delete removes every variant")
+}
+
+func TestCachedResolver_ECSKeyFollowsPolicy(t *testing.T) {
+	t.Parallel()
+
+	var upstream atomic.Int32
+
+	cache := dnsproxy.NewCachedResolver(signedResolver(&upstream), 100, 60, 3600)
+	cache.SetECSPolicy(dnsproxy.NewRuleStore([]config.Rule{
+		{Pattern: "*.strip.example", Via: "wan1", ECS: &config.ECSPolicy{Mode: config.ECSModeStrip}},
+		{Pattern: "*.pass.example", Via: "wan1", ECS: &config.ECSPolicy{Mode: config.ECSModePassthrough}},
+	}), false)
+
+	resolve := func(name, prefix string) *dns.Msg {
+		_, subnet, _ := net.ParseCIDR(prefix)
+		ones, _ := subnet.Mask.Size()
+
+		q := new(dns.Msg)
+		q.SetQuestion(name, dns.TypeA)
+		q.SetEdns0(1232, false)
+		q.IsEdns0().Option = append(q.IsEdns0().Option, &dns.EDNS0_SUBNET{
+			Code:          dns.EDNS0SUBNET,
+			Family:        1,
+			SourceNetmask: uint8(ones), //nolint:gosec // test prefix
+			Address:       subnet.IP.To4(),
+		})
+
+		out, _, err := cache.Resolve(context.Background(), q)
+		require.NoError(t, err)
+
+		return out
+	}
+
+	// Upstreams never see the subnet of stripped names, so clients share the entry
+	resolve("www.strip.example.", "198.51.100.0/24")
+
+	out := resolve("www.strip.example.", "203.0.113.0/24")
+	assert.Equal(t, int32(1), upstream.Load())
+
+	require.NotNil(t, out.IsEdns0())
+	require.Len(t, out.IsEdns0().Option, 1)
+	subnet, ok := out.IsEdns0().Option[0].(*dns.EDNS0_SUBNET)
+	require.True(t, ok)
+	assert.Equal(t, "203.0.113.0", subnet.Address.String(), "the client's own subnet is echoed")
+	assert.Zero(t, subnet.SourceScope)
+
+	// Names without a rule group policy follow the upstreams, which strip it here
+	resolve("www.other.example.", "198.51.100.0/24")
+	resolve("www.other.example.", "203.0.113.0/24")
+	assert.Equal(t, int32(2), upstream.Load())
+
+	// Forwarded subnets may get different answers
+	resolve("www.pass.example.", "198.51.100.0/24")
+	resolve("www.pass.example.", "203.0.113.0/24")
+	assert.Equal(t, int32(4), upstream.Load())
+}
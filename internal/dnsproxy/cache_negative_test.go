@@ -0,0 +1,99 @@
+package dnsproxy_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bavix/outway/internal/dnsproxy"
+)
+
+func TestCachedResolver_NegativeCaching(t *testing.T) {
+	t.Parallel()
+
+	var upstream atomic.Int32
+
+	soa := func(ttl, minttl uint32) dns.RR {
+		return &dns.SOA{
+			Hdr:    dns.RR_Header{Name: "example.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: ttl},
+			Ns:     "ns.example.",
+			Mbox:   "admin.example.",
+			Minttl: minttl,
+		}
+	}
+
+	next := &MockResolver{resolveFunc: func(_ context.Context, q *dns.Msg) (*dns.Msg, string, error) {
+		upstream.Add(1)
+
+		m := new(dns.Msg)
+		m.SetReply(q)
+
+		switch q.Question[0].Name {
+		case "missing.example.":
+			m.Rcode = dns.RcodeNameError
+			m.Ns = append(m.Ns, soa(3600, 120))
+		case "nodata.example.":
+			m.Ns = append(m.Ns, soa(30, 7200))
+		case "long.example.":
+			m.Rcode = dns.RcodeNameError
+			m.Ns = append(m.Ns, soa(86400, 86400))
+		case "nosoa.example.":
+			m.Rcode = dns.RcodeNameError
+		case "broken.example.":
+			m.Rcode = dns.RcodeServerFailure
+		case "refused.example.":
+			m.Rcode = dns.RcodeRefused
+		}
+
+		return m, "mock", nil
+	}}
+
+	cache := dnsproxy.NewCachedResolver(next, 100, 60, 3600)
+	cache.NegativeMaxTTLSeconds = 900
+	cache.ServFailTTLSeconds = 5
+
+	resolve := func(name string) {
+		q := new(dns.Msg)
+		q.SetQuestion(name, dns.TypeA)
+
+		_, _, err := cache.Resolve(context.Background(), q)
+		require.NoError(t, err)
+	}
+
+	expiresIn := func(name string) time.Duration {
+		items, _ := cache.List(0, 10, name, "", "")
+		require.Len(t, items, 1, name)
+
+		return time.Until(items[0].ExpiresAt)
+	}
+
+	for _, name := range []string{"missing.example.", "nodata.example.", "long.example.", "broken.example."} {
+		resolve(name)
+		resolve(name)
+	}
+
+	assert.Equal(t, int32(4), upstream.Load(), "negative and failed answers are served from cache")
+
+	assert.InDelta(t, 120, expiresIn("missing").Seconds(), 2, "SOA minimum")
+	assert.InDelta(t, 30, expiresIn("nodata").Seconds(), 2, "SOA record TTL")
+	assert.InDelta(t, 900, expiresIn("long").Seconds(), 2, "negative upper bound")
+	assert.InDelta(t, 5, expiresIn("broken").Seconds(), 2, "SERVFAIL TTL")
+
+	items, _ := cache.List(0, 10, "nodata", "", "")
+	assert.Equal(t, "NODATA", items[0].Status)
+
+	items, _ = cache.List(0, 10, "broken", "", "")
+	assert.Equal(t, "SERVFAIL", items[0].Status)
+
+	// Answers without SOA and other failures are not cached
+	resolve("nosoa.example.")
+	resolve("nosoa.example.")
+	resolve("refused.example.")
+	resolve("refused.example.")
+	assert.Equal(t, int32(8), upstream.Load())
+}
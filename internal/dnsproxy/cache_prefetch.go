@@ -0,0 +1,139 @@
+package dnsproxy
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/bavix/outway/internal/metrics"
+)
+
+const (
+	// prefetchInterval is how often the cache is scanned for hot entries.
+	prefetchInterval = 5 * time.Second
+	// prefetchConcurrency bounds parallel upstream refreshes per scan.
+	prefetchConcurrency = 4
+)
+
+var errPrefetchEmpty = errors.New("prefetch returned no response")
+
+// prefetchCandidates returns keys of entries hit at least minHits times that
+// have used up the given fraction of their lifetime and are not expired yet.
+func (c *CachedResolver) prefetchCandidates(at float64, minHits int) []string {
+	if c == nil || c.lru == nil {
+		return nil
+	}
+
+	now := time.Now()
+
+	var keys []string
+
+	for _, key := range c.lru.Keys() {
+		it, ok := c.lru.Peek(key)
+		if !ok || it.stats == nil || !now.Before(it.expire) {
+			continue
+		}
+
+		if int(it.stats.hits.Load()) < minHits || it.stats.prefetched.Load() {
+			continue
+		}
+
+		lifetime := it.expire.Sub(it.stored)
+		if now.Sub(it.stored) < time.Duration(float64(lifetime)*at) {
+			continue
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+// Prefetch refreshes hot entries before they expire and returns how many
+// were refreshed. Answers go through the rest of the pipeline, so marks of
+// the refreshed addresses are renewed as well.
+func (c *CachedResolver) Prefetch(ctx context.Context, at float64, minHits int) int {
+	keys := c.prefetchCandidates(at, minHits)
+	if len(keys) == 0 {
+		return 0
+	}
+
+	ctx = withMarkRefresh(ctx)
+
+	var refreshed atomic.Int64
+
+	var wg sync.WaitGroup
+
+	slots := make(chan struct{}, prefetchConcurrency)
+
+	for _, key := range keys {
+		parts, ok := parseCacheKey(key)
+		if !ok {
+			continue
+		}
+
+		q := parts.query()
+
+		// Refresh each lifetime once, even if the upstream keeps failing
+		if it, ok := c.lru.Peek(key); ok && it.stats != nil {
+			it.stats.prefetched.Store(true)
+		}
+
+		slots <- struct{}{}
+
+		wg.Go(func() {
+			defer func() { <-slots }()
+
+			_, err, _ := c.sf.Do(key, func() (any, error) {
+				out, _, err := c.resolveAndCache(ctx, q, key)
+				if err == nil && out == nil {
+					err = errPrefetchEmpty
+				}
+
+				return nil, err
+			})
+			if err != nil {
+				zerolog.Ctx(ctx).Debug().Err(err).Str("cache_key", key).Msg("cache prefetch failed")
+				metrics.IncCachePrefetch("error")
+
+				return
+			}
+
+			refreshed.Add(1)
+			metrics.IncCachePrefetch("ok")
+		})
+	}
+
+	wg.Wait()
+
+	return int(refreshed.Load())
+}
+
+// startPrefetch periodically refreshes hot cache entries while prefetch is
+// enabled in the current config.
+func (p *Proxy) startPrefetch(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(prefetchInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				cfg := p.config.GetConfig()
+				if cfg == nil || !cfg.Cache.Enabled || !cfg.Cache.Prefetch {
+					continue
+				}
+
+				if n := p.Cache().Prefetch(ctx, cfg.Cache.PrefetchAt, cfg.Cache.PrefetchMinHits); n > 0 {
+					zerolog.Ctx(ctx).Debug().Int("entries", n).Msg("cache entries prefetched")
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,62 @@
+package dnsproxy_test
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bavix/outway/internal/dnsproxy"
+)
+
+func TestCachedResolver_Prefetch(t *testing.T) {
+	t.Parallel()
+
+	var upstream atomic.Int32
+
+	next := &MockResolver{resolveFunc: func(_ context.Context, q *dns.Msg) (*dns.Msg, string, error) {
+		upstream.Add(1)
+
+		m := new(dns.Msg)
+		m.SetReply(q)
+		m.Answer = append(m.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: q.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 2},
+			A:   net.ParseIP("192.0.2.1"),
+		})
+
+		return m, "mock", nil
+	}}
+
+	cache := dnsproxy.NewCachedResolver(next, 100, 1, 2)
+
+	query := func(name string, times int) {
+		for range times {
+			q := new(dns.Msg)
+			q.SetQuestion(name, dns.TypeA)
+
+			_, _, err := cache.Resolve(context.Background(), q)
+			require.NoError(t, err)
+		}
+	}
+
+	query("hot.example.", 4)  // miss plus three hits
+	query("cold.example.", 2) // miss plus one hit
+	require.Equal(t, int32(2), upstream.Load())
+
+	assert.Zero(t, cache.Prefetch(context.Background(), 0.5, 3), "too early in the lifetime")
+
+	time.Sleep(1100 * time.Millisecond)
+
+	assert.Equal(t, 1, cache.Prefetch(context.Background(), 0.5, 3))
+	assert.Equal(t, int32(3), upstream.Load())
+
+	assert.Zero(t, cache.Prefetch(context.Background(), 0.5, 3), "hits start over after a refresh")
+
+	_, ok := cache.Get("hot.example:1")
+	assert.True(t, ok)
+}
@@ -0,0 +1,310 @@
+package dnsproxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/rs/zerolog"
+
+	"github.com/bavix/outway/internal/config"
+)
+
+// Snapshot layout: magic, format version, saved-at (unix nanoseconds), then
+// records of [payload length][payload][crc32 of payload]. A payload holds the
+// cache key, the absolute expiry and the message in DNS wire format. Records
+// are written from least to most recently used so restoring keeps the LRU order.
+const (
+	snapshotMagic       = "OWCACHE1"
+	snapshotMaxRecord   = 1 << 20 // sanity bound for a single record
+	snapshotDirPerm     = 0o750
+	snapshotFilePerm    = 0o600
+	snapshotHeaderBytes = len(snapshotMagic) + 2 + 8
+
+	// snapshotVersion changes with the layout or the cache key format;
+	// snapshots of other versions are discarded.
+	snapshotVersion uint16 = 2
+)
+
+var (
+	errSnapshotFormat  = errors.New("not a cache snapshot")
+	errSnapshotCorrupt = errors.New("cache snapshot is corrupt")
+	errSnapshotVersion = errors.New("cache snapshot has another format version")
+)
+
+// SaveSnapshot writes non-expired entries to path atomically and returns
+// the number of entries written.
+func (c *CachedResolver) SaveSnapshot(path string) (int, error) {
+	if c == nil || c.lru == nil {
+		return 0, nil
+	}
+
+	var buf bytes.Buffer
+
+	buf.WriteString(snapshotMagic)
+	_ = binary.Write(&buf, binary.BigEndian, snapshotVersion)
+	_ = binary.Write(&buf, binary.BigEndian, time.Now().UnixNano())
+
+	now := time.Now()
+	written := 0
+
+	for _, key := range c.lru.Keys() {
+		it, ok := c.lru.Peek(key)
+		if !ok || now.After(it.expire) || len(key) > math.MaxUint16 {
+			continue
+		}
+
+		wire, err := it.msg.Pack()
+		if err != nil {
+			continue
+		}
+
+		writeSnapshotRecord(&buf, key, it.expire, wire)
+
+		written++
+	}
+
+	if err := writeFileAtomic(path, buf.Bytes()); err != nil {
+		return 0, err
+	}
+
+	return written, nil
+}
+
+// LoadSnapshot restores entries from path. Expired entries are skipped and
+// record TTLs are capped to the time left. A damaged tail stops the load but
+// keeps everything read before it; the error then wraps errSnapshotCorrupt.
+// A snapshot of another format version restores nothing and the error wraps
+// errSnapshotVersion. A missing file is not an error.
+func (c *CachedResolver) LoadSnapshot(ctx context.Context, path string) (int, error) {
+	if c == nil || c.lru == nil {
+		return 0, nil
+	}
+
+	data, err := os.ReadFile(path) //nolint:gosec // path comes from trusted config
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+
+	if err != nil {
+		return 0, fmt.Errorf("read cache snapshot: %w", err)
+	}
+
+	if len(data) < snapshotHeaderBytes || string(data[:len(snapshotMagic)]) != snapshotMagic {
+		return 0, fmt.Errorf("%w: %s", errSnapshotFormat, path)
+	}
+
+	if version := binary.BigEndian.Uint16(data[len(snapshotMagic):]); version != snapshotVersion {
+		return 0, fmt.Errorf("%w: %s: %d", errSnapshotVersion, path, version)
+	}
+
+	r := bytes.NewReader(data[snapshotHeaderBytes:])
+	now := time.Now()
+	loaded := 0
+
+	for r.Len() > 0 {
+		key, expire, msg, err := readSnapshotRecord(r)
+		if err != nil {
+			return loaded, fmt.Errorf("%w: %s: %w", errSnapshotCorrupt, path, err)
+		}
+
+		remaining := expire.Sub(now)
+		if remaining < time.Second {
+			continue
+		}
+
+		capTTL(msg, uint32(min(remaining/time.Second, math.MaxUint32)))
+		c.store(ctx, key, msg, expire)
+
+		loaded++
+	}
+
+	return loaded, nil
+}
+
+func writeSnapshotRecord(buf *bytes.Buffer, key string, expire time.Time, wire []byte) {
+	var payload bytes.Buffer
+
+	_ = binary.Write(&payload, binary.BigEndian, uint16(len(key))) //nolint:gosec // bounded by caller
+	payload.WriteString(key)
+	_ = binary.Write(&payload, binary.BigEndian, expire.UnixNano())
+	payload.Write(wire)
+
+	_ = binary.Write(buf, binary.BigEndian, uint32(payload.Len())) //nolint:gosec // DNS messages are small
+	buf.Write(payload.Bytes())
+	_ = binary.Write(buf, binary.BigEndian, crc32.ChecksumIEEE(payload.Bytes()))
+}
+
+//nolint:nonamedreturns // several values read from one record
+func readSnapshotRecord(r *bytes.Reader) (key string, expire time.Time, msg *dns.Msg, err error) {
+	var size uint32
+	if err = binary.Read(r, binary.BigEndian, &size); err != nil {
+		return "", time.Time{}, nil, err
+	}
+
+	if size > snapshotMaxRecord || int(size) > r.Len() {
+		return "", time.Time{}, nil, io.ErrUnexpectedEOF
+	}
+
+	payload := make([]byte, size)
+	_, _ = r.Read(payload)
+
+	var sum uint32
+	if err = binary.Read(r, binary.BigEndian, &sum); err != nil {
+		return "", time.Time{}, nil, err
+	}
+
+	if crc32.ChecksumIEEE(payload) != sum {
+		return "", time.Time{}, nil, errSnapshotCorrupt
+	}
+
+	const fixed = 2 + 8 // key length + expiry
+	if len(payload) < fixed {
+		return "", time.Time{}, nil, io.ErrUnexpectedEOF
+	}
+
+	keyLen := int(binary.BigEndian.Uint16(payload))
+	if len(payload) < fixed+keyLen {
+		return "", time.Time{}, nil, io.ErrUnexpectedEOF
+	}
+
+	key = string(payload[2 : 2+keyLen])
+	nanos := int64(binary.BigEndian.Uint64(payload[2+keyLen:])) //nolint:gosec // written from int64
+
+	msg = new(dns.Msg)
+	if err = msg.Unpack(payload[fixed+keyLen:]); err != nil {
+		return "", time.Time{}, nil, err
+	}
+
+	return key, time.Unix(0, nanos), msg, nil
+}
+
+// capTTL lowers record TTLs so restored answers never outlive their entry.
+func capTTL(msg *dns.Msg, ttl uint32) {
+	for _, section := range [][]dns.RR{msg.Answer, msg.Ns, msg.Extra} {
+		for _, rr := range section {
+			if h := rr.Header(); h.Rrtype != dns.TypeOPT && h.Ttl > ttl {
+				h.Ttl = ttl
+			}
+		}
+	}
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, snapshotDirPerm); err != nil {
+		return fmt.Errorf("create snapshot dir: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("create snapshot: %w", err)
+	}
+
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+
+		return fmt.Errorf("write snapshot: %w", err)
+	}
+
+	if err := tmp.Chmod(snapshotFilePerm); err != nil {
+		_ = tmp.Close()
+
+		return fmt.Errorf("write snapshot: %w", err)
+	}
+
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+
+		return fmt.Errorf("sync snapshot: %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close snapshot: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("replace snapshot: %w", err)
+	}
+
+	return nil
+}
+
+// startCacheSnapshots restores the cache snapshot and saves it periodically
+// until ctx is done. The final save on shutdown is done by SaveCacheSnapshot.
+func (p *Proxy) startCacheSnapshots(ctx context.Context, cfg config.CacheConfig) {
+	cache := p.Cache()
+	if cache == nil || cfg.SnapshotPath == "" {
+		return
+	}
+
+	logger := zerolog.Ctx(ctx).With().Str("path", cfg.SnapshotPath).Logger()
+
+	start := time.Now()
+
+	n, err := cache.LoadSnapshot(ctx, cfg.SnapshotPath)
+
+	switch {
+	case errors.Is(err, errSnapshotVersion):
+		// Written by another release; the next save replaces it
+		logger.Info().Err(err).Msg("cache snapshot discarded")
+	case err != nil:
+		logger.Warn().Err(err).Int("entries", n).Msg("cache snapshot partially restored")
+	default:
+		logger.Info().Int("entries", n).Dur("took", time.Since(start)).Msg("cache snapshot restored")
+	}
+
+	interval := cfg.SnapshotInterval
+	if interval <= 0 {
+		interval = config.DefaultCacheSnapshotInterval
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				p.SaveCacheSnapshot(ctx)
+			}
+		}
+	}()
+}
+
+// SaveCacheSnapshot writes the cache to the configured snapshot file, if any.
+// It is called periodically, on shutdown and before a self-update restart.
+func (p *Proxy) SaveCacheSnapshot(ctx context.Context) {
+	cfg := p.config.GetConfig()
+
+	cache := p.Cache()
+	if cfg == nil || cache == nil || cfg.Cache.SnapshotPath == "" {
+		return
+	}
+
+	p.snapshotMu.Lock()
+	defer p.snapshotMu.Unlock()
+
+	n, err := cache.SaveSnapshot(cfg.Cache.SnapshotPath)
+	if err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Str("path", cfg.Cache.SnapshotPath).Msg("failed to save cache snapshot")
+
+		return
+	}
+
+	zerolog.Ctx(ctx).Debug().Int("entries", n).Str("path", cfg.Cache.SnapshotPath).Msg("cache snapshot saved")
+}
@@ -0,0 +1,125 @@
+package dnsproxy_test
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bavix/outway/internal/dnsproxy"
+)
+
+func warmCache(t *testing.T, names ...string) *dnsproxy.CachedResolver {
+	t.Helper()
+
+	next := &MockResolver{resolveFunc: func(_ context.Context, q *dns.Msg) (*dns.Msg, string, error) {
+		m := new(dns.Msg)
+		m.SetReply(q)
+		m.Answer = append(m.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: q.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 600},
+			A:   net.ParseIP("192.0.2.1"),
+		})
+
+		return m, "mock", nil
+	}}
+
+	cache := dnsproxy.NewCachedResolver(next, 100, 60, 3600)
+
+	for _, name := range names {
+		q := new(dns.Msg)
+		q.SetQuestion(name, dns.TypeA)
+
+		_, _, err := cache.Resolve(context.Background(), q)
+		require.NoError(t, err)
+	}
+
+	return cache
+}
+
+func TestCachedResolver_SnapshotRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "state", "cache.bin")
+
+	n, err := warmCache(t, "a.example.", "b.example.").SaveSnapshot(path)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	restored := dnsproxy.NewCachedResolver(nil, 100, 60, 3600)
+
+	n, err = restored.LoadSnapshot(context.Background(), path)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	msg, ok := restored.Get("a.example:1")
+	require.True(t, ok)
+	require.Len(t, msg.Answer, 1)
+	assert.LessOrEqual(t, msg.Answer[0].Header().Ttl, uint32(600))
+
+	// Entry limits of the new cache are honored
+	small := dnsproxy.NewCachedResolver(nil, 1, 60, 3600)
+
+	_, err = small.LoadSnapshot(context.Background(), path)
+	require.NoError(t, err)
+
+	_, total := small.List(0, 10, "", "", "")
+	assert.Equal(t, 1, total)
+
+	_, ok = small.Get("b.example:1")
+	assert.True(t, ok, "most recently used entries survive")
+}
+
+func TestCachedResolver_SnapshotCorruption(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cache.bin")
+
+	_, err := warmCache(t, "a.example.", "b.example.").SaveSnapshot(path)
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	// Damage the last record: the first one is still restored
+	data[len(data)-6] ^= 0xff
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+
+	restored := dnsproxy.NewCachedResolver(nil, 100, 60, 3600)
+
+	n, err := restored.LoadSnapshot(context.Background(), path)
+	require.Error(t, err)
+	assert.Equal(t, 1, n)
+
+	// Snapshots of another format version restore nothing
+	_, err = warmCache(t, "a.example.").SaveSnapshot(path)
+	require.NoError(t, err)
+
+	data, err = os.ReadFile(path)
+	require.NoError(t, err)
+
+	data[len("OWCACHE1")+1]++
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+
+	fresh := dnsproxy.NewCachedResolver(nil, 100, 60, 3600)
+
+	n, err = fresh.LoadSnapshot(context.Background(), path)
+	require.ErrorContains(t, err, "format version")
+	assert.Zero(t, n)
+	assert.Zero(t, fresh.Stats().Entries)
+
+	// Foreign files are rejected and missing files are ignored
+	require.NoError(t, os.WriteFile(path, []byte("garbage"), 0o600))
+
+	_, err = restored.LoadSnapshot(context.Background(), path)
+	require.Error(t, err)
+
+	n, err = restored.LoadSnapshot(context.Background(), filepath.Join(dir, "missing.bin"))
+	require.NoError(t, err)
+	assert.Zero(t, n)
+}
@@ -0,0 +1,80 @@
+package dnsproxy
+
+import (
+	"context"
+	"net"
+
+	"github.com/miekg/dns"
+	"github.com/rs/zerolog"
+
+	"github.com/bavix/outway/internal/acl"
+	"github.com/bavix/outway/internal/config"
+	"github.com/bavix/outway/internal/metrics"
+)
+
+// admit checks the query against the listener ACL and rate limits and, when
+// it is not allowed, answers it as configured. The client is identified by
+// the connection address: ECS is set by the client and cannot be trusted here.
+func (p *Proxy) admit(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) bool {
+	ip := remoteIP(w)
+
+	switch p.guard.Check(ip) {
+	case acl.Allow:
+		return true
+	case acl.Denied:
+		metrics.IncACLRefused()
+		zerolog.Ctx(ctx).Debug().Stringer("client_ip", ip).Msg("query refused by acl")
+
+		writeRcode(w, r, dns.RcodeRefused)
+
+		return false
+	case acl.Limited:
+	}
+
+	action := p.guard.Action()
+	metrics.IncRateLimited(action)
+	zerolog.Ctx(ctx).Debug().Stringer("client_ip", ip).Str("action", action).Msg("query rate limited")
+
+	switch action {
+	case config.RateLimitActionRefuse:
+		writeRcode(w, r, dns.RcodeRefused)
+	case config.RateLimitActionTruncate:
+		// Spoofed sources cannot complete a TCP handshake, real clients retry over it
+		if _, udp := w.RemoteAddr().(*net.UDPAddr); !udp {
+			writeRcode(w, r, dns.RcodeRefused)
+
+			break
+		}
+
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Truncated = true
+		_ = w.WriteMsg(m)
+	}
+
+	return false
+}
+
+func writeRcode(w dns.ResponseWriter, r *dns.Msg, rcode int) {
+	m := new(dns.Msg)
+	m.SetRcode(r, rcode)
+	_ = w.WriteMsg(m)
+}
+
+func remoteIP(w dns.ResponseWriter) net.IP {
+	switch addr := w.RemoteAddr().(type) {
+	case *net.UDPAddr:
+		return addr.IP
+	case *net.TCPAddr:
+		return addr.IP
+	case nil:
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(w.RemoteAddr().String())
+	if err != nil {
+		return nil
+	}
+
+	return net.ParseIP(host)
+}
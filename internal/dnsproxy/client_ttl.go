@@ -0,0 +1,82 @@
+package dnsproxy
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// ClientTTLResolver clamps answer TTLs to the client_ttl_min/client_ttl_max
+// bounds of the matching rule group. It sits below the mark decorator and the
+// cache, so marks and cache entries are derived from the TTL clients get.
+type ClientTTLResolver struct {
+	Next  Resolver
+	Rules *RuleStore
+}
+
+func (c *ClientTTLResolver) Resolve(ctx context.Context, q *dns.Msg) (*dns.Msg, string, error) {
+	out, src, err := c.Next.Resolve(ctx, q)
+	if err != nil || out == nil || len(out.Answer) == 0 || c.Rules == nil || q == nil || len(q.Question) == 0 {
+		return out, src, err
+	}
+
+	rule, ok := c.Rules.Find(strings.ToLower(strings.TrimSuffix(q.Question[0].Name, ".")))
+	if !ok || (rule.ClientTTLMin == 0 && rule.ClientTTLMax == 0) {
+		return out, src, err
+	}
+
+	return clampTTLs(out, rule.ClientTTLMin, rule.ClientTTLMax), src, nil
+}
+
+// clampTTLs returns msg with answer TTLs clamped to [lo, hi]; hi of 0 is unbounded.
+func clampTTLs(msg *dns.Msg, lo, hi uint32) *dns.Msg {
+	var out *dns.Msg
+
+	for i, rr := range msg.Answer {
+		ttl := max(rr.Header().Ttl, lo)
+		if hi > 0 {
+			ttl = min(ttl, hi)
+		}
+
+		if ttl == rr.Header().Ttl {
+			continue
+		}
+
+		if out == nil {
+			out = msg.Copy()
+		}
+
+		out.Answer[i].Header().Ttl = ttl
+	}
+
+	if out == nil {
+		return msg
+	}
+
+	return out
+}
+
+// capTTLs lowers record TTLs to limit, copying only the records it changes:
+// cached records are shared between replies.
+func capTTLs(rrs []dns.RR, limit uint32) []dns.RR {
+	out := rrs
+	copied := false
+
+	for i, rr := range rrs {
+		if rr.Header().Rrtype == dns.TypeOPT || rr.Header().Ttl <= limit {
+			continue
+		}
+
+		if !copied {
+			out = slices.Clone(rrs)
+			copied = true
+		}
+
+		out[i] = dns.Copy(rr)
+		out[i].Header().Ttl = limit
+	}
+
+	return out
+}
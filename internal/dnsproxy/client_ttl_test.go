@@ -0,0 +1,83 @@
+package dnsproxy_test
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bavix/outway/internal/config"
+	"github.com/bavix/outway/internal/dnsproxy"
+)
+
+func ttlResolver(ttls ...uint32) *MockResolver {
+	return &MockResolver{resolveFunc: func(_ context.Context, q *dns.Msg) (*dns.Msg, string, error) {
+		m := new(dns.Msg)
+		m.SetReply(q)
+
+		for _, ttl := range ttls {
+			m.Answer = append(m.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: q.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl},
+				A:   net.ParseIP("192.0.2.1"),
+			})
+		}
+
+		return m, "mock", nil
+	}}
+}
+
+func answerTTLs(msg *dns.Msg) []uint32 {
+	ttls := make([]uint32, 0, len(msg.Answer))
+	for _, rr := range msg.Answer {
+		ttls = append(ttls, rr.Header().Ttl)
+	}
+
+	return ttls
+}
+
+func TestClientTTLResolver(t *testing.T) {
+	t.Parallel()
+
+	rules := dnsproxy.NewRuleStore([]config.Rule{
+		{Pattern: "*.clamp.example", Via: "wg0", ClientTTLMin: 30, ClientTTLMax: 300},
+		{Pattern: "*.floor.example", Via: "wg0", ClientTTLMin: 120},
+		{Pattern: "*.plain.example", Via: "wg0"},
+	})
+
+	r := &dnsproxy.ClientTTLResolver{Next: ttlResolver(5, 60, 3600), Rules: rules}
+
+	ttls := func(name string) []uint32 {
+		q := new(dns.Msg)
+		q.SetQuestion(name, dns.TypeA)
+
+		out, _, err := r.Resolve(context.Background(), q)
+		require.NoError(t, err)
+
+		return answerTTLs(out)
+	}
+
+	assert.Equal(t, []uint32{30, 60, 300}, ttls("www.clamp.example."))
+	assert.Equal(t, []uint32{120, 120, 3600}, ttls("www.floor.example."))
+	assert.Equal(t, []uint32{5, 60, 3600}, ttls("www.plain.example."))
+	assert.Equal(t, []uint32{5, 60, 3600}, ttls("unrouted.example."))
+}
+
+func TestCachedResolver_HitTTLBoundedByEntry(t *testing.T) {
+	t.Parallel()
+
+	cache := dnsproxy.NewCachedResolver(ttlResolver(3600), 100, 60, 120)
+
+	q := new(dns.Msg)
+	q.SetQuestion("hit.example.", dns.TypeA)
+
+	_, _, err := cache.Resolve(context.Background(), q)
+	require.NoError(t, err)
+
+	out, _, err := cache.Resolve(context.Background(), q)
+	require.NoError(t, err)
+	require.Len(t, out.Answer, 1)
+	assert.LessOrEqual(t, out.Answer[0].Header().Ttl, uint32(120), "clients must not outlive the cache entry")
+}
@@ -0,0 +1,137 @@
+package dnsproxy
+
+import (
+	"net"
+	"net/netip"
+	"net/url"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/bavix/outway/internal/dnstap"
+)
+
+// tapWriter reports every response written to a client as CLIENT_RESPONSE,
+// including refusals and failures answered outside the pipeline.
+type tapWriter struct {
+	dns.ResponseWriter
+
+	tap       *dnstap.Tap
+	query     []byte
+	queryTime time.Time
+}
+
+func (w *tapWriter) WriteMsg(m *dns.Msg) error {
+	err := w.ResponseWriter.WriteMsg(m)
+
+	if packed, perr := m.Pack(); perr == nil {
+		w.tap.Send(dnstap.Message{
+			Type:         dnstap.ClientResponse,
+			Protocol:     clientProtocol(w.RemoteAddr()),
+			QueryAddr:    addrPort(w.RemoteAddr()),
+			ResponseAddr: addrPort(w.LocalAddr()),
+			QueryTime:    w.queryTime,
+			ResponseTime: time.Now(),
+			Query:        w.query,
+			Response:     packed,
+		})
+	}
+
+	return err
+}
+
+// tapClient reports r as CLIENT_QUERY and returns a writer reporting the response.
+func (p *Proxy) tapClient(w dns.ResponseWriter, r *dns.Msg) dns.ResponseWriter {
+	if p.tap == nil {
+		return w
+	}
+
+	now := time.Now()
+
+	packed, err := r.Pack()
+	if err != nil {
+		return w
+	}
+
+	p.tap.Send(dnstap.Message{
+		Type:         dnstap.ClientQuery,
+		Protocol:     clientProtocol(w.RemoteAddr()),
+		QueryAddr:    addrPort(w.RemoteAddr()),
+		ResponseAddr: addrPort(w.LocalAddr()),
+		QueryTime:    now,
+		Query:        packed,
+	})
+
+	return &tapWriter{ResponseWriter: w, tap: p.tap, query: packed, queryTime: now}
+}
+
+// tapForwarder reports a message exchanged with the upstream.
+func (u *UpstreamResolver) tapForwarder(typ dnstap.MessageType, q, resp *dns.Msg, queryTime time.Time) {
+	m := dnstap.Message{
+		Type:         typ,
+		Protocol:     upstreamProtocol(u.network),
+		ResponseAddr: upstreamAddrPort(u.address),
+		QueryTime:    queryTime,
+	}
+
+	if packed, err := q.Pack(); err == nil {
+		m.Query = packed
+	}
+
+	if resp != nil {
+		m.ResponseTime = time.Now()
+
+		if packed, err := resp.Pack(); err == nil {
+			m.Response = packed
+		}
+	}
+
+	u.tap.Send(m)
+}
+
+func clientProtocol(addr net.Addr) dnstap.Protocol {
+	if _, ok := addr.(*net.TCPAddr); ok {
+		return dnstap.TCP
+	}
+
+	return dnstap.UDP
+}
+
+func upstreamProtocol(network string) dnstap.Protocol {
+	switch network {
+	case protocolTCP:
+		return dnstap.TCP
+	case protocolDot:
+		return dnstap.DoT
+	case "doh":
+		return dnstap.DoH
+	default:
+		return dnstap.UDP
+	}
+}
+
+func addrPort(addr net.Addr) netip.AddrPort {
+	if addr == nil {
+		return netip.AddrPort{}
+	}
+
+	ap, _ := netip.ParseAddrPort(addr.String())
+
+	return ap
+}
+
+// upstreamAddrPort returns the upstream address when it is a literal IP.
+func upstreamAddrPort(address string) netip.AddrPort {
+	if u, err := url.Parse(address); err == nil && u.Host != "" {
+		port := u.Port()
+		if port == "" {
+			port = "443"
+		}
+
+		address = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	ap, _ := netip.ParseAddrPort(address)
+
+	return ap
+}
@@ -9,5 +9,5 @@ func (DoHStrategy) Supports(t string) bool { return t == "doh" }
 func (DoHStrategy) NewResolver(t, address string, deps StrategyDeps) *UpstreamResolver {
 	exch := func(m *dns.Msg, url string) (*dns.Msg, error) { return deps.ExchangeDoH(m, url) }
 
-	return &UpstreamResolver{network: t, address: address, exchange: exch}
+	return &UpstreamResolver{network: t, address: address, exchange: exch, tap: deps.Tap}
 }
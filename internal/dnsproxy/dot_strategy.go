@@ -40,5 +40,5 @@ func (DotStrategy) NewResolver(t, address string, deps StrategyDeps) *UpstreamRe
 		Timeout: dotTimeout,
 	}
 
-	return &UpstreamResolver{client: client, network: t, address: host}
+	return &UpstreamResolver{client: client, network: t, address: host, tap: deps.Tap}
 }
@@ -0,0 +1,265 @@
+package dnsproxy
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/rs/zerolog"
+
+	"github.com/bavix/outway/internal/config"
+)
+
+type ecsScopeKey struct{}
+
+// ecsScope carries the rule matched for a query down to the upstreams.
+type ecsScope struct {
+	via     string
+	applied bool // a rule group policy already rewrote the query
+}
+
+func withECSScope(ctx context.Context, scope ecsScope) context.Context {
+	return context.WithValue(ctx, ecsScopeKey{}, scope)
+}
+
+func ecsScopeFrom(ctx context.Context) ecsScope {
+	scope, _ := ctx.Value(ecsScopeKey{}).(ecsScope)
+
+	return scope
+}
+
+// ECSRuleResolver applies the client subnet policy of the rule group matching
+// the query. It sits in front of the upstream chain, so the policy wins over
+// those configured on individual upstreams.
+type ECSRuleResolver struct {
+	Next  Resolver
+	Rules *RuleStore
+	// InterfaceAddrs lists addresses of an interface (optional, defaults to the host's).
+	InterfaceAddrs func(name string) ([]net.Addr, error)
+}
+
+func (e *ECSRuleResolver) Resolve(ctx context.Context, q *dns.Msg) (*dns.Msg, string, error) {
+	if q == nil || len(q.Question) == 0 || e.Rules == nil {
+		return e.Next.Resolve(ctx, q)
+	}
+
+	name := strings.ToLower(strings.TrimSuffix(q.Question[0].Name, "."))
+
+	rule, ok := e.Rules.Find(name)
+	if !ok {
+		return e.Next.Resolve(ctx, q)
+	}
+
+	scope := ecsScope{via: rule.Via}
+	if rule.ECS == nil {
+		return e.Next.Resolve(withECSScope(ctx, scope), q)
+	}
+
+	scope.applied = true
+	ctx = withECSScope(ctx, scope)
+
+	return resolveWithECS(ctx, e.Next, q, *rule.ECS, rule.Via, e.InterfaceAddrs)
+}
+
+// ECSResolver applies the client subnet policy of a single upstream unless a
+// rule group policy already did.
+type ECSResolver struct {
+	Next   Resolver
+	Policy config.ECSPolicy
+	// InterfaceAddrs lists addresses of an interface (optional, defaults to the host's).
+	InterfaceAddrs func(name string) ([]net.Addr, error)
+}
+
+func (e *ECSResolver) Resolve(ctx context.Context, q *dns.Msg) (*dns.Msg, string, error) {
+	scope := ecsScopeFrom(ctx)
+	if scope.applied || q == nil {
+		return e.Next.Resolve(ctx, q)
+	}
+
+	return resolveWithECS(ctx, e.Next, q, e.Policy, scope.via, e.InterfaceAddrs)
+}
+
+// resolveWithECS sends q upstream with its ECS option rewritten by policy and
+// restores the client's view of the option in the response.
+func resolveWithECS(
+	ctx context.Context,
+	next Resolver,
+	q *dns.Msg,
+	policy config.ECSPolicy,
+	via string,
+	addrs func(string) ([]net.Addr, error),
+) (*dns.Msg, string, error) {
+	var subnet *net.IPNet
+
+	switch strings.ToLower(policy.Mode) {
+	case "", config.ECSModePassthrough:
+		return next.Resolve(ctx, q)
+	case config.ECSModeFixed:
+		_, subnet, _ = net.ParseCIDR(strings.TrimSpace(policy.Prefix))
+	case config.ECSModeVia:
+		subnet = viaSubnet(via, policy, addrs)
+		if subnet == nil {
+			zerolog.Ctx(ctx).Debug().Str("via", via).Msg("no public address on via interface, stripping ecs")
+		}
+	}
+
+	out, src, err := next.Resolve(ctx, withECS(q, subnet))
+	if out != nil {
+		restoreECS(q, out)
+	}
+
+	return out, src, err
+}
+
+// withECS returns a copy of q carrying subnet as its only ECS option, or no
+// ECS option at all when subnet is nil.
+func withECS(q *dns.Msg, subnet *net.IPNet) *dns.Msg {
+	out := q.Copy()
+
+	opt := out.IsEdns0()
+	if opt == nil {
+		if subnet == nil {
+			return out
+		}
+
+		out.SetEdns0(ednsUDPSize, false)
+		opt = out.IsEdns0()
+	}
+
+	opt.Option = withoutECS(opt.Option)
+
+	if subnet != nil {
+		opt.Option = append(opt.Option, ecsOption(subnet))
+	}
+
+	return out
+}
+
+// restoreECS makes the response mirror the client's EDNS: the subnet we sent
+// is not the client's, so it is reported with scope 0 to clients that sent
+// one and dropped otherwise.
+func restoreECS(q, out *dns.Msg) {
+	opt := out.IsEdns0()
+	if opt == nil {
+		return
+	}
+
+	clientOpt := q.IsEdns0()
+	if clientOpt == nil {
+		extra := out.Extra[:0]
+
+		for _, rr := range out.Extra {
+			if rr.Header().Rrtype != dns.TypeOPT {
+				extra = append(extra, rr)
+			}
+		}
+
+		out.Extra = extra
+
+		return
+	}
+
+	opt.Option = withoutECS(opt.Option)
+
+	for _, o := range clientOpt.Option {
+		if subnet, ok := o.(*dns.EDNS0_SUBNET); ok {
+			echo := *subnet
+			echo.SourceScope = 0
+			opt.Option = append(opt.Option, &echo)
+		}
+	}
+}
+
+func withoutECS(options []dns.EDNS0) []dns.EDNS0 {
+	out := make([]dns.EDNS0, 0, len(options))
+
+	for _, o := range options {
+		if _, ok := o.(*dns.EDNS0_SUBNET); !ok {
+			out = append(out, o)
+		}
+	}
+
+	return out
+}
+
+func ecsOption(subnet *net.IPNet) *dns.EDNS0_SUBNET {
+	ones, _ := subnet.Mask.Size()
+
+	opt := &dns.EDNS0_SUBNET{
+		Code:          dns.EDNS0SUBNET,
+		Family:        ecsFamilyIPv6,
+		SourceNetmask: uint8(ones), //nolint:gosec // prefix length fits in a byte
+		Address:       subnet.IP,
+	}
+
+	if v4 := subnet.IP.To4(); v4 != nil {
+		opt.Family = ecsFamilyIPv4
+		opt.Address = v4
+	}
+
+	return opt
+}
+
+// viaSubnet derives the subnet announced for an interface from its first
+// public address, preferring IPv4.
+func viaSubnet(via string, policy config.ECSPolicy, addrs func(string) ([]net.Addr, error)) *net.IPNet {
+	if via == "" {
+		return nil
+	}
+
+	if addrs == nil {
+		addrs = interfaceAddrs
+	}
+
+	list, err := addrs(via)
+	if err != nil {
+		return nil
+	}
+
+	var v6 net.IP
+
+	for _, a := range list {
+		ipNet, ok := a.(*net.IPNet)
+		if !ok || !ipNet.IP.IsGlobalUnicast() || ipNet.IP.IsPrivate() {
+			continue
+		}
+
+		if v4 := ipNet.IP.To4(); v4 != nil {
+			bits := policy.IPv4Bits
+			if bits == 0 {
+				bits = config.DefaultECSIPv4Bits
+			}
+
+			mask := net.CIDRMask(bits, net.IPv4len*8)
+
+			return &net.IPNet{IP: v4.Mask(mask), Mask: mask}
+		}
+
+		if v6 == nil {
+			v6 = ipNet.IP
+		}
+	}
+
+	if v6 == nil {
+		return nil
+	}
+
+	bits := policy.IPv6Bits
+	if bits == 0 {
+		bits = config.DefaultECSIPv6Bits
+	}
+
+	mask := net.CIDRMask(bits, net.IPv6len*8)
+
+	return &net.IPNet{IP: v6.Mask(mask), Mask: mask}
+}
+
+func interfaceAddrs(name string) ([]net.Addr, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return iface.Addrs()
+}
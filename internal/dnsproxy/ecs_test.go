@@ -0,0 +1,155 @@
+package dnsproxy_test
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bavix/outway/internal/config"
+	"github.com/bavix/outway/internal/dnsproxy"
+)
+
+// ecsEcho records the ECS option it receives and echoes it with a scope.
+func ecsEcho(seen *string) *MockResolver {
+	return &MockResolver{resolveFunc: func(_ context.Context, q *dns.Msg) (*dns.Msg, string, error) {
+		*seen = ""
+
+		m := new(dns.Msg)
+		m.SetReply(q)
+
+		if opt := q.IsEdns0(); opt != nil {
+			m.SetEdns0(opt.UDPSize(), opt.Do())
+
+			for _, o := range opt.Option {
+				if subnet, ok := o.(*dns.EDNS0_SUBNET); ok {
+					*seen = (&net.IPNet{
+						IP:   subnet.Address,
+						Mask: net.CIDRMask(int(subnet.SourceNetmask), len(subnet.Address)*8),
+					}).String()
+
+					echo := *subnet
+					echo.SourceScope = subnet.SourceNetmask
+					m.IsEdns0().Option = append(m.IsEdns0().Option, &echo)
+				}
+			}
+		}
+
+		return m, "mock", nil
+	}}
+}
+
+func ecsQuery(name, subnet string) *dns.Msg {
+	q := new(dns.Msg)
+	q.SetQuestion(name, dns.TypeA)
+
+	if subnet != "" {
+		_, ipNet, _ := net.ParseCIDR(subnet)
+		ones, _ := ipNet.Mask.Size()
+
+		q.SetEdns0(1232, false)
+		q.IsEdns0().Option = append(q.IsEdns0().Option, &dns.EDNS0_SUBNET{
+			Code:          dns.EDNS0SUBNET,
+			Family:        1,
+			SourceNetmask: uint8(ones), //nolint:gosec // test prefix
+			Address:       ipNet.IP.To4(),
+		})
+	}
+
+	return q
+}
+
+func TestECSResolver_UpstreamPolicies(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		policy config.ECSPolicy
+		client string
+		want   string
+	}{
+		{name: "default passthrough", policy: config.ECSPolicy{}, client: "198.51.100.0/24", want: "198.51.100.0/24"},
+		{name: "strip", policy: config.ECSPolicy{Mode: config.ECSModeStrip}, client: "198.51.100.0/24", want: ""},
+		{
+			name:   "fixed replaces client subnet",
+			policy: config.ECSPolicy{Mode: config.ECSModeFixed, Prefix: "203.0.113.0/24"},
+			client: "198.51.100.0/24",
+			want:   "203.0.113.0/24",
+		},
+		{
+			name:   "fixed without client ecs",
+			policy: config.ECSPolicy{Mode: config.ECSModeFixed, Prefix: "203.0.113.0/24"},
+			want:   "203.0.113.0/24",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var seen string
+
+			r := &dnsproxy.ECSResolver{Next: ecsEcho(&seen), Policy: tt.policy}
+
+			q := ecsQuery("cdn.example.", tt.client)
+			out, _, err := r.Resolve(context.Background(), q)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, seen)
+
+			// The client's query is left untouched
+			assert.Equal(t, tt.client != "", q.IsEdns0() != nil)
+
+			if tt.client == "" {
+				assert.Nil(t, out.IsEdns0(), "clients without EDNS get no OPT back")
+
+				return
+			}
+
+			require.NotNil(t, out.IsEdns0())
+			require.Len(t, out.IsEdns0().Option, 1)
+
+			subnet, ok := out.IsEdns0().Option[0].(*dns.EDNS0_SUBNET)
+			require.True(t, ok)
+			assert.Equal(t, "198.51.100.0", subnet.Address.String(), "the client sees its own subnet echoed")
+		})
+	}
+}
+
+func TestECSRuleResolver_GroupPolicyWins(t *testing.T) {
+	t.Parallel()
+
+	var seen string
+
+	addrs := func(name string) ([]net.Addr, error) {
+		require.Equal(t, "wan1", name)
+
+		return []net.Addr{
+			&net.IPNet{IP: net.ParseIP("10.0.0.2"), Mask: net.CIDRMask(24, 32)},
+			&net.IPNet{IP: net.ParseIP("2001:db8:1:2::5"), Mask: net.CIDRMask(64, 128)},
+			&net.IPNet{IP: net.ParseIP("192.0.2.77"), Mask: net.CIDRMask(24, 32)},
+		}, nil
+	}
+
+	upstream := &dnsproxy.ECSResolver{
+		Next:   ecsEcho(&seen),
+		Policy: config.ECSPolicy{Mode: config.ECSModeStrip},
+	}
+
+	rules := dnsproxy.NewRuleStore([]config.Rule{
+		{Pattern: "*.cdn.example", Via: "wan1", ECS: &config.ECSPolicy{Mode: config.ECSModeVia}},
+		{Pattern: "*.plain.example", Via: "wan1"},
+	})
+
+	r := &dnsproxy.ECSRuleResolver{Next: upstream, Rules: rules, InterfaceAddrs: addrs}
+
+	_, _, err := r.Resolve(context.Background(), ecsQuery("img.cdn.example.", "198.51.100.0/24"))
+	require.NoError(t, err)
+	assert.Equal(t, "192.0.2.0/24", seen, "via mode announces the interface's public prefix")
+
+	_, _, err = r.Resolve(context.Background(), ecsQuery("www.plain.example.", "198.51.100.0/24"))
+	require.NoError(t, err)
+	assert.Empty(t, seen, "groups without a policy fall back to the upstream's")
+}
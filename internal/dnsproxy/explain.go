@@ -0,0 +1,230 @@
+package dnsproxy
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/bavix/outway/internal/config"
+	"github.com/bavix/outway/internal/firewall"
+)
+
+// Pipeline stages reported by Explain besides the sources of the stages.
+const (
+	stageLAN      = "lan"
+	stageUpstream = "upstream"
+)
+
+// Mark states reported by Explain.
+const (
+	MarkActive   = "marked"
+	MarkQueued   = "queued"
+	MarkExpired  = "expired"
+	MarkUnmarked = "not marked"
+)
+
+var (
+	errNoResolver = errors.New("resolver not ready")
+	errNoAnswer   = errors.New("no answer")
+)
+
+// Explanation describes how the pipeline answered a query and how its
+// addresses are routed.
+type Explanation struct {
+	Msg *dns.Msg `json:"-"`
+
+	Stage        string          `json:"stage"`
+	Source       string          `json:"source"`
+	CNAMEChain   []string        `json:"cname_chain,omitempty"`
+	BlockGroup   string          `json:"block_group,omitempty"`
+	BlockList    string          `json:"block_list,omitempty"`
+	PolicyZone   string          `json:"policy_zone,omitempty"`
+	PolicyAction string          `json:"policy_action,omitempty"`
+	Route        *RouteExplained `json:"route,omitempty"`
+	Marks        []MarkExplained `json:"marks,omitempty"`
+	Duration     time.Duration   `json:"-"`
+}
+
+// RouteExplained is the rule group routing the answered addresses.
+type RouteExplained struct {
+	Group         string `json:"group,omitempty"` // empty for host overrides
+	Pattern       string `json:"pattern"`
+	Via           string `json:"via"`
+	AddressFamily string `json:"address_family,omitempty"`
+	PinTTL        bool   `json:"pin_ttl"`
+}
+
+// MarkExplained is the mark of one answered address.
+type MarkExplained struct {
+	IP        string `json:"ip"`
+	ClientTTL uint32 `json:"client_ttl"`
+	MarkTTL   uint32 `json:"mark_ttl"` // TTL a fresh mark gets
+	State     string `json:"state"`
+	ExpiresIn int    `json:"expires_in,omitempty"` // seconds left of an active mark
+	// Installed tells whether the backend has the route; nil when it cannot tell
+	Installed *bool `json:"installed,omitempty"`
+}
+
+// Explain resolves q through the active pipeline like a client query and
+// reports the stage that answered, the CNAME chain, the matching rule and the
+// state of each address's mark.
+//
+// Like a client query it may reach an upstream, fill the cache and count in
+// the metrics, and it marks the answered addresses. With dryRun the addresses
+// are not marked and a cache miss is neither stored nor shared with client
+// queries, so the marks reported are the ones in place before the query.
+func (p *Proxy) Explain(ctx context.Context, q *dns.Msg, dryRun bool) (*Explanation, error) {
+	resolver := p.ResolverActive()
+	if resolver == nil {
+		return nil, errNoResolver
+	}
+
+	var trace *QueryTrace
+	if dryRun {
+		ctx, trace = WithDryRunTrace(ctx)
+	} else {
+		ctx, trace = WithQueryTrace(ctx)
+	}
+
+	start := time.Now()
+
+	out, src, err := resolver.Resolve(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	if out == nil {
+		return nil, errNoAnswer
+	}
+
+	e := &Explanation{Msg: out, Source: src, Stage: stageOf(src), Duration: time.Since(start)}
+	e.BlockGroup, e.BlockList = trace.RuleGroup(), trace.BlockList()
+	e.PolicyZone, e.PolicyAction = trace.Policy()
+
+	if len(q.Question) == 0 {
+		return e, nil
+	}
+
+	e.CNAMEChain = cnameChain(q.Question[0].Name, out.Answer)
+
+	mark := p.asyncMarkRes.Load()
+	if mark == nil || mark.Rules == nil || e.BlockGroup != "" || e.PolicyZone != "" {
+		return e, nil
+	}
+
+	name := strings.ToLower(strings.TrimSuffix(q.Question[0].Name, "."))
+
+	rule, ok := mark.route(src, name)
+	if !ok {
+		return e, nil
+	}
+
+	e.Route = &RouteExplained{
+		Group:         rule.Group,
+		Pattern:       rule.Pattern,
+		Via:           rule.Via,
+		AddressFamily: rule.AddressFamily,
+		PinTTL:        rule.PinTTL,
+	}
+
+	checker, _ := p.backend.(firewall.RouteChecker)
+
+	for _, rr := range out.Answer {
+		var ip string
+
+		switch a := rr.(type) {
+		case *dns.A:
+			ip = a.A.String()
+		case *dns.AAAA:
+			ip = a.AAAA.String()
+		default:
+			continue
+		}
+
+		e.Marks = append(e.Marks, explainMark(ctx, mark, checker, rule.Via, ip, rr.Header().Ttl, rule))
+	}
+
+	return e, nil
+}
+
+func explainMark(
+	ctx context.Context, mark *AsyncMarkResolver, checker firewall.RouteChecker,
+	via, ip string, clientTTL uint32, rule config.Rule,
+) MarkExplained {
+	m := MarkExplained{IP: ip, ClientTTL: clientTTL, MarkTTL: mark.markTTL(rule, clientTTL), State: MarkUnmarked}
+
+	expiry, queued := mark.markState(ip, via)
+
+	switch left := time.Until(expiry); {
+	case queued:
+		m.State = MarkQueued
+	case left > 0:
+		m.State, m.ExpiresIn = MarkActive, int(left/time.Second)
+	case !expiry.IsZero():
+		m.State = MarkExpired
+	}
+
+	if checker != nil {
+		if installed, err := checker.HasRoute(ctx, via, ip); err == nil {
+			m.Installed = &installed
+		}
+	}
+
+	return m
+}
+
+// stageOf names the pipeline stage behind a resolve source; upstreams report
+// their address.
+func stageOf(src string) string {
+	switch src {
+	case sourceHosts, sourceCache, sourceBlocklist, sourceRPZ:
+		return src
+	case stageLAN:
+		return stageLAN
+	default:
+		return stageUpstream
+	}
+}
+
+// cnameChain follows CNAME records from name through answers and returns
+// the names visited, or nil when name is not an alias.
+func cnameChain(name string, answers []dns.RR) []string {
+	chain := []string{name}
+
+	for range answers {
+		next := ""
+
+		for _, rr := range answers {
+			if c, ok := rr.(*dns.CNAME); ok && strings.EqualFold(c.Hdr.Name, chain[len(chain)-1]) {
+				next = c.Target
+
+				break
+			}
+		}
+
+		if next == "" || containsFold(chain, next) {
+			break
+		}
+
+		chain = append(chain, next)
+	}
+
+	if len(chain) == 1 {
+		return nil
+	}
+
+	return chain
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,141 @@
+package dnsproxy_test
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bavix/outway/internal/config"
+	"github.com/bavix/outway/internal/dnsproxy"
+	"github.com/bavix/outway/internal/metrics"
+)
+
+const explainConfig = `listen:
+  udp: ":5353"
+  tcp: ":5353"
+upstreams:
+  - name: cloudflare
+    address: 1.1.1.1:53
+rule_groups:
+  - name: lab
+    via: wg0
+    pin_ttl: true
+    patterns: ["*.example.com"]
+hosts:
+  - pattern: alias.example.com
+    cname: box.example.com
+  - pattern: box.example.com
+    a: ["10.0.0.5"]
+    ttl: 60
+  - pattern: plain.example.org
+    a: ["10.0.0.6"]
+`
+
+// routingBackend reports marked addresses as routed.
+type routingBackend struct {
+	recordingBackend
+}
+
+func (b *routingBackend) HasRoute(_ context.Context, iface, ip string) (bool, error) {
+	return slices.Contains(b.Marks(), iface+"/"+ip), nil
+}
+
+func TestProxyExplain(t *testing.T) {
+	metrics.BindService() // the pipeline counts queries; bound before tests run in parallel
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, explainConfig)
+
+	cfg, err := config.Load(path)
+	require.NoError(t, err)
+
+	backend := &routingBackend{}
+	proxy := dnsproxy.New(cfg, backend)
+
+	q := new(dns.Msg)
+	q.SetQuestion("alias.example.com.", dns.TypeA)
+
+	e, err := proxy.Explain(context.Background(), q, false)
+	require.NoError(t, err)
+	assert.Equal(t, "hosts", e.Stage)
+	assert.Equal(t, []string{"alias.example.com.", "box.example.com."}, e.CNAMEChain)
+	require.NotNil(t, e.Route)
+	assert.Equal(t, "lab", e.Route.Group)
+	assert.Equal(t, "*.example.com", e.Route.Pattern)
+	assert.Equal(t, "wg0", e.Route.Via)
+	require.Len(t, e.Marks, 1)
+	assert.Equal(t, "10.0.0.5", e.Marks[0].IP)
+	assert.Equal(t, uint32(60), e.Marks[0].ClientTTL)
+	assert.GreaterOrEqual(t, e.Marks[0].MarkTTL, uint32(60))
+
+	// The query marks its answers like a client query
+	require.Eventually(t, func() bool {
+		e, err = proxy.Explain(context.Background(), q, false)
+
+		return err == nil && len(e.Marks) == 1 && e.Marks[0].State == dnsproxy.MarkActive
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Positive(t, e.Marks[0].ExpiresIn)
+	require.NotNil(t, e.Marks[0].Installed)
+	assert.True(t, *e.Marks[0].Installed)
+
+	marks := proxy.Marks()
+	require.Len(t, marks, 1)
+	assert.Equal(t, "10.0.0.5", marks[0].IP)
+	assert.Equal(t, "wg0", marks[0].Via)
+	assert.Equal(t, []string{"lab"}, marks[0].Groups)
+	assert.Positive(t, marks[0].ExpiresIn)
+
+	q.SetQuestion("plain.example.org.", dns.TypeA)
+
+	e, err = proxy.Explain(context.Background(), q, false)
+	require.NoError(t, err)
+	assert.Empty(t, e.CNAMEChain)
+	assert.Nil(t, e.Route)
+	assert.Empty(t, e.Marks)
+}
+
+func TestProxyExplainDryRun(t *testing.T) {
+	metrics.BindService() // the pipeline counts queries; bound before tests run in parallel
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, explainConfig)
+
+	cfg, err := config.Load(path)
+	require.NoError(t, err)
+
+	backend := &routingBackend{}
+	proxy := dnsproxy.New(cfg, backend)
+
+	q := new(dns.Msg)
+	q.SetQuestion("box.example.com.", dns.TypeA)
+
+	e, err := proxy.Explain(context.Background(), q, true)
+	require.NoError(t, err)
+	require.NotNil(t, e.Route)
+	assert.Equal(t, "wg0", e.Route.Via)
+	require.Len(t, e.Marks, 1)
+	assert.Equal(t, dnsproxy.MarkUnmarked, e.Marks[0].State)
+
+	// Nothing is queued, so the address stays unmarked
+	time.Sleep(50 * time.Millisecond)
+	assert.Empty(t, backend.Marks())
+	assert.Empty(t, proxy.Marks())
+
+	// Nor is the answer cached, so the next client query marks it
+	_, src, err := proxy.ResolverActive().Resolve(context.Background(), q)
+	require.NoError(t, err)
+	assert.NotEqual(t, "cache", src)
+
+	require.Eventually(t, func() bool {
+		return len(backend.Marks()) == 1
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"wg0/10.0.0.5"}, backend.Marks())
+}
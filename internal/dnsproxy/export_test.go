@@ -0,0 +1,26 @@
+package dnsproxy
+
+import (
+	"context"
+
+	"github.com/miekg/dns"
+
+	"github.com/bavix/outway/internal/config"
+)
+
+// HistoryStore exposes the on-disk history store to tests.
+type HistoryStore = historyStore
+
+func OpenHistoryStore(ctx context.Context, cfg config.HistoryConfig) (*HistoryStore, error) {
+	return openHistoryStore(ctx, cfg)
+}
+
+func (s *historyStore) Add(e QueryEvent) { s.add(e) }
+
+func (s *historyStore) Close() { s.close() }
+
+func (s *historyStore) Recent(n int) []QueryEvent { return s.recent(n) }
+
+func (s *historyStore) Each(f HistoryFilter, fn func(QueryEvent) error) error { return s.each(f, fn) }
+
+func (p *Proxy) HandleDNS(ctx context.Context) dns.HandlerFunc { return p.handleDNS(ctx) }
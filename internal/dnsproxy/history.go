@@ -0,0 +1,90 @@
+package dnsproxy
+
+import (
+	"strings"
+	"time"
+)
+
+// HistoryFilter selects query events; zero fields match everything.
+type HistoryFilter struct {
+	Domain    string // case-insensitive substring of the name
+	ClientIP  string
+	QType     uint16
+	Status    string
+	Upstream  string // substring, e.g. an address or "cache"
+	RuleGroup string
+	From      time.Time
+	To        time.Time
+}
+
+// Match reports whether e passes the filter.
+func (f HistoryFilter) Match(e QueryEvent) bool {
+	switch {
+	case f.Domain != "" && !strings.Contains(strings.ToLower(e.Name), strings.ToLower(f.Domain)):
+		return false
+	case f.ClientIP != "" && e.ClientIP != f.ClientIP:
+		return false
+	case f.QType != 0 && e.QType != f.QType:
+		return false
+	case f.Status != "" && !strings.EqualFold(e.Status, f.Status):
+		return false
+	case f.Upstream != "" && !strings.Contains(e.Upstream, f.Upstream):
+		return false
+	case f.RuleGroup != "" && e.RuleGroup != f.RuleGroup:
+		return false
+	case !f.From.IsZero() && e.Time.Before(f.From):
+		return false
+	case !f.To.IsZero() && e.Time.After(f.To):
+		return false
+	default:
+		return true
+	}
+}
+
+// historyPage collects one page of matching events while counting all of them.
+type historyPage struct {
+	offset, limit int
+	total         int
+	events        []QueryEvent
+}
+
+func (p *historyPage) add(e QueryEvent) {
+	if p.total >= p.offset && len(p.events) < p.limit {
+		p.events = append(p.events, e)
+	}
+
+	p.total++
+}
+
+// QueryHistory returns matching events newest first, with the number of all matches.
+// Events come from the persistent store when one is configured.
+func (p *Proxy) QueryHistory(f HistoryFilter, offset, limit int) ([]QueryEvent, int) {
+	page := &historyPage{offset: max(offset, 0), limit: limit, events: []QueryEvent{}}
+
+	_ = p.EachHistory(f, func(e QueryEvent) error {
+		page.add(e)
+
+		return nil
+	})
+
+	return page.events, page.total
+}
+
+// EachHistory calls fn for matching events newest first until fn fails.
+func (p *Proxy) EachHistory(f HistoryFilter, fn func(QueryEvent) error) error {
+	if p.historyStore != nil {
+		return p.historyStore.each(f, fn)
+	}
+
+	var err error
+
+	p.history.EachEvent(func(e QueryEvent) bool {
+		if f.Match(e) {
+			err = fn(e)
+		}
+
+		return err == nil
+	})
+
+	return err
+}
@@ -0,0 +1,448 @@
+package dnsproxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/bavix/outway/internal/config"
+)
+
+const (
+	historySegmentMinEntries = 1000
+	historySegmentsPerLimit  = 8
+	historyQueueSize         = 4096
+	historyFlushInterval     = time.Second
+	historySweepInterval     = time.Hour
+	historyFilePrefix        = "history-"
+	historyFileSuffix        = ".ndjson"
+	historySegmentTimeFormat = "20060102T150405.000000000"
+	historyDirPerm           = 0o750
+	historyFilePerm          = 0o640
+)
+
+// historySegment is one append-only NDJSON file of the history store.
+type historySegment struct {
+	path  string
+	count int
+	last  time.Time
+	size  int64
+}
+
+// historyStore keeps query history on disk in NDJSON segments under a
+// directory. Segments roll over every few thousand events; the oldest ones are
+// removed once the rest still holds MaxEntries events or once they are past
+// the retention. Events are written from a single goroutine and dropped
+// rather than slowing down resolution.
+type historyStore struct {
+	dir            string
+	maxEntries     int
+	retention      time.Duration
+	segmentEntries int
+	flushEvery     time.Duration
+	log            *zerolog.Logger
+
+	mu       sync.Mutex
+	segments []*historySegment // oldest first; the last one is being written
+	file     *os.File
+	w        *bufio.Writer
+
+	events    chan QueryEvent
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// openHistoryStore opens the store in cfg.Path. It returns nil when history
+// is kept in memory only.
+func openHistoryStore(ctx context.Context, cfg config.HistoryConfig) (*historyStore, error) {
+	if cfg.Path == "" {
+		return nil, nil //nolint:nilnil // persistence disabled
+	}
+
+	if err := os.MkdirAll(cfg.Path, historyDirPerm); err != nil {
+		return nil, fmt.Errorf("create history directory: %w", err)
+	}
+
+	s := &historyStore{
+		dir:            cfg.Path,
+		maxEntries:     max(cfg.MaxEntries, 1),
+		retention:      time.Duration(cfg.Retention) * time.Hour,
+		segmentEntries: max(cfg.MaxEntries/historySegmentsPerLimit, historySegmentMinEntries),
+		flushEvery:     historyFlushInterval,
+		log:            zerolog.Ctx(ctx),
+		events:         make(chan QueryEvent, historyQueueSize),
+		done:           make(chan struct{}),
+	}
+
+	if cfg.FlushInterval > 0 {
+		s.flushEvery = time.Duration(cfg.FlushInterval) * time.Millisecond
+	}
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	err := s.rollLocked()
+	s.mu.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	s.wg.Go(s.run)
+
+	return s, nil
+}
+
+// load indexes the segments left by previous runs.
+func (s *historyStore) load() error {
+	paths, err := filepath.Glob(filepath.Join(s.dir, historyFilePrefix+"*"+historyFileSuffix))
+	if err != nil {
+		return fmt.Errorf("list history segments: %w", err)
+	}
+
+	slices.Sort(paths)
+
+	for _, path := range paths {
+		seg := &historySegment{path: path}
+
+		size, err := readHistorySegment(path, -1, func(e QueryEvent) {
+			seg.count++
+			seg.last = e.Time
+		})
+		if err != nil {
+			// A damaged segment loses its events, not the whole history
+			s.log.Warn().Err(err).Str("path", path).Msg("skipping unreadable history segment")
+
+			continue
+		}
+
+		seg.size = size
+		s.segments = append(s.segments, seg)
+	}
+
+	return nil
+}
+
+// add queues e for writing.
+func (s *historyStore) add(e QueryEvent) {
+	if s == nil {
+		return
+	}
+
+	select {
+	case <-s.done:
+	case s.events <- e:
+	default:
+	}
+}
+
+// close writes queued events and closes the current segment.
+func (s *historyStore) close() {
+	if s == nil {
+		return
+	}
+
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.wg.Wait()
+	})
+}
+
+func (s *historyStore) run() {
+	flush := time.NewTicker(s.flushEvery)
+	defer flush.Stop()
+
+	sweep := time.NewTicker(historySweepInterval)
+	defer sweep.Stop()
+
+	for {
+		select {
+		case e := <-s.events:
+			s.write(e)
+		case <-flush.C:
+			s.mu.Lock()
+			s.flushLocked()
+			s.mu.Unlock()
+		case <-sweep.C:
+			s.mu.Lock()
+			s.compactLocked()
+			s.mu.Unlock()
+		case <-s.done:
+			for len(s.events) > 0 {
+				s.write(<-s.events)
+			}
+
+			s.mu.Lock()
+			if err := s.closeSegmentLocked(); err != nil {
+				s.log.Warn().Err(err).Msg("failed to close history segment")
+			}
+			s.mu.Unlock()
+
+			return
+		}
+	}
+}
+
+func (s *historyStore) write(e QueryEvent) {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Without a segment, e.g. after the disk filled up, each event retries
+	if s.w == nil {
+		if err := s.rollLocked(); err != nil {
+			return
+		}
+	}
+
+	n, err := s.w.Write(append(line, '\n'))
+	if err != nil {
+		// Errors of a bufio.Writer stick, so the segment is given up
+		s.log.Warn().Err(err).Msg("failed to write query history")
+		s.rollLoggedLocked()
+
+		return
+	}
+
+	seg := s.segments[len(s.segments)-1]
+	seg.count++
+	seg.size += int64(n)
+	seg.last = e.Time
+
+	if seg.count >= s.segmentEntries {
+		s.rollLoggedLocked()
+	}
+}
+
+// flushLocked writes buffered events and starts a new segment when the
+// current one fails.
+func (s *historyStore) flushLocked() {
+	if s.w == nil {
+		return
+	}
+
+	if err := s.w.Flush(); err != nil {
+		s.log.Warn().Err(err).Msg("failed to write query history")
+		s.rollLoggedLocked()
+	}
+}
+
+// rollLoggedLocked is rollLocked for writers that cannot return the error.
+func (s *historyStore) rollLoggedLocked() {
+	if err := s.rollLocked(); err != nil {
+		s.log.Warn().Err(err).Msg("failed to start history segment")
+	}
+}
+
+// closeSegmentLocked flushes and closes the current segment, if any. The
+// segment is dropped from writing even when that fails.
+func (s *historyStore) closeSegmentLocked() error {
+	if s.file == nil {
+		return nil
+	}
+
+	err := errors.Join(s.w.Flush(), s.file.Close())
+	s.file, s.w = nil, nil
+
+	if err != nil {
+		return fmt.Errorf("close history segment: %w", err)
+	}
+
+	return nil
+}
+
+// rollLocked closes the current segment and starts a new one. Events written
+// before a failed close stay in the old segment as far as they reached it.
+func (s *historyStore) rollLocked() error {
+	if err := s.closeSegmentLocked(); err != nil {
+		s.log.Warn().Err(err).Msg("failed to close history segment")
+	}
+
+	name := historyFilePrefix + time.Now().UTC().Format(historySegmentTimeFormat) + historyFileSuffix
+	path := filepath.Join(s.dir, name)
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, historyFilePerm) //nolint:gosec // configured directory
+	if err != nil {
+		return fmt.Errorf("open history segment: %w", err)
+	}
+
+	s.file = f
+	s.w = bufio.NewWriter(f)
+	s.segments = append(s.segments, &historySegment{path: path})
+	s.compactLocked()
+
+	return nil
+}
+
+// compactLocked removes old segments, never the one being written.
+func (s *historyStore) compactLocked() {
+	total := 0
+	for _, seg := range s.segments {
+		total += seg.count
+	}
+
+	cutoff := time.Now().Add(-s.retention)
+
+	for len(s.segments) > 1 {
+		oldest := s.segments[0]
+
+		expired := s.retention > 0 && oldest.last.Before(cutoff)
+		if oldest.count > 0 && !expired && total-oldest.count < s.maxEntries {
+			break
+		}
+
+		if err := os.Remove(oldest.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			s.log.Warn().Err(err).Str("path", oldest.path).Msg("failed to remove history segment")
+
+			break
+		}
+
+		total -= oldest.count
+		s.segments = s.segments[1:]
+	}
+}
+
+// each calls fn for matching events newest first, within MaxEntries and the
+// retention, until fn fails.
+func (s *historyStore) each(f HistoryFilter, fn func(QueryEvent) error) error {
+	s.mu.Lock()
+	s.flushLocked()
+
+	segments := make([]historySegment, 0, len(s.segments))
+	for _, seg := range s.segments {
+		segments = append(segments, *seg)
+	}
+
+	s.mu.Unlock()
+
+	var cutoff time.Time
+	if s.retention > 0 {
+		cutoff = time.Now().Add(-s.retention)
+	}
+
+	type match struct {
+		pos int
+		e   QueryEvent
+	}
+
+	scanned := 0
+
+	for _, seg := range slices.Backward(segments) {
+		// Only the flushed part of a segment is read; it may be removed meanwhile.
+		// Segments are read oldest first, so only matches are kept to walk back.
+		var (
+			matches []match
+			n       int
+		)
+
+		_, err := readHistorySegment(seg.path, seg.size, func(e QueryEvent) {
+			if f.Match(e) {
+				matches = append(matches, match{pos: n, e: e})
+			}
+
+			n++
+		})
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		for _, m := range slices.Backward(matches) {
+			if scanned+n-m.pos > s.maxEntries || m.e.Time.Before(cutoff) {
+				return nil
+			}
+
+			if err := fn(m.e); err != nil {
+				return err
+			}
+		}
+
+		scanned += n
+		if scanned >= s.maxEntries {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// recent returns up to n newest events, oldest first.
+func (s *historyStore) recent(n int) []QueryEvent {
+	if s == nil || n <= 0 {
+		return nil
+	}
+
+	events := make([]QueryEvent, 0, n)
+
+	_ = s.each(HistoryFilter{}, func(e QueryEvent) error {
+		if len(events) == n {
+			return io.EOF
+		}
+
+		events = append(events, e)
+
+		return nil
+	})
+
+	slices.Reverse(events)
+
+	return events
+}
+
+// readHistorySegment streams up to limit bytes of a segment (all when
+// negative) to fn and returns the bytes read up to the last complete line. A
+// partially written last line and lines that do not decode are skipped.
+func readHistorySegment(path string, limit int64, fn func(QueryEvent)) (int64, error) {
+	f, err := os.Open(path) //nolint:gosec // segment of the configured directory
+	if err != nil {
+		return 0, fmt.Errorf("open history segment: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	var r io.Reader = f
+	if limit >= 0 {
+		r = io.LimitReader(f, limit)
+	}
+
+	br := bufio.NewReader(r)
+
+	var size int64
+
+	for {
+		line, err := br.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			return size, nil
+		}
+
+		if err != nil {
+			return size, fmt.Errorf("read history segment: %w", err)
+		}
+
+		size += int64(len(line))
+
+		var e QueryEvent
+		if json.Unmarshal(line, &e) == nil {
+			fn(e)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package dnsproxy_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bavix/outway/internal/config"
+	"github.com/bavix/outway/internal/dnsproxy"
+)
+
+func TestHistoryStoreSkipsUnreadableSegments(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	cfg := config.HistoryConfig{Path: dir, MaxEntries: 100}
+
+	store, err := dnsproxy.OpenHistoryStore(context.Background(), cfg)
+	require.NoError(t, err)
+
+	store.Add(dnsproxy.QueryEvent{Name: "a.example.", Time: time.Now()})
+	store.Close()
+
+	// A directory in place of a segment cannot be read
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "history-00000000T000000.000000000.ndjson"), 0o750))
+
+	store, err = dnsproxy.OpenHistoryStore(context.Background(), cfg)
+	require.NoError(t, err)
+	t.Cleanup(store.Close)
+
+	events := store.Recent(10)
+	require.Len(t, events, 1)
+	assert.Equal(t, "a.example.", events[0].Name)
+}
+
+// historyEvents adds n events a second apart, the last one at end.
+func historyEvents(store *dnsproxy.HistoryStore, n int, end time.Time) {
+	for i := range n {
+		store.Add(dnsproxy.QueryEvent{
+			Name:     fmt.Sprintf("host%d.example.", i),
+			Status:   "ok",
+			Time:     end.Add(time.Duration(i-n+1) * time.Second),
+			ClientIP: "192.168.1." + strconv.Itoa(i%2+1),
+		})
+	}
+}
+
+func TestHistoryStorePersistsAcrossRestart(t *testing.T) {
+	t.Parallel()
+
+	cfg := config.HistoryConfig{Path: t.TempDir(), MaxEntries: 100}
+
+	store, err := dnsproxy.OpenHistoryStore(context.Background(), cfg)
+	require.NoError(t, err)
+
+	historyEvents(store, 5, time.Now())
+	store.Close()
+
+	store, err = dnsproxy.OpenHistoryStore(context.Background(), cfg)
+	require.NoError(t, err)
+
+	t.Cleanup(store.Close)
+
+	historyEvents(store, 1, time.Now().Add(time.Second))
+
+	var events []dnsproxy.QueryEvent
+
+	require.Eventually(t, func() bool {
+		events = store.Recent(10)
+
+		return len(events) == 6
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "host0.example.", events[0].Name, "oldest first")
+	assert.Equal(t, "host4.example.", events[4].Name)
+	assert.Equal(t, "host0.example.", events[5].Name, "the event of the second run is the newest")
+}
+
+func TestHistoryStoreCompaction(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	cfg := config.HistoryConfig{Path: dir, MaxEntries: 1000}
+
+	store, err := dnsproxy.OpenHistoryStore(context.Background(), cfg)
+	require.NoError(t, err)
+
+	historyEvents(store, 3500, time.Now())
+	store.Close()
+
+	// Segments of 1000 events are dropped while the rest still holds MaxEntries
+	segments, err := filepath.Glob(filepath.Join(dir, "history-*.ndjson"))
+	require.NoError(t, err)
+	assert.Len(t, segments, 2)
+
+	store, err = dnsproxy.OpenHistoryStore(context.Background(), cfg)
+	require.NoError(t, err)
+	t.Cleanup(store.Close)
+
+	var names []string
+
+	require.NoError(t, store.Each(dnsproxy.HistoryFilter{}, func(e dnsproxy.QueryEvent) error {
+		names = append(names, e.Name)
+
+		return nil
+	}))
+
+	require.Len(t, names, 1000, "reads stop at MaxEntries")
+	assert.Equal(t, "host3499.example.", names[0])
+	assert.Equal(t, "host2500.example.", names[999])
+}
+
+func TestHistoryStoreRetention(t *testing.T) {
+	t.Parallel()
+
+	cfg := config.HistoryConfig{Path: t.TempDir(), MaxEntries: 100, Retention: 1}
+
+	store, err := dnsproxy.OpenHistoryStore(context.Background(), cfg)
+	require.NoError(t, err)
+	t.Cleanup(store.Close)
+
+	historyEvents(store, 2, time.Now().Add(-2*time.Hour))
+	historyEvents(store, 3, time.Now())
+
+	require.Eventually(t, func() bool { return len(store.Recent(10)) == 3 }, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestHistoryStoreExport(t *testing.T) {
+	t.Parallel()
+
+	cfg := config.HistoryConfig{Path: t.TempDir(), MaxEntries: 100}
+
+	store, err := dnsproxy.OpenHistoryStore(context.Background(), cfg)
+	require.NoError(t, err)
+	t.Cleanup(store.Close)
+
+	historyEvents(store, 10, time.Now())
+
+	filter := dnsproxy.HistoryFilter{ClientIP: "192.168.1.2"}
+
+	var exported []string
+
+	export := func(e dnsproxy.QueryEvent) error {
+		if len(exported) == 3 {
+			return io.EOF // an aborted download
+		}
+
+		exported = append(exported, e.Name)
+
+		return nil
+	}
+
+	require.Eventually(t, func() bool {
+		exported = nil
+
+		return errors.Is(store.Each(filter, export), io.EOF)
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"host9.example.", "host7.example.", "host5.example."}, exported)
+}
@@ -0,0 +1,54 @@
+package dnsproxy_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bavix/outway/internal/dnsproxy"
+)
+
+func TestHistoryFilterMatch(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	e := dnsproxy.QueryEvent{
+		Name:      "WWW.Example.com",
+		QType:     dns.TypeAAAA,
+		Upstream:  "udp:8.8.8.8:53",
+		Status:    "ok",
+		Time:      now,
+		ClientIP:  "192.168.1.10",
+		RuleGroup: "vpn",
+	}
+
+	tests := []struct {
+		name   string
+		filter dnsproxy.HistoryFilter
+		want   bool
+	}{
+		{name: "empty", filter: dnsproxy.HistoryFilter{}, want: true},
+		{name: "domain substring", filter: dnsproxy.HistoryFilter{Domain: "example"}, want: true},
+		{name: "domain case", filter: dnsproxy.HistoryFilter{Domain: "www.EXAMPLE"}, want: true},
+		{name: "other domain", filter: dnsproxy.HistoryFilter{Domain: "example.org"}, want: false},
+		{name: "client", filter: dnsproxy.HistoryFilter{ClientIP: "192.168.1.10"}, want: true},
+		{name: "other client", filter: dnsproxy.HistoryFilter{ClientIP: "192.168.1.1"}, want: false},
+		{name: "qtype", filter: dnsproxy.HistoryFilter{QType: dns.TypeA}, want: false},
+		{name: "status", filter: dnsproxy.HistoryFilter{Status: "OK"}, want: true},
+		{name: "upstream", filter: dnsproxy.HistoryFilter{Upstream: "8.8.8.8"}, want: true},
+		{name: "rule group", filter: dnsproxy.HistoryFilter{RuleGroup: "lan"}, want: false},
+		{name: "in range", filter: dnsproxy.HistoryFilter{From: now.Add(-time.Hour), To: now.Add(time.Hour)}, want: true},
+		{name: "before range", filter: dnsproxy.HistoryFilter{From: now.Add(time.Minute)}, want: false},
+		{name: "after range", filter: dnsproxy.HistoryFilter{To: now.Add(-time.Minute)}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tt.want, tt.filter.Match(e))
+		})
+	}
+}
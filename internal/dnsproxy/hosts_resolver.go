@@ -9,10 +9,17 @@ import (
 	"github.com/rs/zerolog"
 
 	"github.com/bavix/outway/internal/config"
+	"github.com/bavix/outway/internal/lanresolver"
 )
 
 const (
 	defaultHostsTTL = 60
+	sourceHosts     = "hosts"
+
+	// maxCNAMEChase bounds alias chains between host overrides.
+	maxCNAMEChase = 8
+	// maxTXTChunk is the maximum length of a single TXT character-string.
+	maxTXTChunk = 255
 )
 
 // HostsResolver answers from static hosts list; falls through to Next if no match.
@@ -25,90 +32,271 @@ type HostsResolver struct {
 	Cfg          *config.Config
 }
 
-func (h *HostsResolver) Resolve(ctx context.Context, q *dns.Msg) (*dns.Msg, string, error) { //nolint:gocognit,cyclop,funlen
+func (h *HostsResolver) Resolve(ctx context.Context, q *dns.Msg) (*dns.Msg, string, error) {
+	return h.resolve(ctx, q, 0)
+}
+
+func (h *HostsResolver) resolve(ctx context.Context, q *dns.Msg, depth int) (*dns.Msg, string, error) {
 	if q == nil || len(q.Question) == 0 {
 		return h.Next.Resolve(ctx, q)
 	}
 
-	name := strings.ToLower(strings.TrimSpace(strings.TrimSuffix(q.Question[0].Name, ".")))
-	qtype := q.Question[0].Qtype
+	question := q.Question[0]
+	name := strings.ToLower(strings.TrimSpace(strings.TrimSuffix(question.Name, ".")))
+	hosts := h.hosts()
 
-	// Get hosts dynamically from manager if available, otherwise use static hosts
-	var hosts []config.HostOverride
-	if h.HostsManager != nil {
-		hosts = h.HostsManager.GetHosts()
-	} else {
-		hosts = h.Hosts
+	if question.Qtype == dns.TypePTR {
+		return h.resolvePTR(ctx, q, hosts)
 	}
 
-	var (
-		aRecords    []net.IP
-		aaaaRecords []net.IP
-	)
-
-	ttl := uint32(defaultHostsTTL)
-
-	for _, ho := range hosts {
-		if matchDomainPattern(ho.Pattern, name) {
-			for _, s := range ho.A {
-				if ip := net.ParseIP(s); ip != nil {
-					aRecords = append(aRecords, ip)
-				}
-			}
+	ho, ok := findHostOverride(hosts, name)
+	if !ok || !hasHostRecords(ho) {
+		return h.Next.Resolve(ctx, q)
+	}
 
-			for _, s := range ho.AAAA {
-				if ip := net.ParseIP(s); ip != nil {
-					aaaaRecords = append(aaaaRecords, ip)
-				}
-			}
+	ttl := h.clampTTL(ho.TTL)
 
-			if ho.TTL > 0 {
-				ttl = ho.TTL
-			}
+	m := new(dns.Msg)
+	m.SetReply(q)
+	m.Authoritative = true
 
-			break
-		}
+	if ho.CNAME != "" {
+		return h.resolveCNAME(ctx, q, m, ho, ttl, depth)
 	}
 
-	if len(aRecords) == 0 && len(aaaaRecords) == 0 {
-		return h.Next.Resolve(ctx, q)
-	}
+	m.Answer = hostRecords(ho, question.Name, question.Qtype, ttl)
 
 	// Log hosts match at debug level
 	zerolog.Ctx(ctx).Debug().
 		Str("query", name).
-		Uint16("qtype", qtype).
-		Int("a_records", len(aRecords)).
-		Int("aaaa_records", len(aaaaRecords)).
+		Uint16("qtype", question.Qtype).
+		Int("answers", len(m.Answer)).
 		Uint32("ttl", ttl).
 		Msg("hosts override matched")
 
-	// Clamp TTL to configured cache bounds if available
-	if h.Cfg != nil && h.Cfg.Cache.Enabled {
-		ttl = uint32(max(h.Cfg.Cache.MinTTLSeconds, min(int(ttl), h.Cfg.Cache.MaxTTLSeconds))) //nolint:gosec // TTL bounds validated in config
+	return m, sourceHosts, nil
+}
+
+// resolveCNAME answers with the alias and chases the target through the
+// hosts list first and the rest of the pipeline afterwards.
+func (h *HostsResolver) resolveCNAME(
+	ctx context.Context, q, m *dns.Msg, ho config.HostOverride, ttl uint32, depth int,
+) (*dns.Msg, string, error) {
+	question := q.Question[0]
+	target := dns.Fqdn(strings.ToLower(strings.TrimSpace(ho.CNAME)))
+
+	m.Answer = append(m.Answer, &dns.CNAME{
+		Hdr:    dns.RR_Header{Name: question.Name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: ttl},
+		Target: target,
+	})
+
+	zerolog.Ctx(ctx).Debug().
+		Str("query", question.Name).
+		Str("target", target).
+		Int("depth", depth).
+		Msg("hosts cname override matched")
+
+	if question.Qtype == dns.TypeCNAME || depth >= maxCNAMEChase {
+		return m, sourceHosts, nil
+	}
+
+	chase := new(dns.Msg)
+	chase.SetQuestion(target, question.Qtype)
+	chase.RecursionDesired = q.RecursionDesired
+
+	resp, _, err := h.resolve(ctx, chase, depth+1)
+	if err != nil {
+		return nil, sourceHosts, err
+	}
+
+	if resp != nil {
+		m.Answer = append(m.Answer, resp.Answer...)
+		m.Rcode = resp.Rcode
+	}
+
+	return m, sourceHosts, nil
+}
+
+// resolvePTR answers reverse queries for addresses of non-wildcard overrides.
+func (h *HostsResolver) resolvePTR(ctx context.Context, q *dns.Msg, hosts []config.HostOverride) (*dns.Msg, string, error) {
+	question := q.Question[0]
+
+	ip := lanresolver.IPFromReverseName(question.Name)
+	if ip == nil {
+		return h.Next.Resolve(ctx, q)
+	}
+
+	names := hostNamesForIP(hosts, ip)
+	if len(names) == 0 {
+		return h.Next.Resolve(ctx, q)
 	}
 
 	m := new(dns.Msg)
 	m.SetReply(q)
 	m.Authoritative = true
 
-	if qtype == dns.TypeA || qtype == dns.TypeANY {
-		for _, ip := range aRecords {
+	for _, name := range names {
+		m.Answer = append(m.Answer, &dns.PTR{
+			Hdr: dns.RR_Header{Name: question.Name, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: h.clampTTL(name.ttl)},
+			Ptr: name.target,
+		})
+	}
+
+	return m, sourceHosts, nil
+}
+
+type hostName struct {
+	target string
+	ttl    uint32
+}
+
+// hostNamesForIP returns names of non-wildcard overrides that hold ip.
+func hostNamesForIP(hosts []config.HostOverride, ip net.IP) []hostName {
+	var out []hostName
+
+	seen := make(map[string]struct{})
+
+	for _, ho := range hosts {
+		if ho.IsWildcard() || !overrideHasIP(ho, ip) {
+			continue
+		}
+
+		target := dns.Fqdn(strings.ToLower(strings.TrimSpace(ho.Pattern)))
+		if _, ok := seen[target]; ok {
+			continue
+		}
+
+		seen[target] = struct{}{}
+		out = append(out, hostName{target: target, ttl: ho.TTL})
+	}
+
+	return out
+}
+
+func (h *HostsResolver) hosts() []config.HostOverride {
+	// Get hosts dynamically from manager if available, otherwise use static hosts
+	if h.HostsManager != nil {
+		return h.HostsManager.GetHosts()
+	}
+
+	return h.Hosts
+}
+
+func (h *HostsResolver) clampTTL(ttl uint32) uint32 {
+	if ttl == 0 {
+		ttl = defaultHostsTTL
+	}
+
+	// Clamp TTL to configured cache bounds if available
+	if h.Cfg != nil && h.Cfg.Cache.Enabled {
+		ttl = uint32(max(h.Cfg.Cache.MinTTLSeconds, min(int(ttl), h.Cfg.Cache.MaxTTLSeconds))) //nolint:gosec // TTL bounds validated in config
+	}
+
+	return ttl
+}
+
+// findHostOverride returns the first override whose pattern matches name.
+func findHostOverride(hosts []config.HostOverride, name string) (config.HostOverride, bool) {
+	for _, ho := range hosts {
+		if matchDomainPattern(ho.Pattern, name) {
+			return ho, true
+		}
+	}
+
+	return config.HostOverride{}, false
+}
+
+func hasHostRecords(ho config.HostOverride) bool {
+	return ho.CNAME != "" || len(ho.TXT) > 0 || len(ho.MX) > 0 || len(ho.SRV) > 0 ||
+		len(parseIPs(ho.A)) > 0 || len(parseIPs(ho.AAAA)) > 0
+}
+
+func overrideHasIP(ho config.HostOverride, ip net.IP) bool {
+	for _, list := range [][]string{ho.A, ho.AAAA} {
+		for _, addr := range parseIPs(list) {
+			if addr.Equal(ip) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func parseIPs(list []string) []net.IP {
+	out := make([]net.IP, 0, len(list))
+
+	for _, s := range list {
+		if ip := net.ParseIP(strings.TrimSpace(s)); ip != nil {
+			out = append(out, ip)
+		}
+	}
+
+	return out
+}
+
+// hostRecords builds answers of the requested type (all types for ANY).
+//
+//nolint:cyclop,funlen // one branch per record type
+func hostRecords(ho config.HostOverride, owner string, qtype uint16, ttl uint32) []dns.RR {
+	var out []dns.RR
+
+	want := func(t uint16) bool { return qtype == t || qtype == dns.TypeANY }
+	hdr := func(t uint16) dns.RR_Header {
+		return dns.RR_Header{Name: owner, Rrtype: t, Class: dns.ClassINET, Ttl: ttl}
+	}
+
+	if want(dns.TypeA) {
+		for _, ip := range parseIPs(ho.A) {
 			if v4 := ip.To4(); v4 != nil {
-				rr := &dns.A{Hdr: dns.RR_Header{Name: q.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl}, A: v4}
-				m.Answer = append(m.Answer, rr)
+				out = append(out, &dns.A{Hdr: hdr(dns.TypeA), A: v4})
 			}
 		}
 	}
 
-	if qtype == dns.TypeAAAA || qtype == dns.TypeANY {
-		for _, ip := range aaaaRecords {
+	if want(dns.TypeAAAA) {
+		for _, ip := range parseIPs(ho.AAAA) {
 			if v6 := ip.To16(); v6 != nil && ip.To4() == nil {
-				rr := &dns.AAAA{Hdr: dns.RR_Header{Name: q.Question[0].Name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: ttl}, AAAA: v6}
-				m.Answer = append(m.Answer, rr)
+				out = append(out, &dns.AAAA{Hdr: hdr(dns.TypeAAAA), AAAA: v6})
 			}
 		}
 	}
 
-	return m, "hosts", nil
+	if want(dns.TypeTXT) {
+		for _, txt := range ho.TXT {
+			out = append(out, &dns.TXT{Hdr: hdr(dns.TypeTXT), Txt: splitTXT(txt)})
+		}
+	}
+
+	if want(dns.TypeMX) {
+		for _, mx := range ho.MX {
+			out = append(out, &dns.MX{Hdr: hdr(dns.TypeMX), Preference: mx.Preference, Mx: dns.Fqdn(mx.Host)})
+		}
+	}
+
+	if want(dns.TypeSRV) {
+		for _, srv := range ho.SRV {
+			out = append(out, &dns.SRV{
+				Hdr:      hdr(dns.TypeSRV),
+				Priority: srv.Priority,
+				Weight:   srv.Weight,
+				Port:     srv.Port,
+				Target:   dns.Fqdn(srv.Target),
+			})
+		}
+	}
+
+	return out
+}
+
+// splitTXT splits a long value into 255-byte character-strings.
+func splitTXT(s string) []string {
+	var out []string
+
+	for len(s) > maxTXTChunk {
+		out = append(out, s[:maxTXTChunk])
+		s = s[maxTXTChunk:]
+	}
+
+	return append(out, s)
 }
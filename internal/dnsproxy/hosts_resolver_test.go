@@ -3,7 +3,11 @@ package dnsproxy_test
 import (
 	"context"
 	"net"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/miekg/dns"
 	"github.com/stretchr/testify/assert"
@@ -225,6 +229,141 @@ func TestHostsResolver_WildcardPattern(t *testing.T) {
 	assert.Len(t, msg.Answer, 1)
 }
 
+func TestHostsResolver_RecordTypes(t *testing.T) {
+	t.Parallel()
+
+	resolver := &dnsproxy.HostsResolver{
+		Next: &MockResolver{},
+		Hosts: []config.HostOverride{{
+			Pattern: "mail.lan",
+			TXT:     []string{"v=spf1 -all", strings.Repeat("x", 300)},
+			MX:      []config.MXRecord{{Preference: 10, Host: "mx.lan"}},
+			SRV:     []config.SRVRecord{{Priority: 1, Weight: 5, Port: 5060, Target: "sip.lan"}},
+		}},
+	}
+
+	query := func(qtype uint16) *dns.Msg {
+		q := new(dns.Msg)
+		q.SetQuestion("mail.lan.", qtype)
+
+		msg, src, err := resolver.Resolve(context.Background(), q)
+		require.NoError(t, err)
+		assert.Equal(t, "hosts", src)
+
+		return msg
+	}
+
+	msg := query(dns.TypeTXT)
+	require.Len(t, msg.Answer, 2)
+	assert.Equal(t, []string{"v=spf1 -all"}, msg.Answer[0].(*dns.TXT).Txt)
+	assert.Len(t, msg.Answer[1].(*dns.TXT).Txt, 2, "long TXT split into 255-byte strings")
+
+	msg = query(dns.TypeMX)
+	require.Len(t, msg.Answer, 1)
+	assert.Equal(t, "mx.lan.", msg.Answer[0].(*dns.MX).Mx)
+	assert.Equal(t, uint16(10), msg.Answer[0].(*dns.MX).Preference)
+
+	msg = query(dns.TypeSRV)
+	require.Len(t, msg.Answer, 1)
+	assert.Equal(t, uint16(5060), msg.Answer[0].(*dns.SRV).Port)
+	assert.Equal(t, "sip.lan.", msg.Answer[0].(*dns.SRV).Target)
+
+	msg = query(dns.TypeA)
+	assert.Equal(t, dns.RcodeSuccess, msg.Rcode)
+	assert.Empty(t, msg.Answer, "overridden name answers NODATA for other types")
+
+	assert.Len(t, query(dns.TypeANY).Answer, 4)
+}
+
+func TestHostsResolver_CNAMEChase(t *testing.T) {
+	t.Parallel()
+
+	next := &MockResolver{resolveFunc: func(_ context.Context, q *dns.Msg) (*dns.Msg, string, error) {
+		m := new(dns.Msg)
+		m.SetReply(q)
+		m.Answer = append(m.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: q.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 30},
+			A:   net.ParseIP("203.0.113.7"),
+		})
+
+		return m, "upstream", nil
+	}}
+
+	resolver := &dnsproxy.HostsResolver{
+		Next: next,
+		Hosts: []config.HostOverride{
+			{Pattern: "alias.lan", CNAME: "box.lan"},
+			{Pattern: "box.lan", A: []string{"10.0.0.5"}},
+			{Pattern: "cdn.lan", CNAME: "cdn.example.net"},
+			{Pattern: "loop-a.lan", CNAME: "loop-b.lan"},
+			{Pattern: "loop-b.lan", CNAME: "loop-a.lan"},
+		},
+	}
+
+	q := new(dns.Msg)
+	q.SetQuestion("alias.lan.", dns.TypeA)
+
+	msg, src, err := resolver.Resolve(context.Background(), q)
+	require.NoError(t, err)
+	assert.Equal(t, "hosts", src)
+	require.Len(t, msg.Answer, 2)
+	assert.Equal(t, "box.lan.", msg.Answer[0].(*dns.CNAME).Target)
+	assert.Equal(t, "10.0.0.5", msg.Answer[1].(*dns.A).A.String())
+
+	q.SetQuestion("cdn.lan.", dns.TypeA)
+
+	msg, _, err = resolver.Resolve(context.Background(), q)
+	require.NoError(t, err)
+	require.Len(t, msg.Answer, 2)
+	assert.Equal(t, "cdn.example.net.", msg.Answer[1].Header().Name, "target resolved by next resolver")
+
+	q.SetQuestion("loop-a.lan.", dns.TypeA)
+
+	msg, _, err = resolver.Resolve(context.Background(), q)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, len(msg.Answer), 9, "alias loops are bounded")
+}
+
+func TestHostsResolver_PTR(t *testing.T) {
+	t.Parallel()
+
+	resolver := &dnsproxy.HostsResolver{
+		Next: &MockResolver{},
+		Hosts: []config.HostOverride{
+			{Pattern: "*.wild.lan", A: []string{"10.0.0.9"}},
+			{Pattern: "nas.lan", A: []string{"10.0.0.9"}, AAAA: []string{"fd00::9"}},
+		},
+	}
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "9.0.0.10.in-addr.arpa.", want: "nas.lan."},
+		{name: "9.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.d.f.ip6.arpa.", want: "nas.lan."},
+		{name: "8.0.0.10.in-addr.arpa."},
+		{name: "0.10.in-addr.arpa."},
+	}
+
+	for _, tt := range tests {
+		q := new(dns.Msg)
+		q.SetQuestion(tt.name, dns.TypePTR)
+
+		msg, src, err := resolver.Resolve(context.Background(), q)
+		require.NoError(t, err)
+
+		if tt.want == "" {
+			assert.Equal(t, "mock", src, tt.name)
+
+			continue
+		}
+
+		assert.Equal(t, "hosts", src, tt.name)
+		require.Len(t, msg.Answer, 1, tt.name)
+		assert.Equal(t, tt.want, msg.Answer[0].(*dns.PTR).Ptr)
+	}
+}
+
 // mockHostsManager is a simple mock implementation of HostsManager for testing.
 type mockHostsManager struct {
 	hosts []config.HostOverride
@@ -258,3 +397,56 @@ func (m *mockHostsManager) UpdateHosts(hosts []config.HostOverride) {
 	m.hosts = make([]config.HostOverride, len(hosts))
 	copy(m.hosts, hosts)
 }
+
+type recordingBackend struct {
+	mu    sync.Mutex
+	marks []string
+}
+
+func (b *recordingBackend) Name() string { return "recording" }
+
+func (b *recordingBackend) MarkIP(_ context.Context, iface, ip string, _ int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.marks = append(b.marks, iface+"/"+ip)
+
+	return nil
+}
+
+func (b *recordingBackend) CleanupAll(context.Context) error { return nil }
+
+func (b *recordingBackend) Marks() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return slices.Clone(b.marks)
+}
+
+func TestHostsResolver_ViaMarksAddresses(t *testing.T) {
+	t.Parallel()
+
+	hostsManager := &mockHostsManager{hosts: []config.HostOverride{
+		{Pattern: "vpn.lan", A: []string{"10.8.0.1"}, Via: "wg0"},
+		{Pattern: "plain.lan", A: []string{"10.0.0.1"}},
+	}}
+	cfg := &config.Config{}
+	backend := &recordingBackend{}
+
+	hosts := hostsManager.CreateHostsResolver(&MockResolver{}, cfg)
+	mark := dnsproxy.NewAsyncMarkResolver(hosts, backend, dnsproxy.NewRuleStore(nil), cfg)
+	mark.Hosts = hostsManager
+
+	t.Cleanup(mark.Stop)
+
+	for _, name := range []string{"vpn.lan.", "plain.lan."} {
+		q := new(dns.Msg)
+		q.SetQuestion(name, dns.TypeA)
+
+		_, _, err := mark.Resolve(context.Background(), q)
+		require.NoError(t, err)
+	}
+
+	require.Eventually(t, func() bool { return len(backend.Marks()) > 0 }, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"wg0/10.8.0.1"}, backend.Marks())
+}
@@ -52,6 +52,8 @@ type HistoryManager interface {
 	GetHistoryPaginated(offset, limit int) []QueryEvent
 	// GetHistorySize returns the total number of history events
 	GetHistorySize() int
+	// EachEvent calls fn for events newest first until it returns false
+	EachEvent(fn func(QueryEvent) bool)
 	// ClearHistory clears all history
 	ClearHistory()
 }
@@ -64,14 +66,18 @@ type RulesManager interface {
 	UpdateRules(rules *RuleStore)
 	// GetRuleGroups returns current rule groups
 	GetRuleGroups() []config.RuleGroup
+	// UpdateRuleGroups replaces rule groups
+	UpdateRuleGroups(groups []config.RuleGroup)
 }
 
 // ConfigManager defines the interface for managing configuration.
 type ConfigManager interface {
 	// GetConfig returns current configuration
 	GetConfig() *config.Config
-	// SaveConfig saves configuration to disk
-	SaveConfig() error
+	// SaveConfig saves configuration to disk as made by the author of ctx
+	SaveConfig(ctx context.Context) error
 	// UpdateConfig updates configuration atomically
 	UpdateConfig(updater func(*config.Config)) error
+	// ApplyConfig updates configuration atomically without saving it
+	ApplyConfig(updater func(*config.Config))
 }
@@ -3,6 +3,7 @@ package dnsproxy
 import (
 	"context"
 	"fmt"
+	"slices"
 	"sync"
 
 	"github.com/rs/zerolog"
@@ -135,18 +136,23 @@ func (hm *hostsManager) UpdateHostsInPlace(hosts []config.HostOverride) error {
 	defer hm.mu.Unlock()
 
 	// Validate all hosts before updating (critical for preventing invalid state)
-	for i, host := range hosts {
-		if err := host.Validate(); err != nil {
-			return fmt.Errorf("invalid host override #%d: %w", i+1, err)
-		}
+	if err := validateHosts(hosts); err != nil {
+		return err
 	}
 
-	// Update hosts atomically
+	// Update hosts atomically; callers update the config through ConfigManager
 	hm.hosts = make([]config.HostOverride, len(hosts))
 	copy(hm.hosts, hosts)
 
-	// Update config
-	hm.cfg.Hosts = hm.hosts
+	return nil
+}
+
+func validateHosts(hosts []config.HostOverride) error {
+	for i, host := range hosts {
+		if err := host.Validate(); err != nil {
+			return fmt.Errorf("invalid host override #%d: %w", i+1, err)
+		}
+	}
 
 	return nil
 }
@@ -272,6 +278,24 @@ func (hm *historyManager) GetHistorySize() int {
 	return hm.size
 }
 
+func (hm *historyManager) EachEvent(fn func(QueryEvent) bool) {
+	// Iterate over a copy: fn may be slow (e.g. an HTTP export) and must not block AddEvent
+	hm.mu.RLock()
+
+	events := make([]QueryEvent, 0, hm.size)
+	for i := 1; i <= hm.size; i++ {
+		events = append(events, hm.events[(hm.head-i+hm.capacity)%hm.capacity])
+	}
+
+	hm.mu.RUnlock()
+
+	for _, e := range events {
+		if !fn(e) {
+			return
+		}
+	}
+}
+
 func (hm *historyManager) ClearHistory() {
 	hm.mu.Lock()
 	defer hm.mu.Unlock()
@@ -319,6 +343,13 @@ func (rm *rulesManager) GetRuleGroups() []config.RuleGroup {
 	return result
 }
 
+func (rm *rulesManager) UpdateRuleGroups(groups []config.RuleGroup) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	rm.groups = slices.Clone(groups)
+}
+
 // configManager is a thread-safe implementation of ConfigManager.
 type configManager struct {
 	mu  sync.RWMutex
@@ -338,16 +369,16 @@ func (cm *configManager) GetConfig() *config.Config {
 	return cm.cfg
 }
 
-func (cm *configManager) SaveConfig() error {
+func (cm *configManager) SaveConfig(ctx context.Context) error {
 	cm.mu.RLock()
 	defer cm.mu.RUnlock()
 
-	zerolog.Ctx(context.Background()).Debug().
+	zerolog.Ctx(ctx).Debug().
 		Str("config_path", cm.cfg.Path).
 		Msg("saving configuration to disk")
 
-	if err := cm.cfg.Save(); err != nil {
-		zerolog.Ctx(context.Background()).Error().
+	if err := cm.cfg.SaveContext(ctx); err != nil {
+		zerolog.Ctx(ctx).Error().
 			Err(err).
 			Str("config_path", cm.cfg.Path).
 			Msg("failed to save configuration")
@@ -355,7 +386,7 @@ func (cm *configManager) SaveConfig() error {
 		return err
 	}
 
-	zerolog.Ctx(context.Background()).Debug().
+	zerolog.Ctx(ctx).Debug().
 		Str("config_path", cm.cfg.Path).
 		Msg("configuration saved successfully")
 
@@ -370,3 +401,12 @@ func (cm *configManager) UpdateConfig(updater func(*config.Config)) error {
 
 	return cm.cfg.Save()
 }
+
+// ApplyConfig runs updater under the write lock so saves never see a
+// half-updated configuration.
+func (cm *configManager) ApplyConfig(updater func(*config.Config)) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	updater(cm.cfg)
+}
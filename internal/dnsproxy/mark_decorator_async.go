@@ -1,7 +1,11 @@
 package dnsproxy
 
 import (
+	"cmp"
 	"context"
+	"maps"
+	"net/netip"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -18,8 +22,31 @@ import (
 type markRequest struct {
 	ip        string
 	iface     string
+	group     string // rule group routing the address, empty for host overrides
 	ttl       int
+	clientTTL uint32 // TTL handed to the client; the mark must outlive it
 	timestamp time.Time
+	refresh   bool // re-mark even if the address is still marked
+}
+
+type markRefreshKey struct{}
+
+// withMarkRefresh makes marks queued under ctx bypass the marked-IP cache,
+// so a prefetched answer extends routes before they lapse.
+func withMarkRefresh(ctx context.Context) context.Context {
+	return context.WithValue(ctx, markRefreshKey{}, true)
+}
+
+func isMarkRefresh(ctx context.Context) bool {
+	v, _ := ctx.Value(markRefreshKey{}).(bool)
+
+	return v
+}
+
+// markCovers reports whether a mark expiring at expiry outlives a record
+// handed to a client at now with clientTTL.
+func markCovers(expiry, now time.Time, clientTTL uint32) bool {
+	return now.Before(expiry.Add(-cacheExpiryBuffer)) && !expiry.Before(now.Add(time.Duration(clientTTL)*time.Second))
 }
 
 // AsyncMarkResolver performs IP marking asynchronously with debounce and caching.
@@ -28,11 +55,14 @@ type AsyncMarkResolver struct {
 	Backend firewall.Backend
 	Rules   *RuleStore
 	Cfg     *config.Config
+	// Hosts provides host overrides whose via routes statically answered addresses (optional).
+	Hosts HostsManager
 
 	// Async marking state
 	mu            sync.RWMutex
-	pendingMarks  map[string]*markRequest // "ip:iface" -> request
-	markedIPs     map[string]time.Time    // "ip:iface" -> expiry time
+	pendingMarks  map[string]*markRequest        // "ip:iface" -> request
+	markedIPs     map[string]time.Time           // "ip:iface" -> expiry time
+	markGroups    map[string]map[string]struct{} // "ip:iface" -> rule groups routing it
 	debounceTimer *time.Timer
 	debounceDelay time.Duration
 	workerRunning bool
@@ -56,6 +86,7 @@ func NewAsyncMarkResolver(next Resolver, backend firewall.Backend, rules *RuleSt
 		Cfg:           cfg,
 		pendingMarks:  make(map[string]*markRequest),
 		markedIPs:     make(map[string]time.Time),
+		markGroups:    make(map[string]map[string]struct{}),
 		debounceDelay: defaultDebounceDelay,
 		workerStop:    make(chan struct{}),
 	}
@@ -75,81 +106,266 @@ func (m *AsyncMarkResolver) Resolve(ctx context.Context, q *dns.Msg) (*dns.Msg,
 
 	name := strings.ToLower(strings.TrimSpace(strings.TrimSuffix(q.Question[0].Name, ".")))
 
-	rule, ok := m.Rules.Find(name)
+	rule, ok := m.route(src, name)
 	if !ok {
 		return out, src, err
 	}
 
+	trace := QueryTraceFromContext(ctx)
+	if trace.DryRun() {
+		trace.SetRoute(rule.Group, rule.Via, nil)
+
+		return out, src, err
+	}
+
 	// Queue IPs for async marking (non-blocking)
-	m.queueMarks(ctx, out.Answer, rule, name)
+	marked := m.queueMarks(ctx, out.Answer, rule, name)
+	trace.SetRoute(rule.Group, rule.Via, marked)
 
 	return out, src, err
 }
 
+// route returns the rule routing the answers for name: a host override with
+// via set for answers it produced, else the matching rule group.
+func (m *AsyncMarkResolver) route(src, name string) (config.Rule, bool) {
+	if rule, ok := m.hostRoute(src, name); ok {
+		return rule, true
+	}
+
+	return m.Rules.Find(name)
+}
+
+// markTTL returns how long an address handed to a client with clientTTL is
+// marked for rule.
+func (m *AsyncMarkResolver) markTTL(rule config.Rule, clientTTL uint32) uint32 {
+	// The mark never expires before the client forgets the record
+	ttl := minTTL(clientTTL)
+	if rule.PinTTL {
+		ttl = max(uint32(m.Cfg.GetMinMarkTTL(clientTTL).Seconds()), clientTTL)
+	}
+
+	// Cache hits are answered for at least the cache's minimum TTL without re-marking
+	if m.Cfg != nil && m.Cfg.Cache.Enabled && m.Cfg.Cache.MinTTLSeconds > 0 {
+		ttl = max(ttl, uint32(m.Cfg.Cache.MinTTLSeconds)) //nolint:gosec // TTL bounds validated in config
+	}
+
+	return ttl
+}
+
+// markState returns when the mark of ip via iface expires, and whether it is
+// still queued instead.
+func (m *AsyncMarkResolver) markState(ip, iface string) (time.Time, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	key := ip + ":" + iface
+	_, queued := m.pendingMarks[key]
+
+	return m.markedIPs[key], queued
+}
+
+// MarkedIP is an address marked for routing through an interface.
+type MarkedIP struct {
+	IP        string    `json:"ip"`
+	Via       string    `json:"via"`
+	Groups    []string  `json:"groups,omitempty"` // empty for host overrides
+	Expires   time.Time `json:"expires"`
+	ExpiresIn int       `json:"expires_in"` // seconds
+}
+
+// Marks returns the unexpired marks ordered by interface and address.
+func (m *AsyncMarkResolver) Marks() []MarkedIP {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := time.Now()
+	out := make([]MarkedIP, 0, len(m.markedIPs))
+
+	for key, expiry := range m.markedIPs {
+		i := strings.LastIndex(key, ":")
+		if i <= 0 || !expiry.After(now) {
+			continue
+		}
+
+		out = append(out, MarkedIP{
+			IP:        key[:i],
+			Via:       key[i+1:],
+			Groups:    slices.Sorted(maps.Keys(m.markGroups[key])),
+			Expires:   expiry,
+			ExpiresIn: int(expiry.Sub(now) / time.Second),
+		})
+	}
+
+	slices.SortFunc(out, func(a, b MarkedIP) int {
+		ipA, _ := netip.ParseAddr(a.IP)
+		ipB, _ := netip.ParseAddr(b.IP)
+
+		return cmp.Or(cmp.Compare(a.Via, b.Via), ipA.Compare(ipB))
+	})
+
+	return out
+}
+
+// hostRoute returns a rule for answers produced by a host override with via set.
+func (m *AsyncMarkResolver) hostRoute(src, name string) (config.Rule, bool) {
+	if src != sourceHosts || m.Hosts == nil {
+		return config.Rule{}, false
+	}
+
+	ho, ok := findHostOverride(m.Hosts.GetHosts(), name)
+	if !ok || ho.Via == "" {
+		return config.Rule{}, false
+	}
+
+	return config.Rule{Pattern: ho.Pattern, Via: ho.Via, PinTTL: true}, true
+}
+
 // Stop gracefully stops the AsyncMarkResolver.
 func (m *AsyncMarkResolver) Stop() {
 	m.mu.Lock()
-	defer m.mu.Unlock()
 
 	if !m.workerRunning {
+		m.mu.Unlock()
+
 		return
 	}
 
 	m.workerRunning = false
 	close(m.workerStop)
-	m.workerWg.Wait()
 
-	// Process any remaining marks before stopping
 	if m.debounceTimer != nil {
 		m.debounceTimer.Stop()
 	}
 
+	// Release the lock before waiting: the worker and the flush below both take it
+	m.mu.Unlock()
+
+	m.workerWg.Wait()
+
+	// Process any remaining marks before stopping
 	// Use background context for cleanup operation
 	m.processPendingMarks(context.Background())
 }
 
-// queueMarks queues IP addresses for async marking.
+// inheritMarks takes over the marked addresses of the resolver being
+// replaced, so a pipeline rebuild does not mark them again.
+func (m *AsyncMarkResolver) inheritMarks(prev *AsyncMarkResolver) {
+	prev.mu.RLock()
+	marked := maps.Clone(prev.markedIPs)
+	groups := maps.Clone(prev.markGroups)
+	prev.mu.RUnlock()
+
+	m.mu.Lock()
+	maps.Copy(m.markedIPs, marked)
+	maps.Copy(m.markGroups, groups)
+	m.mu.Unlock()
+}
+
+// unmarkGroups drops the marks of addresses that were routed only by rule
+// groups for which active reports false, so traffic leaves the interface
+// before the marks expire. Marks made for host overrides are kept.
+func (m *AsyncMarkResolver) unmarkGroups(ctx context.Context, active func(group string) bool) {
+	type mark struct{ ip, iface string }
+
+	var stale []mark
+
+	m.mu.Lock()
+
+	for key, groups := range m.markGroups {
+		for group := range groups {
+			if !active(group) {
+				delete(groups, group)
+			}
+		}
+
+		if len(groups) > 0 {
+			continue
+		}
+
+		delete(m.markGroups, key)
+		delete(m.markedIPs, key)
+		delete(m.pendingMarks, key)
+
+		// Keys are "ip:iface"; IPv6 addresses contain colons themselves
+		if i := strings.LastIndex(key, ":"); i > 0 {
+			stale = append(stale, mark{ip: key[:i], iface: key[i+1:]})
+		}
+	}
+
+	m.mu.Unlock()
+
+	unmarker, ok := m.Backend.(firewall.Unmarker)
+	if !ok || len(stale) == 0 {
+		return
+	}
+
+	logger := zerolog.Ctx(ctx)
+
+	for _, s := range stale {
+		if err := unmarker.UnmarkIP(ctx, s.iface, s.ip); err != nil {
+			logger.Warn().Err(err).Str("ip", s.ip).Str("iface", s.iface).Msg("failed to unmark IP")
+		}
+	}
+
+	logger.Info().Int("addresses", len(stale)).Msg("unmarked addresses of inactive rule groups")
+}
+
+// trackGroup records that group routes the address of key.
+func (m *AsyncMarkResolver) trackGroup(key, group string) {
+	if group == "" {
+		return
+	}
+
+	if m.markGroups[key] == nil {
+		m.markGroups[key] = make(map[string]struct{})
+	}
+
+	m.markGroups[key][group] = struct{}{}
+}
+
+// queueMarks queues IP addresses for async marking and returns the addresses
+// routed through the rule's interface, queued or already marked.
 //
 //nolint:funlen // complex IP extraction and queuing logic
-func (m *AsyncMarkResolver) queueMarks(ctx context.Context, answers []dns.RR, rule config.Rule, domain string) {
+func (m *AsyncMarkResolver) queueMarks(ctx context.Context, answers []dns.RR, rule config.Rule, domain string) []string {
 	now := time.Now()
+	refresh := isMarkRefresh(ctx)
+
+	var marked []string
 
 	for _, rr := range answers {
-		var (
-			ip  string
-			ttl uint32
-		)
+		var ip string
 
 		switch a := rr.(type) {
 		case *dns.A:
 			ip = a.A.String()
-
-			ttl = a.Hdr.Ttl
-			if rule.PinTTL {
-				ttl = uint32(m.Cfg.GetMinMarkTTL(ttl).Seconds())
-			} else {
-				ttl = minTTL(ttl)
-			}
 		case *dns.AAAA:
 			ip = a.AAAA.String()
-
-			ttl = a.Hdr.Ttl
-			if rule.PinTTL {
-				ttl = uint32(m.Cfg.GetMinMarkTTL(ttl).Seconds())
-			} else {
-				ttl = minTTL(ttl)
-			}
 		default:
 			continue
 		}
 
-		// Check cache first - skip if already marked and not expired
+		marked = append(marked, ip)
+
+		clientTTL := rr.Header().Ttl
+		ttl := m.markTTL(rule, clientTTL)
+
+		// Check cache first - skip if already marked long enough
 		cacheKey := ip + ":" + rule.Via
 
 		m.mu.RLock()
 
-		if expiry, exists := m.markedIPs[cacheKey]; exists && now.Before(expiry.Add(-cacheExpiryBuffer)) {
+		if expiry, exists := m.markedIPs[cacheKey]; exists && !refresh && markCovers(expiry, now, clientTTL) {
+			_, tracked := m.markGroups[cacheKey][rule.Group]
 			m.mu.RUnlock()
+
+			// Another group may share the address; it keeps the mark alive
+			if !tracked && rule.Group != "" {
+				m.mu.Lock()
+				m.trackGroup(cacheKey, rule.Group)
+				m.mu.Unlock()
+			}
+
 			zerolog.Ctx(ctx).Debug().
 				Str("domain", domain).
 				Str("ip", ip).
@@ -163,13 +379,25 @@ func (m *AsyncMarkResolver) queueMarks(ctx context.Context, answers []dns.RR, ru
 
 		// Queue for async marking
 		m.mu.Lock()
-		m.pendingMarks[cacheKey] = &markRequest{
+		req := &markRequest{
 			ip:        ip,
 			iface:     rule.Via,
+			group:     rule.Group,
 			ttl:       int(ttl),
+			clientTTL: clientTTL,
 			timestamp: now,
+			refresh:   refresh,
+		}
+
+		// Merge with a pending request so no client's answer is left uncovered
+		if prev, queued := m.pendingMarks[cacheKey]; queued {
+			req.ttl = max(req.ttl, prev.ttl)
+			req.clientTTL = max(req.clientTTL, prev.clientTTL)
+			req.refresh = req.refresh || prev.refresh
 		}
 
+		m.pendingMarks[cacheKey] = req
+
 		// Reset debounce timer
 		if m.debounceTimer != nil {
 			m.debounceTimer.Stop()
@@ -189,6 +417,8 @@ func (m *AsyncMarkResolver) queueMarks(ctx context.Context, answers []dns.RR, ru
 			Int("ttl", int(ttl)).
 			Msg("IP queued for async marking")
 	}
+
+	return marked
 }
 
 // processPendingMarks processes all pending mark requests in batch.
@@ -230,7 +460,7 @@ func (m *AsyncMarkResolver) markIPsBatch(ctx context.Context, marks []*markReque
 		// Double-check cache (another goroutine might have marked it)
 		m.mu.RLock()
 
-		if expiry, exists := m.markedIPs[cacheKey]; exists && now.Before(expiry.Add(-cacheExpiryBuffer)) {
+		if expiry, exists := m.markedIPs[cacheKey]; exists && !req.refresh && markCovers(expiry, now, req.clientTTL) {
 			m.mu.RUnlock()
 			logger.Debug().
 				Str("ip", req.ip).
@@ -250,7 +480,9 @@ func (m *AsyncMarkResolver) markIPsBatch(ctx context.Context, marks []*markReque
 				Str("iface", req.iface).
 				Int("ttl", req.ttl).
 				Msg("failed to mark IP")
-			metrics.M.DNSMarksError.Inc()
+			if metrics.M.DNSMarksError != nil {
+				metrics.M.DNSMarksError.Inc()
+			}
 
 			errorCount++
 		} else {
@@ -259,6 +491,7 @@ func (m *AsyncMarkResolver) markIPsBatch(ctx context.Context, marks []*markReque
 
 			m.mu.Lock()
 			m.markedIPs[cacheKey] = expiry
+			m.trackGroup(cacheKey, req.group)
 			m.mu.Unlock()
 
 			logger.Debug().
@@ -266,7 +499,9 @@ func (m *AsyncMarkResolver) markIPsBatch(ctx context.Context, marks []*markReque
 				Str("iface", req.iface).
 				Int("ttl", req.ttl).
 				Msg("IP marked successfully")
-			metrics.M.DNSMarksSuccess.Inc()
+			if metrics.M.DNSMarksSuccess != nil {
+				metrics.M.DNSMarksSuccess.Inc()
+			}
 
 			successCount++
 		}
@@ -319,6 +554,7 @@ func (m *AsyncMarkResolver) cleanupCache() {
 	for key, expiry := range m.markedIPs {
 		if now.After(expiry) {
 			delete(m.markedIPs, key)
+			delete(m.markGroups, key)
 
 			removed++
 		}
@@ -0,0 +1,55 @@
+package dnsproxy_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bavix/outway/internal/config"
+	"github.com/bavix/outway/internal/dnsproxy"
+)
+
+func TestAsyncMarkResolverStopFlushesPendingMarks(t *testing.T) {
+	t.Parallel()
+
+	hostsManager := &mockHostsManager{hosts: []config.HostOverride{
+		{Pattern: "vpn.lan", A: []string{"10.8.0.1"}, Via: "wg0"},
+	}}
+	cfg := &config.Config{}
+	backend := &recordingBackend{}
+
+	mark := dnsproxy.NewAsyncMarkResolver(hostsManager.CreateHostsResolver(&MockResolver{}, cfg), backend, dnsproxy.NewRuleStore(nil), cfg)
+	mark.Hosts = hostsManager
+
+	q := new(dns.Msg)
+	q.SetQuestion("vpn.lan.", dns.TypeA)
+
+	_, _, err := mark.Resolve(context.Background(), q)
+	require.NoError(t, err)
+
+	// The mark is still waiting for its batch: Stop must flush it rather
+	// than wait for the worker while holding the lock the flush needs
+	stopped := make(chan struct{})
+
+	go func() {
+		mark.Stop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		require.FailNow(t, "Stop did not return")
+	}
+
+	// Counted without bound metrics as well
+	require.Eventually(t, func() bool { return len(backend.Marks()) > 0 }, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"wg0/10.8.0.1"}, backend.Marks())
+
+	// Stopping again is a no-op
+	mark.Stop()
+}
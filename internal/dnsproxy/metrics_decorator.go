@@ -29,7 +29,7 @@ func (m *MetricsResolver) Resolve(ctx context.Context, q *dns.Msg) (*dns.Msg, st
 	metrics.M.RequestDuration.Observe(durSec)
 	metrics.ObserveRequestDurationUpstream(src, durSec)
 
-	if err != nil {
+	if err != nil && !errors.Is(err, ErrQueryDropped) {
 		metrics.IncResolveError(src)
 	}
 
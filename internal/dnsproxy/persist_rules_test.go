@@ -0,0 +1,119 @@
+package dnsproxy_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bavix/outway/internal/config"
+	"github.com/bavix/outway/internal/dnsproxy"
+)
+
+const persistRulesConfig = `listen:
+  udp: ":5353"
+  tcp: ":5353"
+upstreams:
+  - name: cloudflare
+    address: 1.1.1.1:53
+rule_groups:
+  - name: video
+    description: Streaming
+    via: wg0
+    pin_ttl: true
+    patterns: ["*.youtube.com", "*.netflix.com"]
+  - name: ads
+    action: block
+    patterns: ["ads.example.com"]
+  - name: work
+    via: wg1
+    address_family: ipv4_only
+    client_ttl_max: 300
+    patterns: ["*.corp.example"]
+`
+
+func TestProxyPersistRulesRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, persistRulesConfig)
+
+	cfg, err := config.Load(path)
+	require.NoError(t, err)
+
+	want := cfg.RuleGroups
+
+	proxy := dnsproxy.New(cfg, &MockFirewallBackend{})
+	require.NoError(t, proxy.PersistRules(context.Background()))
+
+	saved, err := config.Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, want, saved.RuleGroups)
+}
+
+func TestProxyPersistRulesKeepsGroups(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, persistRulesConfig)
+
+	cfg, err := config.Load(path)
+	require.NoError(t, err)
+
+	proxy := dnsproxy.New(cfg, &MockFirewallBackend{})
+
+	proxy.Rules().Delete("*.netflix.com")
+	proxy.Rules().Upsert(config.Rule{Group: "work", Pattern: "*.vpn.example", Via: "wg1", AddressFamily: "ipv4_only", ClientTTLMax: 300})
+	proxy.Rules().Upsert(config.Rule{Group: "games", Pattern: "*.steam.com", Via: "wg2", PinTTL: true})
+
+	require.NoError(t, proxy.PersistRules(context.Background()))
+
+	saved, err := config.Load(path)
+	require.NoError(t, err)
+	require.Len(t, saved.RuleGroups, 4)
+
+	video := saved.RuleGroups[0]
+	assert.Equal(t, "video", video.Name)
+	assert.Equal(t, "Streaming", video.Description)
+	assert.Equal(t, "wg0", video.Via)
+	assert.True(t, video.PinTTL)
+	assert.Equal(t, []string{"*.youtube.com"}, video.Patterns)
+
+	assert.Equal(t, "ads", saved.RuleGroups[1].Name)
+	assert.True(t, saved.RuleGroups[1].IsBlock())
+
+	work := saved.RuleGroups[2]
+	assert.Equal(t, "wg1", work.Via)
+	assert.Equal(t, "ipv4_only", work.AddressFamily)
+	assert.Equal(t, 300, work.ClientTTLMax)
+	assert.Equal(t, []string{"*.corp.example", "*.vpn.example"}, work.Patterns)
+
+	assert.Equal(t, config.RuleGroup{Name: "games", Via: "wg2", PinTTL: true, Patterns: []string{"*.steam.com"}}, saved.RuleGroups[3])
+
+	assert.Equal(t, saved.RuleGroups, proxy.GetRuleGroups())
+
+	// Removing the last rule of a group keeps the group and its settings
+	proxy.Rules().Delete("*.youtube.com")
+	require.NoError(t, proxy.PersistRules(context.Background()))
+
+	saved, err = config.Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"video", "ads", "work", "games"}, groupNames(saved.RuleGroups))
+
+	video = saved.RuleGroups[0]
+	assert.Equal(t, "Streaming", video.Description)
+	assert.Equal(t, "wg0", video.Via)
+	assert.True(t, video.PinTTL)
+	assert.Empty(t, video.Patterns)
+}
+
+func groupNames(groups []config.RuleGroup) []string {
+	names := make([]string, 0, len(groups))
+	for _, g := range groups {
+		names = append(names, g.Name)
+	}
+
+	return names
+}
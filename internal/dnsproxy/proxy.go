@@ -18,9 +18,15 @@ import (
 	"github.com/miekg/dns"
 	"github.com/rs/zerolog"
 
+	"github.com/bavix/outway/internal/acl"
+	"github.com/bavix/outway/internal/blocklist"
 	"github.com/bavix/outway/internal/config"
+	"github.com/bavix/outway/internal/dnstap"
 	"github.com/bavix/outway/internal/firewall"
+	"github.com/bavix/outway/internal/lanresolver"
 	"github.com/bavix/outway/internal/metrics"
+	"github.com/bavix/outway/internal/querylog"
+	"github.com/bavix/outway/internal/rpz"
 	"github.com/bavix/outway/internal/version"
 )
 
@@ -125,25 +131,86 @@ func (s *RuleStore) Delete(pattern string) {
 	s.rules = res
 }
 
-func (s *RuleStore) FindIface(host string) string {
+// Groups folds the rules back into base, the groups they were built from.
+// Route groups take their patterns and settings from the rules attributed to
+// them, in store order, and keep their settings without patterns once no rule
+// is left; block groups are kept as they are. Rules of unknown groups form new groups, named after the
+// interface when a rule has no group.
+func (s *RuleStore) Groups(base []config.RuleGroup) []config.RuleGroup {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
+	out := make([]config.RuleGroup, 0, len(base))
+	index := make(map[string]int, len(base))
+
+	for _, g := range base {
+		if !g.IsBlock() {
+			g.Patterns = nil
+			index[g.Name] = len(out)
+		}
+
+		out = append(out, g)
+	}
+
 	for _, r := range s.rules {
-		if matchDomainPattern(r.Pattern, host) {
-			return r.Via
+		name := r.Group
+		if name == "" {
+			name = r.Via
+		}
+
+		i, ok := index[name]
+		if !ok {
+			i = len(out)
+			index[name] = i
+			out = append(out, config.RuleGroup{Name: name})
+		}
+
+		if g := &out[i]; len(g.Patterns) == 0 {
+			g.Via, g.PinTTL, g.ECS = r.Via, r.PinTTL, r.ECS
+			g.ClientTTLMin, g.ClientTTLMax = int(r.ClientTTLMin), int(r.ClientTTLMax)
+
+			if !strings.EqualFold(g.AddressFamily, r.AddressFamily) {
+				g.AddressFamily = r.AddressFamily
+			}
+
+			if enabled := !r.Disabled; enabled != g.IsEnabled() {
+				g.Enabled = &enabled
+			}
+
+			if specs := r.Schedule.Specs(); !slices.Equal(g.Schedule, specs) {
+				g.Schedule = specs
+			}
 		}
+
+		out[i].Patterns = append(out[i].Patterns, r.Pattern)
+	}
+
+	return out
+}
+
+// FindIface returns the interface of the first active rule matching host.
+func (s *RuleStore) FindIface(host string) string {
+	if r, ok := s.Find(host); ok {
+		return r.Via
 	}
 
 	return ""
 }
 
+// Find returns the first rule matching host whose group is enabled and inside
+// its schedule.
 func (s *RuleStore) Find(host string) (config.Rule, bool) {
+	return s.FindAt(host, time.Now())
+}
+
+// FindAt returns the first rule matching host whose group is enabled and
+// inside its schedule at t.
+func (s *RuleStore) FindAt(host string, t time.Time) (config.Rule, bool) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	for _, r := range s.rules {
-		if matchDomainPattern(r.Pattern, host) {
+		if r.Active(t) && matchDomainPattern(r.Pattern, host) {
 			return r, true
 		}
 	}
@@ -151,19 +218,64 @@ func (s *RuleStore) Find(host string) (config.Rule, bool) {
 	return config.Rule{}, false
 }
 
+// ActiveGroups returns the groups with at least one rule active at t.
+func (s *RuleStore) ActiveGroups(t time.Time) map[string]bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	active := make(map[string]bool)
+
+	for _, r := range s.rules {
+		if r.Active(t) {
+			active[r.Group] = true
+		}
+	}
+
+	return active
+}
+
+// GroupPatterns returns the patterns of each group, active or not.
+func (s *RuleStore) GroupPatterns() map[string][]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	patterns := make(map[string][]string)
+
+	for _, r := range s.rules {
+		patterns[r.Group] = append(patterns[r.Group], r.Pattern)
+	}
+
+	return patterns
+}
+
 type Proxy struct {
 	// Managers for thread-safe operations
 	upstreams UpstreamsManager
 	hosts     HostsManager
 	cache     CacheManager
 	history   HistoryManager
-	rules     RulesManager
-	config    ConfigManager
+	// historyStore persists history on disk (nil when kept in memory only)
+	historyStore *historyStore
+	rules        RulesManager
+	config       ConfigManager
 
 	// Core components
 	backend      firewall.Backend
 	active       atomic.Value       // Resolver
-	asyncMarkRes *AsyncMarkResolver // Reference to async mark resolver for cleanup
+	blocklists   *blocklist.Manager // Block rule groups and list subscriptions
+	policy       *rpz.Engine        // Response policy zones
+	addressBooks *addressBooks      // Sources of PTR answers for private ranges
+	guard        *acl.Guard         // Listener ACL and per-client rate limits
+	queryLog     *querylog.Logger   // Append-only query log sinks (nil when disabled)
+	tap          *dnstap.Tap        // dnstap output (nil when disabled)
+	health       *upstreamHealth    // Query outcomes per upstream
+	snapshotMu   sync.Mutex         // Serializes cache snapshot writes
+	reloadMu     sync.Mutex         // Serializes config reloads
+	pipelineMu   sync.Mutex         // Serializes pipeline rebuilds
+	groups       groupStates        // Rule group states seen by the last check
+
+	// asyncMarkRes is the mark stage of the active pipeline
+	asyncMarkRes atomic.Pointer[AsyncMarkResolver]
 
 	// DNS clients
 	dnsUDP    *dns.Client
@@ -195,6 +307,7 @@ func New(cfg *config.Config, backend firewall.Backend) *Proxy {
 		dnsUDP:    &dns.Client{Net: "udp", Timeout: defaultDNSTimeout},
 		dnsTCP:    &dns.Client{Net: "tcp", Timeout: defaultDNSTimeout},
 		dohClient: &http.Client{Timeout: defaultDoHTimeout},
+		health:    newUpstreamHealth(),
 	}
 
 	// Initialize managers
@@ -203,6 +316,13 @@ func New(cfg *config.Config, backend firewall.Backend) *Proxy {
 	p.hosts = newHostsManager(cfg)
 	p.history = newHistoryManager(capacity)
 	p.rules = newRulesManager(NewRuleStore(cfg.GetAllRules()), cfg.RuleGroups)
+	p.blocklists = blocklist.NewManager()
+	p.blocklists.SetGroups(cfg.RuleGroups)
+	p.policy = rpz.NewEngine()
+	p.policy.SetZones(cfg.RPZ)
+	p.addressBooks = &addressBooks{hosts: p.hosts}
+	p.guard = acl.NewGuard()
+	p.groups.update(p.rules.GetRules(), time.Now())
 
 	// Initialize cache if enabled
 	if cfg.Cache.Enabled {
@@ -213,6 +333,9 @@ func New(cfg *config.Config, backend firewall.Backend) *Proxy {
 			cfg.Cache.MinTTLSeconds,
 			cfg.Cache.MaxTTLSeconds,
 		)
+		cache.NegativeMinTTLSeconds = cfg.Cache.NegativeMinTTLSeconds
+		cache.NegativeMaxTTLSeconds = cfg.Cache.NegativeMaxTTL()
+		cache.ServFailTTLSeconds = cfg.Cache.ServFailTTL()
 		p.cache = newCacheManager(cache)
 	}
 
@@ -238,9 +361,52 @@ func (p *Proxy) Start(ctx context.Context) error {
 		Str("build_time", version.GetBuildTime()).
 		Msg("starting DNS servers")
 	metrics.SetReady(true)
+
+	// Upstream resolvers report to dnstap, so open it before building the pipeline
+	tap, err := dnstap.New(ctx, cfg.Dnstap)
+	if err != nil {
+		return fmt.Errorf("failed to open dnstap output: %w", err)
+	}
+
+	p.tap = tap
+
 	// initial pipeline
 	p.rebuildResolver(ctx)
 
+	// Download block list subscriptions in background and keep them fresh
+	p.blocklists.Start(ctx)
+
+	// Load response policy zones; files are reloaded on change
+	p.policy.Start(ctx)
+
+	// Warm the cache from the last snapshot and keep the snapshot fresh
+	p.startCacheSnapshots(ctx, cfg.Cache)
+
+	// Refresh popular cache entries before they expire
+	p.startPrefetch(ctx)
+
+	// Stop routing through rule groups once their schedule window closes
+	p.startRuleSchedules(ctx)
+
+	store, err := openHistoryStore(ctx, cfg.History)
+	if err != nil {
+		return fmt.Errorf("failed to open history store: %w", err)
+	}
+
+	// Show the last events of the previous run right away
+	for _, e := range store.recent(cfg.History.MaxEntries) {
+		p.history.AddEvent(e)
+	}
+
+	p.historyStore = store
+
+	queryLog, err := querylog.New(ctx, cfg.History)
+	if err != nil {
+		return fmt.Errorf("failed to open query log: %w", err)
+	}
+
+	p.queryLog = queryLog
+
 	udpSrv := &dns.Server{Addr: cfg.Listen.UDP, Net: "udp"}
 	tcpSrv := &dns.Server{Addr: cfg.Listen.TCP, Net: "tcp"}
 
@@ -276,9 +442,9 @@ func (p *Proxy) Start(ctx context.Context) error {
 		zerolog.Ctx(ctx).Info().Msg("shutting down DNS servers")
 
 		// Stop async mark resolver
-		if p.asyncMarkRes != nil {
+		if mark := p.asyncMarkRes.Load(); mark != nil {
 			//nolint:contextcheck // Stop is a cleanup method, context not needed
-			p.asyncMarkRes.Stop()
+			mark.Stop()
 		}
 
 		if err := udpSrv.Shutdown(); err != nil {
@@ -289,6 +455,10 @@ func (p *Proxy) Start(ctx context.Context) error {
 			zerolog.Ctx(ctx).Err(err).Msg("failed to shutdown TCP server")
 		}
 
+		p.queryLog.Close()
+		p.historyStore.close()
+		p.tap.Close()
+
 		metrics.SetReady(false)
 	}()
 
@@ -297,6 +467,8 @@ func (p *Proxy) Start(ctx context.Context) error {
 
 func (p *Proxy) handleDNS(ctx context.Context) dns.HandlerFunc { //nolint:funcorder,funlen,cyclop
 	return func(w dns.ResponseWriter, r *dns.Msg) {
+		ctx, trace := WithQueryTrace(ctx)
+
 		// Panic recovery for DNS handler
 		defer func() {
 			if rec := recover(); rec != nil {
@@ -318,6 +490,12 @@ func (p *Proxy) handleDNS(ctx context.Context) dns.HandlerFunc { //nolint:funcor
 			return
 		}
 
+		w = p.tapClient(w, r)
+
+		if !p.admit(ctx, w, r) {
+			return
+		}
+
 		// resolve via active pipeline
 		resAny := p.active.Load()
 
@@ -331,6 +509,23 @@ func (p *Proxy) handleDNS(ctx context.Context) dns.HandlerFunc { //nolint:funcor
 		}
 
 		resp, usedUpstream, err := resolver.Resolve(ctx, r)
+		if errors.Is(err, ErrQueryDropped) {
+			// Policy requires silence: no answer at all, not even SERVFAIL
+			if len(r.Question) > 0 {
+				p.recordEvent(QueryEvent{
+					Name:     strings.TrimSuffix(r.Question[0].Name, "."),
+					QType:    r.Question[0].Qtype,
+					Upstream: usedUpstream,
+					Status:   "dropped",
+					Time:     time.Now(),
+					ClientIP: clientIP,
+					Policy:   policyLabel(trace),
+				}, time.Since(start))
+			}
+
+			return
+		}
+
 		if err != nil {
 			// record error event
 			if len(r.Question) > 0 {
@@ -351,15 +546,14 @@ func (p *Proxy) handleDNS(ctx context.Context) dns.HandlerFunc { //nolint:funcor
 
 				logger.Error().Err(err).Msg("DNS resolution failed")
 
-				p.history.AddEvent(QueryEvent{
+				p.recordEvent(QueryEvent{
 					Name:     strings.TrimSuffix(q.Name, "."),
 					QType:    q.Qtype,
 					Upstream: usedUpstream,
-					Duration: duration.String(),
 					Status:   "error",
 					Time:     time.Now(),
 					ClientIP: clientIP,
-				})
+				}, duration)
 			} else {
 				zerolog.Ctx(ctx).Error().Err(err).Str("upstream", usedUpstream).Msg("DNS resolution failed (no question)")
 			}
@@ -396,15 +590,32 @@ func (p *Proxy) handleDNS(ctx context.Context) dns.HandlerFunc { //nolint:funcor
 				Str("client_ip", clientIP).
 				Msg("DNS resolution successful")
 
-			p.history.AddEvent(QueryEvent{
-				Name:     queryName,
-				QType:    q.Qtype,
-				Upstream: usedUpstream,
-				Duration: duration.String(),
-				Status:   "ok",
-				Time:     time.Now(),
-				ClientIP: clientIP,
-			})
+			status := "ok"
+
+			switch {
+			case usedUpstream == sourceBlocklist:
+				status = "blocked"
+			case usedUpstream == sourceRPZ:
+				status = "policy"
+			}
+
+			event := QueryEvent{
+				Name:      queryName,
+				QType:     q.Qtype,
+				Upstream:  usedUpstream,
+				Status:    status,
+				Time:      time.Now(),
+				ClientIP:  clientIP,
+				RuleGroup: trace.RuleGroup(),
+				BlockList: trace.BlockList(),
+				Policy:    policyLabel(trace),
+			}
+
+			if status == "ok" {
+				p.annotateRoute(&event, trace)
+			}
+
+			p.recordEvent(event, duration)
 		}
 
 		_ = w.WriteMsg(resp)
@@ -599,52 +810,60 @@ func (p *Proxy) Cache() *CachedResolver {
 	return nil
 }
 
-// PersistRules writes current rule set back to config file.
-func (p *Proxy) PersistRules() error {
-	// Update rule groups with current rules
-	// This is a simplified approach - in a real implementation,
-	// you might want to preserve the group structure
-	var allRules []config.Rule
-
-	cfg := p.config.GetConfig()
-	for _, group := range cfg.GetRuleGroups() {
-		for _, pattern := range group.Patterns {
-			allRules = append(allRules, config.Rule{
-				Pattern: pattern,
-				Via:     group.Via,
-			})
-		}
+// BackendName returns the name of the firewall backend, if any.
+func (p *Proxy) BackendName() string {
+	if p.backend == nil {
+		return ""
 	}
 
-	// For now, we'll put all rules in the first group or create a default group
-	if len(cfg.RuleGroups) == 0 {
-		patterns := make([]string, len(allRules))
-		for i, rule := range allRules {
-			patterns[i] = rule.Pattern
-		}
-
-		cfg.RuleGroups = []config.RuleGroup{{
-			Name:     "Default",
-			Via:      "default",
-			Patterns: patterns,
-		}}
-	} else {
-		patterns := make([]string, len(allRules))
-		for i, rule := range allRules {
-			patterns[i] = rule.Pattern
-		}
+	return p.backend.Name()
+}
 
-		cfg.RuleGroups[0].Patterns = patterns
+// Marks returns the addresses currently marked for routing.
+func (p *Proxy) Marks() []MarkedIP {
+	mark := p.asyncMarkRes.Load()
+	if mark == nil {
+		return nil
 	}
 
-	if err := p.config.SaveConfig(); err != nil {
-		// Note: ctx might not be available here, but we can still log
+	return mark.Marks()
+}
+
+// PersistRules folds the runtime rule store back into the rule groups and
+// saves the config file.
+func (p *Proxy) PersistRules(ctx context.Context) error {
+	p.config.ApplyConfig(func(cfg *config.Config) {
+		cfg.RuleGroups = p.rules.GetRules().Groups(cfg.RuleGroups)
+		p.rules.UpdateRuleGroups(cfg.RuleGroups)
+	})
+
+	if err := p.config.SaveConfig(ctx); err != nil {
 		return fmt.Errorf("failed to save configuration: %w", err)
 	}
 
 	return nil
 }
 
+// Blocklists returns the block list manager for admin helpers.
+func (p *Proxy) Blocklists() *blocklist.Manager { return p.blocklists }
+
+// AddAddressBook registers a source of host names for PTR answers of private addresses.
+func (p *Proxy) AddAddressBook(book lanresolver.AddressBook) { p.addressBooks.add(book) }
+
+// Policy returns the response policy zone engine for admin helpers.
+func (p *Proxy) Policy() *rpz.Engine { return p.policy }
+
+// SyncPolicyZones applies response policy zone sources from the current config.
+func (p *Proxy) SyncPolicyZones() {
+	p.policy.SetZones(p.config.GetConfig().RPZ)
+}
+
+// SyncBlockGroups recompiles block rule groups from the current config.
+// Newly added list sources are downloaded in background.
+func (p *Proxy) SyncBlockGroups() {
+	p.blocklists.SetGroups(p.config.GetConfig().GetRuleGroups())
+}
+
 // GetUpstreams returns upstreams helpers.
 func (p *Proxy) GetUpstreams() []string {
 	return p.upstreams.GetUpstreamAddresses()
@@ -677,23 +896,17 @@ func (p *Proxy) SetUpstreamsConfig(ctx context.Context, ups []config.UpstreamCon
 		if u.Weight < 0 {
 			return fmt.Errorf("upstream '%s': %w (got %d)", u.Name, errUpstreamInvalidWeight, u.Weight)
 		}
-	}
 
-	// 2) Prepare runtime view with detected types and sane weights
-	typed := make([]config.UpstreamConfig, 0, len(ups))
-	for i := range ups {
-		u := ups[i]
-		if u.Weight <= 0 {
-			u.Weight = 1
-		}
-
-		if u.Type == "" {
-			u.Type = configDetectType(u.Address)
+		if u.ECS != nil {
+			if err := u.ECS.Validate(); err != nil {
+				return fmt.Errorf("upstream '%s': %w", u.Name, err)
+			}
 		}
-
-		typed = append(typed, u)
 	}
 
+	// 2) Prepare runtime view with detected types and sane weights
+	typed := runtimeUpstreams(ups)
+
 	// 3) Update upstreams manager atomically (thread-safe, with validation already done)
 	if err := p.upstreams.SetUpstreams(typed); err != nil {
 		logger.Error().Err(err).Msg("failed to update upstreams in manager")
@@ -726,19 +939,19 @@ func (p *Proxy) SetUpstreamsConfig(ctx context.Context, ups []config.UpstreamCon
 			Name:    u.Name,
 			Address: normalizedAddr,
 			Weight:  u.Weight,
+			ECS:     u.ECS,
 			// Type intentionally left empty (omitempty)
 		})
 	}
 
+	p.config.ApplyConfig(func(cfg *config.Config) { cfg.Upstreams = persist })
+
 	// 6) Save configuration asynchronously to avoid blocking DNS proxy
 	// This prevents service disruption if disk I/O is slow
 	go func() {
 		saveLogger := logger.With().Str("operation", "async_save").Logger()
 
-		cfg := p.config.GetConfig()
-		cfg.Upstreams = persist
-
-		if err := p.config.SaveConfig(); err != nil {
+		if err := p.config.SaveConfig(context.WithoutCancel(ctx)); err != nil {
 			saveLogger.Error().
 				Err(err).
 				Msg("failed to save config after updating upstreams (async save failed)")
@@ -756,6 +969,24 @@ func (p *Proxy) SetUpstreamsConfig(ctx context.Context, ups []config.UpstreamCon
 	return nil
 }
 
+// runtimeUpstreams returns ups with detected types and sane weights.
+func runtimeUpstreams(ups []config.UpstreamConfig) []config.UpstreamConfig {
+	typed := make([]config.UpstreamConfig, 0, len(ups))
+	for _, u := range ups {
+		if u.Weight <= 0 {
+			u.Weight = 1
+		}
+
+		if u.Type == "" {
+			u.Type = configDetectType(u.Address)
+		}
+
+		typed = append(typed, u)
+	}
+
+	return typed
+}
+
 // local shim to avoid import cycle; mirrors internal/config.detectType.
 func configDetectType(addr string) string {
 	if strings.HasPrefix(addr, "https://") {
@@ -809,6 +1040,8 @@ func (p *Proxy) SetHosts(ctx context.Context, hosts []config.HostOverride) error
 		return fmt.Errorf("validation failed: %w", err)
 	}
 
+	p.config.ApplyConfig(func(cfg *config.Config) { cfg.Hosts = slices.Clone(hosts) })
+
 	logger.Debug().Msg("hosts updated successfully in manager (in-memory)")
 
 	// Note: rebuildResolver() is no longer needed because HostsResolver is now dynamic
@@ -821,7 +1054,7 @@ func (p *Proxy) SetHosts(ctx context.Context, hosts []config.HostOverride) error
 	go func() {
 		saveLogger := logger.With().Str("operation", "async_save").Logger()
 
-		if err := p.config.SaveConfig(); err != nil {
+		if err := p.config.SaveConfig(context.WithoutCancel(ctx)); err != nil {
 			saveLogger.Error().
 				Err(err).
 				Msg("failed to save config after updating hosts (async save failed)")
@@ -908,7 +1141,7 @@ func (p *Proxy) SetUpstreams(ctx context.Context, us []string) error { //nolint:
 	cfg := p.config.GetConfig()
 	cfg.Upstreams = upstreams
 
-	if err := p.config.SaveConfig(); err != nil {
+	if err := p.config.SaveConfig(ctx); err != nil {
 		zerolog.Ctx(ctx).Err(err).Msg("failed to save config after updating upstreams")
 
 		return fmt.Errorf("failed to save configuration: %w", err)
@@ -919,13 +1152,72 @@ func (p *Proxy) SetUpstreams(ctx context.Context, us []string) error { //nolint:
 
 // QueryEvent represents one DNS query record for in-memory history.
 type QueryEvent struct {
-	Name     string    `json:"name"`
-	QType    uint16    `json:"qtype"`
-	Upstream string    `json:"upstream"`
-	Duration string    `json:"duration"`
-	Status   string    `json:"status"`
-	Time     time.Time `json:"time"`
-	ClientIP string    `json:"client_ip"`
+	Name      string    `json:"name"`
+	QType     uint16    `json:"qtype"`
+	Upstream  string    `json:"upstream"`
+	Duration  string    `json:"duration"`
+	Status    string    `json:"status"`
+	Time      time.Time `json:"time"`
+	ClientIP  string    `json:"client_ip"`
+	RuleGroup string    `json:"rule_group,omitempty"`
+	BlockList string    `json:"block_list,omitempty"`
+	Policy    string    `json:"policy,omitempty"`     // "zone:action" of the applied RPZ rule
+	Via       string    `json:"via,omitempty"`        // Interface the answer is routed through
+	MarkedIPs []string  `json:"marked_ips,omitempty"` // Addresses marked for that interface
+}
+
+// recordEvent adds the event, answered in duration, to history and the query log.
+func (p *Proxy) recordEvent(event QueryEvent, duration time.Duration) {
+	event.Duration = duration.String()
+
+	p.history.AddEvent(event)
+	p.historyStore.add(event)
+
+	if p.queryLog == nil {
+		return
+	}
+
+	p.queryLog.Log(querylog.Entry{
+		Time:       event.Time,
+		Client:     event.ClientIP,
+		Name:       event.Name,
+		Type:       dns.Type(event.QType).String(),
+		Status:     event.Status,
+		Upstream:   event.Upstream,
+		DurationMS: float64(duration) / float64(time.Millisecond),
+		RuleGroup:  event.RuleGroup,
+		Via:        event.Via,
+		MarkedIPs:  event.MarkedIPs,
+		BlockList:  event.BlockList,
+		Policy:     event.Policy,
+	})
+}
+
+// annotateRoute fills in the rule group, interface and addresses of a routed
+// answer. Addresses are those the mark stage routed for this query; answers
+// from the cache name the group and interface only.
+func (p *Proxy) annotateRoute(event *QueryEvent, trace *QueryTrace) {
+	if group, via, marked := trace.Route(); via != "" {
+		event.RuleGroup = group
+		event.Via = via
+		event.MarkedIPs = marked
+
+		return
+	}
+
+	if rule, ok := p.rules.GetRules().Find(strings.ToLower(event.Name)); ok {
+		event.RuleGroup = rule.Group
+		event.Via = rule.Via
+	}
+}
+
+func policyLabel(t *QueryTrace) string {
+	zone, action := t.Policy()
+	if zone == "" {
+		return ""
+	}
+
+	return zone + ":" + action
 }
 
 // History returns a copy of last events (newest first).
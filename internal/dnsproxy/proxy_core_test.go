@@ -1,16 +1,21 @@
 package dnsproxy_test
 
 import (
+	"context"
 	"fmt"
+	"net"
+	"path/filepath"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/miekg/dns"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/bavix/outway/internal/config"
 	"github.com/bavix/outway/internal/dnsproxy"
+	"github.com/bavix/outway/internal/metrics"
 )
 
 // TestMatchDomainPattern is already covered in proxy_test.go
@@ -109,3 +114,71 @@ func TestRuleStoreConcurrency(t *testing.T) {
 // TestMatchDomainPatternEdgeCases is already covered in proxy_test.go
 
 // mockResponseWriter is defined in managers_internal_test.go
+
+// recordingWriter is a dns.ResponseWriter of a UDP client that keeps the reply.
+type recordingWriter struct {
+	dns.ResponseWriter
+
+	reply *dns.Msg
+}
+
+func (w *recordingWriter) RemoteAddr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv4(192, 0, 2, 10), Port: 53000}
+}
+
+func (w *recordingWriter) LocalAddr() net.Addr { return &net.UDPAddr{IP: net.IPv4zero, Port: 53} }
+
+func (w *recordingWriter) WriteMsg(m *dns.Msg) error {
+	w.reply = m
+
+	return nil
+}
+
+func TestProxyHistoryListsMarkedAddresses(t *testing.T) {
+	metrics.BindService() // the pipeline counts queries; bound before tests run in parallel
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, ruleScheduleConfig+`  - pattern: vpn.corp.test
+    a: ["10.8.0.1"]
+    via: wg9
+`)
+
+	cfg, err := config.Load(path)
+	require.NoError(t, err)
+
+	proxy := dnsproxy.New(cfg, &recordingBackend{})
+	handle := proxy.HandleDNS(context.Background())
+
+	query := func(name string) dnsproxy.QueryEvent {
+		q := new(dns.Msg)
+		q.SetQuestion(name, dns.TypeA)
+
+		w := &recordingWriter{}
+		handle(w, q)
+		require.NotNil(t, w.reply)
+
+		history := proxy.History()
+
+		return history[len(history)-1]
+	}
+
+	// Routed by the host override rather than a rule group
+	event := query("vpn.corp.test.")
+	assert.Equal(t, "wg9", event.Via)
+	assert.Empty(t, event.RuleGroup)
+	assert.Equal(t, []string{"10.8.0.1"}, event.MarkedIPs)
+
+	event = query("box.example.com.")
+	assert.Equal(t, "lab", event.RuleGroup)
+	assert.Equal(t, "wg0", event.Via)
+	assert.Equal(t, []string{"10.0.0.5"}, event.MarkedIPs)
+	assert.NotEmpty(t, event.Duration)
+
+	// A cache hit does not reach the mark stage, so nothing is marked for it
+	event = query("box.example.com.")
+	assert.Equal(t, "cache", event.Upstream)
+	assert.Equal(t, "lab", event.RuleGroup)
+	assert.Equal(t, "wg0", event.Via)
+	assert.Empty(t, event.MarkedIPs)
+}
@@ -19,6 +19,11 @@ import (
 func (p *Proxy) rebuildResolver(ctx context.Context) {
 	logger := zerolog.Ctx(ctx)
 
+	// Config changes from reloads, the API and the CLI rebuild concurrently;
+	// each rebuild must see the mark stage of the previous one
+	p.pipelineMu.Lock()
+	defer p.pipelineMu.Unlock()
+
 	logger.Info().Msg("rebuilding DNS resolver pipeline")
 
 	// Build upstream resolvers using weighted order from config
@@ -27,6 +32,7 @@ func (p *Proxy) rebuildResolver(ctx context.Context) {
 		UDP: p.dnsUDP,
 		TCP: p.dnsTCP,
 		DoH: p.dohClient,
+		Tap: p.tap,
 		ExchangeDoH: func(m *dns.Msg, url string) (*dns.Msg, error) {
 			out, _, err := p.exchangeDoH(ctx, m, url)
 
@@ -36,6 +42,7 @@ func (p *Proxy) rebuildResolver(ctx context.Context) {
 
 	// Get upstreams from manager
 	rs := p.upstreams.RebuildResolvers(ctx, strategies, deps)
+	upstreamECS := len(rs) == 0 // the fallback resolvers forward the client subnet
 
 	logger.Debug().Int("resolvers_count", len(rs)).Msg("upstream resolvers rebuilt")
 
@@ -43,26 +50,21 @@ func (p *Proxy) rebuildResolver(ctx context.Context) {
 	if len(rs) == 0 {
 		logger.Warn().Msg("no resolvers created, using default fallback resolvers")
 		// If no resolvers were created, create a default fallback resolver
-		defaultUpstreams := []string{"udp:8.8.8.8:53", "udp:1.1.1.1:53"}
-		for _, raw := range defaultUpstreams {
-			netw, addr := parseUpstream(raw)
-			for _, s := range strategies {
-				if s.Supports(netw) {
-					if r := s.NewResolver(netw, addr, deps); r != nil {
-						rs = append(rs, r)
-					}
-
-					break
-				}
+		for _, u := range keyUpstreams(rawUpstreams(fallbackUpstreams)) {
+			if r := p.newUpstreamResolver(u, strategies, deps); r != nil {
+				rs = append(rs, r)
 			}
 		}
 	}
 
 	chain := NewChainResolver(rs...)
 
+	// Rule group ECS policies rewrite queries before any upstream sees them
+	upstream := &ECSRuleResolver{Next: chain, Rules: p.rules.GetRules()}
+
 	// Create hosts resolver using manager
 	cfg := p.config.GetConfig()
-	hosts := p.hosts.CreateHostsResolver(chain, cfg)
+	hosts := p.hosts.CreateHostsResolver(upstream, cfg)
 
 	// Initialize zone detector and lease manager with auto-detection
 	zoneDetector := localzone.NewZoneDetector()
@@ -75,7 +77,13 @@ func (p *Proxy) rebuildResolver(ctx context.Context) {
 
 	// Create LAN resolver (always enabled)
 	lanResolver := lanresolver.NewLANResolver(hosts, zoneDetector, leaseManager)
-	next := Resolver(lanResolver)
+	if p.addressBooks != nil {
+		lanResolver.SetAddressBooks(p.addressBooks)
+	}
+	// Answers are narrowed to the group's address family and client TTL
+	// bounds before marking and caching
+	next := Resolver(&AddressFamilyResolver{Next: lanResolver, Rules: p.rules.GetRules()})
+	next = &ClientTTLResolver{Next: next, Rules: p.rules.GetRules()}
 
 	// Create async mark resolver for better performance (non-blocking IP marking)
 	// This prevents DNS queries from being blocked by slow firewall operations
@@ -86,7 +94,13 @@ func (p *Proxy) rebuildResolver(ctx context.Context) {
 		p.rules.GetRules(),
 		cfg,
 	)
-	p.asyncMarkRes = mark
+	mark.Hosts = p.hosts
+
+	// Marks of the previous pipeline stay valid, so they are not repeated
+	prev := p.asyncMarkRes.Load()
+	if prev != nil {
+		mark.inheritMarks(prev)
+	}
 
 	// Build core without metrics first so cache can wrap it
 	var core Resolver = mark
@@ -95,6 +109,12 @@ func (p *Proxy) rebuildResolver(ctx context.Context) {
 		// Update the existing cache's Next resolver instead of creating a new one
 		p.cache.UpdateCacheNext(core)
 
+		for _, u := range p.upstreams.GetUpstreams() {
+			upstreamECS = upstreamECS || isECSPassthrough(u.ECS)
+		}
+
+		p.cache.GetCache().SetECSPolicy(p.rules.GetRules(), upstreamECS)
+
 		if cfg.Cache.ServeStale {
 			core = &ServeStaleResolver{Cache: p.cache.GetCache()}
 		} else {
@@ -102,9 +122,25 @@ func (p *Proxy) rebuildResolver(ctx context.Context) {
 		}
 	}
 
+	// Blocked names are answered before the cache so list changes apply immediately
+	core = &BlocklistResolver{Next: core, Lists: p.blocklists}
+
+	// Response policy zones take precedence over block groups and see every response
+	core = &RPZResolver{Next: core, Policy: p.policy}
+
+	// Listener access settings live in the same config and change with it
+	p.guard.Configure(cfg.ACL, cfg.RateLimit)
+
 	// Place metrics outermost to include cache/hosts/upstreams in duration
 	root := Resolver(&MetricsResolver{Next: core})
 	p.active.Store(root)
+	p.asyncMarkRes.Store(mark)
+
+	// The replaced resolver flushes its queued marks and stops its worker
+	if prev != nil {
+		//nolint:contextcheck // Stop is a cleanup method, context not needed
+		prev.Stop()
+	}
 
 	logger.Info().
 		Int("upstreams", len(rs)).
@@ -116,17 +152,9 @@ func (p *Proxy) rebuildResolver(ctx context.Context) {
 func (p *Proxy) buildLegacyResolvers(strategies []UpstreamStrategy, deps StrategyDeps) []Resolver {
 	var rs []Resolver
 
-	upstreams := p.upstreams.GetUpstreamAddresses()
-	for _, raw := range upstreams {
-		netw, addr := parseUpstream(raw)
-		for _, s := range strategies {
-			if s.Supports(netw) {
-				if r := s.NewResolver(netw, addr, deps); r != nil {
-					rs = append(rs, r)
-				}
-
-				break
-			}
+	for _, u := range keyUpstreams(rawUpstreams(p.upstreams.GetUpstreamAddresses())) {
+		if r := p.newUpstreamResolver(u, strategies, deps); r != nil {
+			rs = append(rs, r)
 		}
 	}
 
@@ -135,10 +163,9 @@ func (p *Proxy) buildLegacyResolvers(strategies []UpstreamStrategy, deps Strateg
 
 // buildWeightedResolvers creates resolvers grouped by weight with random selection within each group.
 func (p *Proxy) buildWeightedResolvers(ups []config.UpstreamConfig, strategies []UpstreamStrategy, deps StrategyDeps) []Resolver {
-	// Copy and sort upstreams by weight desc using slices.SortFunc
-	sorted := make([]config.UpstreamConfig, len(ups))
-	copy(sorted, ups)
-	slices.SortFunc(sorted, func(a, b config.UpstreamConfig) int {
+	// Key upstreams in config order, then sort them by weight desc using slices.SortFunc
+	sorted := keyUpstreams(ups)
+	slices.SortFunc(sorted, func(a, b keyedUpstream) int {
 		// desc
 		if a.Weight == b.Weight {
 			return 0
@@ -173,7 +200,7 @@ func (p *Proxy) buildWeightedResolvers(ups []config.UpstreamConfig, strategies [
 // sortWeightsDesc removed in favor of slices.SortFunc above
 
 // buildResolversFromGroup creates resolvers from a weight group with random ordering.
-func (p *Proxy) buildResolversFromGroup(group []config.UpstreamConfig, strategies []UpstreamStrategy, deps StrategyDeps) []Resolver {
+func (p *Proxy) buildResolversFromGroup(group []keyedUpstream, strategies []UpstreamStrategy, deps StrategyDeps) []Resolver {
 	// Shuffle upstreams within the same weight group for random selection
 	// nosemgrep: go.lang.security.audit.crypto.math_random.math-random-used
 	rand.Shuffle(len(group), func(i, j int) {
@@ -183,17 +210,34 @@ func (p *Proxy) buildResolversFromGroup(group []config.UpstreamConfig, strategie
 	var rs []Resolver
 
 	for _, u := range group {
-		netw, addr := u.Type, u.Address
-		for _, s := range strategies {
-			if s.Supports(netw) {
-				if r := s.NewResolver(netw, addr, deps); r != nil {
-					rs = append(rs, r)
-				}
-
-				break
-			}
+		if r := p.newUpstreamResolver(u, strategies, deps); r != nil {
+			rs = append(rs, r)
 		}
 	}
 
 	return rs
 }
+
+// newUpstreamResolver creates the resolver of u recording its health, or nil
+// when no strategy supports its type.
+func (p *Proxy) newUpstreamResolver(u keyedUpstream, strategies []UpstreamStrategy, deps StrategyDeps) Resolver {
+	for _, s := range strategies {
+		if !s.Supports(u.Type) {
+			continue
+		}
+
+		r := s.NewResolver(u.Type, u.Address, deps)
+		if r == nil {
+			return nil
+		}
+
+		var next Resolver = r
+		if u.ECS != nil {
+			next = &ECSResolver{Next: r, Policy: *u.ECS}
+		}
+
+		return &healthResolver{Next: next, key: u.key, health: p.health}
+	}
+
+	return nil
+}
@@ -0,0 +1,143 @@
+package dnsproxy
+
+import (
+	"context"
+	"sync"
+)
+
+type queryTraceKey struct{}
+
+// QueryTrace collects annotations made by pipeline stages while a single
+// query is resolved, so that handleDNS can record them in history.
+// All methods are safe on a nil receiver.
+type QueryTrace struct {
+	mu        sync.Mutex
+	ruleGroup string
+	blockList string
+
+	policyZone   string
+	policyAction string
+
+	routeGroup string
+	via        string
+	marked     []string
+
+	dryRun bool
+}
+
+// WithQueryTrace returns a context carrying a fresh QueryTrace.
+func WithQueryTrace(ctx context.Context) (context.Context, *QueryTrace) {
+	t := &QueryTrace{}
+
+	return context.WithValue(ctx, queryTraceKey{}, t), t
+}
+
+// WithDryRunTrace returns a context carrying a fresh QueryTrace of a dry run:
+// the mark stage records the route of the answers without marking them.
+func WithDryRunTrace(ctx context.Context) (context.Context, *QueryTrace) {
+	t := &QueryTrace{dryRun: true}
+
+	return context.WithValue(ctx, queryTraceKey{}, t), t
+}
+
+// QueryTraceFromContext returns the trace attached to ctx, or nil.
+func QueryTraceFromContext(ctx context.Context) *QueryTrace {
+	t, _ := ctx.Value(queryTraceKey{}).(*QueryTrace)
+
+	return t
+}
+
+// SetBlocked records the block group and list that answered the query.
+func (t *QueryTrace) SetBlocked(group, list string) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.ruleGroup = group
+	t.blockList = list
+}
+
+// RuleGroup returns the rule group recorded for the query.
+func (t *QueryTrace) RuleGroup() string {
+	if t == nil {
+		return ""
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.ruleGroup
+}
+
+// BlockList returns the block list that matched the query, if any.
+func (t *QueryTrace) BlockList() string {
+	if t == nil {
+		return ""
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.blockList
+}
+
+// SetPolicy records the response policy zone and action applied to the query.
+func (t *QueryTrace) SetPolicy(zone, action string) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.policyZone = zone
+	t.policyAction = action
+}
+
+// Policy returns the response policy zone and action applied to the query, if any.
+func (t *QueryTrace) Policy() (string, string) {
+	if t == nil {
+		return "", ""
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.policyZone, t.policyAction
+}
+
+// SetRoute records the rule group and interface routing the answer and the
+// addresses marked for it.
+func (t *QueryTrace) SetRoute(group, via string, marked []string) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.routeGroup = group
+	t.via = via
+	t.marked = marked
+}
+
+// Route returns the rule group and interface the answer was routed through
+// and the addresses marked for it, if the query reached the mark stage.
+func (t *QueryTrace) Route() (string, string, []string) {
+	if t == nil {
+		return "", "", nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.routeGroup, t.via, t.marked
+}
+
+// DryRun reports whether the query must not mark its answers.
+func (t *QueryTrace) DryRun() bool {
+	return t != nil && t.dryRun
+}
@@ -0,0 +1,147 @@
+package dnsproxy
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/rs/zerolog"
+
+	"github.com/bavix/outway/internal/config"
+)
+
+// liveSections are the config sections Reload applies to the running proxy.
+// Other sections are read at startup and take effect after a restart.
+//
+//nolint:gochecknoglobals // fixed list of reloadable sections
+var liveSections = []string{"include", "upstreams", "rule_groups", "hosts", "rpz", "acl", "rate_limit", "users", "update"}
+
+// ReloadFile loads and validates the config file the proxy was started with
+// and applies it with Reload. On error the running configuration is kept.
+func (p *Proxy) ReloadFile(ctx context.Context) error {
+	next, err := config.Load(p.config.GetConfig().Path)
+	if err != nil {
+		return fmt.Errorf("reload config: %w", err)
+	}
+
+	return p.Reload(ctx, next)
+}
+
+// Reload applies the sections of next that differ from the running
+// configuration. The cache, marked addresses and listeners are kept; changes
+// to sections read only at startup are logged and wait for a restart.
+// Everything is validated before the first section is applied, so a failed
+// reload leaves the running configuration untouched.
+//
+//nolint:cyclop,funlen // one branch per reloadable section
+func (p *Proxy) Reload(ctx context.Context, next *config.Config) error {
+	if err := next.Validate(); err != nil {
+		return fmt.Errorf("reload config: %w", err)
+	}
+
+	if err := validateHosts(next.Hosts); err != nil {
+		return fmt.Errorf("reload hosts: %w", err)
+	}
+
+	p.reloadMu.Lock()
+	defer p.reloadMu.Unlock()
+
+	logger := zerolog.Ctx(ctx)
+
+	var (
+		changed   []string
+		rebaseErr error
+	)
+
+	// Sections are applied at once under the config lock; later saves write
+	// API changes against the reloaded files
+	p.config.ApplyConfig(func(cfg *config.Config) {
+		changed = config.Diff(cfg, next)
+
+		for _, section := range changed {
+			applySection(cfg, next, section)
+		}
+
+		rebaseErr = cfg.Rebase(next)
+	})
+
+	if rebaseErr != nil {
+		logger.Warn().Err(rebaseErr).Msg("failed to track reloaded config files")
+	}
+
+	if len(changed) == 0 {
+		logger.Debug().Msg("configuration unchanged, nothing to reload")
+
+		return nil
+	}
+
+	applied := make([]string, 0, len(changed))
+	rebuild := false
+
+	for _, section := range changed {
+		if !slices.Contains(liveSections, section) {
+			logger.Warn().Str("section", section).Msg("config section changed, restart to apply")
+
+			continue
+		}
+
+		applied = append(applied, section)
+
+		switch section {
+		case "upstreams":
+			_ = p.upstreams.SetUpstreams(runtimeUpstreams(next.Upstreams))
+			rebuild = true
+		case "rule_groups":
+			p.rules.UpdateRules(NewRuleStore(next.GetAllRules()))
+			p.rules.UpdateRuleGroups(next.RuleGroups)
+			p.SyncBlockGroups()
+			rebuild = true
+		case "hosts":
+			// Validated above
+			_ = p.hosts.UpdateHostsInPlace(next.Hosts)
+		case "rpz":
+			p.SyncPolicyZones()
+		case "acl", "rate_limit":
+			rebuild = true
+		}
+	}
+
+	// Resolvers hold the rule store and upstreams; the cache instance is reused
+	if rebuild {
+		p.rebuildResolver(ctx)
+	}
+
+	if slices.Contains(applied, "rule_groups") {
+		p.UnmarkInactiveGroups(ctx)
+	}
+
+	logger.Info().Strs("sections", applied).Msg("configuration reloaded")
+
+	return nil
+}
+
+// applySection copies a reloadable section of next into cfg. Other sections
+// are left for a restart.
+func applySection(cfg, next *config.Config, section string) {
+	switch section {
+	case "upstreams":
+		cfg.Upstreams = next.Upstreams
+	case "rule_groups":
+		cfg.RuleGroups = next.RuleGroups
+	case "hosts":
+		cfg.Hosts = next.Hosts
+	case "rpz":
+		cfg.RPZ = next.RPZ
+	case "acl":
+		cfg.ACL = next.ACL
+	case "rate_limit":
+		cfg.RateLimit = next.RateLimit
+	case "users":
+		cfg.Users = next.Users
+	case "update":
+		cfg.Update = next.Update
+	case "include":
+		// Included files only matter through the sections they define
+		cfg.Include = next.Include
+	}
+}
@@ -0,0 +1,171 @@
+package dnsproxy_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bavix/outway/internal/config"
+	"github.com/bavix/outway/internal/dnsproxy"
+)
+
+const reloadBaseConfig = `listen:
+  udp: ":5353"
+  tcp: ":5353"
+upstreams:
+  - name: cloudflare
+    address: 1.1.1.1:53
+rule_groups:
+  - name: video
+    via: wg0
+    patterns: ["*.example.com"]
+`
+
+func writeConfig(t *testing.T, path, body string) {
+	t.Helper()
+
+	require.NoError(t, os.WriteFile(path, []byte(body), 0o600))
+}
+
+func TestProxyReloadFile(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, reloadBaseConfig)
+
+	cfg, err := config.Load(path)
+	require.NoError(t, err)
+
+	proxy := dnsproxy.New(cfg, &MockFirewallBackend{})
+	ctx := context.Background()
+
+	writeConfig(t, path, `listen:
+  udp: ":5454"
+  tcp: ":5454"
+upstreams:
+  - name: google
+    address: 8.8.8.8:53
+rule_groups:
+  - name: video
+    via: wg1
+    patterns: ["*.example.org"]
+hosts:
+  - pattern: nas.lan
+    a: ["192.168.1.10"]
+`)
+	require.NoError(t, proxy.ReloadFile(ctx))
+
+	_, ok := proxy.Rules().Find("www.example.com")
+	assert.False(t, ok, "old pattern is gone")
+
+	rule, ok := proxy.Rules().Find("www.example.org")
+	require.True(t, ok)
+	assert.Equal(t, "wg1", rule.Via)
+
+	assert.Equal(t, []string{"udp:8.8.8.8:53"}, proxy.GetUpstreams())
+	require.Len(t, proxy.GetHosts(), 1)
+	assert.Equal(t, "wg1", proxy.GetRuleGroups()[0].Via)
+
+	// Listeners are bound at startup and wait for a restart
+	assert.Equal(t, ":5353", proxy.GetConfig().Listen.UDP)
+}
+
+func TestProxyReloadFileRejectsInvalid(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, reloadBaseConfig)
+
+	cfg, err := config.Load(path)
+	require.NoError(t, err)
+
+	proxy := dnsproxy.New(cfg, &MockFirewallBackend{})
+
+	writeConfig(t, path, reloadBaseConfig+`hosts:
+  - pattern: nas.lan
+    a: ["not-an-ip"]
+`)
+	require.Error(t, proxy.ReloadFile(context.Background()))
+
+	assert.Empty(t, proxy.GetHosts())
+
+	_, ok := proxy.Rules().Find("www.example.com")
+	assert.True(t, ok, "running rules are kept")
+}
+
+func TestProxyReloadKeepsConfigOnInvalidHosts(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, reloadBaseConfig)
+
+	cfg, err := config.Load(path)
+	require.NoError(t, err)
+
+	proxy := dnsproxy.New(cfg, &MockFirewallBackend{})
+
+	next, err := config.Load(path)
+	require.NoError(t, err)
+
+	next.RuleGroups[0].Via = "wg1"
+	next.Hosts = []config.HostOverride{{Pattern: "nas.lan", A: []string{"not-an-ip"}}}
+
+	require.Error(t, proxy.Reload(context.Background(), next))
+
+	// Sections before the invalid one are not applied either
+	assert.Equal(t, "wg0", proxy.GetConfig().RuleGroups[0].Via)
+	assert.Equal(t, "wg0", proxy.GetRuleGroups()[0].Via)
+	assert.Empty(t, proxy.GetConfig().Hosts)
+}
+
+func TestProxyReloadConcurrentWithAPI(t *testing.T) {
+	t.Parallel()
+
+	newConfig := func(via string) *config.Config {
+		return &config.Config{
+			Path:   filepath.Join(os.TempDir(), "outway-reload-concurrent.yaml"),
+			Listen: config.ListenConfig{UDP: ":5353", TCP: ":5353"},
+			Upstreams: []config.UpstreamConfig{
+				{Name: "cloudflare", Address: "1.1.1.1:53", Type: "udp", Weight: 1},
+			},
+			RuleGroups: []config.RuleGroup{
+				{Name: "video", Via: via, Patterns: []string{"*.example.com"}},
+			},
+		}
+	}
+
+	proxy := dnsproxy.New(newConfig("wg0"), &MockFirewallBackend{})
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+
+	for i := range 4 {
+		wg.Go(func() {
+			for j := range 10 {
+				assert.NoError(t, proxy.Reload(ctx, newConfig(fmt.Sprintf("wg%d", (i+j)%2))))
+			}
+		})
+
+		wg.Go(func() {
+			for j := range 10 {
+				ups := []config.UpstreamConfig{{Name: "google", Address: "8.8.8.8:53", Weight: i*10 + j + 1}}
+				assert.NoError(t, proxy.SetUpstreamsConfig(ctx, ups))
+				assert.NoError(t, proxy.SetHosts(ctx, []config.HostOverride{{Pattern: "nas.lan", A: []string{"192.168.1.10"}}}))
+
+				_ = proxy.Marks()
+			}
+		})
+	}
+
+	wg.Wait()
+
+	via := proxy.GetRuleGroups()[0].Via
+	assert.Contains(t, []string{"wg0", "wg1"}, via)
+	assert.Equal(t, via, proxy.GetConfig().RuleGroups[0].Via)
+}
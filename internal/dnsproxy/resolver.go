@@ -5,6 +5,8 @@ import (
 	"net/http"
 
 	"github.com/miekg/dns"
+
+	"github.com/bavix/outway/internal/dnstap"
 )
 
 // Resolver is a pluggable DNS resolution pipeline component.
@@ -24,4 +26,5 @@ type StrategyDeps struct {
 	TCP         *dns.Client
 	DoH         *http.Client
 	ExchangeDoH func(msg *dns.Msg, url string) (*dns.Msg, error)
+	Tap         *dnstap.Tap // receives forwarder messages (optional)
 }
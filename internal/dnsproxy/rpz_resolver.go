@@ -0,0 +1,229 @@
+package dnsproxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/rs/zerolog"
+
+	"github.com/bavix/outway/internal/metrics"
+	"github.com/bavix/outway/internal/rpz"
+)
+
+// ErrQueryDropped is returned when a policy requires the query to be left unanswered.
+var ErrQueryDropped = errors.New("query dropped by policy")
+
+const (
+	sourceRPZ     = "rpz"
+	defaultRPZTTL = 60
+)
+
+// RPZResolver applies response policy zones. QNAME triggers are checked before
+// resolving; response IP and NSDNAME triggers are checked on the answer from Next.
+type RPZResolver struct {
+	Next   Resolver
+	Policy *rpz.Engine
+}
+
+func (r *RPZResolver) Resolve(ctx context.Context, q *dns.Msg) (*dns.Msg, string, error) {
+	if q == nil || len(q.Question) == 0 || r.Policy == nil {
+		return r.Next.Resolve(ctx, q)
+	}
+
+	name := strings.ToLower(strings.TrimSuffix(q.Question[0].Name, "."))
+
+	if hit, ok := r.Policy.MatchQName(name); ok {
+		if hit.Action == rpz.ActionPassthru {
+			r.record(ctx, name, hit)
+
+			return r.Next.Resolve(ctx, q)
+		}
+
+		return r.apply(ctx, q, name, hit)
+	}
+
+	resp, src, err := r.Next.Resolve(ctx, q)
+	if err != nil || resp == nil {
+		return resp, src, err
+	}
+
+	if hit, ok := r.matchResponse(ctx, resp, name); ok {
+		if hit.Action == rpz.ActionPassthru {
+			r.record(ctx, name, hit)
+
+			return resp, src, nil
+		}
+
+		return r.apply(ctx, q, name, hit)
+	}
+
+	return resp, src, nil
+}
+
+// matchResponse checks answer addresses and, when any zone has NSDNAME
+// triggers, the name servers of the queried domain.
+func (r *RPZResolver) matchResponse(ctx context.Context, resp *dns.Msg, name string) (rpz.Hit, bool) {
+	if r.Policy.HasIP() {
+		for _, rr := range resp.Answer {
+			var ip net.IP
+
+			switch v := rr.(type) {
+			case *dns.A:
+				ip = v.A
+			case *dns.AAAA:
+				ip = v.AAAA
+			default:
+				continue
+			}
+
+			if hit, ok := r.Policy.MatchIP(ip); ok {
+				return hit, true
+			}
+		}
+	}
+
+	if r.Policy.HasNSDName() {
+		for _, ns := range r.nameServers(ctx, resp, name) {
+			if hit, ok := r.Policy.MatchNSDName(ns); ok {
+				return hit, true
+			}
+		}
+	}
+
+	return rpz.Hit{}, false
+}
+
+// nameServers returns NS names for the queried domain. Authority data in the
+// response is used when present; otherwise the NS set is looked up through
+// Next (and therefore cached), falling back to the zone named by an SOA.
+func (r *RPZResolver) nameServers(ctx context.Context, resp *dns.Msg, name string) []string {
+	if names := nsNames(resp); len(names) > 0 {
+		return names
+	}
+
+	lookup := func(zone string) (*dns.Msg, bool) {
+		q := new(dns.Msg)
+		q.SetQuestion(dns.Fqdn(zone), dns.TypeNS)
+
+		out, _, err := r.Next.Resolve(ctx, q)
+
+		return out, err == nil && out != nil
+	}
+
+	out, ok := lookup(name)
+	if !ok {
+		return nil
+	}
+
+	if names := nsNames(out); len(names) > 0 {
+		return names
+	}
+
+	for _, rr := range out.Ns {
+		if soa, isSOA := rr.(*dns.SOA); isSOA && !strings.EqualFold(soa.Hdr.Name, dns.Fqdn(name)) {
+			if parent, ok := lookup(soa.Hdr.Name); ok {
+				return nsNames(parent)
+			}
+		}
+	}
+
+	return nil
+}
+
+func nsNames(m *dns.Msg) []string {
+	var names []string
+
+	for _, section := range [][]dns.RR{m.Answer, m.Ns} {
+		for _, rr := range section {
+			if ns, ok := rr.(*dns.NS); ok {
+				names = append(names, strings.ToLower(strings.TrimSuffix(ns.Ns, ".")))
+			}
+		}
+	}
+
+	return names
+}
+
+func (r *RPZResolver) apply(ctx context.Context, q *dns.Msg, name string, hit rpz.Hit) (*dns.Msg, string, error) {
+	r.record(ctx, name, hit)
+
+	m := new(dns.Msg)
+	m.SetReply(q)
+	m.RecursionAvailable = true
+
+	switch hit.Action {
+	case rpz.ActionNXDomain:
+		m.Rcode = dns.RcodeNameError
+	case rpz.ActionNoData:
+	case rpz.ActionDrop:
+		return nil, sourceRPZ, ErrQueryDropped
+	case rpz.ActionLocalData:
+		m.Answer = r.localData(ctx, q, hit.Data)
+	case rpz.ActionPassthru:
+		// handled by the caller
+	}
+
+	return m, sourceRPZ, nil
+}
+
+// localData rewrites policy records to the query name. A CNAME is chased
+// through Next so clients get the final addresses.
+func (r *RPZResolver) localData(ctx context.Context, q *dns.Msg, data []dns.RR) []dns.RR {
+	question := q.Question[0]
+
+	var (
+		answer []dns.RR
+		cname  *dns.CNAME
+	)
+
+	for _, rr := range data {
+		hdr := rr.Header()
+		if hdr.Rrtype != question.Qtype && hdr.Rrtype != dns.TypeCNAME {
+			continue
+		}
+
+		cp := dns.Copy(rr)
+		cp.Header().Name = question.Name
+		cp.Header().Ttl = defaultRPZTTL
+
+		if c, ok := cp.(*dns.CNAME); ok {
+			// "*.target" local data points at the query name under target.
+			if base, wild := strings.CutPrefix(c.Target, "*."); wild {
+				c.Target = dns.Fqdn(strings.TrimSuffix(question.Name, ".") + "." + base)
+			}
+
+			cname = c
+		}
+
+		answer = append(answer, cp)
+	}
+
+	if cname == nil || question.Qtype == dns.TypeCNAME {
+		return answer
+	}
+
+	chase := new(dns.Msg)
+	chase.SetQuestion(cname.Target, question.Qtype)
+	chase.RecursionDesired = true
+
+	if resp, _, err := r.Next.Resolve(ctx, chase); err == nil && resp != nil {
+		answer = append(answer, resp.Answer...)
+	}
+
+	return answer
+}
+
+func (r *RPZResolver) record(ctx context.Context, name string, hit rpz.Hit) {
+	metrics.IncPolicyHit(hit.Zone, string(hit.Trigger), string(hit.Action))
+	QueryTraceFromContext(ctx).SetPolicy(hit.Zone, string(hit.Action))
+
+	zerolog.Ctx(ctx).Debug().
+		Str("query", name).
+		Str("zone", hit.Zone).
+		Str("trigger", string(hit.Trigger)).
+		Str("action", string(hit.Action)).
+		Msg("rpz rule matched")
+}
@@ -0,0 +1,151 @@
+package dnsproxy_test
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bavix/outway/internal/config"
+	"github.com/bavix/outway/internal/dnsproxy"
+	"github.com/bavix/outway/internal/rpz"
+)
+
+const testPolicy = `$ORIGIN rpz.test.
+blocked.com          CNAME .
+empty.com            CNAME *.
+silent.com           CNAME rpz-drop.
+allowed.com          CNAME rpz-passthru.
+walled.com           A     10.0.0.1
+garden.com           CNAME walled.net.
+32.9.9.51.198.rpz-ip CNAME .
+ns.evil.net.rpz-nsdname CNAME .
+`
+
+func newRPZResolver(t *testing.T) *dnsproxy.RPZResolver {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "policy.zone")
+	require.NoError(t, os.WriteFile(path, []byte(testPolicy), 0o600))
+
+	engine := rpz.NewEngine()
+	engine.SetZones([]config.RPZConfig{{Name: "rpz.test", File: path}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	engine.Start(ctx)
+	require.Eventually(t, func() bool {
+		_, ok := engine.MatchQName("blocked.com")
+
+		return ok
+	}, 2*time.Second, 10*time.Millisecond)
+
+	next := &MockResolver{resolveFunc: func(_ context.Context, q *dns.Msg) (*dns.Msg, string, error) {
+		m := new(dns.Msg)
+		m.SetReply(q)
+
+		name := q.Question[0].Name
+		hdr := dns.RR_Header{Name: name, Rrtype: q.Question[0].Qtype, Class: dns.ClassINET, Ttl: 60}
+
+		switch {
+		case q.Question[0].Qtype == dns.TypeNS && name == "shady.com.":
+			m.Answer = append(m.Answer, &dns.NS{Hdr: hdr, Ns: "ns.evil.net."})
+		case q.Question[0].Qtype == dns.TypeNS:
+		case name == "bad-ip.com.":
+			m.Answer = append(m.Answer, &dns.A{Hdr: hdr, A: net.ParseIP("198.51.9.9")})
+		default:
+			m.Answer = append(m.Answer, &dns.A{Hdr: hdr, A: net.ParseIP("192.0.2.10")})
+		}
+
+		return m, "mock", nil
+	}}
+
+	return &dnsproxy.RPZResolver{Next: next, Policy: engine}
+}
+
+func TestRPZResolver_Actions(t *testing.T) {
+	t.Parallel()
+
+	r := newRPZResolver(t)
+
+	query := func(name string, qtype uint16) (*dns.Msg, string, error) {
+		q := new(dns.Msg)
+		q.SetQuestion(name, qtype)
+
+		return r.Resolve(context.Background(), q)
+	}
+
+	msg, src, err := query("blocked.com.", dns.TypeA)
+	require.NoError(t, err)
+	assert.Equal(t, "rpz", src)
+	assert.Equal(t, dns.RcodeNameError, msg.Rcode)
+
+	msg, _, err = query("empty.com.", dns.TypeA)
+	require.NoError(t, err)
+	assert.Equal(t, dns.RcodeSuccess, msg.Rcode)
+	assert.Empty(t, msg.Answer)
+
+	_, _, err = query("silent.com.", dns.TypeA)
+	require.ErrorIs(t, err, dnsproxy.ErrQueryDropped)
+
+	_, src, err = query("allowed.com.", dns.TypeA)
+	require.NoError(t, err)
+	assert.Equal(t, "mock", src)
+
+	msg, _, err = query("walled.com.", dns.TypeA)
+	require.NoError(t, err)
+	require.Len(t, msg.Answer, 1)
+	assert.Equal(t, "walled.com.", msg.Answer[0].Header().Name)
+	assert.Equal(t, "10.0.0.1", msg.Answer[0].(*dns.A).A.String())
+
+	msg, _, err = query("walled.com.", dns.TypeAAAA)
+	require.NoError(t, err)
+	assert.Empty(t, msg.Answer)
+
+	msg, _, err = query("garden.com.", dns.TypeA)
+	require.NoError(t, err)
+	require.Len(t, msg.Answer, 2, "CNAME plus chased target")
+	assert.Equal(t, "walled.net.", msg.Answer[0].(*dns.CNAME).Target)
+	assert.Equal(t, "walled.net.", msg.Answer[1].Header().Name)
+}
+
+func TestRPZResolver_ResponseTriggers(t *testing.T) {
+	t.Parallel()
+
+	r := newRPZResolver(t)
+
+	q := new(dns.Msg)
+	q.SetQuestion("bad-ip.com.", dns.TypeA)
+
+	ctx, trace := dnsproxy.WithQueryTrace(context.Background())
+
+	msg, src, err := r.Resolve(ctx, q)
+	require.NoError(t, err)
+	assert.Equal(t, "rpz", src)
+	assert.Equal(t, dns.RcodeNameError, msg.Rcode)
+
+	zone, action := trace.Policy()
+	assert.Equal(t, "rpz.test", zone)
+	assert.Equal(t, string(rpz.ActionNXDomain), action)
+
+	q.SetQuestion("shady.com.", dns.TypeA)
+
+	msg, src, err = r.Resolve(context.Background(), q)
+	require.NoError(t, err)
+	assert.Equal(t, "rpz", src)
+	assert.Equal(t, dns.RcodeNameError, msg.Rcode)
+
+	q.SetQuestion("fine.com.", dns.TypeA)
+
+	msg, src, err = r.Resolve(context.Background(), q)
+	require.NoError(t, err)
+	assert.Equal(t, "mock", src)
+	assert.Len(t, msg.Answer, 1)
+}
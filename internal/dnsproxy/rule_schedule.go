@@ -0,0 +1,91 @@
+package dnsproxy
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ruleScheduleInterval is how often schedule windows of rule groups are checked.
+const ruleScheduleInterval = time.Minute
+
+// groupStates remembers which rule groups were active and their patterns, so
+// changes in either direction are noticed, including of removed groups.
+type groupStates struct {
+	mu       sync.Mutex
+	active   map[string]bool
+	patterns map[string][]string
+}
+
+// update records the groups of store at t and returns the patterns of groups
+// that turned active or inactive since the last update.
+func (g *groupStates) update(store *RuleStore, t time.Time) []string {
+	active := store.ActiveGroups(t)
+	patterns := store.GroupPatterns()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	// Groups seen before or now; removed groups count as turned inactive
+	groups := make(map[string][]string, len(patterns))
+	maps.Copy(groups, g.patterns)
+	maps.Copy(groups, patterns)
+
+	var changed []string
+
+	for group := range groups {
+		if g.active[group] != active[group] {
+			changed = append(changed, g.patterns[group]...)
+			changed = append(changed, patterns[group]...)
+		}
+	}
+
+	g.active, g.patterns = active, patterns
+
+	return changed
+}
+
+// UnmarkInactiveGroups drops the marks of addresses routed only by rule groups
+// that are disabled, outside their schedule or gone, so their traffic stops
+// using the group's interface right away instead of when the marks expire.
+// Cached answers of groups that turned active or inactive are dropped as well:
+// marks are placed below the cache, so only a fresh resolution marks a name of
+// a group that is switched back on.
+func (p *Proxy) UnmarkInactiveGroups(ctx context.Context) {
+	store := p.rules.GetRules()
+	now := time.Now()
+
+	if changed := p.groups.update(store, now); len(changed) > 0 && p.cache != nil {
+		p.cache.GetCache().DeleteMatching(func(name string) bool {
+			return slices.ContainsFunc(changed, func(pattern string) bool { return matchDomainPattern(pattern, name) })
+		})
+	}
+
+	mark := p.asyncMarkRes.Load()
+	if mark == nil {
+		return
+	}
+
+	active := store.ActiveGroups(now)
+	mark.unmarkGroups(ctx, func(group string) bool { return active[group] })
+}
+
+// startRuleSchedules applies schedule windows of rule groups as they open and
+// close.
+func (p *Proxy) startRuleSchedules(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(ruleScheduleInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				p.UnmarkInactiveGroups(ctx)
+			}
+		}
+	}()
+}
@@ -0,0 +1,232 @@
+package dnsproxy_test
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bavix/outway/internal/config"
+	"github.com/bavix/outway/internal/dnsproxy"
+	"github.com/bavix/outway/internal/metrics"
+)
+
+const ruleScheduleConfig = `listen:
+  udp: ":5353"
+  tcp: ":5353"
+upstreams:
+  - name: cloudflare
+    address: 1.1.1.1:53
+cache:
+  enabled: true
+rule_groups:
+  - name: lab
+    via: wg0
+    patterns: ["*.example.com"]
+hosts:
+  - pattern: box.example.com
+    a: ["10.0.0.5"]
+    ttl: 300
+`
+
+// setGroupEnabled reloads the config at path with the named rule groups
+// switched off, or on when enabled.
+func setGroupEnabled(t *testing.T, proxy *dnsproxy.Proxy, path string, enabled bool, names ...string) {
+	t.Helper()
+
+	next, err := config.Load(path)
+	require.NoError(t, err)
+
+	for i := range next.RuleGroups {
+		if slices.Contains(names, next.RuleGroups[i].Name) {
+			next.RuleGroups[i].Enabled = &enabled
+		}
+	}
+
+	require.NoError(t, proxy.Reload(context.Background(), next))
+}
+
+func resolveA(t *testing.T, proxy *dnsproxy.Proxy, name string) {
+	t.Helper()
+
+	q := new(dns.Msg)
+	q.SetQuestion(name, dns.TypeA)
+
+	_, _, err := proxy.ResolverActive().Resolve(context.Background(), q)
+	require.NoError(t, err)
+}
+
+func TestProxyRuleGroupReenabledMarksCachedNames(t *testing.T) {
+	metrics.BindService() // the pipeline counts queries; bound before tests run in parallel
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, ruleScheduleConfig)
+
+	cfg, err := config.Load(path)
+	require.NoError(t, err)
+
+	backend := &recordingBackend{}
+	proxy := dnsproxy.New(cfg, backend)
+
+	// Answered and cached while the group is off, so nothing is marked
+	setGroupEnabled(t, proxy, path, false, "lab")
+	resolveA(t, proxy, "box.example.com.")
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Empty(t, backend.Marks())
+
+	// The cached answer is dropped once the group is back on
+	setGroupEnabled(t, proxy, path, true, "lab")
+	resolveA(t, proxy, "box.example.com.")
+
+	require.Eventually(t, func() bool {
+		return len(backend.Marks()) == 1
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"wg0/10.0.0.5"}, backend.Marks())
+}
+
+// unmarkingBackend records marks and unmarks.
+type unmarkingBackend struct {
+	recordingBackend
+
+	mu      sync.Mutex
+	unmarks []string
+}
+
+func (b *unmarkingBackend) UnmarkIP(_ context.Context, iface, ip string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.unmarks = append(b.unmarks, iface+"/"+ip)
+
+	return nil
+}
+
+func (b *unmarkingBackend) Unmarks() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return slices.Clone(b.unmarks)
+}
+
+func TestProxyUnmarkInactiveGroups(t *testing.T) {
+	metrics.BindService() // the pipeline counts queries; bound before tests run in parallel
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, ruleScheduleConfig+`  - pattern: box.example.org
+    a: ["10.0.0.5"]
+  - pattern: nas.example.com
+    a: ["10.0.0.6"]
+`)
+
+	cfg, err := config.Load(path)
+	require.NoError(t, err)
+
+	cfg.RuleGroups = append(cfg.RuleGroups, config.RuleGroup{Name: "shared", Via: "wg0", Patterns: []string{"*.example.org"}})
+	require.NoError(t, cfg.Save())
+
+	backend := &unmarkingBackend{}
+	proxy := dnsproxy.New(cfg, backend)
+
+	for _, name := range []string{"box.example.com.", "box.example.org.", "nas.example.com."} {
+		resolveA(t, proxy, name)
+	}
+
+	require.Eventually(t, func() bool { return len(proxy.Marks()) == 2 }, 2*time.Second, 10*time.Millisecond)
+
+	// 10.0.0.5 is still routed by the shared group, 10.0.0.6 only by lab
+	setGroupEnabled(t, proxy, path, false, "lab")
+
+	assert.Equal(t, []string{"wg0/10.0.0.6"}, backend.Unmarks())
+
+	marks := proxy.Marks()
+	require.Len(t, marks, 1)
+	assert.Equal(t, "10.0.0.5", marks[0].IP)
+	assert.Equal(t, []string{"shared"}, marks[0].Groups)
+
+	// Without any group left the shared address goes as well
+	setGroupEnabled(t, proxy, path, false, "lab", "shared")
+
+	assert.Equal(t, []string{"wg0/10.0.0.6", "wg0/10.0.0.5"}, backend.Unmarks())
+	assert.Empty(t, proxy.Marks())
+
+	// Switched back on, the names are marked again on their next query
+	setGroupEnabled(t, proxy, path, true, "lab", "shared")
+
+	for _, name := range []string{"box.example.com.", "nas.example.com."} {
+		resolveA(t, proxy, name)
+	}
+
+	require.Eventually(t, func() bool { return len(proxy.Marks()) == 2 }, 2*time.Second, 10*time.Millisecond)
+	assert.Len(t, backend.Marks(), 4)
+}
+
+func TestProxyRuleGroupEnabledAndSchedule(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, persistRulesConfig+`  - name: paused
+    via: wg2
+    enabled: false
+    patterns: ["*.paused.example"]
+  - name: allday
+    via: wg3
+    schedule: ["00:00-24:00"]
+    patterns: ["*.allday.example"]
+`)
+
+	cfg, err := config.Load(path)
+	require.NoError(t, err)
+
+	proxy := dnsproxy.New(cfg, &MockFirewallBackend{})
+
+	_, ok := proxy.Rules().Find("www.paused.example")
+	assert.False(t, ok)
+	assert.Empty(t, proxy.Rules().FindIface("www.paused.example"))
+	assert.Equal(t, "wg3", proxy.Rules().FindIface("www.allday.example"))
+
+	active := proxy.Rules().ActiveGroups(time.Now())
+	assert.False(t, active["paused"])
+	assert.True(t, active["allday"])
+	assert.True(t, active["video"])
+
+	want := cfg.RuleGroups
+	require.NoError(t, proxy.PersistRules(context.Background()))
+
+	saved, err := config.Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, want, saved.RuleGroups)
+}
+
+func TestRuleStoreFindAt(t *testing.T) {
+	t.Parallel()
+
+	office, err := config.ParseSchedule([]string{"mon-fri 09:00-18:00"})
+	require.NoError(t, err)
+
+	rules := dnsproxy.NewRuleStore([]config.Rule{
+		{Group: "office", Pattern: "*.corp.example", Via: "wg1", Schedule: office},
+		{Group: "fallback", Pattern: "*.example", Via: "wg0"},
+	})
+
+	monday := time.Date(2026, time.October, 19, 10, 0, 0, 0, time.Local)
+
+	rule, ok := rules.FindAt("vpn.corp.example", monday)
+	require.True(t, ok)
+	assert.Equal(t, "office", rule.Group)
+
+	rule, ok = rules.FindAt("vpn.corp.example", monday.Add(-48*time.Hour))
+	require.True(t, ok)
+	assert.Equal(t, "fallback", rule.Group, "outside the window the next rule matches")
+
+	_, ok = rules.FindAt("example.org", monday)
+	assert.False(t, ok)
+}
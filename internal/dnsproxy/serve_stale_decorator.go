@@ -2,8 +2,6 @@ package dnsproxy
 
 import (
 	"context"
-	"strconv"
-	"strings"
 	"time"
 
 	"github.com/miekg/dns"
@@ -20,12 +18,18 @@ func (s *ServeStaleResolver) Resolve(ctx context.Context, q *dns.Msg) (*dns.Msg,
 		return s.Cache.Next.Resolve(ctx, q)
 	}
 
-	key := strings.ToLower(strings.TrimSuffix(q.Question[0].Name, ".")) + ":" + strconv.FormatUint(uint64(q.Question[0].Qtype), 10)
+	// Dry runs must not refresh entries without marking their answers
+	if QueryTraceFromContext(ctx).DryRun() {
+		return s.Cache.Resolve(ctx, q)
+	}
+
+	key := s.Cache.key(q)
 
 	// Try to read from cache, even if expired
 	if it, ok := s.Cache.lru.Get(key); ok {
-		// If not expired, let cache resolver handle it normally for metrics and reuse
-		if !nowAfter(it.expire) {
+		// If not expired, let cache resolver handle it normally for metrics and reuse.
+		// Stale failures are not worth serving: retry them right away.
+		if !nowAfter(it.expire) || it.msg.Rcode == dns.RcodeServerFailure {
 			return s.Cache.Resolve(ctx, q)
 		}
 
@@ -38,16 +42,7 @@ func (s *ServeStaleResolver) Resolve(ctx context.Context, q *dns.Msg) (*dns.Msg,
 			})
 		}()
 
-		reply := new(dns.Msg)
-		reply.SetReply(q)
-		reply.RecursionAvailable = it.msg.RecursionAvailable
-		reply.Authoritative = it.msg.Authoritative
-		reply.Rcode = it.msg.Rcode
-		reply.Answer = it.msg.Answer
-		reply.Ns = it.msg.Ns
-		reply.Extra = it.msg.Extra
-
-		return reply, sourceCache, nil
+		return cachedReply(q, it.msg), sourceCache, nil
 	}
 
 	// Miss: fall through to cache resolver which will populate on success
@@ -5,5 +5,5 @@ type TCPStrategy struct{}
 
 func (TCPStrategy) Supports(t string) bool { return t == protocolTCP }
 func (TCPStrategy) NewResolver(t, address string, deps StrategyDeps) *UpstreamResolver {
-	return &UpstreamResolver{client: deps.TCP, network: t, address: address}
+	return &UpstreamResolver{client: deps.TCP, network: t, address: address, tap: deps.Tap}
 }
@@ -5,5 +5,5 @@ type UDPStrategy struct{}
 
 func (UDPStrategy) Supports(t string) bool { return t == protocolUDP }
 func (UDPStrategy) NewResolver(t, address string, deps StrategyDeps) *UpstreamResolver {
-	return &UpstreamResolver{client: deps.UDP, network: t, address: address}
+	return &UpstreamResolver{client: deps.UDP, network: t, address: address, tap: deps.Tap}
 }
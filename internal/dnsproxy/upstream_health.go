@@ -0,0 +1,201 @@
+package dnsproxy
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/bavix/outway/internal/config"
+)
+
+// unhealthyAfter is the number of failed queries in a row after which an
+// upstream is reported unhealthy.
+const unhealthyAfter = 3
+
+// fallbackUpstreams answer queries when no upstream resolver could be built.
+var fallbackUpstreams = []string{"udp:8.8.8.8:53", "udp:1.1.1.1:53"} //nolint:gochecknoglobals // fixed list of fallback upstreams
+
+// UpstreamHealth reports how an upstream answered the queries sent to it.
+type UpstreamHealth struct {
+	Name                string    `json:"name"`
+	Address             string    `json:"address"`
+	Healthy             bool      `json:"healthy"`
+	Queries             uint64    `json:"queries"`
+	Failures            uint64    `json:"failures"`
+	ConsecutiveFailures uint64    `json:"consecutive_failures"`
+	AvgRTTMs            float64   `json:"avg_rtt_ms"`
+	LastError           string    `json:"last_error,omitempty"`
+	LastSuccess         time.Time `json:"last_success,omitzero"`
+	LastFailure         time.Time `json:"last_failure,omitzero"`
+}
+
+type upstreamStats struct {
+	queries, failures, consecutive uint64
+	rtt                            time.Duration // sum over successful queries
+	lastError                      string
+	lastSuccess, lastFailure       time.Time
+}
+
+// upstreamHealth collects query outcomes per upstream key. It outlives
+// pipeline rebuilds. All methods are safe on a nil receiver.
+type upstreamHealth struct {
+	mu    sync.Mutex
+	stats map[string]*upstreamStats
+}
+
+func newUpstreamHealth() *upstreamHealth {
+	return &upstreamHealth{stats: make(map[string]*upstreamStats)}
+}
+
+func (h *upstreamHealth) record(key string, rtt time.Duration, err error) {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.stats[key]
+	if !ok {
+		s = &upstreamStats{}
+		h.stats[key] = s
+	}
+
+	s.queries++
+
+	if err != nil {
+		s.failures++
+		s.consecutive++
+		s.lastError = err.Error()
+		s.lastFailure = time.Now()
+
+		return
+	}
+
+	s.consecutive = 0
+	s.rtt += rtt
+	s.lastSuccess = time.Now()
+}
+
+// report returns the health of the upstream recorded under key; upstreams
+// without queries yet are healthy.
+func (h *upstreamHealth) report(key, name, address string) UpstreamHealth {
+	out := UpstreamHealth{Name: name, Address: address, Healthy: true}
+
+	if h == nil {
+		return out
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.stats[key]
+	if !ok {
+		return out
+	}
+
+	out.Healthy = s.consecutive < unhealthyAfter
+	out.Queries, out.Failures, out.ConsecutiveFailures = s.queries, s.failures, s.consecutive
+	out.LastError, out.LastSuccess, out.LastFailure = s.lastError, s.lastSuccess, s.lastFailure
+
+	if answered := s.queries - s.failures; answered > 0 {
+		out.AvgRTTMs = float64(s.rtt) / float64(answered) / float64(time.Millisecond)
+	}
+
+	return out
+}
+
+// healthResolver records the outcome of every query to an upstream.
+type healthResolver struct {
+	Next   Resolver
+	key    string
+	health *upstreamHealth
+}
+
+func (h *healthResolver) Resolve(ctx context.Context, q *dns.Msg) (*dns.Msg, string, error) {
+	start := time.Now()
+	out, src, err := h.Next.Resolve(ctx, q)
+
+	// Queries given up by the client say nothing about the upstream
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return out, src, err
+	}
+
+	if err == nil && out == nil {
+		err = errNoAnswer
+	}
+
+	h.health.record(h.key, time.Since(start), err)
+
+	return out, src, err
+}
+
+// UpstreamHealth returns the health of the upstreams in use in config order,
+// or of the fallback upstreams when none is configured.
+func (p *Proxy) UpstreamHealth() []UpstreamHealth {
+	ups := p.upstreams.GetUpstreams()
+	if len(ups) == 0 {
+		ups = rawUpstreams(fallbackUpstreams)
+	}
+
+	out := make([]UpstreamHealth, 0, len(ups))
+
+	for _, u := range keyUpstreams(ups) {
+		out = append(out, p.health.report(u.key, upstreamName(u.UpstreamConfig), u.Address))
+	}
+
+	return out
+}
+
+// upstreamName identifies an upstream in health reports.
+func upstreamName(u config.UpstreamConfig) string {
+	if u.Name != "" {
+		return u.Name
+	}
+
+	return u.Address
+}
+
+// keyedUpstream is an upstream with the key its health is recorded under.
+type keyedUpstream struct {
+	config.UpstreamConfig
+
+	key string
+}
+
+// keyUpstreams pairs ups with their health keys. Upstreams are told apart by
+// name, type and address, and entries alike in all three by their position,
+// so upstreams sharing an address keep their own stats.
+func keyUpstreams(ups []config.UpstreamConfig) []keyedUpstream {
+	out := make([]keyedUpstream, len(ups))
+	seen := make(map[string]int, len(ups))
+
+	for i, u := range ups {
+		key := u.Name + "|" + u.Type + ":" + u.Address
+		if n := seen[key]; n > 0 {
+			out[i] = keyedUpstream{UpstreamConfig: u, key: key + "#" + strconv.Itoa(n)}
+		} else {
+			out[i] = keyedUpstream{UpstreamConfig: u, key: key}
+		}
+
+		seen[key]++
+	}
+
+	return out
+}
+
+// rawUpstreams turns "type:address" upstreams into upstream configs.
+func rawUpstreams(raw []string) []config.UpstreamConfig {
+	out := make([]config.UpstreamConfig, 0, len(raw))
+
+	for _, r := range raw {
+		netw, addr := parseUpstream(r)
+		out = append(out, config.UpstreamConfig{Type: netw, Address: addr})
+	}
+
+	return out
+}
@@ -0,0 +1,100 @@
+package dnsproxy_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bavix/outway/internal/config"
+	"github.com/bavix/outway/internal/dnsproxy"
+	"github.com/bavix/outway/internal/metrics"
+)
+
+func TestProxyUpstreamHealth(t *testing.T) {
+	metrics.BindService() // the pipeline counts queries; bound before tests run in parallel
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, `listen:
+  udp: ":5353"
+  tcp: ":5353"
+cache:
+  enabled: false
+upstreams:
+  - name: closed
+    address: 127.0.0.1:1
+    type: udp
+`)
+
+	cfg, err := config.Load(path)
+	require.NoError(t, err)
+
+	proxy := dnsproxy.New(cfg, &MockFirewallBackend{})
+
+	health := proxy.UpstreamHealth()
+	require.Len(t, health, 1)
+	assert.Equal(t, "closed", health[0].Name)
+	assert.True(t, health[0].Healthy, "upstreams without queries are healthy")
+
+	q := new(dns.Msg)
+	q.SetQuestion("example.org.", dns.TypeA)
+
+	for range 3 {
+		_, _, err = proxy.ResolverActive().Resolve(context.Background(), q)
+		require.Error(t, err)
+	}
+
+	health = proxy.UpstreamHealth()
+	require.Len(t, health, 1)
+	assert.False(t, health[0].Healthy)
+	assert.Equal(t, uint64(3), health[0].Queries)
+	assert.Equal(t, uint64(3), health[0].Failures)
+	assert.Equal(t, uint64(3), health[0].ConsecutiveFailures)
+	assert.NotEmpty(t, health[0].LastError)
+	assert.False(t, health[0].LastFailure.IsZero())
+}
+
+func TestProxyUpstreamHealthSharedAddress(t *testing.T) {
+	metrics.BindService() // the pipeline counts queries; bound before tests run in parallel
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, `listen:
+  udp: ":5353"
+  tcp: ":5353"
+cache:
+  enabled: false
+upstreams:
+  - name: closed
+    address: 127.0.0.1:1
+    type: udp
+  - name: closed
+    address: 127.0.0.1:1
+    type: tcp
+`)
+
+	cfg, err := config.Load(path)
+	require.NoError(t, err)
+
+	proxy := dnsproxy.New(cfg, &MockFirewallBackend{})
+
+	q := new(dns.Msg)
+	q.SetQuestion("example.org.", dns.TypeA)
+
+	_, _, err = proxy.ResolverActive().Resolve(context.Background(), q)
+	require.Error(t, err)
+
+	// Each upstream counts the query it was sent, not the one of the other
+	health := proxy.UpstreamHealth()
+	require.Len(t, health, 2)
+
+	for _, h := range health {
+		assert.Equal(t, "closed", h.Name)
+		assert.Equal(t, uint64(1), h.Queries)
+		assert.Equal(t, uint64(1), h.Failures)
+	}
+}
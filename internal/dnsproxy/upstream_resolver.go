@@ -3,9 +3,12 @@ package dnsproxy
 import (
 	"context"
 	"errors"
+	"time"
 
 	"github.com/miekg/dns"
 	"github.com/rs/zerolog"
+
+	"github.com/bavix/outway/internal/dnstap"
 )
 
 var errInvalidUpstreamClientOrQuery = errors.New("invalid upstream client or query")
@@ -16,10 +19,27 @@ type UpstreamResolver struct {
 	network  string
 	address  string
 	exchange func(*dns.Msg, string) (*dns.Msg, error)
+	tap      *dnstap.Tap
 }
 
-//nolint:cyclop
 func (u *UpstreamResolver) Resolve(ctx context.Context, q *dns.Msg) (*dns.Msg, string, error) {
+	if u.tap == nil || q == nil {
+		return u.resolve(ctx, q)
+	}
+
+	start := time.Now()
+	u.tapForwarder(dnstap.ForwarderQuery, q, nil, start)
+
+	out, src, err := u.resolve(ctx, q)
+	if out != nil {
+		u.tapForwarder(dnstap.ForwarderResponse, q, out, start)
+	}
+
+	return out, src, err
+}
+
+//nolint:cyclop
+func (u *UpstreamResolver) resolve(ctx context.Context, q *dns.Msg) (*dns.Msg, string, error) {
 	if u.exchange != nil {
 		if out, err := u.exchange(q, u.address); err == nil && out != nil {
 			return out, u.network + ":" + u.address, nil
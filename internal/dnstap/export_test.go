@@ -0,0 +1,25 @@
+package dnstap
+
+import "context"
+
+// OpenFileOutput opens the file output at path n times, as the tap does when
+// it reconnects after output errors, and returns the files written.
+func OpenFileOutput(ctx context.Context, path string, n int) ([]string, error) {
+	open := fileOpener(path)
+	names := make([]string, 0, n)
+
+	for range n {
+		out, err := open(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		names = append(names, out.file.Name())
+
+		if err := out.close(); err != nil {
+			return nil, err
+		}
+	}
+
+	return names, nil
+}
@@ -0,0 +1,82 @@
+package dnstap
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Frame Streams control frame types.
+const (
+	controlAccept = 0x01
+	controlStart  = 0x02
+	controlStop   = 0x03
+	controlReady  = 0x04
+	controlFinish = 0x05
+
+	controlFieldContentType = 0x01
+
+	contentType = "protobuf:dnstap.Dnstap"
+
+	// maxControlFrame bounds control frames read from collectors.
+	maxControlFrame = 512
+)
+
+var (
+	errUnexpectedControl = errors.New("unexpected frame streams control frame")
+	errControlTooLarge   = errors.New("frame streams control frame too large")
+)
+
+// dataFrame returns payload as a Frame Streams data frame.
+func dataFrame(payload []byte) []byte {
+	frame := make([]byte, 4, 4+len(payload))
+	binary.BigEndian.PutUint32(frame, uint32(len(payload))) //nolint:gosec // dnstap payloads are far below 4 GiB
+
+	return append(frame, payload...)
+}
+
+// controlFrame returns an escaped control frame, carrying the dnstap content
+// type except for STOP and FINISH.
+func controlFrame(typ uint32) []byte {
+	body := binary.BigEndian.AppendUint32(nil, typ)
+
+	if typ != controlStop && typ != controlFinish {
+		body = binary.BigEndian.AppendUint32(body, controlFieldContentType)
+		body = binary.BigEndian.AppendUint32(body, uint32(len(contentType)))
+		body = append(body, contentType...)
+	}
+
+	frame := binary.BigEndian.AppendUint32(nil, 0)                  // escape: zero-length data frame
+	frame = binary.BigEndian.AppendUint32(frame, uint32(len(body))) //nolint:gosec // fixed size
+
+	return append(frame, body...)
+}
+
+// readControl reads a control frame and checks its type.
+func readControl(r io.Reader, want uint32) error {
+	var hdr [8]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return fmt.Errorf("read control frame: %w", err)
+	}
+
+	size := binary.BigEndian.Uint32(hdr[4:])
+	if binary.BigEndian.Uint32(hdr[:4]) != 0 || size < 4 {
+		return errUnexpectedControl
+	}
+
+	if size > maxControlFrame {
+		return errControlTooLarge
+	}
+
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return fmt.Errorf("read control frame: %w", err)
+	}
+
+	if got := binary.BigEndian.Uint32(body); got != want {
+		return fmt.Errorf("%w: %d", errUnexpectedControl, got)
+	}
+
+	return nil
+}
@@ -0,0 +1,156 @@
+package dnstap
+
+import (
+	"net/netip"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// MessageType is the dnstap Message.Type of an event.
+type MessageType uint64
+
+const (
+	ClientQuery       MessageType = 5
+	ClientResponse    MessageType = 6
+	ForwarderQuery    MessageType = 7
+	ForwarderResponse MessageType = 8
+)
+
+// Protocol is the dnstap SocketProtocol a message travelled over.
+type Protocol uint64
+
+const (
+	UDP Protocol = 1
+	TCP Protocol = 2
+	DoT Protocol = 3
+	DoH Protocol = 4
+)
+
+const (
+	familyINET  = 1
+	familyINET6 = 2
+
+	// Dnstap.Type MESSAGE
+	dnstapTypeMessage = 1
+)
+
+// Field numbers of dnstap.proto.
+const (
+	fieldIdentity = 1
+	fieldVersion  = 2
+	fieldMessage  = 14
+	fieldType     = 15
+
+	fieldMsgType          = 1
+	fieldSocketFamily     = 2
+	fieldSocketProtocol   = 3
+	fieldQueryAddress     = 4
+	fieldResponseAddress  = 5
+	fieldQueryPort        = 6
+	fieldResponsePort     = 7
+	fieldQueryTimeSec     = 8
+	fieldQueryTimeNsec    = 9
+	fieldQueryMessage     = 10
+	fieldResponseTimeSec  = 12
+	fieldResponseTimeNsec = 13
+	fieldResponseMessage  = 14
+)
+
+// Message is one DNS message seen by the proxy. Query and Response hold
+// packed wire-format messages; unset fields are omitted.
+type Message struct {
+	Type         MessageType
+	Protocol     Protocol
+	QueryAddr    netip.AddrPort // the client, or the proxy for forwarder messages
+	ResponseAddr netip.AddrPort // the proxy, or the upstream for forwarder messages
+	QueryTime    time.Time
+	ResponseTime time.Time
+	Query        []byte
+	Response     []byte
+}
+
+// encode returns m wrapped in a dnstap.Dnstap protobuf.
+func encode(identity, version []byte, m Message) []byte {
+	var msg []byte
+
+	msg = appendVarint(msg, fieldMsgType, uint64(m.Type))
+
+	if family := socketFamily(m.QueryAddr, m.ResponseAddr); family != 0 {
+		msg = appendVarint(msg, fieldSocketFamily, family)
+	}
+
+	if m.Protocol != 0 {
+		msg = appendVarint(msg, fieldSocketProtocol, uint64(m.Protocol))
+	}
+
+	msg = appendAddr(msg, fieldQueryAddress, fieldQueryPort, m.QueryAddr)
+	msg = appendAddr(msg, fieldResponseAddress, fieldResponsePort, m.ResponseAddr)
+	msg = appendTime(msg, fieldQueryTimeSec, fieldQueryTimeNsec, m.QueryTime)
+	msg = appendBytes(msg, fieldQueryMessage, m.Query)
+	msg = appendTime(msg, fieldResponseTimeSec, fieldResponseTimeNsec, m.ResponseTime)
+	msg = appendBytes(msg, fieldResponseMessage, m.Response)
+
+	var out []byte
+
+	out = appendBytes(out, fieldIdentity, identity)
+	out = appendBytes(out, fieldVersion, version)
+	out = protowire.AppendTag(out, fieldMessage, protowire.BytesType)
+	out = protowire.AppendBytes(out, msg)
+	out = appendVarint(out, fieldType, dnstapTypeMessage)
+
+	return out
+}
+
+func socketFamily(addrs ...netip.AddrPort) uint64 {
+	for _, a := range addrs {
+		if !a.IsValid() {
+			continue
+		}
+
+		if a.Addr().Unmap().Is4() {
+			return familyINET
+		}
+
+		return familyINET6
+	}
+
+	return 0
+}
+
+func appendVarint(b []byte, field protowire.Number, v uint64) []byte {
+	b = protowire.AppendTag(b, field, protowire.VarintType)
+
+	return protowire.AppendVarint(b, v)
+}
+
+func appendBytes(b []byte, field protowire.Number, v []byte) []byte {
+	if len(v) == 0 {
+		return b
+	}
+
+	b = protowire.AppendTag(b, field, protowire.BytesType)
+
+	return protowire.AppendBytes(b, v)
+}
+
+func appendAddr(b []byte, addrField, portField protowire.Number, a netip.AddrPort) []byte {
+	if !a.IsValid() {
+		return b
+	}
+
+	b = appendBytes(b, addrField, a.Addr().Unmap().AsSlice())
+
+	return appendVarint(b, portField, uint64(a.Port()))
+}
+
+func appendTime(b []byte, secField, nsecField protowire.Number, t time.Time) []byte {
+	if t.IsZero() {
+		return b
+	}
+
+	b = appendVarint(b, secField, uint64(t.Unix())) //nolint:gosec // wall clock is after 1970
+	b = protowire.AppendTag(b, nsecField, protowire.Fixed32Type)
+
+	return protowire.AppendFixed32(b, uint32(t.Nanosecond())) //nolint:gosec // nanoseconds fit in 32 bits
+}
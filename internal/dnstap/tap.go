@@ -0,0 +1,357 @@
+// Package dnstap streams DNS messages to a dnstap collector over Frame Streams.
+package dnstap
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/bavix/outway/internal/config"
+	"github.com/bavix/outway/internal/version"
+)
+
+const (
+	defaultBufferSize = 4096
+	reconnectMin      = time.Second
+	reconnectMax      = 30 * time.Second
+	ioTimeout         = 5 * time.Second
+	dirPerm           = 0o750
+	filePerm          = 0o640
+)
+
+var errInvalidAddress = errors.New("invalid dnstap address")
+
+// output is an open Frame Streams session.
+type output struct {
+	w    *bufio.Writer
+	conn net.Conn // nil for files
+	file *os.File
+}
+
+func (o *output) write(frame []byte) error {
+	if o.conn != nil {
+		_ = o.conn.SetWriteDeadline(time.Now().Add(ioTimeout))
+	}
+
+	_, err := o.w.Write(frame)
+
+	return err
+}
+
+func (o *output) flush() error {
+	if o.conn != nil {
+		_ = o.conn.SetWriteDeadline(time.Now().Add(ioTimeout))
+	}
+
+	return o.w.Flush()
+}
+
+// close ends the session with STOP, waiting for FINISH on sockets.
+func (o *output) close() error {
+	err := o.write(controlFrame(controlStop))
+	if err == nil {
+		err = o.flush()
+	}
+
+	if o.file != nil {
+		return errors.Join(err, o.file.Close())
+	}
+
+	if err == nil {
+		_ = o.conn.SetReadDeadline(time.Now().Add(ioTimeout))
+		err = readControl(o.conn, controlFinish)
+	}
+
+	return errors.Join(err, o.conn.Close())
+}
+
+// abort drops a broken session without the closing handshake.
+func (o *output) abort() {
+	if o.file != nil {
+		_ = o.file.Close()
+	} else {
+		_ = o.conn.Close()
+	}
+}
+
+// Tap queues dnstap frames and delivers them from a single goroutine,
+// reconnecting to socket collectors as needed. All methods are safe on a nil
+// receiver, which sends nothing.
+type Tap struct {
+	frames   chan []byte
+	identity []byte
+	version  []byte
+	open     func(ctx context.Context) (*output, error)
+	log      *zerolog.Logger
+	dropped  atomic.Uint64
+
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// New starts delivery to cfg.Address. It returns nil when dnstap is disabled.
+// Socket collectors may come up later; files must be writable right away.
+func New(ctx context.Context, cfg config.DnstapConfig) (*Tap, error) {
+	if cfg.Address == "" {
+		return nil, nil //nolint:nilnil // dnstap disabled
+	}
+
+	u, err := url.Parse(cfg.Address)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidAddress, err)
+	}
+
+	t := &Tap{
+		identity: []byte(cfg.Identity),
+		version:  []byte("outway " + version.GetVersion()),
+		log:      zerolog.Ctx(ctx),
+		done:     make(chan struct{}),
+	}
+
+	if len(t.identity) == 0 {
+		host, _ := os.Hostname()
+		t.identity = []byte(host)
+	}
+
+	size := defaultBufferSize
+	if cfg.BufferSize > 0 {
+		size = cfg.BufferSize
+	}
+
+	t.frames = make(chan []byte, size)
+
+	var first *output
+
+	switch u.Scheme {
+	case "unix":
+		t.open = dialer("unix", u.Path)
+	case "tcp":
+		t.open = dialer("tcp", u.Host)
+	case "file":
+		t.open = fileOpener(u.Path)
+
+		if first, err = t.open(ctx); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s", errInvalidAddress, cfg.Address)
+	}
+
+	t.wg.Go(func() { t.run(ctx, first) })
+
+	return t, nil
+}
+
+// Send queues m. Messages are dropped rather than delaying queries when the
+// collector is slow or unreachable.
+func (t *Tap) Send(m Message) {
+	if t == nil {
+		return
+	}
+
+	frame := dataFrame(encode(t.identity, t.version, m))
+
+	select {
+	case <-t.done:
+	case t.frames <- frame:
+	default:
+		t.dropped.Add(1)
+	}
+}
+
+// Dropped returns how many messages were lost to a full queue.
+func (t *Tap) Dropped() uint64 {
+	if t == nil {
+		return 0
+	}
+
+	return t.dropped.Load()
+}
+
+// Close delivers queued messages and ends the session.
+func (t *Tap) Close() {
+	if t == nil {
+		return
+	}
+
+	t.closeOnce.Do(func() {
+		close(t.done)
+		t.wg.Wait()
+	})
+}
+
+func (t *Tap) run(ctx context.Context, out *output) {
+	backoff := reconnectMin
+
+	for {
+		if out == nil {
+			var err error
+
+			out, err = t.open(ctx)
+			if err != nil {
+				t.log.Debug().Err(err).Dur("retry_in", backoff).Msg("dnstap collector unavailable")
+
+				select {
+				case <-t.done:
+					return
+				case <-time.After(backoff):
+				}
+
+				backoff = min(backoff*2, reconnectMax)
+
+				continue
+			}
+
+			backoff = reconnectMin
+		}
+
+		if !t.serve(out) {
+			return
+		}
+
+		out = nil
+	}
+}
+
+// serve writes frames to out until it fails (true: reconnect) or the tap is
+// closed (false).
+func (t *Tap) serve(out *output) bool {
+	for {
+		select {
+		case frame := <-t.frames:
+			err := out.write(frame)
+			if err == nil && len(t.frames) == 0 {
+				err = out.flush()
+			}
+
+			if err != nil {
+				t.log.Warn().Err(err).Msg("dnstap output failed, reconnecting")
+				out.abort()
+
+				return true
+			}
+		case <-t.done:
+			for len(t.frames) > 0 {
+				if err := out.write(<-t.frames); err != nil {
+					break
+				}
+			}
+
+			if err := out.close(); err != nil {
+				t.log.Debug().Err(err).Msg("dnstap session not closed cleanly")
+			}
+
+			return false
+		}
+	}
+}
+
+// dialer connects to a bidirectional collector: READY, ACCEPT, then START.
+func dialer(network, address string) func(ctx context.Context) (*output, error) {
+	return func(ctx context.Context) (*output, error) {
+		d := net.Dialer{Timeout: ioTimeout}
+
+		conn, err := d.DialContext(ctx, network, address)
+		if err != nil {
+			return nil, fmt.Errorf("dial dnstap collector: %w", err)
+		}
+
+		_ = conn.SetDeadline(time.Now().Add(ioTimeout))
+
+		err = writeAll(conn, controlFrame(controlReady))
+		if err == nil {
+			err = readControl(conn, controlAccept)
+		}
+
+		if err == nil {
+			err = writeAll(conn, controlFrame(controlStart))
+		}
+
+		if err != nil {
+			_ = conn.Close()
+
+			return nil, fmt.Errorf("dnstap handshake: %w", err)
+		}
+
+		_ = conn.SetDeadline(time.Time{})
+
+		return &output{w: bufio.NewWriter(conn), conn: conn}, nil
+	}
+}
+
+// fileOpener writes a unidirectional stream. The configured file is replaced
+// on start since a stream holds a single START frame; reopening after an
+// output error starts a new numbered file instead of truncating the capture.
+func fileOpener(path string) func(ctx context.Context) (*output, error) {
+	var reopened int
+
+	return func(ctx context.Context) (*output, error) {
+		if err := os.MkdirAll(filepath.Dir(path), dirPerm); err != nil {
+			return nil, fmt.Errorf("create dnstap directory: %w", err)
+		}
+
+		reopen := reopened > 0
+
+		f, err := createStreamFile(path, &reopened)
+		if err != nil {
+			return nil, fmt.Errorf("open dnstap file: %w", err)
+		}
+
+		if reopen {
+			zerolog.Ctx(ctx).Info().Str("file", f.Name()).Msg("dnstap output reopened")
+		}
+
+		out := &output{w: bufio.NewWriter(f), file: f}
+		if err := out.write(controlFrame(controlStart)); err != nil {
+			_ = f.Close()
+
+			return nil, fmt.Errorf("write dnstap file: %w", err)
+		}
+
+		return out, nil
+	}
+}
+
+// createStreamFile truncates path on the first call and later creates the
+// next free "name.N.ext" so earlier captures are kept.
+func createStreamFile(path string, reopened *int) (*os.File, error) {
+	if *reopened == 0 {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, filePerm) //nolint:gosec // configured path
+		if err == nil {
+			*reopened = 1
+		}
+
+		return f, err
+	}
+
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+
+	for {
+		name := fmt.Sprintf("%s.%d%s", base, *reopened, ext)
+		*reopened++
+
+		f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_EXCL, filePerm) //nolint:gosec // derived from the configured path
+		if !errors.Is(err, os.ErrExist) {
+			return f, err
+		}
+	}
+}
+
+func writeAll(conn net.Conn, b []byte) error {
+	_, err := conn.Write(b)
+
+	return err
+}
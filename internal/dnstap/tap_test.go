@@ -0,0 +1,267 @@
+package dnstap_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/bavix/outway/internal/config"
+	"github.com/bavix/outway/internal/dnstap"
+)
+
+const contentType = "protobuf:dnstap.Dnstap"
+
+// frame is a decoded Frame Streams frame: a control type or a data payload.
+type frame struct {
+	control uint32
+	data    []byte
+}
+
+func readFrame(t *testing.T, r io.Reader) frame {
+	t.Helper()
+
+	var size uint32
+	require.NoError(t, binary.Read(r, binary.BigEndian, &size))
+
+	if size > 0 {
+		data := make([]byte, size)
+		_, err := io.ReadFull(r, data)
+		require.NoError(t, err)
+
+		return frame{data: data}
+	}
+
+	require.NoError(t, binary.Read(r, binary.BigEndian, &size))
+
+	body := make([]byte, size)
+	_, err := io.ReadFull(r, body)
+	require.NoError(t, err)
+
+	f := frame{control: binary.BigEndian.Uint32(body)}
+	if f.control != 0x03 && f.control != 0x05 {
+		assert.Contains(t, string(body), contentType)
+	}
+
+	return f
+}
+
+func writeControl(t *testing.T, w io.Writer, typ uint32) {
+	t.Helper()
+
+	body := binary.BigEndian.AppendUint32(nil, typ)
+	body = binary.BigEndian.AppendUint32(body, 1)
+	body = binary.BigEndian.AppendUint32(body, uint32(len(contentType)))
+	body = append(body, contentType...)
+
+	hdr := binary.BigEndian.AppendUint32(nil, 0)
+	hdr = binary.BigEndian.AppendUint32(hdr, uint32(len(body))) //nolint:gosec // test frame
+
+	_, err := w.Write(append(hdr, body...))
+	require.NoError(t, err)
+}
+
+// fields decodes the top-level fields of a protobuf message.
+func fields(t *testing.T, b []byte) map[protowire.Number][]any {
+	t.Helper()
+
+	out := map[protowire.Number][]any{}
+
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		require.GreaterOrEqual(t, n, 0)
+
+		b = b[n:]
+
+		switch typ {
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			out[num] = append(out[num], v)
+			b = b[n:]
+		case protowire.Fixed32Type:
+			v, n := protowire.ConsumeFixed32(b)
+			out[num] = append(out[num], v)
+			b = b[n:]
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			out[num] = append(out[num], v)
+			b = b[n:]
+		default:
+			t.Fatalf("unexpected wire type %d", typ)
+		}
+	}
+
+	return out
+}
+
+func sampleMessage() dnstap.Message {
+	return dnstap.Message{
+		Type:         dnstap.ClientQuery,
+		Protocol:     dnstap.UDP,
+		QueryAddr:    netip.MustParseAddrPort("192.168.1.10:53000"),
+		ResponseAddr: netip.MustParseAddrPort("192.168.1.1:53"),
+		QueryTime:    time.Unix(1700000000, 42),
+		Query:        []byte{0x12, 0x34},
+	}
+}
+
+func assertSample(t *testing.T, payload []byte) {
+	t.Helper()
+
+	top := fields(t, payload)
+	assert.Equal(t, []byte("test"), top[1][0], "identity")
+	assert.Equal(t, uint64(1), top[15][0], "type MESSAGE")
+
+	msg := fields(t, top[14][0].([]byte)) //nolint:forcetypeassert // bytes field
+	assert.Equal(t, uint64(dnstap.ClientQuery), msg[1][0])
+	assert.Equal(t, uint64(1), msg[2][0], "INET")
+	assert.Equal(t, uint64(dnstap.UDP), msg[3][0])
+	assert.Equal(t, []byte{192, 168, 1, 10}, msg[4][0])
+	assert.Equal(t, uint64(53000), msg[6][0])
+	assert.Equal(t, uint64(1700000000), msg[8][0])
+	assert.Equal(t, uint32(42), msg[9][0])
+	assert.Equal(t, []byte{0x12, 0x34}, msg[10][0])
+}
+
+func TestTapDisabled(t *testing.T) {
+	t.Parallel()
+
+	tap, err := dnstap.New(context.Background(), config.DnstapConfig{})
+	require.NoError(t, err)
+	assert.Nil(t, tap)
+
+	tap.Send(sampleMessage())
+	tap.Close()
+}
+
+func TestTapFile(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "outway.dnstap")
+
+	tap, err := dnstap.New(context.Background(), config.DnstapConfig{Address: "file://" + path, Identity: "test"})
+	require.NoError(t, err)
+
+	tap.Send(sampleMessage())
+	tap.Close()
+
+	f, err := os.Open(path) //nolint:gosec // test file
+	require.NoError(t, err)
+
+	defer func() { _ = f.Close() }()
+
+	r := bufio.NewReader(f)
+	assert.Equal(t, uint32(0x02), readFrame(t, r).control, "START")
+	assertSample(t, readFrame(t, r).data)
+	assert.Equal(t, uint32(0x03), readFrame(t, r).control, "STOP")
+}
+
+func TestTapFileReopenKeepsCapture(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "outway.dnstap")
+	taken := filepath.Join(dir, "outway.1.dnstap")
+	require.NoError(t, os.WriteFile(taken, []byte("keep"), 0o600))
+
+	names, err := dnstap.OpenFileOutput(context.Background(), path, 3)
+	require.NoError(t, err)
+	assert.Equal(t, []string{path, filepath.Join(dir, "outway.2.dnstap"), filepath.Join(dir, "outway.3.dnstap")}, names)
+
+	data, err := os.ReadFile(taken) //nolint:gosec // test file
+	require.NoError(t, err)
+	assert.Equal(t, "keep", string(data))
+
+	for _, name := range names {
+		f, err := os.Open(name) //nolint:gosec // test file
+		require.NoError(t, err)
+
+		r := bufio.NewReader(f)
+		assert.Equal(t, uint32(0x02), readFrame(t, r).control, "START")
+		assert.Equal(t, uint32(0x03), readFrame(t, r).control, "STOP")
+		require.NoError(t, f.Close())
+	}
+}
+
+func TestTapUnixSocket(t *testing.T) {
+	t.Parallel()
+
+	// Unix socket paths are short; keep the directory name small
+	dir, err := os.MkdirTemp("", "tap")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	sock := filepath.Join(dir, "s")
+
+	ln, err := (&net.ListenConfig{}).Listen(context.Background(), "unix", sock)
+	require.NoError(t, err)
+
+	defer func() { _ = ln.Close() }()
+
+	received := make(chan []byte, 1)
+
+	go func() {
+		conn, err := ln.Accept()
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		defer func() { _ = conn.Close() }()
+
+		r := bufio.NewReader(conn)
+		assert.Equal(t, uint32(0x04), readFrame(t, r).control, "READY")
+		writeControl(t, conn, 0x01) // ACCEPT
+		assert.Equal(t, uint32(0x02), readFrame(t, r).control, "START")
+
+		received <- readFrame(t, r).data
+
+		assert.Equal(t, uint32(0x03), readFrame(t, r).control, "STOP")
+		_, _ = conn.Write(binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32([]byte{0, 0, 0, 0}, 4), 0x05))
+	}()
+
+	tap, err := dnstap.New(context.Background(), config.DnstapConfig{Address: "unix://" + sock, Identity: "test"})
+	require.NoError(t, err)
+
+	tap.Send(sampleMessage())
+
+	select {
+	case payload := <-received:
+		assertSample(t, payload)
+	case <-time.After(5 * time.Second):
+		t.Fatal("collector received nothing")
+	}
+
+	tap.Close()
+	assert.Zero(t, tap.Dropped())
+}
+
+func TestTapDropsWhenCollectorIsDown(t *testing.T) {
+	t.Parallel()
+
+	tap, err := dnstap.New(context.Background(), config.DnstapConfig{
+		Address:    "unix://" + filepath.Join(t.TempDir(), "missing.sock"),
+		BufferSize: 2,
+	})
+	require.NoError(t, err)
+
+	start := time.Now()
+
+	for range 10 {
+		tap.Send(sampleMessage())
+	}
+
+	assert.Less(t, time.Since(start), time.Second, "sending never blocks")
+	assert.Equal(t, uint64(8), tap.Dropped())
+
+	tap.Close()
+}
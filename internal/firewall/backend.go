@@ -17,6 +17,17 @@ type Backend interface {
 	CleanupAll(ctx context.Context) error
 }
 
+// Unmarker is implemented by backends that can drop a mark before it expires.
+type Unmarker interface {
+	UnmarkIP(ctx context.Context, iface, ip string) error
+}
+
+// RouteChecker is implemented by backends that can tell whether the mark of
+// an address is currently installed.
+type RouteChecker interface {
+	HasRoute(ctx context.Context, iface, ip string) (bool, error)
+}
+
 // DetectBackend detects the appropriate firewall backend for the current system.
 //
 //nolint:ireturn // factory function must return interface to support multiple implementations
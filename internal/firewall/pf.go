@@ -2,6 +2,7 @@ package firewall
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"os/exec"
@@ -130,6 +131,57 @@ func (p *pfBackend) MarkIP(ctx context.Context, iface, ip string, ttlSeconds int
 	return nil
 }
 
+// UnmarkIP removes a marked address from the table and its route before the
+// mark expires.
+func (p *pfBackend) UnmarkIP(ctx context.Context, iface, ip string) error {
+	p.mu.Lock()
+
+	if t, ok := p.timers[ip]; ok {
+		t.Stop()
+		delete(p.timers, ip)
+	}
+
+	p.mu.Unlock()
+
+	p.cacheMu.Lock()
+	delete(p.routeCache, ip+":"+iface)
+	p.cacheMu.Unlock()
+
+	table := PFTableName(iface)
+
+	cmd := exec.CommandContext(ctx, "pfctl", "-t", table, "-T", "delete", ip) //nolint:gosec // pfctl is a system utility
+	if out, err := cmd.CombinedOutput(); err != nil {
+		zerolog.Ctx(ctx).Err(err).Bytes("out", out).Msg("pfctl delete failed")
+
+		return fmt.Errorf("failed to delete IP %s from pfctl table %s: %w", ip, table, err)
+	}
+
+	delArgs := []string{"-n", "delete"}
+	if strings.Contains(ip, ":") {
+		delArgs = append(delArgs, "-inet6")
+	}
+
+	delArgs = append(delArgs, "-host", ip, "-interface", iface)
+	_ = exec.CommandContext(ctx, "route", delArgs...).Run() // the route may be gone already
+
+	return nil
+}
+
+// HasRoute reports whether a marked address is in the interface's table.
+func (p *pfBackend) HasRoute(ctx context.Context, iface, ip string) (bool, error) {
+	cmd := exec.CommandContext(ctx, "pfctl", "-t", PFTableName(iface), "-T", "test", ip) //nolint:gosec // pfctl is a system utility
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return false, nil // not in the table
+		}
+
+		return false, fmt.Errorf("failed to test pfctl table: %w", err)
+	}
+
+	return true, nil
+}
+
 func (p *pfBackend) CleanupAll(ctx context.Context) error {
 	zerolog.Ctx(ctx).Info().Msg("cleanup pf tables")
 	p.mu.Lock()
@@ -17,6 +17,7 @@ import (
 var (
 	ErrRouteAddFailed    = errors.New("failed to add route")
 	ErrRouteUpdateFailed = errors.New("failed to update route")
+	ErrRouteDeleteFailed = errors.New("failed to delete route")
 )
 
 // SimpleRouteBackend uses ip route expires for automatic cleanup.
@@ -75,6 +76,56 @@ func (r *SimpleRouteBackend) MarkIP(ctx context.Context, iface, ip string, ttlSe
 	return nil
 }
 
+// UnmarkIP deletes the route of a marked address before it expires.
+func (r *SimpleRouteBackend) UnmarkIP(ctx context.Context, iface, ip string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if err := r.validateInputs(iface, ip); err != nil {
+		return err
+	}
+
+	normalizedIP, _ := NormalizeIP(ip)
+	delete(r.entries, normalizedIP)
+
+	cmd := exec.CommandContext(ctx, "ip", "route", "del", normalizedIP+"/32", "dev", iface) //nolint:gosec // ip is validated input
+	if out, err := cmd.CombinedOutput(); err != nil {
+		output := string(out)
+
+		// The route may have expired already
+		if strings.Contains(output, "No such process") {
+			return nil
+		}
+
+		return fmt.Errorf("%w: %s", ErrRouteDeleteFailed, output)
+	}
+
+	zerolog.Ctx(ctx).Debug().
+		IPAddr("ip", net.ParseIP(normalizedIP)).
+		Str("iface", iface).
+		Msg("route deleted")
+
+	return nil
+}
+
+// HasRoute reports whether the route of a marked address is installed.
+func (r *SimpleRouteBackend) HasRoute(ctx context.Context, iface, ip string) (bool, error) {
+	if err := r.validateInputs(iface, ip); err != nil {
+		return false, err
+	}
+
+	normalizedIP, _ := NormalizeIP(ip)
+
+	cmd := exec.CommandContext(ctx, "ip", "route", "show", normalizedIP+"/32", "dev", iface) //nolint:gosec // ip is validated input
+
+	out, err := cmd.Output()
+	if err != nil {
+		return false, fmt.Errorf("failed to list routes: %w", err)
+	}
+
+	return strings.TrimSpace(string(out)) != "", nil
+}
+
 // CleanupAll removes all tracked entries (routes will expire automatically).
 func (r *SimpleRouteBackend) CleanupAll(ctx context.Context) error {
 	r.mutex.Lock()
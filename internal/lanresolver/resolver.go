@@ -29,6 +29,8 @@ type LANResolver struct {
 	}
 	ZoneDetector *localzone.ZoneDetector
 	LeaseManager *LeaseManager
+	// AddressBooks are extra sources for PTR answers (hosts, devices); leases are always consulted.
+	AddressBooks []AddressBook
 	mu           sync.RWMutex
 }
 
@@ -54,6 +56,11 @@ func (lr *LANResolver) Resolve(ctx context.Context, q *dns.Msg) (*dns.Msg, strin
 	domain := strings.ToLower(strings.TrimSuffix(question.Name, "."))
 	qtype := question.Qtype
 
+	// Reverse names of private ranges are answered locally and never forwarded
+	if IsPrivateReverseName(domain) {
+		return lr.resolveReverse(q)
+	}
+
 	// Check if this is a local zone query
 	isLocal, zone := lr.ZoneDetector.IsLocalZone(domain)
 	if !isLocal {
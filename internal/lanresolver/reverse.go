@@ -0,0 +1,282 @@
+package lanresolver
+
+import (
+	"math"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// AddressBook maps an address to the host names that own it.
+type AddressBook interface {
+	LookupAddr(ip net.IP) []string
+}
+
+// SOA timers of locally served zones (RFC 6303 section 3).
+const (
+	soaRefresh = 604800
+	soaRetry   = 86400
+	soaExpire  = 2419200
+)
+
+// privateReverseZones are the reverse zones of RFC1918 and ULA ranges.
+//
+//nolint:gochecknoglobals // static lookup table
+var privateReverseZones = func() []string {
+	zones := []string{"10.in-addr.arpa", "168.192.in-addr.arpa", "c.f.ip6.arpa", "d.f.ip6.arpa"}
+
+	const first, last = 16, 31 // 172.16.0.0/12
+
+	for i := first; i <= last; i++ {
+		zones = append(zones, strconv.Itoa(i)+".172.in-addr.arpa")
+	}
+
+	return zones
+}()
+
+// IsPrivateReverseName reports whether name lies in a reverse zone of a
+// private (RFC1918 or ULA) range. Such names are never forwarded upstream.
+func IsPrivateReverseName(name string) bool {
+	return privateReverseZone(name) != ""
+}
+
+// privateReverseZone returns the private reverse zone holding name, or "".
+func privateReverseZone(name string) string {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+
+	for _, zone := range privateReverseZones {
+		if name == zone || strings.HasSuffix(name, "."+zone) {
+			return zone
+		}
+	}
+
+	return ""
+}
+
+// isReverseNonTerminal reports whether name is a valid reverse name above a
+// full address, such as a zone apex or "1.168.192.in-addr.arpa". Addresses
+// may exist below it, so it exists as well (RFC 8020).
+func isReverseNonTerminal(name string) bool {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+
+	if rest, ok := strings.CutSuffix(name, ".in-addr.arpa"); ok {
+		labels := strings.Split(rest, ".")
+
+		return len(labels) < net.IPv4len && !slices.ContainsFunc(labels, func(label string) bool {
+			octet, err := strconv.Atoi(label)
+
+			return err != nil || octet < 0 || octet > math.MaxUint8 || strconv.Itoa(octet) != label
+		})
+	}
+
+	if rest, ok := strings.CutSuffix(name, ".ip6.arpa"); ok {
+		nibbles := strings.Split(rest, ".")
+
+		return len(nibbles) < net.IPv6len*2 && !slices.ContainsFunc(nibbles, func(nibble string) bool {
+			_, err := strconv.ParseUint(nibble, 16, 4)
+
+			return len(nibble) != 1 || err != nil
+		})
+	}
+
+	return false
+}
+
+// reverseSOA is the SOA of a locally served reverse zone (RFC 6303). Its
+// minimum keeps negative answers as short-lived as the leases behind them.
+func reverseSOA(zone string) *dns.SOA {
+	return &dns.SOA{
+		Hdr:     dns.RR_Header{Name: dns.Fqdn(zone), Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: defaultTTL},
+		Ns:      "localhost.",
+		Mbox:    "nobody.invalid.",
+		Serial:  1,
+		Refresh: soaRefresh,
+		Retry:   soaRetry,
+		Expire:  soaExpire,
+		Minttl:  defaultTTL,
+	}
+}
+
+// IPFromReverseName parses in-addr.arpa and ip6.arpa names; it returns nil
+// for partial or malformed names.
+func IPFromReverseName(name string) net.IP {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+
+	if rest, ok := strings.CutSuffix(name, ".in-addr.arpa"); ok {
+		labels := strings.Split(rest, ".")
+		if len(labels) != net.IPv4len {
+			return nil
+		}
+
+		for i, j := 0, len(labels)-1; i < j; i, j = i+1, j-1 {
+			labels[i], labels[j] = labels[j], labels[i]
+		}
+
+		return net.ParseIP(strings.Join(labels, ".")).To4()
+	}
+
+	if rest, ok := strings.CutSuffix(name, ".ip6.arpa"); ok {
+		nibbles := strings.Split(rest, ".")
+		if len(nibbles) != net.IPv6len*2 {
+			return nil
+		}
+
+		var b strings.Builder
+
+		for i := len(nibbles) - 1; i >= 0; i-- {
+			if len(nibbles[i]) != 1 {
+				return nil
+			}
+
+			b.WriteString(nibbles[i])
+
+			if i%4 == 0 && i > 0 {
+				b.WriteByte(':')
+			}
+		}
+
+		return net.ParseIP(b.String())
+	}
+
+	return nil
+}
+
+// LookupAddr returns host names of valid leases holding ip.
+func (lm *LeaseManager) LookupAddr(ip net.IP) []string {
+	var names []string
+
+	for _, lease := range lm.GetAllLeases() {
+		if lease.Hostname != "" && ip.Equal(net.ParseIP(lease.IP)) {
+			names = append(names, lease.Hostname)
+		}
+	}
+
+	return names
+}
+
+// resolveReverse answers queries under private reverse zones from leases and
+// address books. Unknown addresses get NXDOMAIN instead of leaking upstream;
+// the zone apex and names above addresses exist and get NODATA. Negative
+// answers carry the zone's SOA so they can be cached.
+func (lr *LANResolver) resolveReverse(q *dns.Msg) (*dns.Msg, string, error) {
+	question := q.Question[0]
+
+	response := new(dns.Msg)
+	response.SetReply(q)
+	response.Authoritative = true
+
+	zone := privateReverseZone(question.Name)
+	soa := reverseSOA(zone)
+
+	if strings.EqualFold(dns.Fqdn(question.Name), soa.Hdr.Name) &&
+		(question.Qtype == dns.TypeSOA || question.Qtype == dns.TypeANY) {
+		response.Answer = append(response.Answer, soa)
+
+		return response, lanResolverID, nil
+	}
+
+	names := lr.lookupAddr(IPFromReverseName(question.Name))
+	if len(names) == 0 {
+		if !isReverseNonTerminal(question.Name) {
+			response.Rcode = dns.RcodeNameError
+		}
+
+		response.Ns = append(response.Ns, soa)
+
+		return response, lanResolverID, nil
+	}
+
+	if question.Qtype != dns.TypePTR && question.Qtype != dns.TypeANY {
+		response.Ns = append(response.Ns, soa)
+
+		return response, lanResolverID, nil
+	}
+
+	for _, name := range names {
+		response.Answer = append(response.Answer, &dns.PTR{
+			Hdr: dns.RR_Header{Name: question.Name, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: defaultTTL},
+			Ptr: name,
+		})
+	}
+
+	return response, lanResolverID, nil
+}
+
+// lookupAddr collects fully qualified names for ip; bare host names are
+// placed in the first local zone.
+func (lr *LANResolver) lookupAddr(ip net.IP) []string {
+	if ip == nil {
+		return nil
+	}
+
+	lr.mu.RLock()
+	books := append([]AddressBook(nil), lr.AddressBooks...)
+	lr.mu.RUnlock()
+
+	if lr.LeaseManager != nil {
+		books = append([]AddressBook{lr.LeaseManager}, books...)
+	}
+
+	var (
+		names []string
+		zone  string
+	)
+
+	seen := make(map[string]struct{})
+
+	for _, book := range books {
+		if book == nil {
+			continue
+		}
+
+		for _, name := range book.LookupAddr(ip) {
+			name = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(name), "."))
+			if name == "" {
+				continue
+			}
+
+			if !strings.Contains(name, ".") {
+				if zone == "" {
+					zone = lr.defaultZone()
+				}
+
+				name += "." + zone
+			}
+
+			name = dns.Fqdn(name)
+			if _, ok := seen[name]; ok {
+				continue
+			}
+
+			seen[name] = struct{}{}
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+func (lr *LANResolver) defaultZone() string {
+	if lr.ZoneDetector == nil {
+		return "lan"
+	}
+
+	if zones, err := lr.ZoneDetector.DetectZones(); err == nil && len(zones) > 0 {
+		return strings.ToLower(strings.TrimSuffix(zones[0], "."))
+	}
+
+	return "lan"
+}
+
+// SetAddressBooks replaces the extra sources consulted for reverse lookups.
+func (lr *LANResolver) SetAddressBooks(books ...AddressBook) {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+
+	lr.AddressBooks = books
+}
+
+var _ AddressBook = (*LeaseManager)(nil)
@@ -0,0 +1,119 @@
+package lanresolver_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"testing/fstest"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bavix/outway/internal/lanresolver"
+	"github.com/bavix/outway/internal/localzone"
+)
+
+type staticAddressBook map[string][]string
+
+func (b staticAddressBook) LookupAddr(ip net.IP) []string { return b[ip.String()] }
+
+func TestIPFromReverseName(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "192.168.1.10", lanresolver.IPFromReverseName("10.1.168.192.in-addr.arpa.").String())
+	assert.Equal(t, "fd00::1",
+		lanresolver.IPFromReverseName("1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.d.f.ip6.arpa.").String())
+	assert.Nil(t, lanresolver.IPFromReverseName("1.168.192.in-addr.arpa."))
+	assert.Nil(t, lanresolver.IPFromReverseName("example.com."))
+}
+
+func TestIsPrivateReverseName(t *testing.T) {
+	t.Parallel()
+
+	assert.True(t, lanresolver.IsPrivateReverseName("5.0.0.10.in-addr.arpa."))
+	assert.True(t, lanresolver.IsPrivateReverseName("1.1.20.172.in-addr.arpa"))
+	assert.True(t, lanresolver.IsPrivateReverseName("168.192.in-addr.arpa."))
+	assert.True(t, lanresolver.IsPrivateReverseName("0.0.d.f.ip6.arpa."))
+	assert.False(t, lanresolver.IsPrivateReverseName("1.1.32.172.in-addr.arpa."))
+	assert.False(t, lanresolver.IsPrivateReverseName("8.8.8.8.in-addr.arpa."))
+	assert.False(t, lanresolver.IsPrivateReverseName("nas.lan."))
+}
+
+func TestLANResolver_Resolve_PTR(t *testing.T) {
+	t.Parallel()
+
+	reader := &TestFileReader{fs: fstest.MapFS{"leases": &fstest.MapFile{
+		Data: []byte("2000000000 aa:bb:cc:dd:ee:ff 192.168.1.100 laptop id\n"),
+	}}}
+	leases := lanresolver.NewLeaseManagerWithReader("leases", reader)
+	require.NoError(t, leases.LoadLeases())
+
+	forwarded := false
+	next := &MockNextResolver{resolveFunc: func(_ context.Context, q *dns.Msg) (*dns.Msg, string, error) {
+		forwarded = true
+
+		return new(dns.Msg).SetReply(q), nextResolverName, nil
+	}}
+
+	zones := localzone.NewZoneDetectorWithConfig([]string{"home"}, "", "", false, false, false, false)
+	resolver := lanresolver.NewLANResolver(next, zones, leases)
+	resolver.SetAddressBooks(staticAddressBook{
+		"192.168.1.100": {"laptop.home."},
+		"192.168.1.5":   {"nas.home", "printer"},
+	})
+
+	query := func(name string, qtype uint16) *dns.Msg {
+		q := new(dns.Msg)
+		q.SetQuestion(name, qtype)
+
+		resp, src, err := resolver.Resolve(context.Background(), q)
+		require.NoError(t, err)
+		assert.Equal(t, "lan", src)
+
+		return resp
+	}
+
+	resp := query("100.1.168.192.in-addr.arpa.", dns.TypePTR)
+	require.Len(t, resp.Answer, 1, "lease and address book names are merged")
+	assert.Equal(t, "laptop.home.", resp.Answer[0].(*dns.PTR).Ptr)
+
+	resp = query("5.1.168.192.in-addr.arpa.", dns.TypePTR)
+	require.Len(t, resp.Answer, 2)
+	assert.Equal(t, "nas.home.", resp.Answer[0].(*dns.PTR).Ptr)
+	assert.Equal(t, "printer.home.", resp.Answer[1].(*dns.PTR).Ptr)
+
+	resp = query("5.1.168.192.in-addr.arpa.", dns.TypeTXT)
+	assert.Equal(t, dns.RcodeSuccess, resp.Rcode)
+	assert.Empty(t, resp.Answer)
+
+	resp = query("77.1.168.192.in-addr.arpa.", dns.TypePTR)
+	assert.Equal(t, dns.RcodeNameError, resp.Rcode)
+	require.Len(t, resp.Ns, 1, "negative answers carry the zone's SOA")
+	assert.Equal(t, "168.192.in-addr.arpa.", resp.Ns[0].Header().Name)
+
+	resp = query("168.192.in-addr.arpa.", dns.TypeSOA)
+	assert.Equal(t, dns.RcodeSuccess, resp.Rcode)
+	require.Len(t, resp.Answer, 1)
+	assert.Equal(t, dns.TypeSOA, resp.Answer[0].Header().Rrtype)
+
+	// The apex and names above addresses exist: NODATA, not NXDOMAIN (RFC 8020)
+	for _, name := range []string{"168.192.in-addr.arpa.", "1.168.192.in-addr.arpa.", "0.0.d.f.ip6.arpa."} {
+		resp = query(name, dns.TypePTR)
+		assert.Equal(t, dns.RcodeSuccess, resp.Rcode, name)
+		assert.Empty(t, resp.Answer, name)
+		require.Len(t, resp.Ns, 1, name)
+		assert.Equal(t, dns.TypeSOA, resp.Ns[0].Header().Rrtype, name)
+	}
+
+	resp = query("x.1.168.192.in-addr.arpa.", dns.TypePTR)
+	assert.Equal(t, dns.RcodeNameError, resp.Rcode, "malformed names do not exist")
+	assert.False(t, forwarded, "private reverse names are never forwarded")
+
+	q := new(dns.Msg)
+	q.SetQuestion("8.8.8.8.in-addr.arpa.", dns.TypePTR)
+
+	_, src, err := resolver.Resolve(context.Background(), q)
+	require.NoError(t, err)
+	assert.Equal(t, nextResolverName, src)
+}
@@ -1,7 +1,12 @@
 package localzone_test
 
 import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -522,3 +527,33 @@ func TestFileWatcherInterface(t *testing.T) {
 // 	require.NoError(t, err)
 // 	assert.Equal(t, []byte("updated"), content)
 // }
+
+func TestWatcherIgnoresOtherFiles(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("a: 1\n"), 0o600))
+
+	w, err := localzone.NewWatcher(10 * time.Millisecond)
+	require.NoError(t, err)
+	require.NoError(t, w.WatchFile(path))
+
+	var calls atomic.Int32
+
+	w.AddCallback(func() { calls.Add(1) })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	w.Start(ctx)
+
+	// State written next to the config file does not count as a change
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "revisions"), 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.yaml.rollback"), []byte("a: 2\n"), 0o600))
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Zero(t, calls.Load())
+
+	require.NoError(t, os.WriteFile(path, []byte("a: 3\n"), 0o600))
+	assert.Eventually(t, func() bool { return calls.Load() > 0 }, 2*time.Second, 10*time.Millisecond)
+}
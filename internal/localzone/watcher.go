@@ -13,6 +13,7 @@ import (
 type Watcher struct {
 	watcher   *fsnotify.Watcher
 	callbacks []func()
+	paths     []string // watched files, directories and glob patterns
 	mu        sync.RWMutex
 	debounce  time.Duration
 	timer     *time.Timer
@@ -39,12 +40,21 @@ func (w *Watcher) AddCallback(callback func()) {
 	w.callbacks = append(w.callbacks, callback)
 }
 
-// WatchFile starts watching a file for changes.
+// WatchFile starts watching a file for changes. The path may be a directory
+// or a glob pattern of file names; other files next to it are ignored.
 func (w *Watcher) WatchFile(path string) error {
 	// Watch the directory containing the file
 	dir := filepath.Dir(path)
 
-	return w.watcher.Add(dir)
+	if err := w.watcher.Add(dir); err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	w.paths = append(w.paths, filepath.Clean(path))
+	w.mu.Unlock()
+
+	return nil
 }
 
 // WatchFiles starts watching multiple files.
@@ -105,7 +115,28 @@ func (w *Watcher) shouldTrigger(event fsnotify.Event) bool {
 	// - Write: file was written to
 	// - Rename: file was renamed (common when files are recreated)
 	// - Remove: file was removed
-	return event.Op&(fsnotify.Create|fsnotify.Write|fsnotify.Rename|fsnotify.Remove) != 0
+	if event.Op&(fsnotify.Create|fsnotify.Write|fsnotify.Rename|fsnotify.Remove) == 0 {
+		return false
+	}
+
+	return w.watches(filepath.Clean(event.Name))
+}
+
+// watches reports whether name is a watched file, matches a watched pattern
+// or lies in a watched directory.
+//
+//nolint:funcorder
+func (w *Watcher) watches(name string) bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	for _, p := range w.paths {
+		if ok, _ := filepath.Match(p, name); ok || filepath.Dir(name) == p {
+			return true
+		}
+	}
+
+	return false
 }
 
 // triggerCallbacks triggers all registered callbacks with debouncing.
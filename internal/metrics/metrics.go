@@ -124,6 +124,49 @@ var (
 		},
 		[]string{"service"},
 	)
+	CachePrefetchTotal = promauto.NewCounterVec(
+		prom.CounterOpts{
+			Name: "dns_cache_prefetch_total",
+			Help: "Cache entries refreshed before expiry (Counter). Labels: service, result.",
+		},
+		[]string{"service", "result"},
+	)
+
+	// DNSBlockedTotal counts queries answered by block rule groups.
+	DNSBlockedTotal = promauto.NewCounterVec(
+		prom.CounterOpts{
+			Name: "dns_blocked_queries_total",
+			Help: "Queries answered locally by block rule groups (Counter). Labels: service, group, list.",
+		},
+		[]string{"service", "group", "list"},
+	)
+
+	// DNSPolicyHitsTotal counts response policy zone rule hits.
+	DNSPolicyHitsTotal = promauto.NewCounterVec(
+		prom.CounterOpts{
+			Name: "dns_rpz_hits_total",
+			Help: "Response policy zone rule hits (Counter). Labels: service, zone, trigger, action.",
+		},
+		[]string{"service", "zone", "trigger", "action"},
+	)
+
+	// DNSACLRefusedTotal counts queries refused because the client is not in the ACL.
+	DNSACLRefusedTotal = promauto.NewCounterVec(
+		prom.CounterOpts{
+			Name: "dns_acl_refused_total",
+			Help: "Queries refused by the listener ACL (Counter).",
+		},
+		[]string{"service"},
+	)
+
+	// DNSRateLimitedTotal counts queries of clients over their rate limit.
+	DNSRateLimitedTotal = promauto.NewCounterVec(
+		prom.CounterOpts{
+			Name: "dns_rate_limited_total",
+			Help: "Queries over the per-client rate limit (Counter). Labels: service, action.",
+		},
+		[]string{"service", "action"},
+	)
 )
 
 var readyFlag int32 //nolint:gochecknoglobals // service ready flag
@@ -214,6 +257,31 @@ func IncResolveError(upstream string) {
 	ResolveErrorsTotal.WithLabelValues(Service(), upstream).Inc()
 }
 
+// IncCachePrefetch increments prefetch counter for a result (ok or error).
+func IncCachePrefetch(result string) {
+	CachePrefetchTotal.WithLabelValues(Service(), result).Inc()
+}
+
+// IncBlocked increments blocked queries counter for a block group and list.
+func IncBlocked(group, list string) {
+	DNSBlockedTotal.WithLabelValues(Service(), group, list).Inc()
+}
+
+// IncPolicyHit increments response policy zone hits counter.
+func IncPolicyHit(zone, trigger, action string) {
+	DNSPolicyHitsTotal.WithLabelValues(Service(), zone, trigger, action).Inc()
+}
+
+// IncACLRefused increments the counter of queries refused by the ACL.
+func IncACLRefused() {
+	DNSACLRefusedTotal.WithLabelValues(Service()).Inc()
+}
+
+// IncRateLimited increments the counter of rate limited queries for an action.
+func IncRateLimited(action string) {
+	DNSRateLimitedTotal.WithLabelValues(Service(), action).Inc()
+}
+
 // Simple in-memory RPS ring (per process).
 const rpsWindow = 60
 
@@ -290,6 +358,8 @@ func IsReady() bool { return atomic.LoadInt32(&readyFlag) == 1 }
 type Stats struct {
 	DNSQueriesTotal          float64 `json:"dns_queries_total"`
 	DNSMarksTotal            float64 `json:"dns_marks_total"`
+	DNSBlockedTotal          float64 `json:"dns_blocked_total"`
+	DNSPolicyHitsTotal       float64 `json:"dns_rpz_hits_total"`
 	MarksDroppedTotal        float64 `json:"marks_dropped_total"`
 	DNSUpstreamRTTAvgSeconds float64 `json:"dns_upstream_rtt_avg_seconds"`
 	DNSRequestAvgSeconds     float64 `json:"dns_request_avg_seconds"`
@@ -338,6 +408,18 @@ func GatherStats(service string) (Stats, error) { //nolint:gocognit,cyclop,funle
 					s.DNSMarksTotal += m.GetCounter().GetValue()
 				}
 			}
+		case "dns_blocked_queries_total":
+			for _, m := range mf.GetMetric() {
+				if withService(m) {
+					s.DNSBlockedTotal += m.GetCounter().GetValue()
+				}
+			}
+		case "dns_rpz_hits_total":
+			for _, m := range mf.GetMetric() {
+				if withService(m) {
+					s.DNSPolicyHitsTotal += m.GetCounter().GetValue()
+				}
+			}
 		case "dns_marks_dropped_total":
 			for _, m := range mf.GetMetric() {
 				if withService(m) {
@@ -0,0 +1,200 @@
+package querylog
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bavix/outway/internal/config"
+)
+
+const (
+	defaultBufferSize = 64 * 1024
+	defaultMaxSizeMB  = 100
+	bytesPerMB        = 1024 * 1024
+	rotatedTimeFormat = "20060102T150405.000"
+	gzipSuffix        = ".gz"
+	dirPerm           = 0o750
+	filePerm          = 0o640
+)
+
+// fileSink appends NDJSON lines to a file, rotating it by size and age.
+// Rotated files are named <file>.<timestamp>, gzipped on request and removed
+// once older than the retention.
+type fileSink struct {
+	path        string
+	bufSize     int
+	maxSize     int64
+	rotateEvery time.Duration
+	retention   time.Duration
+	compress    bool
+
+	f      *os.File
+	w      *bufio.Writer
+	size   int64
+	opened time.Time
+
+	compressing sync.WaitGroup
+}
+
+func openFile(cfg config.HistoryConfig) (*fileSink, error) {
+	s := &fileSink{
+		path:        cfg.File,
+		bufSize:     defaultBufferSize,
+		maxSize:     int64(defaultMaxSizeMB) * bytesPerMB,
+		rotateEvery: time.Duration(cfg.RotateHours) * time.Hour,
+		retention:   time.Duration(cfg.Retention) * time.Hour,
+		compress:    cfg.Compression,
+	}
+
+	if cfg.BufferSize > 0 {
+		s.bufSize = cfg.BufferSize
+	}
+
+	if cfg.MaxSizeMB > 0 {
+		s.maxSize = int64(cfg.MaxSizeMB) * bytesPerMB
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), dirPerm); err != nil {
+		return nil, fmt.Errorf("create query log directory: %w", err)
+	}
+
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+
+	s.cleanup()
+
+	return s, nil
+}
+
+func (s *fileSink) open() error {
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, filePerm)
+	if err != nil {
+		return fmt.Errorf("open query log: %w", err)
+	}
+
+	st, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+
+		return fmt.Errorf("stat query log: %w", err)
+	}
+
+	s.f = f
+	s.w = bufio.NewWriterSize(f, s.bufSize)
+	s.size = st.Size()
+	s.opened = time.Now()
+
+	return nil
+}
+
+func (s *fileSink) write(line []byte) error {
+	if s.size > 0 && (s.size+int64(len(line)) > s.maxSize || (s.rotateEvery > 0 && time.Since(s.opened) >= s.rotateEvery)) {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := s.w.Write(line)
+	s.size += int64(n)
+
+	return err
+}
+
+func (s *fileSink) flush() error {
+	return s.w.Flush()
+}
+
+func (s *fileSink) close() error {
+	err := errors.Join(s.w.Flush(), s.f.Close())
+	s.compressing.Wait()
+
+	return err
+}
+
+// rotate moves the current file aside and starts a new one.
+func (s *fileSink) rotate() error {
+	if err := errors.Join(s.w.Flush(), s.f.Close()); err != nil {
+		return fmt.Errorf("close query log: %w", err)
+	}
+
+	rotated := s.path + "." + time.Now().UTC().Format(rotatedTimeFormat)
+	if err := os.Rename(s.path, rotated); err != nil {
+		return fmt.Errorf("rotate query log: %w", err)
+	}
+
+	if s.compress {
+		s.compressing.Go(func() { _ = gzipFile(rotated) })
+	}
+
+	s.cleanup()
+
+	return s.open()
+}
+
+// cleanup removes rotated files older than the retention.
+func (s *fileSink) cleanup() {
+	if s.retention <= 0 {
+		return
+	}
+
+	matches, _ := filepath.Glob(s.path + ".*")
+	cutoff := time.Now().Add(-s.retention)
+
+	for _, m := range matches {
+		// Partially written archives belong to a running compression
+		if strings.HasSuffix(m, gzipSuffix+".tmp") {
+			continue
+		}
+
+		if st, err := os.Stat(m); err == nil && st.ModTime().Before(cutoff) {
+			_ = os.Remove(m)
+		}
+	}
+}
+
+// gzipFile replaces name with name.gz, keeping its modification time for retention.
+func gzipFile(name string) error {
+	src, err := os.Open(name) //nolint:gosec // rotated query log path
+	if err != nil {
+		return err
+	}
+	defer func() { _ = src.Close() }()
+
+	st, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	tmp := name + gzipSuffix + ".tmp"
+
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, filePerm) //nolint:gosec // rotated query log path
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(dst)
+
+	_, err = io.Copy(zw, src)
+	if err = errors.Join(err, zw.Close(), dst.Close()); err != nil {
+		_ = os.Remove(tmp)
+
+		return err
+	}
+
+	if err := os.Rename(tmp, name+gzipSuffix); err != nil {
+		return err
+	}
+
+	_ = os.Chtimes(name+gzipSuffix, st.ModTime(), st.ModTime())
+
+	return os.Remove(name)
+}
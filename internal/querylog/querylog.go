@@ -0,0 +1,200 @@
+// Package querylog appends resolved queries to NDJSON files and syslog.
+package querylog
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/bavix/outway/internal/config"
+)
+
+const (
+	defaultFlushInterval = time.Second
+	// queueSize bounds entries waiting for the writer; queries never wait on disk.
+	queueSize = 4096
+)
+
+// Entry is one line of the query log.
+type Entry struct {
+	Time       time.Time `json:"time"`
+	Client     string    `json:"client,omitempty"`
+	Name       string    `json:"name"`
+	Type       string    `json:"type"`
+	Status     string    `json:"status"`
+	Upstream   string    `json:"upstream,omitempty"`
+	DurationMS float64   `json:"duration_ms"`
+	RuleGroup  string    `json:"rule_group,omitempty"`
+	Via        string    `json:"via,omitempty"`
+	MarkedIPs  []string  `json:"marked_ips,omitempty"`
+	BlockList  string    `json:"block_list,omitempty"`
+	Policy     string    `json:"policy,omitempty"`
+}
+
+type sink interface {
+	write(line []byte) error
+	flush() error
+	close() error
+}
+
+// Logger queues entries and writes them to its sinks from a single goroutine.
+// All methods are safe on a nil receiver, which logs nothing.
+type Logger struct {
+	entries    chan Entry
+	sinks      []sink
+	flushEvery time.Duration
+	log        *zerolog.Logger
+	dropped    atomic.Uint64
+
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// New opens the sinks enabled in cfg. It returns nil when none is.
+func New(ctx context.Context, cfg config.HistoryConfig) (*Logger, error) {
+	var sinks []sink
+
+	if cfg.File != "" {
+		f, err := openFile(cfg)
+		if err != nil {
+			return nil, err
+		}
+
+		sinks = append(sinks, f)
+	}
+
+	if cfg.Syslog {
+		s, err := openSyslog()
+		if err != nil {
+			for _, s := range sinks {
+				_ = s.close()
+			}
+
+			return nil, err
+		}
+
+		sinks = append(sinks, s)
+	}
+
+	if len(sinks) == 0 {
+		return nil, nil //nolint:nilnil // no sink configured
+	}
+
+	flushEvery := defaultFlushInterval
+	if cfg.FlushInterval > 0 {
+		flushEvery = time.Duration(cfg.FlushInterval) * time.Millisecond
+	}
+
+	l := &Logger{
+		entries:    make(chan Entry, queueSize),
+		sinks:      sinks,
+		flushEvery: flushEvery,
+		log:        zerolog.Ctx(ctx),
+		done:       make(chan struct{}),
+	}
+
+	l.wg.Go(l.run)
+
+	return l, nil
+}
+
+// Log queues e. Entries are dropped rather than slowing down resolution when
+// the writer falls behind.
+func (l *Logger) Log(e Entry) {
+	if l == nil {
+		return
+	}
+
+	select {
+	case <-l.done:
+	case l.entries <- e:
+	default:
+		l.dropped.Add(1)
+	}
+}
+
+// Dropped returns how many entries were lost to a full queue.
+func (l *Logger) Dropped() uint64 {
+	if l == nil {
+		return 0
+	}
+
+	return l.dropped.Load()
+}
+
+// Close writes queued entries, flushes and closes the sinks.
+func (l *Logger) Close() {
+	if l == nil {
+		return
+	}
+
+	l.closeOnce.Do(func() {
+		close(l.done)
+		l.wg.Wait()
+	})
+}
+
+func (l *Logger) run() {
+	ticker := time.NewTicker(l.flushEvery)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case e := <-l.entries:
+			l.write(e)
+		case <-ticker.C:
+			l.flush()
+		case <-l.done:
+			l.drain()
+
+			return
+		}
+	}
+}
+
+func (l *Logger) drain() {
+	for {
+		select {
+		case e := <-l.entries:
+			l.write(e)
+		default:
+			l.flush()
+
+			for _, s := range l.sinks {
+				if err := s.close(); err != nil {
+					l.log.Warn().Err(err).Msg("failed to close query log")
+				}
+			}
+
+			return
+		}
+	}
+}
+
+func (l *Logger) write(e Entry) {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+
+	line = append(line, '\n')
+
+	for _, s := range l.sinks {
+		if err := s.write(line); err != nil {
+			l.log.Warn().Err(err).Msg("failed to write query log")
+		}
+	}
+}
+
+func (l *Logger) flush() {
+	for _, s := range l.sinks {
+		if err := s.flush(); err != nil {
+			l.log.Warn().Err(err).Msg("failed to flush query log")
+		}
+	}
+}
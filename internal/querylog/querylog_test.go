@@ -0,0 +1,135 @@
+package querylog_test
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bavix/outway/internal/config"
+	"github.com/bavix/outway/internal/querylog"
+)
+
+func readEntries(t *testing.T, path string) []querylog.Entry {
+	t.Helper()
+
+	f, err := os.Open(path) //nolint:gosec // test file
+	require.NoError(t, err)
+
+	defer func() { _ = f.Close() }()
+
+	var (
+		r       = bufio.NewReader(f)
+		entries []querylog.Entry
+	)
+
+	if strings.HasSuffix(path, ".gz") {
+		zr, err := gzip.NewReader(f)
+		require.NoError(t, err)
+
+		r = bufio.NewReader(zr)
+	}
+
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		var e querylog.Entry
+		require.NoError(t, json.Unmarshal(sc.Bytes(), &e))
+
+		entries = append(entries, e)
+	}
+
+	return entries
+}
+
+func TestLoggerDisabled(t *testing.T) {
+	t.Parallel()
+
+	l, err := querylog.New(context.Background(), config.HistoryConfig{})
+	require.NoError(t, err)
+	assert.Nil(t, l)
+
+	// A nil logger is a no-op
+	l.Log(querylog.Entry{Name: "example.com"})
+	l.Close()
+}
+
+func TestLoggerWritesNDJSON(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "log", "queries.ndjson")
+
+	l, err := querylog.New(context.Background(), config.HistoryConfig{File: path, FlushInterval: 10})
+	require.NoError(t, err)
+
+	l.Log(querylog.Entry{
+		Time:      time.Now(),
+		Client:    "192.168.1.10",
+		Name:      "www.example.com",
+		Type:      "A",
+		Status:    "ok",
+		RuleGroup: "vpn",
+		Via:       "wg0",
+		MarkedIPs: []string{"192.0.2.1"},
+	})
+
+	require.Eventually(t, func() bool { return len(readEntries(t, path)) == 1 }, time.Second, 10*time.Millisecond,
+		"entries reach the file within the flush interval")
+
+	l.Close()
+
+	e := readEntries(t, path)[0]
+	assert.Equal(t, "vpn", e.RuleGroup)
+	assert.Equal(t, "wg0", e.Via)
+	assert.Equal(t, []string{"192.0.2.1"}, e.MarkedIPs)
+}
+
+func TestLoggerRotation(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "queries.ndjson")
+
+	// A rotated file past the retention is removed on start
+	stale := path + ".20000101T000000.000.gz"
+	require.NoError(t, os.WriteFile(stale, nil, 0o600))
+	require.NoError(t, os.Chtimes(stale, time.Now().Add(-48*time.Hour), time.Now().Add(-48*time.Hour)))
+
+	l, err := querylog.New(context.Background(), config.HistoryConfig{
+		File:        path,
+		MaxSizeMB:   1,
+		Retention:   24,
+		Compression: true,
+	})
+	require.NoError(t, err)
+
+	_, err = os.Stat(stale)
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	// Fewer entries than the queue holds, together well past 1 MB
+	policy := strings.Repeat("p", 2000)
+	for range 1000 {
+		l.Log(querylog.Entry{Name: "www.example.com", Type: "A", Status: "ok", Policy: policy})
+	}
+
+	l.Close()
+
+	rotated, err := filepath.Glob(path + ".*.gz")
+	require.NoError(t, err)
+	require.NotEmpty(t, rotated, "the log is rotated past max_size_mb")
+
+	total := len(readEntries(t, path))
+	for _, r := range rotated {
+		total += len(readEntries(t, r))
+	}
+
+	assert.Equal(t, 1000, total)
+	assert.Zero(t, l.Dropped())
+}
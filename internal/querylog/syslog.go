@@ -0,0 +1,30 @@
+package querylog
+
+import (
+	"bytes"
+	"fmt"
+	"log/syslog"
+)
+
+// syslogSink sends each entry as one message to the local syslog daemon,
+// which is journald on systemd hosts.
+type syslogSink struct {
+	w *syslog.Writer
+}
+
+func openSyslog() (*syslogSink, error) {
+	w, err := syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, "outway")
+	if err != nil {
+		return nil, fmt.Errorf("connect to syslog: %w", err)
+	}
+
+	return &syslogSink{w: w}, nil
+}
+
+func (s *syslogSink) write(line []byte) error {
+	return s.w.Info(string(bytes.TrimSuffix(line, []byte("\n"))))
+}
+
+func (s *syslogSink) flush() error { return nil }
+
+func (s *syslogSink) close() error { return s.w.Close() }
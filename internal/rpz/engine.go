@@ -0,0 +1,363 @@
+package rpz
+
+import (
+	"context"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/rs/zerolog"
+
+	"github.com/bavix/outway/internal/config"
+	"github.com/bavix/outway/internal/localzone"
+)
+
+const (
+	defaultAXFRRefresh = time.Hour
+	watchDebounce      = 500 * time.Millisecond
+)
+
+// Hit describes a matched policy rule.
+type Hit struct {
+	Zone    string
+	Trigger Trigger
+	Action  Action
+	Data    []dns.RR
+}
+
+// ZoneStatus describes a loaded policy zone.
+type ZoneStatus struct {
+	Name     string    `json:"name"`
+	Source   string    `json:"source"`
+	Serial   uint32    `json:"serial"`
+	Rules    int       `json:"rules"`
+	Skipped  int       `json:"skipped"`
+	LoadedAt time.Time `json:"loaded_at,omitzero"`
+	Error    string    `json:"error,omitempty"`
+}
+
+type zoneState struct {
+	cfg      config.RPZConfig
+	zone     *Zone
+	loadedAt time.Time
+	err      error
+}
+
+// Engine holds the configured policy zones in precedence order.
+type Engine struct {
+	mu      sync.RWMutex
+	zones   []*zoneState
+	watcher *localzone.Watcher
+	watched map[string]struct{}
+	wake    chan struct{}
+}
+
+// NewEngine creates an engine without zones; call SetZones to configure it.
+func NewEngine() *Engine {
+	return &Engine{
+		watched: make(map[string]struct{}),
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// SetZones replaces the zone configuration. Zones whose source did not change
+// keep their loaded data; new or changed zones are loaded in background once
+// the engine is started.
+func (e *Engine) SetZones(cfgs []config.RPZConfig) {
+	e.mu.Lock()
+
+	prev := make(map[config.RPZConfig]*zoneState, len(e.zones))
+	for _, z := range e.zones {
+		prev[z.cfg] = z
+	}
+
+	zones := make([]*zoneState, 0, len(cfgs))
+	for _, c := range cfgs {
+		if z, ok := prev[c]; ok {
+			zones = append(zones, z)
+
+			continue
+		}
+
+		zones = append(zones, &zoneState{cfg: c})
+	}
+
+	e.zones = zones
+	e.mu.Unlock()
+
+	e.watchFiles()
+
+	select {
+	case e.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Start loads all zones, watches zone files for changes and periodically
+// re-transfers AXFR zones until ctx is done.
+func (e *Engine) Start(ctx context.Context) {
+	if w, err := localzone.NewWatcher(watchDebounce); err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Msg("rpz file watcher unavailable")
+	} else {
+		w.AddCallback(func() { e.reload(ctx, true, false) })
+
+		e.mu.Lock()
+		e.watcher = w
+		e.mu.Unlock()
+
+		e.watchFiles()
+		w.Start(ctx)
+	}
+
+	go func() {
+		e.reload(ctx, true, true)
+
+		for {
+			timer := time.NewTimer(e.axfrInterval())
+
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+
+				return
+			case <-e.wake:
+				timer.Stop()
+				e.reloadMissing(ctx)
+			case <-timer.C:
+				e.reload(ctx, false, true)
+			}
+		}
+	}()
+}
+
+// MatchQName checks the query name against QNAME triggers.
+func (e *Engine) MatchQName(name string) (Hit, bool) {
+	return e.match(TriggerQName, func(z *Zone) (*Rule, bool) { return z.MatchQName(name) })
+}
+
+// MatchIP checks a response address against response IP triggers.
+func (e *Engine) MatchIP(ip net.IP) (Hit, bool) {
+	return e.match(TriggerResponseIP, func(z *Zone) (*Rule, bool) { return z.MatchIP(ip) })
+}
+
+// MatchNSDName checks a name server name against NSDNAME triggers.
+func (e *Engine) MatchNSDName(name string) (Hit, bool) {
+	return e.match(TriggerNSDName, func(z *Zone) (*Rule, bool) { return z.MatchNSDName(name) })
+}
+
+// HasIP reports whether any loaded zone has response IP triggers.
+func (e *Engine) HasIP() bool {
+	return e.any(func(z *Zone) bool { return z.HasIP() })
+}
+
+// HasNSDName reports whether any loaded zone has NSDNAME triggers.
+func (e *Engine) HasNSDName() bool {
+	return e.any(func(z *Zone) bool { return z.HasNSDName() })
+}
+
+// Zones returns the status of all configured zones.
+func (e *Engine) Zones() []ZoneStatus {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	out := make([]ZoneStatus, 0, len(e.zones))
+	for _, z := range e.zones {
+		st := ZoneStatus{Name: z.cfg.Name, Source: source(z.cfg), LoadedAt: z.loadedAt}
+		if z.zone != nil {
+			st.Serial, st.Rules, st.Skipped = z.zone.Serial, z.zone.Rules, z.zone.Skipped
+		}
+
+		if z.err != nil {
+			st.Error = z.err.Error()
+		}
+
+		out = append(out, st)
+	}
+
+	return out
+}
+
+func (e *Engine) match(trigger Trigger, fn func(*Zone) (*Rule, bool)) (Hit, bool) {
+	if e == nil {
+		return Hit{}, false
+	}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	// The first zone with a matching rule wins, as in other RPZ implementations.
+	for _, z := range e.zones {
+		if z.zone == nil {
+			continue
+		}
+
+		if r, ok := fn(z.zone); ok {
+			return Hit{Zone: z.cfg.Name, Trigger: trigger, Action: r.Action, Data: r.Data}, true
+		}
+	}
+
+	return Hit{}, false
+}
+
+func (e *Engine) any(fn func(*Zone) bool) bool {
+	if e == nil {
+		return false
+	}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return slices.ContainsFunc(e.zones, func(z *zoneState) bool { return z.zone != nil && fn(z.zone) })
+}
+
+// reload loads file and/or AXFR zones. A failed load keeps the previous data.
+func (e *Engine) reload(ctx context.Context, files, axfr bool) {
+	e.load(ctx, func(c config.RPZConfig, _ *zoneState) bool {
+		return (files && c.File != "") || (axfr && c.AXFR != "")
+	})
+}
+
+func (e *Engine) reloadMissing(ctx context.Context) {
+	e.load(ctx, func(_ config.RPZConfig, z *zoneState) bool { return z.zone == nil })
+}
+
+func (e *Engine) load(ctx context.Context, want func(config.RPZConfig, *zoneState) bool) {
+	e.mu.RLock()
+
+	states := make([]*zoneState, 0, len(e.zones))
+	for _, z := range e.zones {
+		if want(z.cfg, z) {
+			states = append(states, z)
+		}
+	}
+	e.mu.RUnlock()
+
+	for _, st := range states {
+		zone, err := fetch(ctx, st.cfg)
+
+		e.mu.Lock()
+		st.err = err
+
+		if err == nil {
+			st.zone, st.loadedAt = zone, time.Now()
+		}
+		e.mu.Unlock()
+
+		if err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Str("zone", st.cfg.Name).Str("source", source(st.cfg)).Msg("failed to load rpz zone")
+
+			continue
+		}
+
+		zerolog.Ctx(ctx).Info().
+			Str("zone", st.cfg.Name).
+			Uint32("serial", zone.Serial).
+			Int("rules", zone.Rules).
+			Int("skipped", zone.Skipped).
+			Msg("rpz zone loaded")
+	}
+}
+
+func (e *Engine) watchFiles() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.watcher == nil {
+		return
+	}
+
+	for _, z := range e.zones {
+		if z.cfg.File == "" {
+			continue
+		}
+
+		if _, ok := e.watched[z.cfg.File]; ok {
+			continue
+		}
+
+		if err := e.watcher.WatchFile(z.cfg.File); err == nil {
+			e.watched[z.cfg.File] = struct{}{}
+		}
+	}
+}
+
+func (e *Engine) axfrInterval() time.Duration {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	interval := defaultAXFRRefresh
+
+	for _, z := range e.zones {
+		if z.cfg.AXFR != "" && z.cfg.Refresh > 0 && z.cfg.Refresh < interval {
+			interval = z.cfg.Refresh
+		}
+	}
+
+	return interval
+}
+
+func fetch(ctx context.Context, c config.RPZConfig) (*Zone, error) {
+	if c.File != "" {
+		f, err := os.Open(c.File)
+		if err != nil {
+			return nil, err
+		}
+
+		defer func() { _ = f.Close() }()
+
+		return Parse(f, c.Name, c.File)
+	}
+
+	return transfer(ctx, c.Name, c.AXFR)
+}
+
+// transfer fetches a zone with AXFR from server.
+func transfer(ctx context.Context, name, server string) (*Zone, error) {
+	m := new(dns.Msg)
+	m.SetAxfr(dns.Fqdn(strings.ToLower(name)))
+
+	t := &dns.Transfer{}
+
+	ch, err := t.In(m, server)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		rrs     []dns.RR
+		lastErr error
+	)
+
+	// Drain the channel even after an error so the transfer goroutine exits.
+	for env := range ch {
+		if env.Error != nil {
+			lastErr = env.Error
+
+			continue
+		}
+
+		rrs = append(rrs, env.RR...)
+	}
+
+	if lastErr != nil {
+		return nil, lastErr
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return FromRecords(name, rrs)
+}
+
+func source(c config.RPZConfig) string {
+	if c.File != "" {
+		return c.File
+	}
+
+	return "axfr://" + c.AXFR
+}
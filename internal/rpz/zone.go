@@ -0,0 +1,349 @@
+// Package rpz loads Response Policy Zones (RPZ) and matches queries and
+// responses against their QNAME, response IP and NSDNAME triggers.
+package rpz
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+var (
+	errInvalidIPTrigger   = errors.New("invalid rpz-ip trigger")
+	errNoOrigin           = errors.New("zone origin is unknown")
+	errUnsupportedTrigger = errors.New("unsupported rpz trigger")
+	errUnsupportedAction  = errors.New("unsupported rpz action")
+)
+
+// Action is the policy applied when a trigger matches.
+type Action string
+
+// Policy actions encoded in RPZ records.
+const (
+	ActionNXDomain  Action = "nxdomain"
+	ActionNoData    Action = "nodata"
+	ActionPassthru  Action = "passthru"
+	ActionDrop      Action = "drop"
+	ActionLocalData Action = "local"
+)
+
+// Trigger identifies which part of a query or response matched a rule.
+type Trigger string
+
+// Supported triggers.
+const (
+	TriggerQName      Trigger = "qname"
+	TriggerResponseIP Trigger = "response-ip"
+	TriggerNSDName    Trigger = "nsdname"
+)
+
+const (
+	labelIP      = "rpz-ip"
+	labelNSDName = "rpz-nsdname"
+	labelNSIP    = "rpz-nsip"
+	labelClient  = "rpz-client-ip"
+
+	targetPassthru = "rpz-passthru."
+	targetDrop     = "rpz-drop."
+	targetTCPOnly  = "rpz-tcp-only."
+
+	ipv4Labels     = 4
+	defaultZoneTTL = 300
+)
+
+// Rule is a single policy record set.
+type Rule struct {
+	Action Action
+	// Data holds the local data records for ActionLocalData; owner names
+	// must be rewritten to the query name before use.
+	Data []dns.RR
+}
+
+type ipRule struct {
+	net  *net.IPNet
+	bits int
+	rule *Rule
+}
+
+// Zone is a compiled response policy zone.
+type Zone struct {
+	Origin  string
+	Serial  uint32
+	Rules   int
+	Skipped int
+
+	qname       map[string]*Rule
+	qnameWild   map[string]*Rule
+	nsdname     map[string]*Rule
+	nsdnameWild map[string]*Rule
+	ips         []ipRule
+}
+
+// Parse reads a zone file. When origin is empty it is taken from the SOA record.
+func Parse(r io.Reader, origin, file string) (*Zone, error) {
+	if origin != "" {
+		origin = dns.Fqdn(origin)
+	}
+
+	zp := dns.NewZoneParser(r, origin, file)
+	zp.SetDefaultTTL(defaultZoneTTL) // policy records rarely care about TTLs
+
+	var rrs []dns.RR
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		rrs = append(rrs, rr)
+	}
+
+	if err := zp.Err(); err != nil {
+		return nil, err
+	}
+
+	return FromRecords(origin, rrs)
+}
+
+// FromRecords compiles a zone from already parsed records (e.g. an AXFR).
+func FromRecords(origin string, rrs []dns.RR) (*Zone, error) {
+	if origin == "" {
+		for _, rr := range rrs {
+			if soa, ok := rr.(*dns.SOA); ok {
+				origin = soa.Hdr.Name
+
+				break
+			}
+		}
+	}
+
+	if origin == "" {
+		return nil, errNoOrigin
+	}
+
+	origin = strings.ToLower(dns.Fqdn(origin))
+
+	z := &Zone{
+		Origin:      origin,
+		qname:       make(map[string]*Rule),
+		qnameWild:   make(map[string]*Rule),
+		nsdname:     make(map[string]*Rule),
+		nsdnameWild: make(map[string]*Rule),
+	}
+
+	// Group records by owner name, keeping file order.
+	owners := make([]string, 0)
+	byOwner := make(map[string][]dns.RR)
+
+	for _, rr := range rrs {
+		owner := strings.ToLower(rr.Header().Name)
+
+		switch v := rr.(type) {
+		case *dns.SOA:
+			if owner == origin {
+				z.Serial = v.Serial
+
+				continue
+			}
+		case *dns.NS:
+			if owner == origin {
+				continue
+			}
+		}
+
+		if !dns.IsSubDomain(origin, owner) || owner == origin {
+			z.Skipped++
+
+			continue
+		}
+
+		if _, ok := byOwner[owner]; !ok {
+			owners = append(owners, owner)
+		}
+
+		byOwner[owner] = append(byOwner[owner], rr)
+	}
+
+	for _, owner := range owners {
+		if err := z.add(strings.TrimSuffix(owner, "."+origin), byOwner[owner]); err != nil {
+			z.Skipped++
+		}
+	}
+
+	return z, nil
+}
+
+// MatchQName finds the rule for a query name. Exact owners win over
+// wildcards and longer wildcards win over shorter ones.
+func (z *Zone) MatchQName(name string) (*Rule, bool) {
+	return matchName(z.qname, z.qnameWild, name)
+}
+
+// MatchNSDName finds the rule for a name server name.
+func (z *Zone) MatchNSDName(name string) (*Rule, bool) {
+	return matchName(z.nsdname, z.nsdnameWild, name)
+}
+
+// HasNSDName reports whether the zone has NSDNAME triggers.
+func (z *Zone) HasNSDName() bool {
+	return len(z.nsdname) > 0 || len(z.nsdnameWild) > 0
+}
+
+// HasIP reports whether the zone has response IP triggers.
+func (z *Zone) HasIP() bool {
+	return len(z.ips) > 0
+}
+
+// MatchIP finds the most specific response IP rule containing ip.
+func (z *Zone) MatchIP(ip net.IP) (*Rule, bool) {
+	var (
+		best     *Rule
+		bestBits = -1
+	)
+
+	for _, r := range z.ips {
+		if r.bits > bestBits && r.net.Contains(ip) {
+			best, bestBits = r.rule, r.bits
+		}
+	}
+
+	return best, best != nil
+}
+
+func matchName(exact, wild map[string]*Rule, name string) (*Rule, bool) {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+
+	if r, ok := exact[name]; ok {
+		return r, true
+	}
+
+	// "*.example.com" matches subdomains only; walk from the longest parent.
+	for n := name; ; {
+		i := strings.IndexByte(n, '.')
+		if i < 0 {
+			return nil, false
+		}
+
+		n = n[i+1:]
+		if r, ok := wild[n]; ok {
+			return r, true
+		}
+	}
+}
+
+func (z *Zone) add(owner string, rrs []dns.RR) error {
+	rule, err := ruleFromRecords(rrs)
+	if err != nil {
+		return err
+	}
+
+	labels := dns.SplitDomainName(owner)
+	last := labels[len(labels)-1]
+
+	switch last {
+	case labelIP:
+		ipnet, bits, err := parseIPTrigger(labels[:len(labels)-1])
+		if err != nil {
+			return err
+		}
+
+		z.ips = append(z.ips, ipRule{net: ipnet, bits: bits, rule: rule})
+	case labelNSDName:
+		addName(z.nsdname, z.nsdnameWild, strings.TrimSuffix(owner, "."+labelNSDName), rule)
+	case labelNSIP, labelClient:
+		return fmt.Errorf("%w: %s", errUnsupportedTrigger, last)
+	default:
+		addName(z.qname, z.qnameWild, owner, rule)
+	}
+
+	z.Rules++
+
+	return nil
+}
+
+func addName(exact, wild map[string]*Rule, name string, rule *Rule) {
+	if base, ok := strings.CutPrefix(name, "*."); ok {
+		wild[base] = rule
+
+		return
+	}
+
+	exact[name] = rule
+}
+
+// ruleFromRecords decodes the policy action from an owner's records.
+func ruleFromRecords(rrs []dns.RR) (*Rule, error) {
+	if len(rrs) == 1 {
+		if cname, ok := rrs[0].(*dns.CNAME); ok {
+			switch target := strings.ToLower(cname.Target); {
+			case target == ".":
+				return &Rule{Action: ActionNXDomain}, nil
+			case target == "*.":
+				return &Rule{Action: ActionNoData}, nil
+			case target == targetPassthru:
+				return &Rule{Action: ActionPassthru}, nil
+			case target == targetDrop:
+				return &Rule{Action: ActionDrop}, nil
+			case target == targetTCPOnly:
+				return nil, fmt.Errorf("%w: %s", errUnsupportedAction, target)
+			}
+		}
+	}
+
+	return &Rule{Action: ActionLocalData, Data: rrs}, nil
+}
+
+// parseIPTrigger decodes "<prefix>.<reversed address>" labels, e.g.
+// "24.0.2.0.192" for 192.0.2.0/24 or "64.zz.db8.2001" for 2001:db8::/64.
+func parseIPTrigger(labels []string) (*net.IPNet, int, error) {
+	if len(labels) < 2 { //nolint:mnd // prefix and at least one address label
+		return nil, 0, errInvalidIPTrigger
+	}
+
+	bits, err := strconv.Atoi(labels[0])
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %w", errInvalidIPTrigger, err)
+	}
+
+	parts := make([]string, 0, len(labels)-1)
+	for i := len(labels) - 1; i > 0; i-- {
+		parts = append(parts, labels[i])
+	}
+
+	var addr string
+
+	if len(parts) == ipv4Labels && !strings.Contains(strings.Join(parts, ""), "zz") && bits <= net.IPv4len*8 {
+		addr = strings.Join(parts, ".")
+	} else {
+		for i, p := range parts {
+			if p == "zz" {
+				parts[i] = ""
+			}
+		}
+
+		addr = strings.Join(parts, ":")
+		if strings.HasPrefix(addr, ":") {
+			addr = ":" + addr
+		}
+
+		if strings.HasSuffix(addr, ":") {
+			addr += ":"
+		}
+	}
+
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return nil, 0, fmt.Errorf("%w: %s", errInvalidIPTrigger, addr)
+	}
+
+	size := net.IPv6len * 8
+	if v4 := ip.To4(); v4 != nil && strings.Contains(addr, ".") {
+		ip, size = v4, net.IPv4len*8
+	}
+
+	if bits < 0 || bits > size {
+		return nil, 0, fmt.Errorf("%w: prefix %d", errInvalidIPTrigger, bits)
+	}
+
+	return &net.IPNet{IP: ip.Mask(net.CIDRMask(bits, size)), Mask: net.CIDRMask(bits, size)}, bits, nil
+}
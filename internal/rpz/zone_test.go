@@ -0,0 +1,211 @@
+package rpz_test
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bavix/outway/internal/config"
+	"github.com/bavix/outway/internal/rpz"
+)
+
+const policyZone = `$TTL 300
+@ IN SOA localhost. admin.localhost. 42 3600 600 86400 60
+@ IN NS localhost.
+
+bad.example.com          CNAME .
+*.bad.example.com        CNAME .
+empty.example.com        CNAME *.
+ok.bad.example.com       CNAME rpz-passthru.
+silent.example.com       CNAME rpz-drop.
+tcp.example.com          CNAME rpz-tcp-only.
+walled.example.com       A     10.0.0.1
+walled.example.com       AAAA  fd00::1
+garden.example.com       CNAME walled.example.net.
+
+32.1.2.0.192.rpz-ip      CNAME .
+24.0.2.0.192.rpz-ip      CNAME *.
+64.zz.db8.2001.rpz-ip    CNAME rpz-drop.
+
+ns1.evil.net.rpz-nsdname   CNAME .
+*.sinister.net.rpz-nsdname CNAME *.
+
+10.1.1.168.192.rpz-client-ip CNAME .
+`
+
+func parse(t *testing.T) *rpz.Zone {
+	t.Helper()
+
+	z, err := rpz.Parse(strings.NewReader(policyZone), "rpz.local", "policy.zone")
+	require.NoError(t, err)
+
+	return z
+}
+
+func TestParse_Metadata(t *testing.T) {
+	t.Parallel()
+
+	z := parse(t)
+
+	assert.Equal(t, "rpz.local.", z.Origin)
+	assert.Equal(t, uint32(42), z.Serial)
+	assert.Equal(t, 12, z.Rules)
+	assert.Equal(t, 2, z.Skipped) // rpz-tcp-only and rpz-client-ip
+	assert.True(t, z.HasIP())
+	assert.True(t, z.HasNSDName())
+}
+
+func TestParse_OriginFromSOA(t *testing.T) {
+	t.Parallel()
+
+	data := "rpz.example. 300 IN SOA ns. admin. 7 1 1 1 1\nbad.com.rpz.example. 300 IN CNAME .\n"
+
+	z, err := rpz.Parse(strings.NewReader(data), "", "")
+	require.NoError(t, err)
+	assert.Equal(t, "rpz.example.", z.Origin)
+
+	r, ok := z.MatchQName("bad.com.")
+	require.True(t, ok)
+	assert.Equal(t, rpz.ActionNXDomain, r.Action)
+}
+
+func TestZone_MatchQName(t *testing.T) {
+	t.Parallel()
+
+	z := parse(t)
+
+	tests := []struct {
+		name   string
+		action rpz.Action
+		found  bool
+	}{
+		{name: "bad.example.com", action: rpz.ActionNXDomain, found: true},
+		{name: "x.bad.example.com.", action: rpz.ActionNXDomain, found: true},
+		{name: "ok.bad.example.com", action: rpz.ActionPassthru, found: true},
+		{name: "empty.example.com", action: rpz.ActionNoData, found: true},
+		{name: "silent.example.com", action: rpz.ActionDrop, found: true},
+		{name: "walled.example.com", action: rpz.ActionLocalData, found: true},
+		{name: "sub.empty.example.com"},
+		{name: "tcp.example.com"},
+		{name: "example.com"},
+	}
+
+	for _, tt := range tests {
+		r, ok := z.MatchQName(tt.name)
+		assert.Equal(t, tt.found, ok, tt.name)
+
+		if tt.found {
+			assert.Equal(t, tt.action, r.Action, tt.name)
+		}
+	}
+
+	r, ok := z.MatchQName("walled.example.com")
+	require.True(t, ok)
+	assert.Len(t, r.Data, 2)
+}
+
+func TestZone_MatchIP(t *testing.T) {
+	t.Parallel()
+
+	z := parse(t)
+
+	r, ok := z.MatchIP(net.ParseIP("192.0.2.1"))
+	require.True(t, ok)
+	assert.Equal(t, rpz.ActionNXDomain, r.Action, "longest prefix wins")
+
+	r, ok = z.MatchIP(net.ParseIP("192.0.2.77"))
+	require.True(t, ok)
+	assert.Equal(t, rpz.ActionNoData, r.Action)
+
+	r, ok = z.MatchIP(net.ParseIP("2001:db8::5"))
+	require.True(t, ok)
+	assert.Equal(t, rpz.ActionDrop, r.Action)
+
+	_, ok = z.MatchIP(net.ParseIP("198.51.100.1"))
+	assert.False(t, ok)
+}
+
+func TestZone_MatchNSDName(t *testing.T) {
+	t.Parallel()
+
+	z := parse(t)
+
+	r, ok := z.MatchNSDName("ns1.evil.net.")
+	require.True(t, ok)
+	assert.Equal(t, rpz.ActionNXDomain, r.Action)
+
+	r, ok = z.MatchNSDName("a.ns.sinister.net")
+	require.True(t, ok)
+	assert.Equal(t, rpz.ActionNoData, r.Action)
+
+	_, ok = z.MatchNSDName("ns2.evil.net")
+	assert.False(t, ok)
+}
+
+func TestEngine_FileZones(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.zone")
+	second := filepath.Join(dir, "second.zone")
+
+	require.NoError(t, os.WriteFile(first, []byte("$ORIGIN first.rpz.\nbad.com CNAME rpz-passthru.\n"), 0o600))
+	require.NoError(t, os.WriteFile(second, []byte("$ORIGIN second.rpz.\nbad.com CNAME .\nworse.com CNAME .\n"), 0o600))
+
+	e := rpz.NewEngine()
+	e.SetZones([]config.RPZConfig{
+		{Name: "first.rpz", File: first},
+		{Name: "second.rpz", File: second},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	e.Start(ctx)
+
+	require.Eventually(t, func() bool {
+		_, ok := e.MatchQName("worse.com")
+
+		return ok
+	}, 2*time.Second, 10*time.Millisecond)
+
+	hit, ok := e.MatchQName("bad.com")
+	require.True(t, ok)
+	assert.Equal(t, "first.rpz", hit.Zone, "earlier zone takes precedence")
+	assert.Equal(t, rpz.ActionPassthru, hit.Action)
+	assert.Equal(t, rpz.TriggerQName, hit.Trigger)
+
+	zones := e.Zones()
+	require.Len(t, zones, 2)
+	assert.Equal(t, 1, zones[0].Rules)
+	assert.Equal(t, 2, zones[1].Rules)
+
+	// Rewriting a file reloads it through the watcher.
+	require.NoError(t, os.WriteFile(first, []byte("$ORIGIN first.rpz.\nother.com CNAME .\n"), 0o600))
+
+	require.Eventually(t, func() bool {
+		hit, ok := e.MatchQName("bad.com")
+
+		return ok && hit.Zone == "second.rpz"
+	}, 5*time.Second, 20*time.Millisecond)
+
+	_, ok = e.MatchQName("other.com")
+	assert.True(t, ok)
+}
+
+func TestEngine_NilSafe(t *testing.T) {
+	t.Parallel()
+
+	var e *rpz.Engine
+
+	_, ok := e.MatchQName("example.com")
+	assert.False(t, ok)
+	assert.False(t, e.HasIP())
+}
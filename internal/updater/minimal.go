@@ -60,6 +60,7 @@ type Config struct {
 	BinaryName     string       // Name of the binary to look for
 	HTTPClient     *http.Client // Optional custom HTTP client
 	Logger         Logger       // Optional logger
+	BeforeRestart  func()       // Optional hook run right before exiting for restart
 }
 
 // Updater provides methods for checking, downloading, and installing updates.
@@ -312,6 +313,10 @@ func (u *Updater) InstallUpdate(ctx context.Context, updatePath string) error {
 
 	u.logger.Infof("update installed successfully to %s, exiting for restart", execPath)
 
+	if u.config.BeforeRestart != nil {
+		u.config.BeforeRestart()
+	}
+
 	// Exit with special code to trigger restart by init system
 	os.Exit(exitCodeRestart)
 
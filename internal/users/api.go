@@ -1,6 +1,7 @@
 package users
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"net/http"
@@ -15,7 +16,7 @@ import (
 
 // ConfigInterface defines the interface for configuration operations.
 type ConfigInterface interface {
-	Save() error
+	SaveContext(ctx context.Context) error
 	Load() error
 	GetUsers() []config.UserConfig
 	SetUsers(users []config.UserConfig)
@@ -169,7 +170,7 @@ func (h *APIHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 	h.config.SetUsers(users)
 
 	// Save config
-	if err := h.config.Save(); err != nil {
+	if err := h.config.SaveContext(r.Context()); err != nil {
 		render.Status(r, http.StatusInternalServerError)
 		render.JSON(w, r, map[string]string{"error": "failed to save config"})
 
@@ -215,7 +216,7 @@ func (h *APIHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.updateUser(user, req); err != nil {
+	if err := h.updateUser(r.Context(), user, req); err != nil {
 		render.Status(r, http.StatusInternalServerError)
 		render.JSON(w, r, map[string]string{"error": err.Error()})
 
@@ -261,7 +262,7 @@ func (h *APIHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 	h.config.SetUsers(users)
 
 	// Save config
-	if err := h.config.Save(); err != nil {
+	if err := h.config.SaveContext(r.Context()); err != nil {
 		render.Status(r, http.StatusInternalServerError)
 		render.JSON(w, r, map[string]string{"error": "failed to save config"})
 
@@ -324,7 +325,7 @@ func (h *APIHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
 	user.Password = hashedPassword
 
 	// Save config
-	if err := h.config.Save(); err != nil {
+	if err := h.config.SaveContext(r.Context()); err != nil {
 		render.Status(r, http.StatusInternalServerError)
 		render.JSON(w, r, map[string]string{"error": "failed to save config"})
 
@@ -413,7 +414,7 @@ func (h *APIHandler) validateUserUpdate(req *UserRequest, currentEmail string) e
 }
 
 // updateUser updates user with new data.
-func (h *APIHandler) updateUser(user *config.UserConfig, req *UserRequest) error {
+func (h *APIHandler) updateUser(ctx context.Context, user *config.UserConfig, req *UserRequest) error {
 	// Hash password if provided
 	hashedPassword := user.Password
 	if req.Password != "" {
@@ -443,7 +444,7 @@ func (h *APIHandler) updateUser(user *config.UserConfig, req *UserRequest) error
 	h.config.SetUsers(users)
 
 	// Save config
-	if err := h.config.Save(); err != nil {
+	if err := h.config.SaveContext(ctx); err != nil {
 		return ErrFailedToSaveConfig
 	}
 
@@ -1,6 +1,7 @@
 package users
 
 import (
+	"context"
 	"sync"
 
 	"github.com/bavix/outway/internal/config"
@@ -17,6 +18,10 @@ func (m *MockConfig) Save() error {
 	return m.SaveError
 }
 
+func (m *MockConfig) SaveContext(context.Context) error {
+	return m.SaveError
+}
+
 func (m *MockConfig) Load() error {
 	return nil
 }
@@ -24,7 +24,7 @@ export default function CachePage({ provider }: CacheProps) {
   const [limit, setLimit] = useState(20);
   const [query, setQuery] = useState('');
   const [debouncedQuery, setDebouncedQuery] = useState('');
-  const [sort, setSort] = useState<'name' | 'qtype' | 'answers' | 'expires'>('expires');
+  const [sort, setSort] = useState<'name' | 'qtype' | 'answers' | 'status' | 'expires'>('expires');
   const [order, setOrder] = useState<'asc' | 'desc'>('desc');
   const [view, setView] = useState<{ open: boolean; key?: string; data?: CacheKeyDetails } >({ open: false });
   const [confirmFlush, setConfirmFlush] = useState(false);
@@ -149,6 +149,7 @@ export default function CachePage({ provider }: CacheProps) {
                   { key: 'name', label: 'Domain' },
                   { key: 'qtype', label: 'Type' },
                   { key: 'answers', label: 'Answers' },
+                  { key: 'status', label: 'Status' },
                   { key: 'expires', label: 'Expires' }
                 ].map((c) => (
                   <TH key={c.key} sortable active={sort === (c.key as any)} order={order} onClick={() => { if (sort === (c.key as any)) { setOrder(order === 'asc' ? 'desc' : 'asc'); } else { setSort(c.key as any); setOrder('asc'); } setOffset(0); }}>
@@ -164,6 +165,7 @@ export default function CachePage({ provider }: CacheProps) {
                   <TD>{r.name}</TD>
                   <TD>{({1:'A',28:'AAAA',5:'CNAME',15:'MX',2:'NS',16:'TXT',33:'SRV',12:'PTR'} as any)[r.qtype] || r.qtype}</TD>
                   <TD>{r.answers}</TD>
+                  <TD>{r.status || 'NOERROR'}</TD>
                   <TD>{Math.max(0, Math.floor((new Date(r.expires_at as any).getTime() - Date.now())/1000))}s</TD>
                   <TD align="right">
                       <Button
@@ -1,8 +1,48 @@
 import { useState, useEffect } from 'preact/hooks';
 import { Card, Button, Input, Badge } from '../components/index.js';
+import { Select } from '../components/Select.js';
 import { useRuleGroups, useRuleGroupsActions } from '../store/store.js';
 import { FailoverProvider } from '../providers/failoverProvider.js';
-import { RuleGroup } from '../providers/types.js';
+import { AddressFamily, RuleGroup } from '../providers/types.js';
+
+const ADDRESS_FAMILIES: { value: AddressFamily; label: string }[] = [
+  { value: 'any', label: 'IPv4 and IPv6' },
+  { value: 'ipv4_only', label: 'IPv4 only' },
+  { value: 'ipv6_only', label: 'IPv6 only' },
+  { value: 'prefer_ipv4', label: 'Prefer IPv4' },
+];
+
+const addressFamilyLabel = (family?: AddressFamily) =>
+  ADDRESS_FAMILIES.find(f => f.value === (family || 'any'))?.label ?? family;
+
+const isEnabled = (g: RuleGroup) => g.enabled !== false;
+
+// One schedule window per line, e.g. "mon-fri 09:00-18:00"
+const parseSchedule = (text: string) =>
+  text.split('\n').map(w => w.trim()).filter(Boolean);
+
+const statusLabel = (g: RuleGroup) => {
+  if (!isEnabled(g)) return 'Disabled';
+  return g.active === false ? 'Outside schedule' : 'Active';
+};
+
+const isBlock = (g: RuleGroup) => g.action === 'block';
+
+// PUT replaces the whole group, so keep every fetched field (ecs, block lists, ...)
+// and only normalize what the form edits.
+const toPayload = (g: RuleGroup): RuleGroup => ({
+  ...g,
+  name: g.name,
+  description: g.description || '',
+  via: g.via,
+  patterns: g.patterns.filter(p => p.trim()),
+  pin_ttl: !!g.pin_ttl,
+  address_family: g.address_family || 'any',
+  client_ttl_min: Number(g.client_ttl_min) || 0,
+  client_ttl_max: Number(g.client_ttl_max) || 0,
+  enabled: isEnabled(g),
+  schedule: g.schedule || [],
+});
 
 interface RulesProps {
   provider: FailoverProvider;
@@ -17,6 +57,7 @@ export function Rules({ provider }: RulesProps) {
   const [via, setVia] = useState('');
   const [patterns, setPatterns] = useState<string[]>(['']);
   const [pinTTL, setPinTTL] = useState(true);
+  const [addressFamily, setAddressFamily] = useState<AddressFamily>('any');
   const [filter, setFilter] = useState('');
   const [isSubmitting, setIsSubmitting] = useState(false);
   const [deleteConfirm, setDeleteConfirm] = useState<string | null>(null);
@@ -76,7 +117,8 @@ export function Rules({ provider }: RulesProps) {
         description: description.trim() || '',
         via: via.trim(),
         patterns: patterns.filter(p => p.trim()),
-        pin_ttl: pinTTL
+        pin_ttl: pinTTL,
+        address_family: addressFamily
       };
 
       await provider.createRuleGroup(newGroup);
@@ -87,6 +129,7 @@ export function Rules({ provider }: RulesProps) {
       setVia('');
       setPatterns(['']);
       setPinTTL(true);
+      setAddressFamily('any');
     } catch (error) {
       console.error('Failed to create rule group:', error);
       setError(error instanceof Error ? error.message : 'Failed to create rule group');
@@ -136,13 +179,7 @@ export function Rules({ provider }: RulesProps) {
   const saveEdit = async (name: string) => {
     const g = editing[name]; if (!g) return;
     try {
-      await provider.updateRuleGroup(name, {
-        name: g.name,
-        description: g.description || '',
-        via: g.via,
-        patterns: g.patterns.filter(p => p.trim()),
-        pin_ttl: !!g.pin_ttl,
-      });
+      await provider.updateRuleGroup(name, toPayload(g));
       cancelEdit(name);
       // Refresh groups to reflect any server-side normalization
       const groups = await provider.fetchRuleGroups();
@@ -153,6 +190,17 @@ export function Rules({ provider }: RulesProps) {
     }
   };
 
+  const toggleEnabled = async (g: RuleGroup) => {
+    try {
+      await provider.updateRuleGroup(g.name, { ...toPayload(g), enabled: !isEnabled(g) });
+      const groups = await provider.fetchRuleGroups();
+      setRuleGroups(groups);
+    } catch (error) {
+      console.error('Failed to toggle rule group:', error);
+      setError(error instanceof Error ? error.message : 'Failed to toggle rule group');
+    }
+  };
+
   const handleDelete = async (groupName: string) => {
     try {
       await provider.deleteRuleGroup(groupName);
@@ -252,6 +300,16 @@ export function Rules({ provider }: RulesProps) {
             </label>
           </div>
 
+          <Select
+            label="Address Family"
+            value={addressFamily}
+            onChange={(e) => setAddressFamily((e.target as HTMLSelectElement).value as AddressFamily)}
+          >
+            {ADDRESS_FAMILIES.map(f => (
+              <option key={f.value} value={f.value}>{f.label}</option>
+            ))}
+          </Select>
+
           <div className="flex gap-3 pt-4 border-t border-gray-200 dark:border-gray-700">
             <Button
               type="submit"
@@ -286,9 +344,15 @@ export function Rules({ provider }: RulesProps) {
                 <span className="text-sm text-gray-600 dark:text-gray-400">
                   {group.patterns.length === 1 ? 'pattern' : 'patterns'}
                 </span>
+                <Badge variant={group.active === false ? 'secondary' : 'primary'}>{statusLabel(group)}</Badge>
               </div>
               <div className="flex gap-2">
-                {editing[group.name] ? (
+                {!isBlock(group) && (
+                  <Button variant="secondary" size="sm" onClick={() => toggleEnabled(group)}>
+                    {isEnabled(group) ? 'Disable' : 'Enable'}
+                  </Button>
+                )}
+                {isBlock(group) ? null : editing[group.name] ? (
                   <>
                     <Button variant="secondary" size="sm" onClick={() => cancelEdit(group.name)}>Cancel</Button>
                     <Button variant="primary" size="sm" onClick={() => saveEdit(group.name)}>Save</Button>
@@ -306,7 +370,7 @@ export function Rules({ provider }: RulesProps) {
               </div>
             </div>
 
-            {editing[group.name] ? (
+            {editing[group.name] && !isBlock(group) ? (
               <div className="space-y-4">
                 <div className="grid grid-cols-1 md:grid-cols-2 gap-4 text-sm">
                   <div className="flex items-center gap-2">
@@ -317,11 +381,38 @@ export function Rules({ provider }: RulesProps) {
                     <span className="font-medium text-gray-700 dark:text-gray-300">Pin TTL:</span>
                     <input type="checkbox" checked={!!editing[group.name]!.pin_ttl} onChange={(e) => updateEditField(group.name, 'pin_ttl', (e.target as HTMLInputElement).checked)} />
                   </div>
+                  <div className="flex items-center gap-2">
+                    <span className="font-medium text-gray-700 dark:text-gray-300">Enabled:</span>
+                    <input type="checkbox" checked={isEnabled(editing[group.name]!)} onChange={(e) => updateEditField(group.name, 'enabled', (e.target as HTMLInputElement).checked)} />
+                  </div>
+                  <div className="flex items-center gap-2">
+                    <span className="font-medium text-gray-700 dark:text-gray-300">Address Family:</span>
+                    <Select value={editing[group.name]!.address_family || 'any'} onChange={(e) => updateEditField(group.name, 'address_family', (e.target as HTMLSelectElement).value)}>
+                      {ADDRESS_FAMILIES.map(f => (
+                        <option key={f.value} value={f.value}>{f.label}</option>
+                      ))}
+                    </Select>
+                  </div>
+                  <div className="flex items-center gap-2">
+                    <span className="font-medium text-gray-700 dark:text-gray-300">Client TTL:</span>
+                    <Input type="number" min="0" placeholder="min" value={String(editing[group.name]!.client_ttl_min || '')} onInput={(e) => updateEditField(group.name, 'client_ttl_min', (e.target as HTMLInputElement).value)} />
+                    <Input type="number" min="0" placeholder="max" value={String(editing[group.name]!.client_ttl_max || '')} onInput={(e) => updateEditField(group.name, 'client_ttl_max', (e.target as HTMLInputElement).value)} />
+                  </div>
                 </div>
                 <div>
                   <span className="font-medium text-sm text-gray-700 dark:text-gray-300">Description:</span>
                   <Input value={editing[group.name]!.description || ''} onInput={(e) => updateEditField(group.name, 'description', (e.target as HTMLInputElement).value)} placeholder="Optional description" />
                 </div>
+                <div>
+                  <span className="font-medium text-sm text-gray-700 dark:text-gray-300">Schedule (one window per line, empty = always):</span>
+                  <textarea
+                    className="mt-1 w-full rounded border border-gray-300 dark:border-gray-600 bg-white dark:bg-gray-800 p-2 text-sm font-mono"
+                    rows={3}
+                    value={(editing[group.name]!.schedule || []).join('\n')}
+                    onInput={(e) => updateEditField(group.name, 'schedule', parseSchedule((e.target as HTMLTextAreaElement).value))}
+                    placeholder="mon-fri 09:00-18:00"
+                  />
+                </div>
                 <div className="mt-2 pt-2 border-t border-gray-200 dark:border-gray-700">
                   <span className="font-medium text-sm text-gray-700 dark:text-gray-300">DNS Patterns:</span>
                   {editing[group.name]!.patterns.map((p, idx) => (
@@ -346,6 +437,22 @@ export function Rules({ provider }: RulesProps) {
                       {group.pin_ttl ? 'Yes' : 'No'}
                     </Badge>
                   </div>
+                  <div className="flex items-center gap-2">
+                    <span className="font-medium text-gray-700 dark:text-gray-300">Address Family:</span>
+                    <Badge variant="secondary">{addressFamilyLabel(group.address_family)}</Badge>
+                  </div>
+                  {group.schedule && group.schedule.length > 0 ? (
+                    <div className="flex items-center gap-2">
+                      <span className="font-medium text-gray-700 dark:text-gray-300">Schedule:</span>
+                      {group.schedule.map(w => <Badge key={w} variant="secondary">{w}</Badge>)}
+                    </div>
+                  ) : null}
+                  {(group.client_ttl_min || group.client_ttl_max) ? (
+                    <div className="flex items-center gap-2">
+                      <span className="font-medium text-gray-700 dark:text-gray-300">Client TTL:</span>
+                      <Badge variant="secondary">{`${group.client_ttl_min || 0}s – ${group.client_ttl_max ? `${group.client_ttl_max}s` : '∞'}`}</Badge>
+                    </div>
+                  ) : null}
                 </div>
                 <div className="mt-4 pt-4 border-t border-gray-200 dark:border-gray-700">
                   <span className="font-medium text-sm text-gray-700 dark:text-gray-300">DNS Patterns:</span>
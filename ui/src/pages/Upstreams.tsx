@@ -115,6 +115,7 @@ export function Upstreams({ provider }: UpstreamsProps) {
     const current = upstreams.find(u => u.address === address);
     if (!current) return;
     const updatedItem: UpstreamItem = {
+      ...current,
       name: (patch.name ?? current.name ?? '').trim(),
       address: (patch.address ?? current.address).trim(),
     } as UpstreamItem;
@@ -246,7 +246,7 @@ export class RESTProvider implements Provider {
         // t is one of udp|tcp|dot|doq etc.; ensure scheme prefix
         address = `${t}://${addr.replace(/^(udp|tcp|tls|dot|quic|doq):\/\//i, '')}`;
       }
-      return { name, address, weight } as UpstreamItem;
+      return { name, address, weight, ...(u.ecs ? { ecs: u.ecs } : {}) } as UpstreamItem;
     });
   }
 
@@ -257,6 +257,8 @@ export class RESTProvider implements Provider {
       const s = String(raw.address || '').trim();
       let name = (raw.name || '').trim();
       const weight = typeof raw.weight === 'number' ? raw.weight : undefined;
+      // The policy is not edited here but must survive the save
+      const ecs = raw.ecs ? { ecs: raw.ecs } : {};
       try {
         const url = new URL(s);
         const scheme = url.protocol.replace(':', '').toLowerCase();
@@ -268,13 +270,13 @@ export class RESTProvider implements Provider {
           address = `${canonical}://${url.host}`;
         }
         if (!name) name = url.hostname;
-        return { name, address, ...(weight !== undefined ? { weight } : {}) } as any;
+        return { name, address, ...(weight !== undefined ? { weight } : {}), ...ecs } as any;
       } catch {}
       // Fallback: assume raw host[:port] → udp URL, derive name from host
       const host = s.replace(/^(udp|tcp|tls|dot|quic|doq):\/\//i, '');
       if (!name) name = host.split(':')[0] || host;
       const address = `udp://${host}`;
-      return { name, address, ...(weight !== undefined ? { weight } : {}) } as any;
+      return { name, address, ...(weight !== undefined ? { weight } : {}), ...ecs } as any;
     });
     await this.fetchJSON('/api/v1/upstreams', {
       method: 'POST',
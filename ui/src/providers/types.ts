@@ -76,10 +76,28 @@ export interface AuthStatusResponse {
 export interface RuleGroup {
   name: string;
   description?: string;
+  // action is "route" (default) or "block"; the block fields are kept as-is on save
+  action?: 'route' | 'block';
   via: string;
   patterns: string[];
   pin_ttl: boolean;
-}
+  address_family?: AddressFamily;
+  client_ttl_min?: number;
+  client_ttl_max?: number;
+  // enabled defaults to true; schedule lists windows like "mon-fri 09:00-18:00"
+  enabled?: boolean;
+  schedule?: string[];
+  // active is reported by the server: enabled and inside the schedule right now
+  active?: boolean;
+  ecs?: ECSPolicy;
+  block_mode?: string;
+  sinkhole?: string[];
+  lists?: string[];
+  allow?: string[];
+  lists_refresh?: string;
+}
+
+export type AddressFamily = 'any' | 'ipv4_only' | 'ipv6_only' | 'prefer_ipv4';
 
 export interface QueryEvent {
   time: string;
@@ -113,10 +131,27 @@ export interface OverviewData {
   errors_last_min?: number;
 }
 
+export interface MXRecord {
+  preference: number;
+  host: string;
+}
+
+export interface SRVRecord {
+  priority: number;
+  weight: number;
+  port: number;
+  target: string;
+}
+
 export interface HostOverride {
   pattern: string;
   a?: string[];
   aaaa?: string[];
+  cname?: string;
+  txt?: string[];
+  mx?: MXRecord[];
+  srv?: SRVRecord[];
+  via?: string;
   ttl?: number;
 }
 
@@ -131,11 +166,19 @@ export interface ServerInfo {
   build_time?: string;
 }
 
+// Client subnet (ECS) policy of an upstream or rule group
+export interface ECSPolicy {
+  mode: string; // passthrough, strip, fixed or via
+  prefix?: string;
+  ipv4_bits?: number;
+  ipv6_bits?: number;
+}
+
 // Upstream item used in UI
 export type UpstreamItem = {
   name: string;
   address: string; // URL form (udp://host:port or https://...)
-} & Partial<{ weight: number }>;
+} & Partial<{ weight: number; ecs: ECSPolicy }>;
 
 export interface Config {
   history_enabled: boolean;
@@ -240,7 +283,7 @@ export interface RuleGroupsResponse {
 }
 
 export interface UpstreamsResponse {
-  upstreams: Array<{ name: string; address: string; type?: string; weight?: number }>;
+  upstreams: Array<{ name: string; address: string; type?: string; weight?: number; ecs?: ECSPolicy }>;
 }
 
 export interface HistoryResponse {
@@ -304,6 +347,8 @@ export interface CacheEntry {
   qtype: number;
   answers: number;
   expires_at: string | Date;
+  rcode?: number;
+  status?: string;
 }
 
 export interface CacheListResponse {